@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// activationPollInterval is how often a running on-demand service's
+// connections are counted to tell whether it has gone idle
+const activationPollInterval = time.Second
+
+// activationRequest asks the service manager to start an on-demand service
+// that received a connection, or to stop one that has gone idle
+type activationRequest struct {
+	svc  Service
+	stop bool
+	done chan struct{}
+}
+
+// validateActivation checks on_demand and idle_timeout
+func (c *Config) validateActivation() error {
+	for name, svc := range c.Services {
+		if svc.IdleTimeout < 0 {
+			return serviceErrorf(name, "idle_timeout", "must not be negative")
+		}
+		if !svc.OnDemand {
+			if svc.IdleTimeout != 0 {
+				return serviceErrorf(name, "idle_timeout", "only applies to on_demand services")
+			}
+			continue
+		}
+		if svc.Oneshot {
+			return serviceErrorf(name, "on_demand", "can't be used with oneshot")
+		}
+
+		sockets := 0
+		for i, f := range svc.Files {
+			if f.Listen == "" {
+				continue
+			}
+			sockets++
+			network, _, err := parseListenAddress(f.Listen)
+			if err == nil && svc.IdleTimeout > 0 && !streamNetwork(network) {
+				return fieldErrorf([]string{"services", name, "files", listIndex(i), "listen"},
+					"idle_timeout needs stream sockets (tcp or unix) to count connections on, not %s", network)
+			}
+		}
+		if sockets == 0 {
+			return serviceErrorf(name, "on_demand", "needs a socket to listen on in files")
+		}
+	}
+	return nil
+}
+
+// streamNetwork reports whether sockets on a network accept connections
+func streamNetwork(network string) bool {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix", "unixpacket":
+		return true
+	default:
+		return false
+	}
+}
+
+// awaitActivation leaves an on-demand service stopped until a connection
+// arrives on one of its sockets
+func (d *Daemon) awaitActivation(svc Service) {
+	d.setState(svc, StateIdle)
+
+	d.mu.Lock()
+	watching := d.activators[svc.Name]
+	d.activators[svc.Name] = true
+	d.mu.Unlock()
+	if !watching {
+		go d.activator(svc.Name)
+	}
+}
+
+// activator starts an on-demand service when a connection arrives and, with
+// an idle_timeout, stops it again once it has had no connections for that
+// long. A service that exits cleanly by itself goes back to waiting too. It
+// returns once the service is no longer on demand.
+func (d *Daemon) activator(name string) {
+	defer func() {
+		d.mu.Lock()
+		delete(d.activators, name)
+		d.mu.Unlock()
+	}()
+
+	for {
+		if d.ctx.Err() != nil {
+			return
+		}
+		svc, exists := d.getConfig().Services[name]
+		if !exists || !svc.OnDemand {
+			return
+		}
+		state := d.serviceState(name)
+		proc, _ := d.getServiceProcess(name)
+
+		switch {
+		case state == StateIdle || (state == StateCompleted && svc.Restart != RestartAlways):
+			d.setState(svc, StateIdle)
+			if err := d.waitForConnection(svc); err != nil {
+				if d.ctx.Err() == nil {
+					logServiceError(name, "Failed to watch sockets for connections, starting service", "error", err)
+				}
+			}
+			if !d.requestActivation(activationRequest{svc: svc}) {
+				return
+			}
+			if svc.IdleTimeout <= 0 {
+				return
+			}
+
+		case svc.IdleTimeout > 0 && proc != nil && proc.running():
+			if d.waitIdle(svc, proc) {
+				logServiceInfo(name, "Service has been idle, stopping it", "idle_timeout", svc.IdleTimeout.String())
+				if !d.requestActivation(activationRequest{svc: svc, stop: true}) {
+					return
+				}
+			}
+
+		default:
+			// Starting, backing off before a restart, or failed: check again
+			// once that has played out
+			if !d.sleep(activationPollInterval) {
+				return
+			}
+		}
+	}
+}
+
+// requestActivation has the service manager carry out an activation and
+// waits for it. It returns false if shutdown began first.
+func (d *Daemon) requestActivation(req activationRequest) bool {
+	req.done = make(chan struct{})
+	select {
+	case d.activationChan <- req:
+	case <-d.ctx.Done():
+		return false
+	}
+	select {
+	case <-req.done:
+		return true
+	case <-d.ctx.Done():
+		return false
+	}
+}
+
+// activate starts or stops an on-demand service. Must be called with
+// elevated privileges.
+func (d *Daemon) activate(req activationRequest) {
+	svc := req.svc
+	unlock := d.lockService(svc.Name)
+	defer unlock()
+
+	proc, exists := d.getServiceProcess(svc.Name)
+	running := exists && proc.running()
+	if req.stop {
+		if running {
+			d.stopProcess(svc.Name, proc, serviceStopTimeout)
+			d.setState(svc, StateIdle)
+		}
+		return
+	}
+	// pei stop holds it off until pei start
+	if running || d.serviceState(svc.Name) == StateDisabled {
+		return
+	}
+	if _, err := d.launchService(svc, "Connection received, starting service", false); err != nil {
+		logServiceError(svc.Name, "Failed to start", "error", err)
+		d.failService(svc, fmt.Sprintf("failed to start: %v", err))
+	}
+}
+
+// waitForConnection waits until one of a service's sockets has a connection
+// or datagram waiting, without taking it, so the service finds it there
+func (d *Daemon) waitForConnection(svc Service) error {
+	var watchers []io.Closer
+	var sockets []*sharedSocket
+	for _, f := range svc.Files {
+		if f.Listen == "" {
+			continue
+		}
+		socket := &sharedSocket{listen: f.Listen, file: d.shared.listeners[f.Listen]}
+		if socket.file == nil {
+			return fmt.Errorf("socket %s is not open", f.Listen)
+		}
+		sockets = append(sockets, socket)
+	}
+
+	ready := make(chan error, len(sockets))
+	for _, socket := range sockets {
+		watcher, err := socket.watch()
+		if err != nil {
+			closeAll(watchers)
+			return err
+		}
+		watchers = append(watchers, watcher)
+		go func() {
+			ready <- waitReadable(watcher)
+		}()
+	}
+
+	var err error
+	select {
+	case err = <-ready:
+	case <-d.ctx.Done():
+		err = d.ctx.Err()
+	}
+	closeAll(watchers)
+
+	// Watching put the sockets in non-blocking mode, which the service would
+	// inherit along with them
+	for _, socket := range sockets {
+		syscall.SetNonblock(int(socket.file.Fd()), false)
+	}
+	return err
+}
+
+// sharedSocket is one of the sockets pei holds open for services
+type sharedSocket struct {
+	listen string
+	file   *os.File
+}
+
+// watch returns a duplicate of the socket the runtime poller can wait on,
+// and that closing interrupts
+func (s *sharedSocket) watch() (*os.File, error) {
+	syscall.ForkLock.RLock()
+	fd, err := syscall.Dup(int(s.file.Fd()))
+	if err == nil {
+		syscall.CloseOnExec(fd)
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("socket %s: %v", s.listen, err)
+	}
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("socket %s: %v", s.listen, err)
+	}
+	return os.NewFile(uintptr(fd), s.listen), nil
+}
+
+// waitReadable blocks until a socket is readable or closed
+func waitReadable(socket *os.File) error {
+	raw, err := socket.SyscallConn()
+	if err != nil {
+		return err
+	}
+	waited := false
+	return raw.Read(func(uintptr) bool {
+		// Returning false the first time has the poller wait for data
+		done := waited
+		waited = true
+		return done
+	})
+}
+
+func closeAll(closers []io.Closer) {
+	for _, closer := range closers {
+		closer.Close()
+	}
+}
+
+// waitIdle waits until a running on-demand service has had no connections
+// for its idle_timeout, returning true, or false if the instance exits or
+// shutdown begins first
+func (d *Daemon) waitIdle(svc Service, proc *serviceProcess) bool {
+	ticker := time.NewTicker(activationPollInterval)
+	defer ticker.Stop()
+
+	lastActive := time.Now()
+	for {
+		select {
+		case <-proc.exited:
+			return false
+		case <-d.ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+
+		active, err := serviceConnections(svc)
+		if err != nil {
+			logServiceError(svc.Name, "Can't count connections, won't stop the service when idle", "error", err)
+			select {
+			case <-proc.exited:
+			case <-d.ctx.Done():
+			}
+			return false
+		}
+		if active > 0 {
+			lastActive = time.Now()
+		} else if time.Since(lastActive) >= svc.IdleTimeout {
+			return true
+		}
+	}
+}
+
+// serviceConnections counts the open connections on a service's sockets
+func serviceConnections(svc Service) (int, error) {
+	total := 0
+	for _, f := range svc.Files {
+		if f.Listen == "" {
+			continue
+		}
+		network, address, err := parseListenAddress(f.Listen)
+		if err != nil {
+			return 0, err
+		}
+		count, err := socketConnections(network, address)
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
@@ -0,0 +1,11 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import "errors"
+
+// socketConnections isn't available here, so idle_timeout can't stop
+// services
+func socketConnections(network, address string) (int, error) {
+	return 0, errors.New("counting connections is not supported on this platform")
+}
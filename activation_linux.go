@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// socketConnections counts the established connections on a listening
+// socket from the kernel's socket tables in /proc/net
+func socketConnections(network, address string) (int, error) {
+	if strings.HasPrefix(network, "unix") {
+		// Accepted unix connections carry the listener's path; 03 is
+		// SS_CONNECTED
+		return countProcNet([]string{"/proc/net/unix"}, func(fields []string) bool {
+			return len(fields) >= 8 && fields[5] == "03" && fields[7] == address
+		})
+	}
+
+	_, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return 0, err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return 0, fmt.Errorf("port %q: %v", portText, err)
+	}
+	suffix := fmt.Sprintf(":%04X", port)
+	// 01 is TCP_ESTABLISHED
+	return countProcNet([]string{"/proc/net/tcp", "/proc/net/tcp6"}, func(fields []string) bool {
+		return len(fields) >= 4 && strings.HasSuffix(fields[1], suffix) && fields[3] == "01"
+	})
+}
+
+// countProcNet counts the entries of /proc/net tables that match
+func countProcNet(paths []string, match func(fields []string) bool) (int, error) {
+	count := 0
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Scan() // header
+		for scanner.Scan() {
+			if match(strings.Fields(scanner.Text())) {
+				count++
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigValidatesActivation(t *testing.T) {
+	tests := []struct {
+		service string
+		err     string
+	}{
+		{`on_demand: true`, "needs a socket"},
+		{`idle_timeout: 1m`, "only applies to on_demand"},
+		{`{on_demand: true, idle_timeout: 1m, files: [{fd: 3, listen: "udp://127.0.0.1:0"}]}`, "needs stream sockets"},
+		{`{on_demand: true, idle_timeout: 1m, files: [{fd: 3, listen: "tcp://127.0.0.1:0"}]}`, ""},
+	}
+	for _, tt := range tests {
+		config := "services:\n  web:\n    command: [\"true\"]\n"
+		if strings.HasPrefix(tt.service, "{") {
+			config = "services:\n  web: " + strings.Replace(tt.service, "{", "{command: [\"true\"], ", 1) + "\n"
+		} else {
+			config += "    " + tt.service + "\n"
+		}
+		_, err := loadConfig(writeConfig(t, config))
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: expected config to load, got %v", tt.service, err)
+		} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.service, tt.err, err)
+		}
+	}
+}
+
+func TestWaitForConnection(t *testing.T) {
+	svc := Service{Name: "web", OnDemand: true, Files: []FileDescriptor{{FD: 3, Listen: "tcp://127.0.0.1:0"}}}
+	config := &Config{Services: map[string]Service{"web": svc}}
+	d := NewDaemon(config, "", "", "")
+	defer d.cancel()
+	shared, err := openSharedFiles(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.shared = shared
+
+	// Stands in for the service, which accepts on the same socket
+	service, err := net.FileListener(shared.listeners[svc.Files[0].Listen])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+
+	waited := make(chan error, 1)
+	go func() { waited <- d.waitForConnection(svc) }()
+	select {
+	case err := <-waited:
+		t.Fatalf("expected to wait for a connection, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	client, err := net.Dial("tcp", service.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Fatalf("expected a connection, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the connection to end the wait")
+	}
+
+	// The connection is left for the service to accept
+	conn, err := service.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if runtime.GOOS == "linux" {
+		active, err := socketConnections("tcp", service.Addr().String())
+		if err != nil || active == 0 {
+			t.Errorf("expected the accepted connection to be counted, got %d, %v", active, err)
+		}
+	}
+}
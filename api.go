@@ -0,0 +1,404 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+)
+
+// API exposes the control socket's protocol on network listeners, for
+// managing pei from outside its machine. Every listener uses TLS, and every
+// client must identify itself, by a bearer token or by a client certificate.
+type API struct {
+	Listeners []APIListener `yaml:"listeners"`
+}
+
+// APIListener is one network address pei accepts control connections on.
+// A read-only listener only answers commands that change nothing, whoever
+// the client is; it may also be a unix socket, and if it names no tokens or
+// clients anyone who can connect may use it.
+type APIListener struct {
+	Address  string        `yaml:"address"` // tcp://host:port, or unix:///path if read_only
+	ReadOnly bool          `yaml:"read_only"`
+	TLS      *APITLS       `yaml:"tls"`
+	Tokens   []APIToken    `yaml:"tokens"`
+	Clients  []APIIdentity `yaml:"clients"`
+}
+
+// APITLS is a listener's server certificate and, for mutual TLS, the CA that
+// client certificates must be signed by
+type APITLS struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+// APIToken lets clients that send the token in token_file act as name
+type APIToken struct {
+	Name       string     `yaml:"name"`
+	TokenFile  string     `yaml:"token_file"`
+	Permission Permission `yaml:"permission"`
+}
+
+// APIIdentity grants a permission to clients whose verified certificate has
+// name as its common name
+type APIIdentity struct {
+	Name       string     `yaml:"name"`
+	Permission Permission `yaml:"permission"`
+}
+
+// Permission is what a client of the network API is allowed to do. Each
+// level allows everything the ones before it do.
+type Permission string
+
+const (
+	PermissionRead    Permission = "read"    // list, status, logs, events and other views
+	PermissionOperate Permission = "operate" // also restart, signal and attach to services
+	PermissionAdmin   Permission = "admin"   // also reload the configuration
+)
+
+func (p Permission) level() int {
+	switch p {
+	case PermissionRead:
+		return 1
+	case PermissionOperate:
+		return 2
+	case PermissionAdmin:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// commandPermissions is the permission each IPC command needs. Commands
+// not listed, including ones added later, need admin, as do definitions and
+// snapshot: they return the configuration as it is, with its SMTP passwords,
+// routing keys, webhook URLs and service environments.
+var commandPermissions = map[string]Permission{
+	"list":         PermissionRead,
+	"status":       PermissionRead,
+	"logs":         PermissionRead,
+	"tail":         PermissionRead,
+	"events":       PermissionRead,
+	"boot-analyze": PermissionRead,
+	"definitions":  PermissionAdmin,
+	"snapshot":     PermissionAdmin,
+	"metrics":      PermissionRead,
+	"version":      PermissionRead,
+	"cancel":       PermissionRead,
+	"restart":      PermissionOperate,
+	"signal":       PermissionOperate,
+	"start":        PermissionOperate,
+	"stop":         PermissionOperate,
+	"kill":         PermissionOperate,
+	"run":          PermissionOperate,
+	"attach":       PermissionOperate,
+	"input":        PermissionOperate,
+	"reload":       PermissionAdmin,
+}
+
+// isLoopback reports whether a host:port address listens only on a loopback
+// interface
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func commandPermission(command string) Permission {
+	if permission, exists := commandPermissions[command]; exists {
+		return permission
+	}
+	return PermissionAdmin
+}
+
+// validateAPI checks the network API listeners
+func (c *Config) validateAPI() error {
+	if c.API == nil {
+		return nil
+	}
+	for i, listener := range c.API.Listeners {
+		path := []string{"api", "listeners", listIndex(i)}
+		network, address, err := parseListenAddress(listener.Address)
+		if err != nil {
+			return fieldErrorf(append(path, "address"), "%v", err)
+		}
+		switch network {
+		case "tcp", "tcp4", "tcp6":
+			if listener.TLS == nil || listener.TLS.CertFile == "" || listener.TLS.KeyFile == "" {
+				return fieldErrorf(append(path, "tls"), "cert_file and key_file are required")
+			}
+			// Only local clients may read without identifying themselves
+			if listener.ReadOnly && len(listener.Tokens) == 0 && len(listener.Clients) == 0 && !isLoopback(address) {
+				return fieldErrorf(path, "needs tokens or clients unless it listens on a loopback address, or anyone who reaches it could read")
+			}
+		case "unix":
+			if !listener.ReadOnly {
+				return fieldErrorf(append(path, "address"), "unix sockets must be read_only, the local socket already allows everything")
+			}
+			if listener.TLS != nil || len(listener.Tokens) > 0 || len(listener.Clients) > 0 {
+				return fieldErrorf(path, "unix sockets don't use tls, tokens or clients, their file permissions decide who connects")
+			}
+		default:
+			return fieldErrorf(append(path, "address"), "must be a tcp:// address, or unix:// if read_only")
+		}
+		if len(listener.Tokens) == 0 && len(listener.Clients) == 0 && !listener.ReadOnly {
+			return fieldErrorf(path, "needs tokens or clients, or nobody could use it")
+		}
+		if len(listener.Clients) > 0 && listener.TLS.ClientCAFile == "" {
+			return fieldErrorf(append(path, "tls", "client_ca_file"), "is required to verify clients")
+		}
+		for j, token := range listener.Tokens {
+			tokenPath := append(path, "tokens", listIndex(j))
+			if token.Name == "" || token.TokenFile == "" {
+				return fieldErrorf(tokenPath, "needs a name and a token_file")
+			}
+			if token.Permission.level() == 0 {
+				return fieldErrorf(append(tokenPath, "permission"), "must be read, operate or admin")
+			}
+		}
+		for j, client := range listener.Clients {
+			clientPath := append(path, "clients", listIndex(j))
+			if client.Name == "" {
+				return fieldErrorf(clientPath, "needs a name")
+			}
+			if client.Permission.level() == 0 {
+				return fieldErrorf(append(clientPath, "permission"), "must be read, operate or admin")
+			}
+		}
+	}
+	return nil
+}
+
+// apiListener is an open network listener and who may use it
+type apiListener struct {
+	net.Listener
+	address  string
+	readOnly bool
+	limiter  *ipcLimiter
+	tokens   map[[sha256.Size]byte]APIToken
+	clients  map[string]Permission
+}
+
+// anonymous reports whether the listener lets anyone who connects use it
+func (l *apiListener) anonymous() bool {
+	return l.readOnly && len(l.tokens) == 0 && len(l.clients) == 0
+}
+
+// openAPIListeners reads the API's certificates and tokens and binds its
+// listeners, while pei is still root
+func openAPIListeners(api *API, limits *IPCLimits) ([]*apiListener, error) {
+	if api == nil {
+		return nil, nil
+	}
+	var listeners []*apiListener
+	fail := func(err error) ([]*apiListener, error) {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+		return nil, err
+	}
+
+	for _, config := range api.Listeners {
+		listener := &apiListener{
+			address:  config.Address,
+			readOnly: config.ReadOnly,
+			limiter:  newIPCLimiter(limits),
+			tokens:   make(map[[sha256.Size]byte]APIToken),
+			clients:  make(map[string]Permission),
+		}
+		for _, token := range config.Tokens {
+			data, err := os.ReadFile(token.TokenFile)
+			if err != nil {
+				return fail(fmt.Errorf("api listener %s: token %s: %v", config.Address, token.Name, err))
+			}
+			secret := strings.TrimSpace(string(data))
+			if secret == "" {
+				return fail(fmt.Errorf("api listener %s: token %s: %s is empty", config.Address, token.Name, token.TokenFile))
+			}
+			listener.tokens[sha256.Sum256([]byte(secret))] = token
+		}
+		for _, client := range config.Clients {
+			listener.clients[client.Name] = client.Permission
+		}
+
+		network, address, _ := parseListenAddress(config.Address)
+		if network == "unix" {
+			os.Remove(address)
+		}
+		inner, err := net.Listen(network, address)
+		if err != nil {
+			return fail(fmt.Errorf("api listener %s: %v", config.Address, err))
+		}
+		listener.Listener = inner
+		if config.TLS != nil {
+			tlsConfig, err := apiTLSConfig(config.TLS)
+			if err != nil {
+				inner.Close()
+				return fail(fmt.Errorf("api listener %s: %v", config.Address, err))
+			}
+			listener.Listener = tls.NewListener(inner, tlsConfig)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// apiTLSConfig loads a listener's certificate, and requires clients to
+// present a certificate signed by the client CA if one is set
+func apiTLSConfig(settings *APITLS) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %v", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if settings.ClientCAFile != "" {
+		pem, err := os.ReadFile(settings.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", settings.ClientCAFile)
+		}
+		// Clients may still use a token instead of a certificate
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// serveAPI accepts connections on a network listener
+func serveAPI(daemon *Daemon, listener *apiListener) {
+	slog.Info("API listening", "address", listener.address)
+	go func() {
+		<-daemon.ctx.Done()
+		listener.Close()
+	}()
+	listener.limiter.serve(daemon.ctx, listener, listener.address, func(conn net.Conn) {
+		serveIPC(conn, daemon, &ipcPeer{listener: listener, limiter: listener.limiter})
+	})
+}
+
+// ipcPeer is who is on the other end of a control connection and what they
+// may do. Connections to the local socket may do anything.
+type ipcPeer struct {
+	listener   *apiListener // nil for the local socket
+	identity   string
+	permission Permission
+
+	// Requests are rate limited per key, see connPeer
+	limiter *ipcLimiter
+	key     string
+}
+
+// localPeer is a connection to the local socket
+var localPeer = ipcPeer{identity: "local", permission: PermissionAdmin}
+
+// authorize checks a request is allowed, and within the peer's rate limit.
+// A network client is identified by its certificate or, failing that, the
+// token in its first request, and keeps that identity for the rest of the
+// connection.
+func (p *ipcPeer) authorize(conn net.Conn, req IPCRequest) error {
+	// Terminal input and cancelling belong to a stream already counted
+	if p.limiter != nil && req.Command != "input" && req.Command != "cancel" && !p.limiter.allow(p.key) {
+		return fmt.Errorf("rate limit exceeded (%g requests per second), try again later", p.limiter.rate)
+	}
+	if p.listener != nil && p.permission == "" {
+		p.identify(conn, req.Token)
+		if p.permission == "" && p.listener.anonymous() {
+			p.identity, p.permission = "anonymous", PermissionRead
+		}
+		if p.permission == "" {
+			return fmt.Errorf("authentication required")
+		}
+		if p.listener.readOnly && p.permission.level() > PermissionRead.level() {
+			p.permission = PermissionRead
+		}
+	}
+	needed := commandPermission(req.Command)
+	if p.listener != nil && p.listener.readOnly && needed != PermissionRead {
+		return fmt.Errorf("permission denied: %s is not available on this read-only listener", req.Command)
+	}
+	if p.permission.level() < needed.level() {
+		return fmt.Errorf("permission denied: %s needs %s permission, %s has %s", req.Command, needed, p.identity, p.permission)
+	}
+	return nil
+}
+
+func (p *ipcPeer) identify(conn net.Conn, token string) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err == nil {
+			if certs := tlsConn.ConnectionState().VerifiedChains; len(certs) > 0 {
+				name := certs[0][0].Subject.CommonName
+				if permission, exists := p.listener.clients[name]; exists {
+					p.identity, p.permission = name, permission
+					return
+				}
+			}
+		}
+	}
+	if token == "" {
+		return
+	}
+	sum := sha256.Sum256([]byte(token))
+	for known, entry := range p.listener.tokens {
+		if subtle.ConstantTimeCompare(known[:], sum[:]) == 1 {
+			p.identity, p.permission = entry.Name, entry.Permission
+			return
+		}
+	}
+}
+
+// apiToken is the bearer token the CLI sends to a network API, from
+// PEI_TOKEN
+var apiToken = os.Getenv("PEI_TOKEN")
+
+// dialAPI connects to a daemon's network API over TLS. PEI_TLS_CA names the
+// CA to verify the daemon with, instead of the system's, and PEI_TLS_CERT and
+// PEI_TLS_KEY a client certificate for mutual TLS.
+func dialAPI(address string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("--host tcp://%s needs a port", address)
+	}
+	config := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if caFile := os.Getenv("PEI_TLS_CA"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PEI_TLS_CA: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	if certFile, keyFile := os.Getenv("PEI_TLS_CERT"), os.Getenv("PEI_TLS_KEY"); certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load PEI_TLS_CERT and PEI_TLS_KEY: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: ipcTimeout}, "tcp", address, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to pei daemon at %s: %v", address, err)
+	}
+	return conn, nil
+}
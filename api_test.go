@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCert issues a certificate for name, signed by parent (or self-signed
+// if parent is nil), and writes it and its key as PEM files in dir
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func TestAPIAuthentication(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "server", ca, caKey)
+	writeCert(t, dir, "ops", ca, caKey)
+	os.WriteFile(filepath.Join(dir, "dashboard.token"), []byte("s3cret\n"), 0600)
+
+	listeners, err := openAPIListeners(&API{Listeners: []APIListener{{
+		Address: "tcp://127.0.0.1:0",
+		TLS: &APITLS{
+			CertFile:     filepath.Join(dir, "server.pem"),
+			KeyFile:      filepath.Join(dir, "server.key"),
+			ClientCAFile: filepath.Join(dir, "ca.pem"),
+		},
+		Tokens:  []APIToken{{Name: "dashboard", TokenFile: filepath.Join(dir, "dashboard.token"), Permission: PermissionRead}},
+		Clients: []APIIdentity{{Name: "ops", Permission: PermissionOperate}},
+	}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDaemon(&Config{}, "", "", "")
+	defer d.cancel()
+	go serveAPI(d, listeners[0])
+
+	defer func(host, token string) { remoteHost, apiToken = host, token }(remoteHost, apiToken)
+	remoteHost = "tcp://" + listeners[0].Addr().String()
+	t.Setenv("PEI_TLS_CA", filepath.Join(dir, "ca.pem"))
+
+	request := func(command string) (*IPCResponse, error) {
+		return sendIPCRequest(IPCRequest{Command: command, Service: "web", Signal: "HUP"})
+	}
+
+	apiToken = ""
+	if resp, err := request("list"); err != nil || resp.Success || resp.Message != "authentication required" {
+		t.Errorf("expected an anonymous client to be refused, got %+v, %v", resp, err)
+	}
+
+	apiToken = "s3cret"
+	if resp, err := request("list"); err != nil || !resp.Success {
+		t.Errorf("expected the read token to list services, got %+v, %v", resp, err)
+	}
+	if resp, err := request("signal"); err != nil || !strings.Contains(resp.Message, "permission denied: signal needs operate permission, dashboard has read") {
+		t.Errorf("expected the read token to be refused signal, got %+v, %v", resp, err)
+	}
+	for _, command := range []string{"snapshot", "definitions"} {
+		if resp, err := request(command); err != nil || resp.Config != nil || resp.Definitions != nil || !strings.Contains(resp.Message, command+" needs admin permission") {
+			t.Errorf("expected the read token to be refused the configuration through %s, got %+v, %v", command, resp, err)
+		}
+	}
+
+	apiToken = ""
+	t.Setenv("PEI_TLS_CERT", filepath.Join(dir, "ops.pem"))
+	t.Setenv("PEI_TLS_KEY", filepath.Join(dir, "ops.key"))
+	if resp, err := request("signal"); err != nil || strings.Contains(resp.Message, "permission") {
+		t.Errorf("expected the ops certificate to be allowed to signal, got %+v, %v", resp, err)
+	}
+	if resp, err := request("reload"); err != nil || !strings.Contains(resp.Message, "reload needs admin permission, ops has operate") {
+		t.Errorf("expected the ops certificate to be refused reload, got %+v, %v", resp, err)
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	for address, want := range map[string]bool{
+		"127.0.0.1:9400": true,
+		"[::1]:9400":     true,
+		"localhost:9400": true,
+		":9400":          false,
+		"0.0.0.0:9400":   false,
+		"10.0.0.5:9400":  false,
+		"node:9400":      false,
+	} {
+		if got := isLoopback(address); got != want {
+			t.Errorf("isLoopback(%q) = %v, want %v", address, got, want)
+		}
+	}
+}
+
+func TestLoadConfigValidatesAPI(t *testing.T) {
+	tests := []struct {
+		api string
+		err string
+	}{
+		{"{address: tcp://:9400, tokens: [{name: a, token_file: /t, permission: read}]}", "api.listeners[0].tls"},
+		{"{address: udp://:9400, tls: {cert_file: /c, key_file: /k}}", "must be a tcp:// address"},
+		{"{address: tcp://:9400, tls: {cert_file: /c, key_file: /k}}", "needs tokens or clients"},
+		{"{address: tcp://0.0.0.0:9400, read_only: true, tls: {cert_file: /c, key_file: /k}}", "needs tokens or clients unless it listens on a loopback address"},
+		{"{address: tcp://:9400, tls: {cert_file: /c, key_file: /k}, clients: [{name: ops, permission: admin}]}", "client_ca_file"},
+		{"{address: tcp://:9400, tls: {cert_file: /c, key_file: /k}, tokens: [{name: a, token_file: /t, permission: root}]}", "must be read, operate or admin"},
+		{"{address: unix:///run/pei-api.sock, tokens: [{name: a, token_file: /t, permission: read}]}", "must be read_only"},
+		{"{address: unix:///run/pei-ro.sock, read_only: true, tokens: [{name: a, token_file: /t, permission: read}]}", "don't use tls, tokens or clients"},
+	}
+	for _, tt := range tests {
+		config := "api:\n  listeners:\n    - " + tt.api + "\nservices:\n  web:\n    command: [\"true\"]\n"
+		_, err := loadConfig(writeConfig(t, config))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: expected error containing %q, got: %v", tt.api, tt.err, err)
+		}
+	}
+}
+
+func TestReadOnlyAPIListener(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "pei-ro.sock")
+	listeners, err := openAPIListeners(&API{Listeners: []APIListener{{Address: "unix://" + socket, ReadOnly: true}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDaemon(&Config{Services: map[string]Service{"web": {Name: "web"}}}, "", "", "")
+	defer d.cancel()
+	go serveAPI(d, listeners[0])
+
+	defer func(host string) { remoteHost = host }(remoteHost)
+	remoteHost = "unix://" + socket
+
+	for _, command := range []string{"list", "status", "events"} {
+		if resp, err := sendIPCRequest(IPCRequest{Command: command}); err != nil || !resp.Success {
+			t.Errorf("expected %s to be answered, got %+v, %v", command, resp, err)
+		}
+	}
+	for _, command := range []string{"restart", "signal", "reload", "attach"} {
+		resp, err := sendIPCRequest(IPCRequest{Command: command, Service: "web"})
+		if err != nil || resp.Success || !strings.Contains(resp.Message, "read-only listener") {
+			t.Errorf("expected %s to be refused, got %+v, %v", command, resp, err)
+		}
+	}
+
+	// Streams on a multiplexed connection are checked too
+	client, err := dialIPCClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if resp, err := client.request(IPCRequest{Command: "restart", Service: "web"}); err != nil || !strings.Contains(resp.Message, "read-only listener") {
+		t.Errorf("expected multiplexed restart to be refused, got %+v, %v", resp, err)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+)
+
+// detachKey is the byte (Ctrl-]) that ends an attach session
+const detachKey = 0x1d
+
+// attachService connects an IPC client to a service's live output, and to
+// its terminal input when the service runs with a PTY. After the initial
+// response the connection carries raw bytes in both directions until the
+// client disconnects or the service exits.
+func (d *Daemon) attachService(conn net.Conn, encoder *json.Encoder, req IPCRequest) {
+	capture, exists := d.getServiceOutput(req.Service)
+	if !exists {
+		response := IPCResponse{Success: false, Message: fmt.Sprintf("Service '%s' not running", req.Service)}
+		if err := encoder.Encode(response); err != nil {
+			slog.Error("Failed to encode IPC response", "error", err)
+		}
+		return
+	}
+
+	message := prepareAttach(capture, req)
+	if err := encoder.Encode(IPCResponse{Success: true, Message: message}); err != nil {
+		return
+	}
+
+	logServiceInfo(req.Service, "Client attached")
+	detach := capture.Attach(conn)
+	defer detach()
+
+	// Forward client input to the terminal, or drop it for output-only sessions
+	inputDone := make(chan struct{})
+	go func() {
+		defer close(inputDone)
+		input := capture.input
+		if input == nil {
+			input = io.Discard
+		}
+		io.Copy(input, conn)
+	}()
+
+	select {
+	case <-inputDone:
+	case <-capture.Done():
+	}
+	logServiceInfo(req.Service, "Client detached")
+}
+
+// prepareAttach sizes a service's terminal to the client's and describes
+// the session about to start
+func prepareAttach(capture *ServiceOutputCapture, req IPCRequest) string {
+	message := fmt.Sprintf("Attached to service '%s'", req.Service)
+	if capture.input == nil {
+		message += " (output only, service has no tty)"
+	} else if req.Rows > 0 && req.Cols > 0 {
+		if master, ok := capture.input.(*os.File); ok {
+			if err := setWinsize(master.Fd(), req.Rows, req.Cols); err != nil {
+				logServiceError(req.Service, "Failed to set pty window size", "error", err)
+			}
+		}
+	}
+	return message
+}
+
+// attachServiceIPC connects the local terminal to a service until the user
+// presses the detach key or the service exits
+func attachServiceIPC(serviceName string) error {
+	conn, err := dialDaemon()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := IPCRequest{Command: "attach", Service: serviceName}
+	stdinFd := os.Stdin.Fd()
+	rows, cols, sizeErr := getWinsize(stdinFd)
+	isTerminal := sizeErr == nil
+	if isTerminal {
+		req.Rows, req.Cols = rows, cols
+	}
+
+	// Only the response is subject to the timeout, not the session
+	if ipcTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(ipcTimeout))
+	}
+	req.Token = apiToken
+	decoder := json.NewDecoder(conn)
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("failed to send request: %v", timeoutError(err))
+	}
+	var response IPCResponse
+	if err := decoder.Decode(&response); err != nil {
+		return fmt.Errorf("failed to decode response: %v", timeoutError(err))
+	}
+	conn.SetDeadline(time.Time{})
+	if !response.Success {
+		return fmt.Errorf("daemon error: %s", response.Message)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s. Press Ctrl-] to detach.\n", response.Message)
+
+	if isTerminal {
+		state, err := makeRaw(stdinFd)
+		if err != nil {
+			return fmt.Errorf("failed to put terminal into raw mode: %v", err)
+		}
+		defer restoreTerminal(stdinFd, state)
+	}
+
+	// Output may already be buffered in the decoder behind the response
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		io.Copy(os.Stdout, io.MultiReader(decoder.Buffered(), conn))
+	}()
+
+	inputDone := make(chan struct{})
+	go func() {
+		defer close(inputDone)
+		buf := make([]byte, 1024)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if n > 0 {
+				data := buf[:n]
+				if i := bytes.IndexByte(data, detachKey); i >= 0 {
+					conn.Write(data[:i])
+					return
+				}
+				if _, err := conn.Write(data); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-outputDone:
+		fmt.Fprintf(os.Stderr, "\r\nConnection closed\r\n")
+	case <-inputDone:
+		fmt.Fprintf(os.Stderr, "\r\nDetached\r\n")
+	}
+	return nil
+}
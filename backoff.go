@@ -0,0 +1,126 @@
+package main
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Restart backoff: a service that keeps crashing waits restart_delay before
+// its first restart, and twice as long before each one after, from at least
+// minRestartBackoff up to restart_delay_max. An instance that stays up for
+// restart_delay_max starts the doubling over. With restart_burst set, no
+// more than that many restarts are made in any restart_window.
+const (
+	minRestartBackoff      = 100 * time.Millisecond
+	defaultRestartDelayMax = time.Minute
+	defaultRestartWindow   = time.Minute
+)
+
+// validateRestartBackoff checks restart_delay, restart_delay_max,
+// restart_burst and restart_window
+func (c *Config) validateRestartBackoff() error {
+	for name, svc := range c.Services {
+		if svc.RestartDelay < 0 {
+			return serviceErrorf(name, "restart_delay", "must not be negative")
+		}
+		if svc.RestartDelayMax < 0 {
+			return serviceErrorf(name, "restart_delay_max", "must not be negative")
+		}
+		if svc.RestartDelayMax > 0 && svc.RestartDelayMax < svc.RestartDelay {
+			return serviceErrorf(name, "restart_delay_max", "must be at least restart_delay (%s)", svc.RestartDelay)
+		}
+		if svc.RestartBurst < 0 {
+			return serviceErrorf(name, "restart_burst", "must not be negative")
+		}
+		if svc.RestartWindow < 0 {
+			return serviceErrorf(name, "restart_window", "must not be negative")
+		}
+		if svc.RestartWindow > 0 && svc.RestartBurst == 0 {
+			return serviceErrorf(name, "restart_window", "needs restart_burst")
+		}
+	}
+	return nil
+}
+
+// restartDelayMax is the longest a service's restart backoff grows to
+func (s Service) restartDelayMax() time.Duration {
+	if s.RestartDelayMax > 0 {
+		return s.RestartDelayMax
+	}
+	return max(defaultRestartDelayMax, s.RestartDelay)
+}
+
+// restartWindow is the span of time restart_burst restarts are allowed in
+func (s Service) restartWindow() time.Duration {
+	if s.RestartWindow > 0 {
+		return s.RestartWindow
+	}
+	return defaultRestartWindow
+}
+
+// restartDelay is how long to wait before restarting a service whose
+// instances have exited attempt times in a row: its backoff plus a random
+// part of its restart_jitter, so services that failed together don't all
+// come back in the same instant
+func restartDelay(svc Service, attempt int) time.Duration {
+	delay := svc.RestartDelay
+	if attempt > 1 {
+		delay = max(delay, minRestartBackoff)
+		for range attempt - 1 {
+			if delay >= svc.restartDelayMax() {
+				break
+			}
+			delay *= 2
+		}
+		delay = min(delay, svc.restartDelayMax())
+	}
+	if svc.RestartJitter <= 0 {
+		return delay
+	}
+	return delay + rand.N(svc.RestartJitter+1)
+}
+
+// scheduleRestart works out when a service that just exited is restarted:
+// after its backoff, and no sooner than restart_burst allows. It records
+// the backoff in the service's status and returns how long to wait.
+func (d *Daemon) scheduleRestart(svc Service) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	status, exists := d.serviceStatus[svc.Name]
+	if !exists {
+		return restartDelay(svc, 1)
+	}
+	if time.Since(status.StartTime) >= svc.restartDelayMax() {
+		status.BackoffAttempt = 0
+	}
+	status.BackoffAttempt++
+	now := time.Now()
+	restartAt := now.Add(restartDelay(svc, status.BackoffAttempt))
+
+	// Only restarts that were carried out inside the window before this one
+	// count, not ones a stop or reload cancelled
+	status.RestartLimited = false
+	if recent := status.recentRestarts; svc.RestartBurst > 0 && len(recent) >= svc.RestartBurst {
+		if oldest := recent[len(recent)-svc.RestartBurst]; restartAt.Sub(oldest) < svc.restartWindow() {
+			restartAt = oldest.Add(svc.restartWindow())
+			status.RestartLimited = true
+		}
+	}
+	status.NextRestart = restartAt
+	return restartAt.Sub(now)
+}
+
+// recordBurst notes when a failure restart was carried out, for
+// restart_burst. Only the last restart_burst of them are needed. Must be
+// called with d.mu held.
+func recordBurst(status *ServiceStatus, req restartRequest, at time.Time) {
+	burst := req.svc.RestartBurst
+	if burst <= 0 || !req.failure() {
+		return
+	}
+	status.recentRestarts = append(status.recentRestarts, at)
+	if len(status.recentRestarts) > burst {
+		status.recentRestarts = status.recentRestarts[len(status.recentRestarts)-burst:]
+	}
+}
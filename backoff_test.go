@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateRestartBackoff(t *testing.T) {
+	tests := []struct {
+		svc  Service
+		want string
+	}{
+		{Service{RestartDelay: time.Second, RestartDelayMax: time.Minute, RestartBurst: 5, RestartWindow: time.Minute}, ""},
+		{Service{RestartDelay: -time.Second}, "restart_delay"},
+		{Service{RestartDelay: time.Minute, RestartDelayMax: time.Second}, "must be at least restart_delay"},
+		{Service{RestartBurst: -1}, "restart_burst"},
+		{Service{RestartWindow: time.Minute}, "needs restart_burst"},
+	}
+
+	for _, tt := range tests {
+		config := &Config{Services: map[string]Service{"web": tt.svc}}
+		err := config.validateRestartBackoff()
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%+v: unexpected error: %v", tt.svc, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: expected error containing %q, got %v", tt.svc, tt.want, err)
+		}
+	}
+}
+
+func TestRestartDelayBacksOff(t *testing.T) {
+	svc := Service{RestartDelay: time.Second, RestartDelayMax: 5 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, delay := range want {
+		if got := restartDelay(svc, i+1); got != delay {
+			t.Errorf("attempt %d: expected %v, got %v", i+1, delay, got)
+		}
+	}
+
+	// Without a restart_delay the first restart is immediate, but a crash
+	// loop still backs off
+	svc = Service{}
+	if got := restartDelay(svc, 1); got != 0 {
+		t.Errorf("expected an immediate first restart, got %v", got)
+	}
+	if got := restartDelay(svc, 3); got != 4*minRestartBackoff {
+		t.Errorf("expected the third restart to wait %v, got %v", 4*minRestartBackoff, got)
+	}
+	if got := restartDelay(svc, 100); got != defaultRestartDelayMax {
+		t.Errorf("expected the backoff to stop at %v, got %v", defaultRestartDelayMax, got)
+	}
+}
+
+func TestScheduleRestart(t *testing.T) {
+	svc := Service{Name: "web", RestartDelay: time.Second, RestartDelayMax: 10 * time.Second, RestartBurst: 2, RestartWindow: time.Hour}
+	d := NewDaemon(&Config{Services: map[string]Service{"web": svc}}, "", "", "")
+	defer d.cancel()
+	d.setState(svc, StateBackoff)
+	d.mu.Lock()
+	d.serviceStatus["web"].StartTime = time.Now()
+	d.mu.Unlock()
+
+	if delay := d.scheduleRestart(svc); delay > time.Second {
+		t.Errorf("expected the first restart after restart_delay, got %v", delay)
+	}
+	if delay := d.scheduleRestart(svc); delay > 2*time.Second || delay < time.Second {
+		t.Errorf("expected the second restart to back off to 2s, got %v", delay)
+	}
+	status, _ := d.getServiceStatus("web")
+	if status.BackoffAttempt != 2 || status.RestartLimited {
+		t.Fatalf("expected two attempts within the burst, got %+v", status)
+	}
+
+	// Restarts only scheduled, then cancelled by a stop or reload, and
+	// restarts nobody asked for because of a failure don't count
+	d.recordRestart(restartRequest{svc: svc, reason: RestartReasonOperator})
+	if delay := d.scheduleRestart(svc); delay > 4*time.Second {
+		t.Errorf("expected restarts that weren't carried out not to count, got %v", delay)
+	}
+
+	// Once two have been carried out in the window, the next waits until the
+	// first leaves it
+	d.recordRestart(restartRequest{svc: svc, reason: RestartReasonCrash})
+	d.recordRestart(restartRequest{svc: svc, reason: RestartReasonFailure})
+	if delay := d.scheduleRestart(svc); delay < 59*time.Minute {
+		t.Errorf("expected restart_burst to hold the next restart back, got %v", delay)
+	}
+	status, _ = d.getServiceStatus("web")
+	if !status.RestartLimited || status.NextRestart.IsZero() || len(status.recentRestarts) != 2 {
+		t.Errorf("expected the held restart in the status, got %+v", status)
+	}
+
+	// An instance that stayed up past restart_delay_max starts over
+	d.mu.Lock()
+	d.serviceStatus["web"].StartTime = time.Now().Add(-time.Minute)
+	d.mu.Unlock()
+	svc.RestartBurst = 0
+	if delay := d.scheduleRestart(svc); delay > time.Second {
+		t.Errorf("expected the backoff to reset, got %v", delay)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BootRecord captures when a single service was started during boot
+type BootRecord struct {
+	Service  string    `json:"service"`
+	Tier     int       `json:"tier"`
+	WaitedOn []string  `json:"waited_on,omitempty"`
+	Start    time.Time `json:"start"`
+	Ready    time.Time `json:"ready"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// BootTimeline records the order and timing of service startup during boot
+type BootTimeline struct {
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	Services []*BootRecord `json:"services"`
+
+	mu sync.Mutex
+}
+
+// NewBootTimeline creates a timeline starting now
+func NewBootTimeline() *BootTimeline {
+	return &BootTimeline{Started: time.Now()}
+}
+
+// begin records that pei has started launching a service
+func (b *BootTimeline) begin(service string, tier int, waitedOn []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.Services = append(b.Services, &BootRecord{
+		Service:  service,
+		Tier:     tier,
+		WaitedOn: waitedOn,
+		Start:    time.Now(),
+	})
+}
+
+// ready records that a service finished starting, or failed to
+func (b *BootTimeline) ready(service string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, record := range b.Services {
+		if record.Service != service {
+			continue
+		}
+		if err != nil {
+			record.Error = err.Error()
+		} else {
+			record.Ready = time.Now()
+		}
+	}
+}
+
+// finish records that every service has been launched
+func (b *BootTimeline) finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.Finished = time.Now()
+}
+
+// snapshot returns a copy of the timeline that is safe to encode
+func (b *BootTimeline) snapshot() *BootTimeline {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	result := &BootTimeline{Started: b.Started, Finished: b.Finished}
+	for _, record := range b.Services {
+		copied := *record
+		result.Services = append(result.Services, &copied)
+	}
+	return result
+}
+
+// blockedBy returns the predecessor that became ready last, which is the one
+// a service actually spent time waiting for
+func (b *BootTimeline) blockedBy(record *BootRecord) *BootRecord {
+	var latest *BootRecord
+	for _, name := range record.WaitedOn {
+		for _, other := range b.Services {
+			if other.Service != name || other.Ready.IsZero() {
+				continue
+			}
+			if latest == nil || other.Ready.After(latest.Ready) {
+				latest = other
+			}
+		}
+	}
+	return latest
+}
+
+// formatOffset formats a boot-relative offset in milliseconds
+func formatOffset(d time.Duration) string {
+	return fmt.Sprintf("+%dms", d.Milliseconds())
+}
+
+func showBootAnalysisIPC() error {
+	resp, err := sendIPCRequest(IPCRequest{Command: "boot-analyze"})
+	if err != nil {
+		return err
+	}
+
+	if !resp.Success {
+		return fmt.Errorf("daemon error: %s", resp.Message)
+	}
+
+	if resp.Boot == nil || len(resp.Boot.Services) == 0 {
+		fmt.Println("No boot timeline recorded")
+		return nil
+	}
+
+	showBootAnalysis(resp.Boot)
+	return nil
+}
+
+// showBootAnalysis prints a waterfall of service startup
+func showBootAnalysis(boot *BootTimeline) {
+	const barWidth = 40
+
+	end := boot.Finished
+	for _, record := range boot.Services {
+		if record.Ready.After(end) {
+			end = record.Ready
+		}
+	}
+	total := end.Sub(boot.Started)
+
+	fmt.Printf("Boot started %s, all services launched in %dms\n\n",
+		boot.Started.Format(time.RFC3339), total.Milliseconds())
+
+	fmt.Printf("%-20s %-8s %-8s %-10s %-*s %s\n", "NAME", "START", "READY", "DURATION", barWidth, "TIMELINE", "BLOCKED ON")
+	fmt.Printf("%-20s %-8s %-8s %-10s %-*s %s\n", "----", "-----", "-----", "--------", barWidth, "--------", "----------")
+
+	for _, record := range boot.Services {
+		startOffset := record.Start.Sub(boot.Started)
+		readyStr := "failed"
+		durationStr := "-"
+		readyOffset := total
+		if !record.Ready.IsZero() {
+			readyOffset = record.Ready.Sub(boot.Started)
+			readyStr = formatOffset(readyOffset)
+			durationStr = fmt.Sprintf("%dms", record.Ready.Sub(record.Start).Milliseconds())
+		}
+
+		// Scale the bar to the total boot time, always drawing at least one cell
+		first, last := 0, 0
+		if total > 0 {
+			first = int(int64(barWidth-1) * int64(startOffset) / int64(total))
+			last = int(int64(barWidth-1) * int64(readyOffset) / int64(total))
+		}
+		bar := strings.Repeat(" ", first) + strings.Repeat("#", last-first+1)
+
+		blockedStr := "-"
+		if blocker := boot.blockedBy(record); blocker != nil {
+			blockedStr = fmt.Sprintf("%s (ready %s)", blocker.Service, formatOffset(blocker.Ready.Sub(boot.Started)))
+		}
+
+		fmt.Printf("%-20s %-8s %-8s %-10s %-*s %s\n",
+			record.Service, formatOffset(startOffset), readyStr, durationStr, barWidth, bar, blockedStr)
+		if record.Error != "" {
+			fmt.Printf("  error: %s\n", record.Error)
+		}
+	}
+}
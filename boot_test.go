@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBootTimeline(t *testing.T) {
+	boot := NewBootTimeline()
+	boot.begin("db", 0, nil)
+	boot.begin("cache", 0, nil)
+	boot.begin("broken", 0, nil)
+	boot.ready("db", nil)
+	boot.ready("broken", errors.New("exit status 1"))
+	boot.ready("cache", nil)
+	boot.begin("web", 1, []string{"db", "cache", "broken"})
+	boot.finish()
+
+	records := make(map[string]*BootRecord)
+	for _, record := range boot.Services {
+		records[record.Service] = record
+	}
+	if record := records["broken"]; record.Error != "exit status 1" || !record.Ready.IsZero() {
+		t.Errorf("expected a failed start recorded without a ready time, got %+v", record)
+	}
+	if record := records["db"]; record.Ready.Before(record.Start) || record.Error != "" {
+		t.Errorf("expected db ready after it started, got %+v", record)
+	}
+	if boot.Finished.Before(records["web"].Start) {
+		t.Error("expected boot to finish after the last service started")
+	}
+
+	// web waited on the predecessor that became ready last, not the failed one
+	if blocker := boot.blockedBy(records["web"]); blocker == nil || blocker.Service != "cache" {
+		t.Errorf("expected web blocked on cache, got %+v", blocker)
+	}
+	if blocker := boot.blockedBy(records["db"]); blocker != nil {
+		t.Errorf("expected db blocked on nothing, got %+v", blocker)
+	}
+
+	// Snapshots don't change as the timeline does
+	snapshot := boot.snapshot()
+	boot.ready("web", nil)
+	if len(snapshot.Services) != 4 || !snapshot.Services[3].Ready.IsZero() {
+		t.Errorf("expected the snapshot unchanged, got %+v", snapshot.Services[3])
+	}
+}
+
+func TestFormatOffset(t *testing.T) {
+	if got := formatOffset(1500 * time.Millisecond); got != "+1500ms" {
+		t.Errorf("expected +1500ms, got %s", got)
+	}
+}
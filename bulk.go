@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// bulkCommands can act on several services in one request
+var bulkCommands = map[string]bool{"restart": true, "start": true, "stop": true, "kill": true, "signal": true}
+
+// bulkStopCommands take services down, so they act on dependents first
+var bulkStopCommands = map[string]bool{"stop": true, "kill": true}
+
+// isBulkRequest reports whether a request names its services with Services,
+// All or Labels rather than a single Service
+func isBulkRequest(req IPCRequest) bool {
+	return bulkCommands[req.Command] && (req.All || len(req.Services) > 0 || len(req.Labels) > 0)
+}
+
+// bulkTargets resolves the services a bulk request acts on: those it names,
+// or every service with All, narrowed to those carrying all its Labels. They
+// are in start order, or in reverse for commands that stop services, so a
+// service is never restarted before what it's ordered after, nor stopped
+// before what's ordered after it.
+func (d *Daemon) bulkTargets(req IPCRequest) ([]string, error) {
+	config := d.getConfig()
+	names := slices.Clone(req.Services)
+	if req.Service != "" {
+		names = append(names, req.Service)
+	}
+	if req.All || len(names) == 0 {
+		names = slices.Collect(maps.Keys(config.Services))
+	}
+	for _, name := range names {
+		if _, exists := config.Services[name]; !exists {
+			return nil, fmt.Errorf("Service '%s' not found", name)
+		}
+	}
+
+	order, err := startOrder(config.Services)
+	if err != nil {
+		return nil, err
+	}
+	if bulkStopCommands[req.Command] {
+		slices.Reverse(order)
+	}
+	var targets []string
+	for _, name := range order {
+		if !slices.Contains(names, name) || !hasLabels(config.Services[name], req.Labels) {
+			continue
+		}
+		// Services picked by selection rather than by name are only
+		// stopped or signalled if there is something to stop or signal,
+		// and only started if an operator stopped them
+		if len(req.Services) == 0 && req.Service == "" {
+			switch req.Command {
+			case "restart":
+			case "start":
+				if d.serviceState(name) != StateDisabled {
+					continue
+				}
+			default:
+				if proc, exists := d.getServiceProcess(name); !exists || !proc.running() {
+					continue
+				}
+			}
+		}
+		targets = append(targets, name)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no services match")
+	}
+	return targets, nil
+}
+
+// hasLabels reports whether a service carries every one of labels
+func hasLabels(svc Service, labels map[string]string) bool {
+	for name, value := range labels {
+		if svc.Labels[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// handleBulkCommand carries out a command for each service a bulk request
+// selects, one after another, and reports each one's outcome. It fails if
+// any of them did.
+func handleBulkCommand(ctx context.Context, daemon *Daemon, req IPCRequest) IPCResponse {
+	targets, err := daemon.bulkTargets(req)
+	if err != nil {
+		return IPCResponse{Success: false, Message: err.Error()}
+	}
+
+	response := IPCResponse{Success: true}
+	var messages []string
+	for _, name := range targets {
+		single := req
+		single.Service, single.Services, single.All, single.Labels = name, nil, false, nil
+		result := handleCommand(ctx, daemon, single)
+		if !result.Success {
+			response.Success = false
+			result.Message = fmt.Sprintf("%s: %s", name, result.Message)
+		}
+		messages = append(messages, result.Message)
+	}
+	response.Message = strings.Join(messages, "\n")
+	return response
+}
+
+// bulkSelection holds the flags that select services for a bulk command
+type bulkSelection struct {
+	all    bool
+	labels map[string]string
+}
+
+// addBulkFlags registers --all and --label on a command's flags, and --group
+// as shorthand for --label group=name unless the command already has a
+// --group of its own, as signal does
+func addBulkFlags(fs *flag.FlagSet) *bulkSelection {
+	sel := &bulkSelection{}
+	fs.BoolVar(&sel.all, "all", false, "act on every service")
+	addLabel := func(name, value string) {
+		if sel.labels == nil {
+			sel.labels = make(map[string]string)
+		}
+		sel.labels[name] = value
+	}
+	fs.Func("label", "only act on services with this `name=value` label (repeatable)", func(v string) error {
+		name, value, found := strings.Cut(v, "=")
+		if !found || name == "" {
+			return fmt.Errorf("expected name=value")
+		}
+		addLabel(name, value)
+		return nil
+	})
+	if fs.Lookup("group") == nil {
+		fs.Func("group", "only act on services labelled group=`name`", func(v string) error {
+			addLabel("group", v)
+			return nil
+		})
+	}
+	return sel
+}
+
+// request builds the request for a command on the services named, or those
+// the flags select
+func (sel *bulkSelection) request(command string, services []string) (IPCRequest, error) {
+	req := IPCRequest{Command: command, All: sel.all, Labels: sel.labels}
+	switch {
+	case len(services) > 0 && sel.all:
+		return req, fmt.Errorf("%s takes service names or --all, not both", command)
+	case len(services) == 0 && !sel.all && len(sel.labels) == 0:
+		return req, fmt.Errorf("%s command requires a service name, --all or --label", command)
+	case len(services) == 1 && len(sel.labels) == 0:
+		req.Service = services[0]
+	default:
+		req.Services = services
+	}
+	return req, nil
+}
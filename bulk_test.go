@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBulkTargets(t *testing.T) {
+	config := &Config{Services: map[string]Service{
+		"db":     {Name: "db", Labels: map[string]string{"group": "data"}},
+		"cache":  {Name: "cache", Labels: map[string]string{"group": "data"}, After: []string{"db"}},
+		"web":    {Name: "web", After: []string{"cache"}},
+		"worker": {Name: "worker", After: []string{"db"}},
+	}}
+	d := NewDaemon(config, "", "", "")
+	defer d.cancel()
+
+	cases := []struct {
+		req  IPCRequest
+		want []string
+	}{
+		{IPCRequest{Command: "restart", All: true}, []string{"db", "cache", "worker", "web"}},
+		{IPCRequest{Command: "restart", Services: []string{"web", "db"}}, []string{"db", "web"}},
+		{IPCRequest{Command: "restart", Labels: map[string]string{"group": "data"}}, []string{"db", "cache"}},
+		{IPCRequest{Command: "restart", All: true, Labels: map[string]string{"group": "none"}}, nil},
+	}
+	for _, c := range cases {
+		targets, err := d.bulkTargets(c.req)
+		if c.want == nil {
+			if err == nil {
+				t.Errorf("%+v: expected no services to match, got %v", c.req, targets)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(targets, c.want) {
+			t.Errorf("%+v: expected %v, got %v (%v)", c.req, c.want, targets, err)
+		}
+	}
+
+	// Stops go in reverse, so dependents stop first
+	targets, err := d.bulkTargets(IPCRequest{Command: "stop", Services: []string{"db", "cache", "web"}})
+	if err != nil || !slices.Equal(targets, []string{"web", "cache", "db"}) {
+		t.Errorf("expected stop order web, cache, db, got %v (%v)", targets, err)
+	}
+
+	// Selected services that aren't running have nothing to stop
+	if _, err := d.bulkTargets(IPCRequest{Command: "stop", All: true}); err == nil {
+		t.Error("expected no running services to match")
+	}
+
+	// Selected services are only started if an operator stopped them
+	d.setState(config.Services["worker"], StateDisabled)
+	d.setState(config.Services["web"], StateFailed)
+	targets, err = d.bulkTargets(IPCRequest{Command: "start", All: true})
+	if err != nil || !slices.Equal(targets, []string{"worker"}) {
+		t.Errorf("expected to start worker, got %v (%v)", targets, err)
+	}
+
+	if _, err := d.bulkTargets(IPCRequest{Command: "restart", Services: []string{"web", "nope"}}); err == nil || !strings.Contains(err.Error(), "nope") {
+		t.Errorf("expected an unknown service to be rejected, got %v", err)
+	}
+}
+
+func TestBulkSelectionRequest(t *testing.T) {
+	fs := flag.NewFlagSet("stop", flag.ContinueOnError)
+	sel := addBulkFlags(fs)
+	if err := fs.Parse([]string{"--group", "data", "--label", "tier=1"}); err != nil {
+		t.Fatal(err)
+	}
+	req, err := sel.request("stop", nil)
+	if err != nil || req.Labels["group"] != "data" || req.Labels["tier"] != "1" || !isBulkRequest(req) {
+		t.Errorf("expected a request for labels group=data and tier=1, got %+v (%v)", req, err)
+	}
+
+	// A single service name is an ordinary request
+	req, err = (&bulkSelection{}).request("stop", []string{"web"})
+	if err != nil || req.Service != "web" || isBulkRequest(req) {
+		t.Errorf("expected a request for web alone, got %+v (%v)", req, err)
+	}
+
+	if _, err := (&bulkSelection{all: true}).request("stop", []string{"web"}); err == nil {
+		t.Error("expected names and --all together to be rejected")
+	}
+	if _, err := (&bulkSelection{}).request("stop", nil); err == nil {
+		t.Error("expected a request without services to be rejected")
+	}
+
+	// signal keeps --group for the process group
+	fs = flag.NewFlagSet("signal", flag.ContinueOnError)
+	group := fs.Bool("group", false, "")
+	addBulkFlags(fs)
+	if err := fs.Parse([]string{"--group"}); err != nil || !*group {
+		t.Errorf("expected signal's own --group to be kept, got %v", err)
+	}
+}
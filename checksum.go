@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Verify checks a service's binary against a detached signature, as made by
+// cosign sign-blob --key
+type Verify struct {
+	Key       string `yaml:"key"`       // PEM public key: ECDSA, RSA or Ed25519
+	Signature string `yaml:"signature"` // base64 signature of the binary
+}
+
+// validateChecksums checks checksum and verify
+func (c *Config) validateChecksums() error {
+	for name, svc := range c.Services {
+		if svc.Checksum != "" {
+			digest, found := strings.CutPrefix(svc.Checksum, "sha256:")
+			if _, err := hex.DecodeString(digest); !found || err != nil || len(digest) != 2*sha256.Size {
+				return serviceErrorf(name, "checksum", "must be sha256: followed by 64 hex digits")
+			}
+		}
+		if svc.Verify != nil {
+			path := []string{"services", name, "verify"}
+			for _, field := range [][2]string{{"key", svc.Verify.Key}, {"signature", svc.Verify.Signature}} {
+				if field[1] == "" {
+					return fieldErrorf(append(path, field[0]), "is required")
+				}
+				if !filepath.IsAbs(field[1]) {
+					return fieldErrorf(append(path, field[0]), "must be an absolute path")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// verifyCommand refuses a service's binary if it doesn't match its checksum
+// or signature. The binary is read again before every start, so one replaced
+// on a volume while pei runs is caught at its next restart.
+func verifyCommand(svc Service) error {
+	if svc.Checksum == "" && svc.Verify == nil {
+		return nil
+	}
+	path, err := resolveCommand(svc)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return fmt.Errorf("reading %s: %v", path, err)
+	}
+	digest := hash.Sum(nil)
+
+	if svc.Checksum != "" {
+		if actual := "sha256:" + hex.EncodeToString(digest); actual != strings.ToLower(svc.Checksum) {
+			return fmt.Errorf("%s does not match its checksum: got %s", path, actual)
+		}
+	}
+	if svc.Verify != nil {
+		if err := svc.Verify.check(path, digest); err != nil {
+			return fmt.Errorf("%s failed signature verification: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// check verifies the signature of a binary whose SHA-256 digest is given
+func (v *Verify) check(path string, digest []byte) error {
+	keyPEM, err := os.ReadFile(v.Key)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return fmt.Errorf("%s is not a PEM public key", v.Key)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %v", v.Key, err)
+	}
+
+	encoded, err := os.ReadFile(v.Signature)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+	if err != nil {
+		return fmt.Errorf("%s is not a base64 signature: %v", v.Signature, err)
+	}
+
+	valid := false
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(key, digest, sig)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, sig) == nil
+	case ed25519.PublicKey:
+		// Ed25519 signs the whole message rather than a digest
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		valid = ed25519.Verify(key, data, sig)
+	default:
+		return fmt.Errorf("unsupported key type %T in %s", key, v.Key)
+	}
+	if !valid {
+		return fmt.Errorf("signature %s does not match key %s", v.Signature, v.Key)
+	}
+	return nil
+}
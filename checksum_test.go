@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyCommand(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "web")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\nexit 0\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("#!/bin/sh\nexit 0\n"))
+
+	svc := Service{Name: "web", Command: []string{binary}, Checksum: "sha256:" + hex.EncodeToString(digest[:])}
+	if err := verifyCommand(svc); err != nil {
+		t.Errorf("expected the checksum to match, got %v", err)
+	}
+	svc.Checksum = "sha256:" + strings.Repeat("0", 64)
+	if err := verifyCommand(svc); err == nil || !strings.Contains(err.Error(), "does not match its checksum") {
+		t.Errorf("expected a checksum mismatch, got %v", err)
+	}
+
+	// A signature made with cosign sign-blob --key
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	keyPath := filepath.Join(dir, "cosign.pub")
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}), 0o644)
+	sig, _ := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	sigPath := filepath.Join(dir, "web.sig")
+	os.WriteFile(sigPath, []byte(base64.StdEncoding.EncodeToString(sig)+"\n"), 0o644)
+
+	svc = Service{Name: "web", Command: []string{binary}, Verify: &Verify{Key: keyPath, Signature: sigPath}}
+	if err := verifyCommand(svc); err != nil {
+		t.Errorf("expected the signature to verify, got %v", err)
+	}
+	os.WriteFile(binary, []byte("#!/bin/sh\nexit 1\n"), 0o755)
+	if err := verifyCommand(svc); err == nil || !strings.Contains(err.Error(), "failed signature verification") {
+		t.Errorf("expected a tampered binary to fail verification, got %v", err)
+	}
+}
+
+func TestValidateChecksums(t *testing.T) {
+	cases := []struct {
+		svc Service
+		err string
+	}{
+		{Service{Checksum: "abc"}, "must be sha256:"},
+		{Service{Checksum: "sha256:" + strings.Repeat("z", 64)}, "must be sha256:"},
+		{Service{Verify: &Verify{Signature: "/app/web.sig"}}, "verify.key: is required"},
+		{Service{Verify: &Verify{Key: "/etc/cosign.pub", Signature: "web.sig"}}, "must be an absolute path"},
+	}
+	for _, c := range cases {
+		config := &Config{Services: map[string]Service{"web": c.svc}}
+		if err := config.validateChecksums(); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%+v: expected error containing %q, got %v", c.svc, c.err, err)
+		}
+	}
+}
@@ -1,29 +1,67 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"time"
 )
 
-func formatUptime(startTime time.Time) string {
-	uptime := time.Since(startTime)
+func formatUptime(uptime time.Duration) string {
 	if uptime.Hours() >= 24 {
 		days := int(uptime.Hours() / 24)
 		hours := int(uptime.Hours()) % 24
 		return fmt.Sprintf("%dd%dh", days, hours)
 	} else if uptime.Hours() >= 1 {
-		return fmt.Sprintf("%.0fh%.0fm", uptime.Hours(), uptime.Minutes())
+		return fmt.Sprintf("%dh%dm", int(uptime.Hours()), int(uptime.Minutes())%60)
 	} else if uptime.Minutes() >= 1 {
-		return fmt.Sprintf("%.0fm", uptime.Minutes())
+		return fmt.Sprintf("%dm", int(uptime.Minutes()))
 	} else {
-		return fmt.Sprintf("%.0fs", uptime.Seconds())
+		return fmt.Sprintf("%ds", int(uptime.Seconds()))
 	}
 }
 
-func listServicesIPC() error {
-	resp, err := sendIPCRequest(IPCRequest{Command: "list"})
+// nextRestartSummary describes when a service that isn't running will next
+// be started, such as "restarting in 12s (attempt 4)"
+func nextRestartSummary(status *ServiceStatus) string {
+	in := formatUptime(status.RestartIn.Round(time.Second))
+	if status.State == StateBackoff {
+		return fmt.Sprintf("restarting in %s (attempt %d)", in, status.Restarts)
+	}
+	return fmt.Sprintf("next run in %s", in)
+}
+
+// backoffSummary describes a service's pending restart, such as
+// "2026-01-02T15:04:05Z (exited 3 times in a row, held back by restart_burst)"
+func backoffSummary(status *ServiceStatus) string {
+	summary := status.NextRestart.Format(time.RFC3339)
+	var notes []string
+	if status.BackoffAttempt > 1 {
+		notes = append(notes, fmt.Sprintf("exited %d times in a row", status.BackoffAttempt))
+	}
+	if status.RestartLimited {
+		notes = append(notes, "held back by restart_burst")
+	}
+	if len(notes) > 0 {
+		summary += " (" + strings.Join(notes, ", ") + ")"
+	}
+	return summary
+}
+
+// listOptions are pei list's -o and --sort
+type listOptions struct {
+	columns string
+	sortBy  string
+}
+
+func listServicesIPC(opts listOptions) error {
+	columns, err := parseListColumns(opts.columns)
+	if err != nil {
+		return err
+	}
+	resp, err := sendIPCRequest(IPCRequest{Command: "list", Usage: needsUsage(columns)})
 	if err != nil {
 		return err
 	}
@@ -32,23 +70,15 @@ func listServicesIPC() error {
 		return fmt.Errorf("daemon error: %s", resp.Message)
 	}
 
-	fmt.Printf("%-20s %-10s %-8s %-12s %-10s\n", "NAME", "STATUS", "PID", "RESTARTS", "UPTIME")
-	fmt.Printf("%-20s %-10s %-8s %-12s %-10s\n", "----", "------", "---", "--------", "------")
-
+	statuses := make([]*ServiceStatus, 0, len(resp.Services))
 	for name, status := range resp.Services {
-		statusStr := "stopped"
-		pidStr := "-"
-		uptimeStr := "-"
-
-		if status.Running {
-			statusStr = "running"
-			pidStr = fmt.Sprintf("%d", status.PID)
-			uptimeStr = formatUptime(status.StartTime)
-		}
-
-		fmt.Printf("%-20s %-10s %-8s %-12d %-10s\n", name, statusStr, pidStr, status.Restarts, uptimeStr)
+		status.Name = name
+		statuses = append(statuses, status)
 	}
-
+	if err := sortServiceStatus(statuses, opts.sortBy); err != nil {
+		return err
+	}
+	writeServiceTable(os.Stdout, statuses, columns)
 	return nil
 }
 
@@ -73,21 +103,76 @@ func showServiceStatusIPC(serviceName string) error {
 
 	if serviceName == "" {
 		// Show all services
-		return listServicesIPC()
+		return listServicesIPC(listOptions{})
 	}
 
 	if resp.Service != nil {
 		status := resp.Service
 		fmt.Printf("Service: %s\n", status.Name)
 
-		if status.Running {
-			fmt.Printf("Status: running\n")
+		if !status.NextRestart.IsZero() {
+			fmt.Printf("Status: %s, %s\n", status.State, nextRestartSummary(status))
+		} else {
+			fmt.Printf("Status: %s\n", status.State)
+		}
+		if status.State == StateBackoff && !status.NextRestart.IsZero() {
+			fmt.Printf("Next restart: %s\n", backoffSummary(status))
+		}
+		if status.Running && status.PID == 0 {
+			fmt.Printf("Connections: %d\n", status.Connections)
+			fmt.Printf("Started: %s\n", status.StartTime.Format(time.RFC3339))
+			fmt.Printf("Uptime: %s\n", formatUptime(status.Uptime))
+		} else if status.Running {
 			fmt.Printf("PID: %d\n", status.PID)
 			fmt.Printf("Started: %s\n", status.StartTime.Format(time.RFC3339))
-			fmt.Printf("Uptime: %s\n", formatUptime(status.StartTime))
+			fmt.Printf("Uptime: %s\n", formatUptime(status.Uptime))
 			fmt.Printf("Restarts: %d\n", status.Restarts)
-		} else {
-			fmt.Printf("Status: stopped\n")
+		}
+		if health := status.Health; health != nil {
+			state := health.Status
+			if state == "" {
+				state = "not yet decided"
+			}
+			fmt.Printf("Health: %s (pid %d checked %s ago in %s, %d of %d checks failed)\n", state, health.PID,
+				formatUptime(time.Since(health.LastCheck)), health.LastLatency.Round(time.Millisecond), health.Failures, health.Checks)
+			if health.LastError != "" {
+				fmt.Printf("Last health check error: %s (%d in a row)\n", health.LastError, health.ConsecutiveFailures)
+			}
+		}
+
+		if crash := status.LastCrash; crash != nil {
+			fmt.Printf("Last crash: %s, pid %d: %s\n", crash.Time.Format(time.RFC3339), crash.PID, crash.Error)
+			for _, line := range crash.Output {
+				fmt.Printf("  %s %-6s %s\n", line.Time.Format("15:04:05"), line.Stream, line.Text)
+			}
+		}
+
+		if len(status.Labels) > 0 {
+			var labels []string
+			for name, value := range status.Labels {
+				labels = append(labels, name+"="+value)
+			}
+			sort.Strings(labels)
+			fmt.Printf("Labels: %s\n", strings.Join(labels, ", "))
+		}
+
+		if len(status.RestartHistory) > 0 {
+			fmt.Printf("Last restart reason: %s\n", status.LastRestartReason)
+			fmt.Printf("Restart history:\n")
+			for _, record := range status.RestartHistory {
+				line := fmt.Sprintf("  %s  %s", record.Time.Format(time.RFC3339), record.Reason)
+				if record.Detail != "" {
+					line += fmt.Sprintf(" (%s)", record.Detail)
+				}
+				if len(record.Coalesced) > 0 {
+					reasons := make([]string, len(record.Coalesced))
+					for i, reason := range record.Coalesced {
+						reasons[i] = string(reason)
+					}
+					line += fmt.Sprintf(", also %s", strings.Join(reasons, ", "))
+				}
+				fmt.Println(line)
+			}
 		}
 	}
 
@@ -114,10 +199,27 @@ func showServiceStatus(config *Config, serviceName string) {
 	fmt.Printf("Status: stopped\n")
 }
 
+// parseCommandFlags parses flags for a subcommand, allowing them to appear
+// before or after its positional arguments, and returns the positional ones
+func parseCommandFlags(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			os.Exit(2)
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
 func handleCLICommands(configPath *string, args []string) bool {
 	// If no arguments provided, try to default to listing services from daemon
 	if len(args) == 0 {
-		if err := listServicesIPC(); err == nil {
+		if err := listServicesIPC(listOptions{}); err == nil {
 			// Successfully connected to daemon and listed services
 			return true
 		}
@@ -129,7 +231,24 @@ func handleCLICommands(configPath *string, args []string) bool {
 
 	switch command {
 	case "list":
-		if err := listServicesIPC(); err != nil {
+		fs := flag.NewFlagSet("list", flag.ExitOnError)
+		var opts listOptions
+		fs.StringVar(&opts.columns, "o", "", "columns to show, comma-separated (name,state,pid,restarts,uptime,cpu,mem,fds,health,reason,labels), or wide")
+		fs.StringVar(&opts.sortBy, "sort", "name", "column to sort by; numeric columns sort highest first")
+		parseCommandFlags(fs, args[1:])
+		if _, err := parseListColumns(opts.columns); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := sortServiceStatus(nil, opts.sortBy); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := listServicesIPC(opts); err != nil {
+			if remoteHost != "" {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
 			// Fallback to config-based listing if daemon is not running
 			config, configErr := loadConfig(*configPath)
 			if configErr != nil {
@@ -152,6 +271,10 @@ func handleCLICommands(configPath *string, args []string) bool {
 				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 				os.Exit(1)
 			}
+			if remoteHost != "" {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
 			// Fallback to config-based status if daemon is not running
 			config, configErr := loadConfig(*configPath)
 			if configErr != nil {
@@ -163,13 +286,17 @@ func handleCLICommands(configPath *string, args []string) bool {
 		return true
 
 	case "restart":
-		if len(args) < 2 {
-			fmt.Fprintf(os.Stderr, "Error: restart command requires a service name\n")
+		fs := flag.NewFlagSet("restart", flag.ExitOnError)
+		selection := addBulkFlags(fs)
+		reason := fs.String("reason", "", "why the service is being restarted, kept in its restart history and events")
+		req, err := selection.request("restart", parseCommandFlags(fs, args[1:]))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
-		serviceName := args[1]
+		req.Reason = *reason
 
-		resp, err := sendIPCRequest(IPCRequest{Command: "restart", Service: serviceName})
+		resp, err := sendIPCRequest(req)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error: No pei daemon running - cannot restart service\n")
 			os.Exit(1)
@@ -182,20 +309,82 @@ func handleCLICommands(configPath *string, args []string) bool {
 		}
 		return true
 
+	case "start", "stop", "kill":
+		fs := flag.NewFlagSet(command, flag.ExitOnError)
+		selection := addBulkFlags(fs)
+		force := false
+		if command == "stop" {
+			fs.BoolVar(&force, "force", false, "kill the service's process group at once instead of stopping it gracefully")
+		}
+		req, err := selection.request(command, parseCommandFlags(fs, args[1:]))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		req.Force = force
+
+		resp, err := sendIPCRequest(req)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: No pei daemon running - cannot %s service\n", command)
+			os.Exit(1)
+		}
+		if resp.Success {
+			fmt.Println(resp.Message)
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: %s failed: %s\n", strings.ToUpper(command[:1])+command[1:], resp.Message)
+			os.Exit(1)
+		}
+		return true
+
+	case "reload":
+		fs := flag.NewFlagSet("reload", flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", false, "show what would change without changing it")
+		parseCommandFlags(fs, args[1:])
+		if err := reloadConfigIPC(*dryRun); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+
 	case "signal":
-		if len(args) < 2 {
+		fs := flag.NewFlagSet("signal", flag.ExitOnError)
+		group := fs.Bool("group", false, "signal the service's whole process group")
+		selection := addBulkFlags(fs)
+		positional := parseCommandFlags(fs, args[1:])
+		if len(positional) < 1 {
 			fmt.Fprintf(os.Stderr, "Error: signal command requires service:signal format (e.g., echo:HUP)\n")
 			os.Exit(1)
 		}
-		signalArg := args[1]
 
-		parts := strings.Split(signalArg, ":")
-		if len(parts) != 2 {
-			fmt.Fprintf(os.Stderr, "Error: Signal format should be service:signal (e.g., echo:HUP)\n")
+		// Services picked with --all or --label get the one signal given;
+		// named ones are each service:signal, all with the same signal
+		var services []string
+		signalName := ""
+		for _, arg := range positional {
+			service, sig, found := strings.Cut(arg, ":")
+			if !found && (selection.all || len(selection.labels) > 0) && len(positional) == 1 {
+				service, sig = "", arg
+			} else if !found || service == "" || sig == "" {
+				fmt.Fprintf(os.Stderr, "Error: Signal format should be service:signal (e.g., echo:HUP)\n")
+				os.Exit(1)
+			}
+			if signalName != "" && sig != signalName {
+				fmt.Fprintf(os.Stderr, "Error: all services must be sent the same signal\n")
+				os.Exit(1)
+			}
+			signalName = sig
+			if service != "" {
+				services = append(services, service)
+			}
+		}
+		req, err := selection.request("signal", services)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
+		req.Signal, req.Group = signalName, *group
 
-		resp, err := sendIPCRequest(IPCRequest{Command: "signal", Service: parts[0], Signal: parts[1]})
+		resp, err := sendIPCRequest(req)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error: No pei daemon running - cannot send signal to service\n")
 			os.Exit(1)
@@ -208,6 +397,200 @@ func handleCLICommands(configPath *string, args []string) bool {
 		}
 		return true
 
+	case dialStdioCommand:
+		if err := dialStdio(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+
+	case sandboxExecCommand:
+		if err := runSandboxExec(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "pei %s: %v\n", sandboxExecCommand, err)
+			os.Exit(1)
+		}
+		return true
+
+	case "diff":
+		if err := showConfigDiff(*configPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+
+	case "version":
+		showVersion(os.Stdout)
+		return true
+
+	case "metrics":
+		if err := showMetricsIPC(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+
+	case "debug":
+		if len(args) < 2 || args[1] != "dump" {
+			fmt.Fprintf(os.Stderr, "Error: debug command requires a subcommand: dump\n")
+			os.Exit(1)
+		}
+		if err := debugDumpIPC(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+
+	case "snapshot":
+		if err := snapshotIPC(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+
+	case "plan":
+		config, err := loadConfig(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to load config: %v\n", err)
+			os.Exit(1)
+		}
+		if problems := showPlan(config, *configPath); problems > 0 {
+			os.Exit(1)
+		}
+		return true
+
+	case "graph":
+		fs := flag.NewFlagSet("graph", flag.ExitOnError)
+		format := fs.String("format", "dot", "output format: dot or ascii")
+		parseCommandFlags(fs, args[1:])
+		config, err := loadConfig(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to load config: %v\n", err)
+			os.Exit(1)
+		}
+		if err := showGraph(config, *format, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+
+	case "doctor":
+		config, err := loadConfig(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to load config: %v\n", err)
+			os.Exit(1)
+		}
+		appUser, appGroup := appUserGroup()
+		if failures := runDoctor(config, appUser, appGroup); failures > 0 {
+			os.Exit(1)
+		}
+		return true
+
+	case "config":
+		if len(args) < 2 || args[1] != "render" {
+			fmt.Fprintf(os.Stderr, "Error: config command requires a subcommand: render\n")
+			os.Exit(1)
+		}
+		config, err := loadConfig(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to load config: %v\n", err)
+			os.Exit(1)
+		}
+		if err := renderConfig(config); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to render config: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+
+	case "test":
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "Error: test command requires a test file\n")
+			os.Exit(1)
+		}
+		if err := runConfigTests(*configPath, args[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+
+	case "schema":
+		if err := printSchema(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to write schema: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+
+	case "run":
+		if len(args) != 2 {
+			fmt.Fprintf(os.Stderr, "Error: run command requires a oneshot service name\n")
+			os.Exit(1)
+		}
+		code, err := runIPC(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		os.Exit(code)
+		return true
+
+	case "attach":
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "Error: attach command requires a service name\n")
+			os.Exit(1)
+		}
+		if err := attachServiceIPC(args[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+
+	case "boot-analyze":
+		if err := showBootAnalysisIPC(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+
+	case "logs":
+		fs := flag.NewFlagSet("logs", flag.ExitOnError)
+		lines := fs.Int("n", 100, "number of recent lines to show (0 for all kept)")
+		positional := parseCommandFlags(fs, args[1:])
+		if len(positional) < 1 {
+			fmt.Fprintf(os.Stderr, "Error: logs command requires a service name\n")
+			os.Exit(1)
+		}
+		if err := showLogsIPC(positional[0], *lines); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+
+	case "tail":
+		fs := flag.NewFlagSet("tail", flag.ExitOnError)
+		follow := fs.Bool("f", false, "keep following new output")
+		lines := fs.Int("n", 10, "number of recent lines to show first")
+		stream := fs.String("stream", "", "only show one stream: stdout, stderr or tty")
+		level := fs.String("level", "", "only show lines at or above a level: debug, info, warn or error")
+		services := parseCommandFlags(fs, args[1:])
+		if err := tailLogsIPC(services, *lines, *follow, *stream, *level); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+
+	case "events":
+		fs := flag.NewFlagSet("events", flag.ExitOnError)
+		limit := fs.Int("limit", 0, "show only the most recent events")
+		follow := fs.Bool("f", false, "keep following new events")
+		positional := parseCommandFlags(fs, args[1:])
+		serviceName := ""
+		if len(positional) > 0 {
+			serviceName = positional[0]
+		}
+		if err := showEventsIPC(serviceName, *limit, *follow); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+
 	default:
 		fmt.Printf("Unknown command: %s\n", command)
 		fmt.Println("Run 'pei help' for usage information")
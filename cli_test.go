@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFormatUptime(t *testing.T) {
+	tests := []struct {
+		uptime time.Duration
+		want   string
+	}{
+		{42 * time.Second, "42s"},
+		{59*time.Minute + 40*time.Second, "59m"},
+		{2*time.Hour + 5*time.Minute, "2h5m"},
+		{26 * time.Hour, "1d2h"},
+	}
+
+	for _, tt := range tests {
+		if got := formatUptime(tt.uptime); got != tt.want {
+			t.Errorf("formatUptime(%v) = %s, want %s", tt.uptime, got, tt.want)
+		}
+	}
+}
+
+func TestNextRestartSummary(t *testing.T) {
+	next := time.Now().Add(12 * time.Second)
+	tests := []struct {
+		status ServiceStatus
+		want   string
+	}{
+		{ServiceStatus{State: StateBackoff, Restarts: 4, NextRestart: next, RestartIn: 11600 * time.Millisecond}, "restarting in 12s (attempt 4)"},
+		{ServiceStatus{State: StateCompleted, NextRestart: next, RestartIn: 90 * time.Second}, "next run in 1m"},
+	}
+
+	for _, tt := range tests {
+		if got := nextRestartSummary(&tt.status); got != tt.want {
+			t.Errorf("nextRestartSummary(%s) = %s, want %s", tt.status.State, got, tt.want)
+		}
+	}
+}
+
+func TestBackoffSummary(t *testing.T) {
+	next := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		status ServiceStatus
+		want   string
+	}{
+		{ServiceStatus{NextRestart: next, BackoffAttempt: 1}, "2026-01-02T15:04:05Z"},
+		{ServiceStatus{NextRestart: next, BackoffAttempt: 3}, "2026-01-02T15:04:05Z (exited 3 times in a row)"},
+		{ServiceStatus{NextRestart: next, BackoffAttempt: 6, RestartLimited: true}, "2026-01-02T15:04:05Z (exited 6 times in a row, held back by restart_burst)"},
+	}
+
+	for _, tt := range tests {
+		if got := backoffSummary(&tt.status); got != tt.want {
+			t.Errorf("backoffSummary(%+v) = %s, want %s", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestParseCommandFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-group", "web:HUP"},
+		{"web:HUP", "--group"},
+	} {
+		fs := flag.NewFlagSet("signal", flag.ContinueOnError)
+		group := fs.Bool("group", false, "")
+		positional := parseCommandFlags(fs, args)
+		if !*group || !slices.Equal(positional, []string{"web:HUP"}) {
+			t.Errorf("%v: expected -group and web:HUP, got %v and %v", args, *group, positional)
+		}
+	}
+}
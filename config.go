@@ -2,10 +2,15 @@ package main
 
 import (
 	"gopkg.in/yaml.v3"
-	"os"
+	"reflect"
+	"strings"
 	"time"
 )
 
+// strictConfig rejects unknown config keys regardless of the config's own
+// strict setting. It is set by the --strict flag.
+var strictConfig bool
+
 // RestartPolicy defines how a service should be restarted
 type RestartPolicy string
 
@@ -15,41 +20,153 @@ const (
 	RestartNever     RestartPolicy = "never"
 )
 
+// RestartStrategy defines how a running service is replaced when restarted
+type RestartStrategy string
+
+const (
+	RestartStrategyStopFirst  RestartStrategy = "stop-first"
+	RestartStrategyStartFirst RestartStrategy = "start-first"
+	RestartStrategyBlueGreen  RestartStrategy = "blue-green"
+)
+
 // Service represents a managed service
 type Service struct {
-	Name         string            `yaml:"name"`
-	Command      []string          `yaml:"command"`
-	User         string            `yaml:"user"`
-	Group        string            `yaml:"group"`
-	WorkingDir   string            `yaml:"working_dir"`
-	Environment  map[string]string `yaml:"environment"`
-	RequiresRoot bool              `yaml:"requires_root"`
-	Restart      RestartPolicy     `yaml:"restart"`
-	MaxRestarts  int               `yaml:"max_restarts"`
-	RestartDelay time.Duration     `yaml:"restart_delay"`
-	DependsOn    []string          `yaml:"depends_on"`
-	Stdout       string            `yaml:"stdout"`
-	Stderr       string            `yaml:"stderr"`
-	Interval     time.Duration     `yaml:"interval"`
-	Oneshot      bool              `yaml:"oneshot"`
-	JSONLogs     bool              `yaml:"json_logs"`
+	Name             string            `yaml:"name"`
+	Extends          string            `yaml:"extends"`
+	Instances        int               `yaml:"instances"` // expanded into name-0, name-1, ... on load
+	Command          []string          `yaml:"command"`
+	ProcessTitle     string            `yaml:"process_title"` // argv[0] the process runs with
+	ScrubArgs        []string          `yaml:"scrub_args"`    // flags whose values are hidden once it starts
+	User             string            `yaml:"user"`
+	Group            string            `yaml:"group"`
+	WorkingDir       string            `yaml:"working_dir"`
+	Environment      map[string]string `yaml:"environment"`
+	RequiresRoot     bool              `yaml:"requires_root"`
+	Restart          RestartPolicy     `yaml:"restart"`
+	MaxRestarts      int               `yaml:"max_restarts"`
+	RestartDelay     time.Duration     `yaml:"restart_delay"`
+	RestartJitter    time.Duration     `yaml:"restart_jitter"`
+	RestartDelayMax  time.Duration     `yaml:"restart_delay_max"`
+	RestartBurst     int               `yaml:"restart_burst"`
+	RestartWindow    time.Duration     `yaml:"restart_window"`
+	RestartStrategy  RestartStrategy   `yaml:"restart_strategy"`
+	RestartOverlap   time.Duration     `yaml:"restart_overlap"`
+	StartRetries     int               `yaml:"start_retries"`
+	StartRetryDelay  time.Duration     `yaml:"start_retry_delay"`
+	HealthCheck      *HealthCheck      `yaml:"healthcheck"`
+	ReadyFile        string            `yaml:"ready_file"`
+	ReadyLogPattern  string            `yaml:"ready_log_pattern"`
+	ReadyTimeout     time.Duration     `yaml:"ready_timeout"`
+	PostStartCheck   *PostStartCheck   `yaml:"post_start_check"`
+	DrainDelay       time.Duration     `yaml:"drain_delay"`
+	DrainSignal      string            `yaml:"drain_signal"`
+	DrainCommand     []string          `yaml:"drain_command"`
+	CrashBundle      *CrashBundle      `yaml:"crash_bundle"`
+	CrashLogLines    int               `yaml:"crash_log_lines"`
+	DependsOn        []string          `yaml:"depends_on"`
+	ReloadCommand    []string          `yaml:"reload_command"`
+	Signals          map[string]string `yaml:"signals"`
+	Labels           map[string]string `yaml:"labels"`
+	StopPhase        string            `yaml:"stop_phase"`
+	SignalGroup      bool              `yaml:"signal_group"`
+	NewSession       bool              `yaml:"new_session"`
+	TTY              bool              `yaml:"tty"`
+	Files            []FileDescriptor  `yaml:"files"`
+	After            []string          `yaml:"after"`
+	Priority         int               `yaml:"priority"`
+	Before           []string          `yaml:"before"`
+	Stdout           string            `yaml:"stdout"`
+	Stderr           string            `yaml:"stderr"`
+	OutputMode       string            `yaml:"output_mode"`
+	Interval         time.Duration     `yaml:"interval"`
+	Resources        *ResourceRequest  `yaml:"resources"`
+	Oneshot          bool              `yaml:"oneshot"`
+	OnDemand         bool              `yaml:"on_demand"`
+	IdleTimeout      time.Duration     `yaml:"idle_timeout"`
+	Spawn            string            `yaml:"spawn"`
+	MaxConnections   int               `yaml:"max_connections"`
+	MaxRuntime       time.Duration     `yaml:"max_runtime"`
+	MaxRuntimeAction string            `yaml:"max_runtime_action"`
+	Watch            []string          `yaml:"watch"`
+	WatchAction      string            `yaml:"watch_action"`
+	WatchDebounce    time.Duration     `yaml:"watch_debounce"`
+	Checksum         string            `yaml:"checksum"`
+	Verify           *Verify           `yaml:"verify"`
+	AppArmorProfile  string            `yaml:"apparmor_profile"`
+	SELinuxLabel     string            `yaml:"selinux_label"`
+	ReadOnlyPaths    []string          `yaml:"read_only_paths"`
+	ProtectPaths     []string          `yaml:"protect_paths"`
+	Devices          []string          `yaml:"devices"`
+	UserNamespace    *UserNamespace    `yaml:"user_namespace"`
+	JoinNamespaces   *JoinNamespaces   `yaml:"join_ns"`
+	PIDFile          string            `yaml:"pid_file"`
+	Stdin            string            `yaml:"stdin"`
+	Timezone         string            `yaml:"timezone"`
+	Locale           string            `yaml:"locale"`
+	JSONLogs         bool              `yaml:"json_logs"`
 }
 
 // Config represents the pei configuration
 type Config struct {
-	Version  string             `yaml:"version"`
-	Services map[string]Service `yaml:"services"`
+	Version     string                `yaml:"version"`
+	Strict      bool                  `yaml:"strict"`
+	Signals     map[string]SignalRule `yaml:"signals"`
+	SIGHUP      string                `yaml:"sighup"`
+	LogSpool    *LogSpool             `yaml:"log_spool"`
+	Metadata    *Metadata             `yaml:"metadata"`
+	Kubernetes  *Kubernetes           `yaml:"kubernetes"`
+	Init        []InitStep            `yaml:"init"`
+	PreShutdown *PreShutdown          `yaml:"pre_shutdown"`
+	StopPhases  []StopPhase           `yaml:"stop_phases"`
+	API         *API                  `yaml:"api"`
+	IPC         *IPCLimits            `yaml:"ipc"`
+	Metrics     *MetricsConfig        `yaml:"metrics"`
+	Notifiers   []Notifier            `yaml:"notifiers"`
+	Heartbeat   *Heartbeat            `yaml:"heartbeat"`
+	Reaper      *Reaper               `yaml:"reaper"`
+	Maintenance []MaintenanceWindow   `yaml:"maintenance"`
+	Services    map[string]Service    `yaml:"services"`
 }
 
 func loadConfig(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
+	data, err := readConfigSource(path)
 	if err != nil {
 		return nil, err
 	}
 
+	if templateConfig || strings.HasSuffix(path, ".tmpl") {
+		if data, err = renderConfigTemplate(path, data); err != nil {
+			return nil, err
+		}
+	}
+
+	// Errors from here on point at the file, line and key they are about
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, yamlErrorLines(path, nil, err)
+	}
+	if err := resolveExtends(&doc); err != nil {
+		return nil, locateConfigError(path, &doc, err)
+	}
+	if err := resolveInstances(&doc); err != nil {
+		return nil, locateConfigError(path, &doc, err)
+	}
+	if err := resolveKubernetes(&doc); err != nil {
+		return nil, locateConfigError(path, &doc, err)
+	}
+
 	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, err
+	if len(doc.Content) > 0 {
+		if err := doc.Decode(&config); err != nil {
+			return nil, yamlErrorLines(path, &doc, err)
+		}
+	}
+
+	// Reject unknown keys, so typos like restrat: fail loudly
+	if strictConfig || config.Strict {
+		if err := checkKnownKeys(&doc, reflect.TypeFor[Config](), nil); err != nil {
+			return nil, locateConfigError(path, &doc, err)
+		}
 	}
 
 	// Set service names from map keys
@@ -58,5 +175,150 @@ func loadConfig(path string) (*Config, error) {
 		config.Services[name] = svc
 	}
 
+	if err := config.validate(); err != nil {
+		return nil, locateConfigError(path, &doc, err)
+	}
+
 	return &config, nil
 }
+
+// validate checks the parts of the config that can't be expressed in YAML types
+func (c *Config) validate() error {
+	if err := c.validateServiceNames(); err != nil {
+		return err
+	}
+	if err := c.validateServiceReferences(); err != nil {
+		return err
+	}
+	for name, svc := range c.Services {
+		if svc.RestartJitter < 0 {
+			return serviceErrorf(name, "restart_jitter", "must not be negative")
+		}
+		switch svc.RestartStrategy {
+		case "", RestartStrategyStopFirst, RestartStrategyStartFirst:
+		case RestartStrategyBlueGreen:
+			if svc.HealthCheck == nil {
+				return serviceErrorf(name, "restart_strategy", "blue-green needs a healthcheck")
+			}
+		default:
+			return serviceErrorf(name, "restart_strategy", "unknown strategy %q", svc.RestartStrategy)
+		}
+		if svc.HealthCheck != nil {
+			if err := svc.HealthCheck.validate(); err != nil {
+				return serviceErrorf(name, "healthcheck", "%v", err)
+			}
+		}
+	}
+	if err := c.validateSignals(); err != nil {
+		return err
+	}
+	if err := c.validateStopPhases(); err != nil {
+		return err
+	}
+	if err := c.validatePreShutdown(); err != nil {
+		return err
+	}
+	if err := c.validateInit(); err != nil {
+		return err
+	}
+	if err := c.validateReadiness(); err != nil {
+		return err
+	}
+	if err := c.validatePostStartChecks(); err != nil {
+		return err
+	}
+	if err := c.validateDrain(); err != nil {
+		return err
+	}
+	if err := c.validateCrashBundles(); err != nil {
+		return err
+	}
+	if err := c.validateCrashLogLines(); err != nil {
+		return err
+	}
+	if err := c.validateLogSpool(); err != nil {
+		return err
+	}
+	if err := c.validateLabels(); err != nil {
+		return err
+	}
+	if err := c.validateMetadata(); err != nil {
+		return err
+	}
+	if err := c.validateKubernetes(); err != nil {
+		return err
+	}
+	if err := c.validateFiles(); err != nil {
+		return err
+	}
+	if err := c.validateActivation(); err != nil {
+		return err
+	}
+	if err := c.validateSpawn(); err != nil {
+		return err
+	}
+	if err := c.validateMaxRuntime(); err != nil {
+		return err
+	}
+	if err := c.validateRestartBackoff(); err != nil {
+		return err
+	}
+	if err := c.validateStartRetries(); err != nil {
+		return err
+	}
+	if err := c.validateResources(); err != nil {
+		return err
+	}
+	if err := c.validateWatch(); err != nil {
+		return err
+	}
+	if err := c.validateChecksums(); err != nil {
+		return err
+	}
+	if err := c.validateLSM(); err != nil {
+		return err
+	}
+	if err := c.validateSandbox(); err != nil {
+		return err
+	}
+	if err := c.validateDevices(); err != nil {
+		return err
+	}
+	if err := c.validateUserNamespaces(); err != nil {
+		return err
+	}
+	if err := c.validateJoinNamespaces(); err != nil {
+		return err
+	}
+	if err := c.validatePIDFiles(); err != nil {
+		return err
+	}
+	if err := c.validateStdin(); err != nil {
+		return err
+	}
+	if err := c.validateOutputFiles(); err != nil {
+		return err
+	}
+	if err := c.validateProcessTitles(); err != nil {
+		return err
+	}
+	if err := c.validateLocales(); err != nil {
+		return err
+	}
+	if err := c.validateAPI(); err != nil {
+		return err
+	}
+	if err := c.validateIPCLimits(); err != nil {
+		return err
+	}
+	if err := c.validateMetrics(); err != nil {
+		return err
+	}
+	if err := c.validateNotifiers(); err != nil {
+		return err
+	}
+	if err := c.validateHeartbeat(); err != nil {
+		return err
+	}
+	return c.validateMaintenance()
+}
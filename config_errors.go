@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigError is a problem with a config file, pointing at the key it is
+// about and, once located, where that key is in the file
+type ConfigError struct {
+	File   string
+	Line   int
+	Column int
+	Path   []string // e.g. services, web, ready_file; list items are [i]
+	Err    error
+}
+
+func (e *ConfigError) Error() string {
+	var b strings.Builder
+	if e.File != "" {
+		b.WriteString(e.File)
+		if e.Line > 0 {
+			fmt.Fprintf(&b, ":%d", e.Line)
+			if e.Column > 0 {
+				fmt.Fprintf(&b, ":%d", e.Column)
+			}
+		}
+		b.WriteString(": ")
+	}
+	if len(e.Path) > 0 {
+		b.WriteString(formatKeyPath(e.Path))
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// fieldErrorf reports a problem with the config value at path
+func fieldErrorf(path []string, format string, args ...any) error {
+	return &ConfigError{Path: path, Err: fmt.Errorf(format, args...)}
+}
+
+// serviceErrorf reports a problem with one of a service's keys, or with the
+// service as a whole if key is empty
+func serviceErrorf(name, key, format string, args ...any) error {
+	path := []string{"services", name}
+	if key != "" {
+		path = append(path, key)
+	}
+	return fieldErrorf(path, format, args...)
+}
+
+// listIndex is the path element for item i of a list
+func listIndex(i int) string {
+	return fmt.Sprintf("[%d]", i)
+}
+
+// formatKeyPath joins a key path as services.web.files[0].fd
+func formatKeyPath(path []string) string {
+	var b strings.Builder
+	for i, key := range path {
+		if i > 0 && !strings.HasPrefix(key, "[") {
+			b.WriteByte('.')
+		}
+		b.WriteString(key)
+	}
+	return b.String()
+}
+
+// locateConfigError gives an error from loading the config file at path a
+// position in it: the key a ConfigError is about, or the nearest key that
+// exists if it was inherited or defaulted
+func locateConfigError(file string, doc *yaml.Node, err error) error {
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) {
+		return &ConfigError{File: file, Err: err}
+	}
+	configErr.File = file
+	if configErr.Line == 0 {
+		if node := findConfigNode(doc, configErr.Path); node != nil {
+			configErr.Line, configErr.Column = node.Line, node.Column
+		}
+	}
+	return configErr
+}
+
+// findConfigNode returns the node for the deepest key along path that is in
+// the document: the key itself for mapping entries, or the item for lists
+func findConfigNode(doc *yaml.Node, path []string) *yaml.Node {
+	node := resolveAlias(doc)
+	if node != nil && node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = resolveAlias(node.Content[0])
+	}
+	var found *yaml.Node
+	for _, key := range path {
+		if node == nil {
+			break
+		}
+		switch node.Kind {
+		case yaml.MappingNode:
+			keyNode, value := mappingEntry(node, key)
+			if keyNode == nil {
+				return found
+			}
+			found, node = keyNode, resolveAlias(value)
+		case yaml.SequenceNode:
+			i, err := strconv.Atoi(strings.Trim(key, "[]"))
+			if err != nil || i < 0 || i >= len(node.Content) {
+				return found
+			}
+			node = resolveAlias(node.Content[i])
+			found = node
+		default:
+			return found
+		}
+	}
+	return found
+}
+
+// mappingEntry returns the key and value nodes for a key of a mapping
+func mappingEntry(mapping *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i], mapping.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+// yamlLinePattern finds the line yaml.v3 reports in its error messages
+var yamlLinePattern = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// yamlErrorLines splits a yaml.v3 parse or decode error into one located
+// error per problem
+func yamlErrorLines(file string, doc *yaml.Node, err error) error {
+	messages := []string{err.Error()}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	}
+
+	var errs []error
+	for _, message := range messages {
+		configErr := &ConfigError{File: file, Err: errors.New(strings.TrimPrefix(message, "yaml: "))}
+		if match := yamlLinePattern.FindStringSubmatch(message); match != nil {
+			configErr.Line, _ = strconv.Atoi(match[1])
+			configErr.Err = errors.New(match[2])
+			if doc != nil {
+				configErr.Path = pathAtLine(doc, configErr.Line)
+				if node := findConfigNode(doc, configErr.Path); node != nil && node.Line == configErr.Line {
+					configErr.Column = node.Column
+				}
+			}
+		}
+		errs = append(errs, configErr)
+	}
+	return errors.Join(errs...)
+}
+
+// pathAtLine returns the key path of the deepest value that starts on a
+// line, if any
+func pathAtLine(doc *yaml.Node, line int) []string {
+	var best []string
+	var walk func(node *yaml.Node, path []string)
+	walk = func(node *yaml.Node, path []string) {
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				walk(child, path)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				childPath := append(append([]string(nil), path...), key.Value)
+				if key.Line == line && len(childPath) > len(best) {
+					best = childPath
+				}
+				walk(value, childPath)
+			}
+		case yaml.SequenceNode:
+			for i, item := range node.Content {
+				childPath := append(append([]string(nil), path...), listIndex(i))
+				if item.Line == line && len(childPath) > len(best) {
+					best = childPath
+				}
+				walk(item, childPath)
+			}
+		}
+	}
+	walk(doc, nil)
+	return best
+}
+
+var yamlUnmarshalerType = reflect.TypeFor[yaml.Unmarshaler]()
+
+// checkKnownKeys reports the first mapping key under node that has no field
+// to decode into in t, so typos like restrat: fail loudly. Values of the
+// wrong kind are left for decoding to report.
+func checkKnownKeys(node *yaml.Node, t reflect.Type, path []string) error {
+	node = resolveAlias(node)
+	if node.Kind == yaml.DocumentNode {
+		for _, child := range node.Content {
+			if err := checkKnownKeys(child, t, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(yamlUnmarshalerType) {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
+			if name != "-" {
+				fields[name] = field.Type
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if isMergeKey(key) {
+				continue
+			}
+			keyPath := append(append([]string(nil), path...), key.Value)
+			fieldType, exists := fields[key.Value]
+			if !exists {
+				return &ConfigError{Path: keyPath, Line: key.Line, Column: key.Column, Err: errors.New("unknown key")}
+			}
+			if err := checkKnownKeys(value, fieldType, keyPath); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyPath := append(append([]string(nil), path...), node.Content[i].Value)
+			if err := checkKnownKeys(node.Content[i+1], t.Elem(), keyPath); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return nil
+		}
+		for i, item := range node.Content {
+			if err := checkKnownKeys(item, t.Elem(), append(append([]string(nil), path...), listIndex(i))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
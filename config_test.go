@@ -0,0 +1,359 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a config file into a temporary directory for a test
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pei.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigStrict(t *testing.T) {
+	typo := `
+services:
+  web:
+    command: ["sleep", "1"]
+    restrat: always
+`
+	if _, err := loadConfig(writeConfig(t, typo)); err != nil {
+		t.Errorf("Expected unknown key to be ignored without strict mode, got: %v", err)
+	}
+
+	_, err := loadConfig(writeConfig(t, "strict: true\n"+typo))
+	if err == nil || !strings.Contains(err.Error(), "restrat") {
+		t.Errorf("Expected strict mode to reject unknown key, got: %v", err)
+	}
+}
+
+func TestConfigSchemaCoversServiceFields(t *testing.T) {
+	schema := configSchema()
+	service := schema["$defs"].(map[string]any)["Service"].(map[string]any)
+	properties := service["properties"].(map[string]any)
+
+	for _, key := range []string{"command", "restart", "restart_delay", "depends_on", "after"} {
+		if _, exists := properties[key]; !exists {
+			t.Errorf("Expected schema to describe service key %q", key)
+		}
+	}
+
+	restart := properties["restart"].(map[string]any)
+	if _, exists := restart["enum"]; !exists {
+		t.Error("Expected restart policy to be an enum")
+	}
+}
+
+func TestLoadConfigValidatesFiles(t *testing.T) {
+	tests := []struct {
+		files string
+		err   string
+	}{
+		{`[{fd: 2, path: /tmp/x}]`, "reserved"},
+		{`[{fd: 3, path: /tmp/x}, {fd: 3, path: /tmp/y}]`, "declared twice"},
+		{`[{fd: 3, path: /tmp/x, listen: "tcp://:80"}]`, "exactly one"},
+		{`[{fd: 3, listen: "sctp://:80"}]`, "unsupported network"},
+		{`[{fd: 3, pipe: logs}]`, "pipe end"},
+	}
+
+	for _, tt := range tests {
+		config := "services:\n  web:\n    command: [\"true\"]\n    files: " + tt.files + "\n"
+		_, err := loadConfig(writeConfig(t, config))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("files %s: expected error containing %q, got: %v", tt.files, tt.err, err)
+		}
+	}
+
+	valid := "services:\n  web:\n    command: [\"true\"]\n    files: [{fd: 3, listen: \"tcp://127.0.0.1:0\"}, {fd: 5, pipe: logs, end: write}]\n"
+	if _, err := loadConfig(writeConfig(t, valid)); err != nil {
+		t.Errorf("Expected valid files config to load, got: %v", err)
+	}
+}
+
+func TestLoadConfigValidatesBlueGreen(t *testing.T) {
+	tests := []struct {
+		service string
+		err     string
+	}{
+		{"restart_strategy: blue-green", "needs a healthcheck"},
+		{"restart_strategy: blue-green\n    healthcheck: {tcp: \"127.0.0.1:80\", http: \"http://127.0.0.1/\"}", "exactly one"},
+		{"healthcheck: {}", "exactly one"},
+	}
+
+	for _, tt := range tests {
+		config := "services:\n  web:\n    command: [\"true\"]\n    " + tt.service + "\n"
+		_, err := loadConfig(writeConfig(t, config))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: expected error containing %q, got: %v", tt.service, tt.err, err)
+		}
+	}
+
+	valid := "services:\n  web:\n    command: [\"true\"]\n    restart_strategy: blue-green\n    healthcheck: {command: [\"true\"], retries: 2}\n"
+	if _, err := loadConfig(writeConfig(t, valid)); err != nil {
+		t.Errorf("Expected valid blue-green config to load, got: %v", err)
+	}
+}
+
+func TestLoadConfigValidatesDrain(t *testing.T) {
+	tests := []struct {
+		service string
+		err     string
+	}{
+		{"drain_delay: -1s", "must not be negative"},
+		{"drain_signal: NOPE", "drain_signal"},
+		{"drain_signal: USR1\n    drain_command: [\"true\"]", "only one"},
+	}
+
+	for _, tt := range tests {
+		config := "services:\n  web:\n    command: [\"true\"]\n    " + tt.service + "\n"
+		_, err := loadConfig(writeConfig(t, config))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: expected error containing %q, got: %v", tt.service, tt.err, err)
+		}
+	}
+}
+
+func TestLoadConfigValidatesReadiness(t *testing.T) {
+	tests := []struct {
+		service string
+		err     string
+	}{
+		{"ready_file: run/ready", "absolute path"},
+		{"ready_log_pattern: \"Listening on (\"", "ready_log_pattern"},
+		{"ready_file: /run/ready\n    ready_log_pattern: Listening", "only one"},
+		{"ready_timeout: -1s", "must not be negative"},
+	}
+
+	for _, tt := range tests {
+		config := "services:\n  web:\n    command: [\"true\"]\n    " + tt.service + "\n"
+		_, err := loadConfig(writeConfig(t, config))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: expected error containing %q, got: %v", tt.service, tt.err, err)
+		}
+	}
+}
+
+func TestLoadConfigValidatesPostStartCheck(t *testing.T) {
+	tests := []struct {
+		service string
+		err     string
+	}{
+		{"post_start_check: {delay: 1s}", "needs a command"},
+		{"post_start_check: {command: [\"true\"], timeout: -1s}", "must not be negative"},
+	}
+
+	for _, tt := range tests {
+		config := "services:\n  web:\n    command: [\"true\"]\n    " + tt.service + "\n"
+		_, err := loadConfig(writeConfig(t, config))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: expected error containing %q, got: %v", tt.service, tt.err, err)
+		}
+	}
+}
+
+func TestLoadConfigValidatesInit(t *testing.T) {
+	tests := []struct {
+		init string
+		err  string
+	}{
+		{"- command: [\"true\"]", "needs a name"},
+		{"- name: setup", "needs a command"},
+		{"- {name: setup, command: [\"true\"]}\n  - {name: setup, command: [\"true\"]}", "declared twice"},
+		{"- {name: web, command: [\"true\"]}", "same name as a service"},
+	}
+
+	for _, tt := range tests {
+		config := "init:\n  " + tt.init + "\nservices:\n  web:\n    command: [\"true\"]\n"
+		_, err := loadConfig(writeConfig(t, config))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: expected error containing %q, got: %v", tt.init, tt.err, err)
+		}
+	}
+}
+
+func TestLoadConfigValidatesPreShutdown(t *testing.T) {
+	tests := []struct {
+		hook string
+		err  string
+	}{
+		{"{timeout: 5s}", "needs a command"},
+		{"{command: [\"true\"], timeout: -1s}", "must not be negative"},
+		{"{command: [\"true\"], user: root}", "both user and group"},
+	}
+
+	for _, tt := range tests {
+		config := "pre_shutdown: " + tt.hook + "\nservices:\n  web:\n    command: [\"true\"]\n"
+		_, err := loadConfig(writeConfig(t, config))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: expected error containing %q, got: %v", tt.hook, tt.err, err)
+		}
+	}
+}
+
+func TestLoadConfigValidatesStopPhases(t *testing.T) {
+	unknown := "stop_phases: [{name: web}]\nservices:\n  db:\n    command: [\"true\"]\n    stop_phase: data\n"
+	if _, err := loadConfig(writeConfig(t, unknown)); err == nil || !strings.Contains(err.Error(), "not declared") {
+		t.Errorf("Expected unknown stop_phase to be rejected, got: %v", err)
+	}
+
+	twice := "stop_phases: [{name: web}, {name: web}]\nservices:\n  db:\n    command: [\"true\"]\n"
+	if _, err := loadConfig(writeConfig(t, twice)); err == nil || !strings.Contains(err.Error(), "declared twice") {
+		t.Errorf("Expected duplicate phase to be rejected, got: %v", err)
+	}
+}
+
+func TestLoadConfigValidatesLabels(t *testing.T) {
+	invalid := "services:\n  web:\n    command: [\"true\"]\n    labels: {\"1tier\": web}\n"
+	if _, err := loadConfig(writeConfig(t, invalid)); err == nil || !strings.Contains(err.Error(), "label") {
+		t.Errorf("Expected invalid label name to be rejected, got: %v", err)
+	}
+
+	valid := "services:\n  web:\n    command: [\"true\"]\n    labels: {team: platform, tier_name: web}\n"
+	if _, err := loadConfig(writeConfig(t, valid)); err != nil {
+		t.Errorf("Expected valid labels to load, got: %v", err)
+	}
+}
+
+func TestLoadConfigTemplate(t *testing.T) {
+	t.Setenv("TEST_WEB_PORT", "9090")
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("s3cr\"et\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	contents := "services:\n" +
+		"  web:\n" +
+		"    command: [\"web\", \"{{ env \"TEST_WEB_PORT\" }}\", \"{{ env \"TEST_UNSET\" | default \"x\" }}\"]\n" +
+		"    environment:\n" +
+		"      SECRET: {{ file \"" + secret + "\" | quote }}\n"
+	path := filepath.Join(t.TempDir(), "pei.yaml.tmpl")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("Expected template config to load, got: %v", err)
+	}
+	web := config.Services["web"]
+	if web.Command[1] != "9090" || web.Command[2] != "x" {
+		t.Errorf("Expected rendered command, got %v", web.Command)
+	}
+	if web.Environment["SECRET"] != "s3cr\"et" {
+		t.Errorf("Expected secret from file, got %q", web.Environment["SECRET"])
+	}
+
+	required := filepath.Join(t.TempDir(), "pei.yaml.tmpl")
+	os.WriteFile(required, []byte("version: {{ env \"TEST_UNSET\" | required \"TEST_UNSET\" }}\n"), 0644)
+	if _, err := loadConfig(required); err == nil || !strings.Contains(err.Error(), "TEST_UNSET is required") {
+		t.Errorf("Expected missing required value to fail, got: %v", err)
+	}
+}
+
+func TestLoadConfigExtends(t *testing.T) {
+	contents := `strict: true
+x-defaults:
+  worker: &worker
+    user: worker
+    group: worker
+    restart: always
+    environment: {QUEUE: default}
+x-common: &common
+  max_restarts: 5
+services:
+  worker-1:
+    extends: worker
+    command: ["work", "1"]
+  worker-2:
+    extends: worker-1
+    command: ["work", "2"]
+    restart: on-failure
+  anchored:
+    <<: [*worker, *common]
+    command: ["work", "3"]
+`
+	config, err := loadConfig(writeConfig(t, contents))
+	if err != nil {
+		t.Fatalf("Expected config with extends to load, got: %v", err)
+	}
+
+	first := config.Services["worker-1"]
+	if first.User != "worker" || first.Restart != RestartAlways || first.Environment["QUEUE"] != "default" {
+		t.Errorf("Expected worker-1 to inherit x-defaults, got %+v", first)
+	}
+	second := config.Services["worker-2"]
+	if second.User != "worker" || second.Restart != RestartOnFailure || second.Command[1] != "2" {
+		t.Errorf("Expected worker-2 to inherit worker-1 with overrides, got %+v", second)
+	}
+	anchored := config.Services["anchored"]
+	if anchored.User != "worker" || anchored.MaxRestarts != 5 {
+		t.Errorf("Expected merge keys to apply, got %+v", anchored)
+	}
+
+	cycle := "services:\n  a:\n    extends: b\n  b:\n    extends: a\n"
+	if _, err := loadConfig(writeConfig(t, cycle)); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("Expected extends cycle to fail, got: %v", err)
+	}
+	unknown := "services:\n  a:\n    extends: nope\n"
+	if _, err := loadConfig(writeConfig(t, unknown)); err == nil || !strings.Contains(err.Error(), "unknown base") {
+		t.Errorf("Expected unknown base to fail, got: %v", err)
+	}
+}
+
+func TestLoadConfigErrorPositions(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"validation", "services:\n  web:\n    command: [\"true\"]\n    ready_file: relative\n", "pei.yaml:4:5: services.web.ready_file: must be an absolute path"},
+		{"list item", "services:\n  web:\n    command: [\"true\"]\n    files:\n      - {fd: 3, path: /a}\n      - {fd: 3, path: /b}\n", "pei.yaml:6:10: services.web.files[1].fd: fd 3 declared twice"},
+		{"inherited", "x-defaults:\n  base:\n    ready_file: relative\nservices:\n  web:\n    extends: base\n    command: [\"true\"]\n", "pei.yaml:3:5: services.web.ready_file: must be an absolute path"},
+		{"decode", "services:\n  web:\n    command: \"true\"\n", "pei.yaml:3:5: services.web.command: cannot unmarshal"},
+		{"syntax", "services:\n  web:\n    command: [\"true\"\n", "pei.yaml:2: did not find expected"},
+		{"strict", "strict: true\nservices:\n  web:\n    command: [\"true\"]\n    restrat: always\n", "pei.yaml:5:5: services.web.restrat: unknown key"},
+		{"extends", "services:\n  a:\n    extends: nope\n", "pei.yaml:3:5: services.a.extends: extends unknown base"},
+	}
+	for _, tt := range tests {
+		_, err := loadConfig(writeConfig(t, tt.config))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: expected error containing %q, got: %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestLoadConfigValidatesServiceNames(t *testing.T) {
+	tests := []struct {
+		config string
+		err    string
+	}{
+		{"services:\n  all:\n    command: [\"true\"]\n", "reserved"},
+		{"services:\n  \"web app\":\n    command: [\"true\"]\n", "name must be"},
+		{"services:\n  " + strings.Repeat("a", 65) + ":\n    command: [\"true\"]\n", "at most 64"},
+		{"services:\n  web:\n    command: [\"true\"]\n    depends_on: [db]\n", `services.web.depends_on[0]: unknown service "db"`},
+		{"services:\n  web:\n    command: [\"true\"]\n    after: [web]\n", "itself"},
+		{"x-more: &more\n  web:\n    command: [\"true\"]\nservices:\n  <<: *more\n  web:\n    command: [\"true\"]\n", "pei.yaml:6:3: services.web: service declared twice"},
+	}
+	for _, tt := range tests {
+		_, err := loadConfig(writeConfig(t, tt.config))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("expected error containing %q, got: %v", tt.err, err)
+		}
+	}
+
+	merged := "x-more: &more\n  db:\n    command: [\"true\"]\nservices:\n  <<: *more\n  web:\n    command: [\"true\"]\n    before: [db]\n"
+	config, err := loadConfig(writeConfig(t, merged))
+	if err != nil {
+		t.Fatalf("Expected merged services to load, got: %v", err)
+	}
+	if _, exists := config.Services["db"]; !exists {
+		t.Errorf("Expected merged service db, got %v", config.Services)
+	}
+}
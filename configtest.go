@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigTests is a file of assertions about a configuration, checked by
+// pei test so teams can unit test their pei.yaml in CI
+type ConfigTests struct {
+	Tests []ConfigTest `yaml:"tests"`
+}
+
+// ConfigTest is one named assertion. It can check a service's resolved
+// definition, the order services start in, and how the restart policy
+// handles a sequence of simulated exits.
+type ConfigTest struct {
+	Name       string         `yaml:"name"`
+	Service    string         `yaml:"service"`
+	Expect     *ServiceExpect `yaml:"expect"`
+	StartOrder []string       `yaml:"start_order"`
+	Exits      []int          `yaml:"exits"`
+	Outcome    *ExitsOutcome  `yaml:"outcome"`
+}
+
+// ServiceExpect lists resolved service fields to check; unset ones aren't
+type ServiceExpect struct {
+	Command     []string          `yaml:"command"`
+	User        string            `yaml:"user"`
+	Group       string            `yaml:"group"`
+	WorkingDir  string            `yaml:"working_dir"`
+	Restart     RestartPolicy     `yaml:"restart"`
+	Environment map[string]string `yaml:"environment"`
+	Labels      map[string]string `yaml:"labels"`
+	DependsOn   []string          `yaml:"depends_on"`
+}
+
+// ExitsOutcome is what should happen after a sequence of simulated exits
+type ExitsOutcome struct {
+	Restarts *int         `yaml:"restarts"`
+	State    ServiceState `yaml:"state"`
+}
+
+// loadConfigTests reads a test file, rejecting unknown keys so a typo can't
+// silently skip an assertion
+func loadConfigTests(path string) (*ConfigTests, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	var tests ConfigTests
+	if err := decoder.Decode(&tests); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	for i, test := range tests.Tests {
+		if test.Name == "" {
+			return nil, fmt.Errorf("test %d needs a name", i+1)
+		}
+		if (test.Expect != nil || len(test.Exits) > 0) && test.Service == "" {
+			return nil, fmt.Errorf("test %s: expect and exits need a service", test.Name)
+		}
+		if (len(test.Exits) > 0) != (test.Outcome != nil) {
+			return nil, fmt.Errorf("test %s: exits and outcome go together", test.Name)
+		}
+	}
+	return &tests, nil
+}
+
+// run checks a test against a loaded config and returns every failure
+func (t ConfigTest) run(config *Config, tiers [][]string) []error {
+	var errs []error
+	svc, exists := config.Services[t.Service]
+	if t.Service != "" && !exists {
+		return []error{fmt.Errorf("service %s is not configured", t.Service)}
+	}
+
+	if t.Expect != nil {
+		errs = append(errs, t.Expect.check(svc)...)
+	}
+	if len(t.StartOrder) > 0 {
+		errs = append(errs, checkStartOrder(tiers, t.StartOrder)...)
+	}
+	if len(t.Exits) > 0 {
+		restarts, state := simulateExits(svc, t.Exits)
+		if t.Outcome.Restarts != nil && restarts != *t.Outcome.Restarts {
+			errs = append(errs, fmt.Errorf("expected %d restarts, got %d", *t.Outcome.Restarts, restarts))
+		}
+		if t.Outcome.State != "" && state != t.Outcome.State {
+			errs = append(errs, fmt.Errorf("expected state %s, got %s", t.Outcome.State, state))
+		}
+	}
+	return errs
+}
+
+func (e *ServiceExpect) check(svc Service) []error {
+	var errs []error
+	mismatch := func(field string, expected, got any) {
+		errs = append(errs, fmt.Errorf("expected %s %v, got %v", field, expected, got))
+	}
+	if e.Command != nil && !slices.Equal(e.Command, svc.Command) {
+		mismatch("command", e.Command, svc.Command)
+	}
+	if e.User != "" && e.User != svc.User {
+		mismatch("user", e.User, svc.User)
+	}
+	if e.Group != "" && e.Group != svc.Group {
+		mismatch("group", e.Group, svc.Group)
+	}
+	if e.WorkingDir != "" && e.WorkingDir != svc.WorkingDir {
+		mismatch("working_dir", e.WorkingDir, svc.WorkingDir)
+	}
+	if e.Restart != "" && e.Restart != svc.Restart {
+		mismatch("restart", e.Restart, svc.Restart)
+	}
+	if e.DependsOn != nil && !slices.Equal(slices.Sorted(slices.Values(e.DependsOn)), slices.Sorted(slices.Values(svc.DependsOn))) {
+		mismatch("depends_on", e.DependsOn, svc.DependsOn)
+	}
+	for _, key := range slices.Sorted(maps.Keys(e.Environment)) {
+		if got, ok := svc.Environment[key]; !ok || got != e.Environment[key] {
+			mismatch("environment "+key, fmt.Sprintf("%q", e.Environment[key]), fmt.Sprintf("%q", got))
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(e.Labels)) {
+		if got, ok := svc.Labels[key]; !ok || got != e.Labels[key] {
+			mismatch("label "+key, fmt.Sprintf("%q", e.Labels[key]), fmt.Sprintf("%q", got))
+		}
+	}
+	return errs
+}
+
+// checkStartOrder checks each service starts in an earlier tier than the
+// one after it in order
+func checkStartOrder(tiers [][]string, order []string) []error {
+	tierOf := make(map[string]int)
+	for i, tier := range tiers {
+		for _, name := range tier {
+			tierOf[name] = i
+		}
+	}
+	var errs []error
+	for _, name := range order {
+		if _, exists := tierOf[name]; !exists {
+			errs = append(errs, fmt.Errorf("service %s is not configured", name))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	for i := 1; i < len(order); i++ {
+		before, after := order[i-1], order[i]
+		if tierOf[before] >= tierOf[after] {
+			errs = append(errs, fmt.Errorf("expected %s to start before %s, but they start in tiers %d and %d",
+				before, after, tierOf[before]+1, tierOf[after]+1))
+		}
+	}
+	return errs
+}
+
+// simulateExits feeds exit codes to the service's restart policy in order,
+// as if each instance exited with the next one, and returns how many times
+// the service would be restarted and the state it ends up in
+func simulateExits(svc Service, codes []int) (int, ServiceState) {
+	restarts := 0
+	for _, code := range codes {
+		var err error
+		if code != 0 {
+			err = fmt.Errorf("exit status %d", code)
+		}
+		switch decideExit(svc, err, restarts) {
+		case exitRestart, exitSchedule:
+			restarts++
+		case exitGiveUp:
+			return restarts, StateFailed
+		default:
+			if err != nil {
+				return restarts, StateFailed
+			}
+			return restarts, StateCompleted
+		}
+	}
+	return restarts, StateRunning
+}
+
+// runConfigTests checks a config against a test file, printing a line per
+// test, and returns an error if any failed
+func runConfigTests(configPath, testsPath string) error {
+	tests, err := loadConfigTests(testsPath)
+	if err != nil {
+		return err
+	}
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+	tiers, err := startTiers(config.Services)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, test := range tests.Tests {
+		errs := test.run(config, tiers)
+		if len(errs) == 0 {
+			fmt.Printf("ok    %s\n", test.Name)
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL  %s\n", test.Name)
+		for _, err := range errs {
+			fmt.Printf("      %v\n", err)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tests failed", failed, len(tests.Tests))
+	}
+	fmt.Printf("%d tests passed\n", len(tests.Tests))
+	return nil
+}
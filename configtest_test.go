@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSimulateExits(t *testing.T) {
+	tests := []struct {
+		svc      Service
+		codes    []int
+		restarts int
+		state    ServiceState
+	}{
+		{Service{Restart: RestartAlways, MaxRestarts: 2}, []int{1, 0, 1}, 2, StateFailed},
+		{Service{Restart: RestartAlways}, []int{1, 0}, 2, StateRunning},
+		{Service{Restart: RestartOnFailure}, []int{1, 0}, 1, StateCompleted},
+		{Service{Restart: RestartNever}, []int{3}, 0, StateFailed},
+		{Service{Oneshot: true, Interval: 1}, []int{0, 1}, 2, StateRunning},
+	}
+
+	for _, tt := range tests {
+		restarts, state := simulateExits(tt.svc, tt.codes)
+		if restarts != tt.restarts || state != tt.state {
+			t.Errorf("%+v with exits %v: expected %d restarts and %s, got %d and %s",
+				tt.svc, tt.codes, tt.restarts, tt.state, restarts, state)
+		}
+	}
+}
+
+func TestConfigTestRun(t *testing.T) {
+	config, err := loadConfig(writeConfig(t, `
+services:
+  db:
+    command: ["db"]
+    user: postgres
+  web:
+    command: ["web"]
+    restart: always
+    after: ["db"]
+    environment:
+      PORT: "8080"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tiers, err := startTiers(config.Services)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pass := ConfigTest{
+		Name:       "passes",
+		Service:    "web",
+		Expect:     &ServiceExpect{Restart: RestartAlways, Environment: map[string]string{"PORT": "8080"}},
+		StartOrder: []string{"db", "web"},
+	}
+	if errs := pass.run(config, tiers); len(errs) != 0 {
+		t.Errorf("expected no failures, got %v", errs)
+	}
+
+	fail := ConfigTest{
+		Name:       "fails",
+		Service:    "db",
+		Expect:     &ServiceExpect{User: "root", Environment: map[string]string{"PORT": "8080"}},
+		StartOrder: []string{"web", "db"},
+	}
+	errs := fail.run(config, tiers)
+	if len(errs) != 3 {
+		t.Fatalf("expected user, environment and order failures, got %v", errs)
+	}
+	if !strings.Contains(errs[0].Error(), "expected user root, got postgres") {
+		t.Errorf("unexpected failure message: %v", errs[0])
+	}
+}
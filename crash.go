@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Crash bundle defaults
+const (
+	defaultCrashBundleLogLines = 200
+	defaultCrashBundleKeep     = 10
+)
+
+// crashBundleLayout timestamps bundle directory names, so they sort oldest
+// first
+const crashBundleLayout = "20060102T150405.000Z"
+
+// CrashBundle configures the diagnostics snapshot written when a service
+// exits with an error
+type CrashBundle struct {
+	Dir      string `yaml:"dir"`
+	LogLines int    `yaml:"log_lines"`
+	Keep     int    `yaml:"keep"`
+}
+
+// procRemnantFiles are the /proc/<pid> entries still readable while an
+// exited process waits to be reaped
+var procRemnantFiles = []string{"status", "stat", "cgroup", "limits", "sched", "io"}
+
+// cgroupStatFiles are the cgroup v2 files copied from the service's cgroup
+var cgroupStatFiles = []string{
+	"memory.current", "memory.peak", "memory.events", "memory.stat",
+	"cpu.stat", "pids.current", "pids.events",
+}
+
+// netFiles list the sockets open in pei's network namespace
+var netFiles = []string{"tcp", "tcp6", "udp", "udp6", "unix"}
+
+// validateCrashBundles checks every service's crash_bundle settings
+func (c *Config) validateCrashBundles() error {
+	for name, svc := range c.Services {
+		if svc.CrashBundle == nil {
+			continue
+		}
+		if svc.CrashBundle.LogLines < 0 || svc.CrashBundle.Keep < 0 {
+			return serviceErrorf(name, "crash_bundle", "log_lines and keep must not be negative")
+		}
+	}
+	return nil
+}
+
+// dir is where bundles are written. By default that's a directory only root
+// can read, as bundles hold what a service had in memory, or a private one in
+// the temporary directory when pei doesn't run as root.
+func (b *CrashBundle) dir() string {
+	if b.Dir != "" {
+		return b.Dir
+	}
+	if os.Getuid() == 0 {
+		return "/var/lib/pei/crash"
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("pei-%d", os.Getuid()), "crash")
+}
+
+func (b *CrashBundle) logLines() int {
+	if b.LogLines > 0 {
+		return b.LogLines
+	}
+	return defaultCrashBundleLogLines
+}
+
+func (b *CrashBundle) keep() int {
+	if b.Keep > 0 {
+		return b.Keep
+	}
+	return defaultCrashBundleKeep
+}
+
+// collectProcRemnants waits for a process to exit without reaping it, then
+// copies what /proc and its cgroup still expose. The caller reaps it after.
+func collectProcRemnants(pid int) map[string][]byte {
+	if err := waitExitedNoReap(pid); err != nil {
+		return nil
+	}
+
+	remnants := make(map[string][]byte)
+	for _, name := range procRemnantFiles {
+		if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/%s", pid, name)); err == nil {
+			remnants[filepath.Join("proc", name)] = data
+		}
+	}
+
+	// cgroup v2 lists a single "0::/path" entry
+	if cgroup, ok := remnants[filepath.Join("proc", "cgroup")]; ok {
+		for _, line := range strings.Split(string(cgroup), "\n") {
+			path, found := strings.CutPrefix(line, "0::")
+			if !found {
+				continue
+			}
+			for _, name := range cgroupStatFiles {
+				if data, err := os.ReadFile(filepath.Join("/sys/fs/cgroup", path, name)); err == nil {
+					remnants[filepath.Join("cgroup", name)] = data
+				}
+			}
+		}
+	}
+	return remnants
+}
+
+// crashBundleRequest asks the service manager to write a crash bundle for an
+// instance, which needs elevated privileges to write where only root can read
+type crashBundleRequest struct {
+	svc      Service
+	proc     *serviceProcess
+	err      error
+	remnants map[string][]byte
+}
+
+// requestCrashBundle has the service manager write a crash bundle, unless
+// shutdown begins first
+func (d *Daemon) requestCrashBundle(req crashBundleRequest) {
+	select {
+	case d.crashBundleChan <- req:
+	case <-d.ctx.Done():
+	}
+}
+
+// recordCrashBundle writes a crash bundle and records it as an event. Must
+// be called with elevated privileges.
+func (d *Daemon) recordCrashBundle(req crashBundleRequest) {
+	bundle, err := d.writeCrashBundle(req.svc, req.proc, req.err, req.remnants)
+	if err != nil {
+		logServiceError(req.svc.Name, "Failed to write crash bundle", "bundle", bundle, "error", err)
+		return
+	}
+	d.events.record(EventCrashBundle, req.svc.Name, "Wrote crash bundle",
+		map[string]string{"bundle": bundle, "error": req.err.Error()})
+}
+
+// writeCrashBundle snapshots diagnostics for a crashed instance into a new
+// timestamped directory and prunes old bundles for the service. It returns
+// the bundle's path.
+func (d *Daemon) writeCrashBundle(svc Service, proc *serviceProcess, exitErr error, remnants map[string][]byte) (string, error) {
+	cfg := svc.CrashBundle
+	now := time.Now().UTC()
+	if err := os.MkdirAll(cfg.dir(), 0700); err != nil {
+		return "", err
+	}
+	bundle := filepath.Join(cfg.dir(), fmt.Sprintf("%s-%s", svc.Name, now.Format(crashBundleLayout)))
+	if err := os.Mkdir(bundle, 0700); err != nil {
+		return "", err
+	}
+
+	files := make(map[string][]byte, len(remnants)+4)
+	for name, data := range remnants {
+		files[name] = data
+	}
+
+	var info strings.Builder
+	fmt.Fprintf(&info, "service: %s\n", svc.Name)
+	fmt.Fprintf(&info, "pid: %d\n", proc.cmd.Process.Pid)
+	fmt.Fprintf(&info, "exit: %v\n", exitErr)
+	fmt.Fprintf(&info, "time: %s\n", now.Format(time.RFC3339Nano))
+	fmt.Fprintf(&info, "command: %q\n", svc.Command)
+	fmt.Fprintf(&info, "user: %s\n", svc.User)
+	fmt.Fprintf(&info, "group: %s\n", svc.Group)
+	fmt.Fprintf(&info, "working_dir: %s\n", svc.WorkingDir)
+	if status, exists := d.getServiceStatus(svc.Name); exists {
+		fmt.Fprintf(&info, "started: %s\n", status.StartTime.Format(time.RFC3339Nano))
+		fmt.Fprintf(&info, "restarts: %d\n", status.Restarts)
+	}
+	files["info"] = []byte(info.String())
+
+	var output strings.Builder
+	for _, line := range d.serviceLogs(svc.Name).last(cfg.logLines()) {
+		fmt.Fprintf(&output, "%s %s %s\n", line.Time.Format(time.RFC3339Nano), line.Stream, line.Text)
+	}
+	files["output.log"] = []byte(output.String())
+
+	// Only the names of the service's own variables, as the values are
+	// often secrets, and pei's own environment isn't the service's business
+	var env strings.Builder
+	names := make([]string, 0, len(svc.Environment))
+	for name := range svc.Environment {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(&env, "%s=<redacted>\n", name)
+	}
+	files["environment"] = []byte(env.String())
+
+	for _, name := range netFiles {
+		if data, err := os.ReadFile(filepath.Join("/proc/net", name)); err == nil {
+			files[filepath.Join("net", name)] = data
+		}
+	}
+
+	for name, data := range files {
+		path := filepath.Join(bundle, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return bundle, err
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return bundle, err
+		}
+	}
+
+	pruneCrashBundles(cfg.dir(), svc.Name, cfg.keep())
+	return bundle, nil
+}
+
+// pruneCrashBundles removes the oldest bundles for a service beyond keep
+func pruneCrashBundles(dir, service string, keep int) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	// Matched exactly, so pruning web leaves web-2's bundles alone
+	var bundles []string
+	for _, entry := range entries {
+		stamp, found := strings.CutPrefix(entry.Name(), service+"-")
+		if _, err := time.Parse(crashBundleLayout, stamp); found && err == nil {
+			bundles = append(bundles, filepath.Join(dir, entry.Name()))
+		}
+	}
+	if len(bundles) <= keep {
+		return
+	}
+	// Timestamped names sort oldest first
+	sort.Strings(bundles)
+	for _, bundle := range bundles[:len(bundles)-keep] {
+		if err := os.RemoveAll(bundle); err != nil {
+			logServiceError(service, "Failed to remove old crash bundle", "bundle", bundle, "error", err)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import "errors"
+
+// waitExitedNoReap is not supported here. There's no /proc to read either,
+// so crash bundles only hold the output and environment.
+func waitExitedNoReap(pid int) error {
+	return errors.New("not supported on this platform")
+}
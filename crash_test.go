@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteCrashBundle(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "crash")
+	svc := Service{
+		Name:        "web",
+		Command:     []string{"web"},
+		Environment: map[string]string{"DATABASE_URL": "postgres://admin:s3cret@db", "LOG_LEVEL": "debug"},
+		CrashBundle: &CrashBundle{Dir: dir},
+	}
+	d := NewDaemon(&Config{Services: map[string]Service{"web": svc}}, "", "", "")
+	defer d.cancel()
+	d.serviceLogs("web").add("stderr", "ERROR", "panic: oops")
+	proc := &serviceProcess{cmd: &exec.Cmd{Process: &os.Process{Pid: 42}}}
+
+	bundle, err := d.writeCrashBundle(svc, proc, errors.New("exit status 2"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{dir, bundle} {
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0700 {
+			t.Errorf("expected %s private to its owner, got %v, %v", path, info.Mode(), err)
+		}
+	}
+	for _, name := range []string{"info", "output.log", "environment"} {
+		if info, err := os.Stat(filepath.Join(bundle, name)); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("expected %s readable by its owner only, got %v, %v", name, info.Mode(), err)
+		}
+	}
+	if output, _ := os.ReadFile(filepath.Join(bundle, "output.log")); !strings.Contains(string(output), "panic: oops") {
+		t.Errorf("expected the service's output, got %q", output)
+	}
+
+	// Only the names of the service's own variables
+	env, _ := os.ReadFile(filepath.Join(bundle, "environment"))
+	if string(env) != "DATABASE_URL=<redacted>\nLOG_LEVEL=<redacted>\n" {
+		t.Errorf("expected the service's variables redacted, got %q", env)
+	}
+}
+
+func TestPruneCrashBundles(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	var names []string
+	for i := range 3 {
+		stamp := start.Add(time.Duration(i) * time.Second).Format(crashBundleLayout)
+		names = append(names, "web-"+stamp, "web-2-"+stamp)
+	}
+	names = append(names, "web-notes")
+	for _, name := range names {
+		if err := os.Mkdir(filepath.Join(dir, name), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Pruning web keeps its newest bundle, and leaves web-2's and anything
+	// that isn't a bundle alone
+	pruneCrashBundles(dir, "web", 1)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, entry := range entries {
+		left = append(left, entry.Name())
+	}
+	want := []string{
+		"web-2-20260102T030405.000Z", "web-2-20260102T030406.000Z", "web-2-20260102T030407.000Z",
+		"web-20260102T030407.000Z", "web-notes",
+	}
+	if !slices.Equal(left, want) {
+		t.Errorf("expected %v left, got %v", want, left)
+	}
+}
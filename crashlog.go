@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// defaultCrashLogLines is how many of a service's last output lines are
+// attached to its crash events and status unless crash_log_lines says
+const defaultCrashLogLines = 20
+
+// CrashReport is the last time a service's instance exited with an error,
+// with the output it wrote just before, so the cause is visible in pei status
+// without searching aggregated logs
+type CrashReport struct {
+	Time   time.Time `json:"time"`
+	PID    int       `json:"pid"`
+	Error  string    `json:"error"`
+	Output []LogLine `json:"output,omitempty"`
+}
+
+// validateCrashLogLines checks every service's crash_log_lines
+func (c *Config) validateCrashLogLines() error {
+	for name, svc := range c.Services {
+		if svc.CrashLogLines < 0 {
+			return serviceErrorf(name, "crash_log_lines", "must not be negative")
+		}
+		if svc.CrashLogLines > serviceLogLines {
+			return serviceErrorf(name, "crash_log_lines", "must be at most %d, the lines kept for each service", serviceLogLines)
+		}
+	}
+	return nil
+}
+
+// crashLogLines is how many output lines are attached to a service's crashes
+func (s Service) crashLogLines() int {
+	if s.CrashLogLines > 0 {
+		return s.CrashLogLines
+	}
+	return defaultCrashLogLines
+}
+
+// recordCrash attaches a service's last output lines to its status and to a
+// crashed event, which notifiers pass on
+func (d *Daemon) recordCrash(svc Service, proc *serviceProcess, err error) {
+	report := &CrashReport{
+		Time:   time.Now(),
+		PID:    proc.cmd.Process.Pid,
+		Error:  err.Error(),
+		Output: d.serviceLogs(svc.Name).last(svc.crashLogLines()),
+	}
+
+	d.mu.Lock()
+	if status, exists := d.serviceStatus[svc.Name]; exists {
+		status.LastCrash = report
+	}
+	d.mu.Unlock()
+
+	output := make([]string, len(report.Output))
+	for i, line := range report.Output {
+		output[i] = line.Text
+	}
+	d.events.recordOutput(EventServiceCrashed, svc.Name, "Service exited with an error",
+		map[string]string{"pid": strconv.Itoa(report.PID), "error": report.Error}, output)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateCrashLogLines(t *testing.T) {
+	for lines, want := range map[int]string{0: "", 50: "", -1: "must not be negative", serviceLogLines + 1: "at most"} {
+		config := &Config{Services: map[string]Service{"web": {CrashLogLines: lines}}}
+		err := config.validateCrashLogLines()
+		if want == "" {
+			if err != nil {
+				t.Errorf("%d: unexpected error: %v", lines, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%d: expected error containing %q, got %v", lines, want, err)
+		}
+	}
+}
+
+func TestRecordCrash(t *testing.T) {
+	svc := Service{Name: "web", CrashLogLines: 2}
+	d := NewDaemon(&Config{Services: map[string]Service{"web": svc}}, "", "", "")
+	defer d.cancel()
+	d.setState(svc, StateFailed)
+
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	logs := d.serviceLogs("web")
+	logs.add("stdout", "INFO", "starting")
+	logs.add("stderr", "INFO", "connecting to db")
+	logs.add("stderr", "INFO", "panic: connection refused")
+
+	d.recordCrash(svc, &serviceProcess{cmd: cmd}, errors.New("exit status 2"))
+
+	status, _ := d.getServiceStatus("web")
+	crash := status.LastCrash
+	if crash == nil || crash.Error != "exit status 2" || len(crash.Output) != 2 || crash.Output[1].Stream != "stderr" {
+		t.Fatalf("expected the crash and its last two lines in the status, got %+v", crash)
+	}
+
+	events := d.events.list("web", 1)
+	want := []string{"connecting to db", "panic: connection refused"}
+	if len(events) != 1 || events[0].Type != EventServiceCrashed || !slices.Equal(events[0].Output, want) {
+		t.Fatalf("expected a crashed event with the last lines, got %+v", events)
+	}
+
+	// PagerDuty alerts carry the output in their details
+	alert, err := Notifier{Type: NotifierPagerDuty}.pagerDutyEvent(events[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alert.Payload.CustomDetails["output"] != strings.Join(want, "\n") || alert.Payload.Severity != "error" {
+		t.Errorf("expected the output in the alert, got %+v", alert.Payload)
+	}
+}
@@ -2,77 +2,335 @@ package main
 
 import (
 	"context"
-	"io"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/exec"
 	"os/signal"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 // ServiceStatus represents the current status of a service
 type ServiceStatus struct {
-	Name      string    `json:"name"`
-	Running   bool      `json:"running"`
-	PID       int       `json:"pid"`
-	StartTime time.Time `json:"start_time"`
-	Restarts  int       `json:"restarts"`
+	Name      string       `json:"name"`
+	State     ServiceState `json:"state"`
+	PID       int          `json:"pid"`
+	StartTime time.Time    `json:"start_time"`
+	Restarts  int          `json:"restarts"`
+
+	// FailureRestarts counts the restarts after the service exited or
+	// misbehaved on its own, out of Restarts for every reason. max_restarts
+	// limits these.
+	FailureRestarts int `json:"failure_restarts,omitempty"`
+
+	// Running is derived from State, and true while the service has a live
+	// process. Kept for clients that predate State.
+	Running bool `json:"running"`
+
+	// Labels configured on the service
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Uptime is measured on the daemon's monotonic clock, so it stays
+	// correct if the wall clock jumps after StartTime was recorded
+	Uptime time.Duration `json:"uptime"`
+
+	// When a service in backoff will be restarted, or a completed interval
+	// oneshot will next run, and how long that is from now
+	NextRestart time.Time     `json:"next_restart,omitzero"`
+	RestartIn   time.Duration `json:"restart_in,omitempty"`
+
+	// How many times in a row its instances have exited and been restarted
+	// with backoff, and whether restart_burst is holding the next one back
+	BackoffAttempt int  `json:"backoff_attempt,omitempty"`
+	RestartLimited bool `json:"restart_limited,omitempty"`
+
+	// When the last failure restarts restart_burst counts were carried out
+	recentRestarts []time.Time
+
+	// Why the service was restarted, most recent last
+	LastRestartReason RestartReason         `json:"last_restart_reason,omitempty"`
+	RestartHistory    []RestartRecord       `json:"restart_history,omitempty"`
+	RestartReasons    map[RestartReason]int `json:"restart_reasons,omitempty"`
+
+	// Instances of a per-connection service running for connections
+	Connections int `json:"connections,omitempty"`
+
+	// Results of its health check, once one has run
+	Health *HealthStatus `json:"health,omitempty"`
+
+	// Its last crash and the output before it, once it has crashed
+	LastCrash *CrashReport `json:"last_crash,omitempty"`
+
+	// Resource use of its running instances, when asked for
+	Usage *processUsage `json:"usage,omitempty"`
 }
 
+// outputDrainTimeout bounds how long pei keeps reading an exited service's
+// output, in case processes it left behind still hold it open
+const outputDrainTimeout = time.Second
+
+// serviceStopTimeout is how long a service gets to exit after SIGTERM before
+// it is killed
+const serviceStopTimeout = 30 * time.Second
+
+// defaultRestartOverlap is how long both instances of a start-first restart
+// run side by side before the old one is stopped
+const defaultRestartOverlap = 2 * time.Second
+
 // Daemon represents the main pei daemon that manages services
 type Daemon struct {
-	config *Config
+	config     *Config
+	configPath string
+
+	// lock is the PID file, held open and locked while the daemon runs
+	lock *os.File
 
 	// Service management
-	serviceCmds    map[string]*exec.Cmd
-	serviceStatus  map[string]*ServiceStatus
-	serviceOutputs map[string]*ServiceOutputCapture
-	restartChan    chan Service
+	serviceProcs  map[string]*serviceProcess
+	serviceStatus map[string]*ServiceStatus
+	serviceLocks  map[string]*sync.Mutex
+	restarts      *restartQueue
+	reloadChan    chan reloadRequest
+	postStartChan chan postStartRequest
+
+	// Health probes whose command runs as the service's user
+	healthChan chan healthRequest
+
+	// Replacements a start-first or blue-green restart is rolling out, by
+	// service, and the outcomes of their rollouts
+	rollouts    map[string]*serviceProcess
+	rolloutChan chan rolloutRequest
+
+	// Crash bundles to write where only root can read them
+	crashBundleChan chan crashBundleRequest
+
+	// On-demand services with an activator watching their sockets
+	activationChan chan activationRequest
+	activators     map[string]bool
+
+	// Per-connection services accepting connections
+	spawnChan chan spawnRequest
+	spawners  map[string]*connectionSpawner
+
+	// Requests from pei run to run a oneshot out of schedule
+	runChan chan runRequest
+
+	// Instances whose command lines have arguments to hide
+	scrubChan chan scrubRequest
+
+	// Requests to stop a service and leave it stopped, and to start it again
+	stopChan  chan stopRequest
+	startChan chan startRequest
+
+	// Services whose files are watched, and requests to act on changes
+	watchers  map[string]*serviceWatcher
+	watchChan chan watchRequest
+
+	// Start tiers from after/before ordering, stopped in reverse on shutdown
+	tiers [][]string
+
+	// Boot timeline for pei boot-analyze
+	boot *BootTimeline
+
+	// Sockets and pipes passed to services, kept open across restarts
+	shared *sharedFiles
+
+	// FIFOs services read as stdin, by path, kept open across restarts
+	stdinFIFOs map[string]*os.File
+
+	// heldRestarts are automatic restarts a maintenance window is holding
+	// until it closes, by service
+	heldRestarts map[string]restartRequest
+
+	// resources admits oneshots' scheduled runs as headroom allows
+	resources *resourceScheduler
 
-	// Synchronization
+	// Recent notable events for pei events
+	events *EventJournal
+
+	// Limits on the local control socket's clients, and the network API
+	// listeners, for metrics
+	ipcLimiter   *ipcLimiter
+	apiListeners []*apiListener
+
+	// For pei's own metrics
+	startedAt time.Time
+	reapStats reapStats
+
+	// Recent output of each service, kept across restarts
+	logs map[string]*LogBuffer
+
+	// Synchronization. ctx is cancelled when shutdown begins.
 	mu     sync.RWMutex
 	ctx    context.Context
 	cancel context.CancelFunc
 
+	// managerDone is closed once the service manager has stopped, so it no
+	// longer changes privileges underneath shutdown. Nil until it starts.
+	managerDone chan struct{}
+
 	// Privilege management
 	appUser  string
 	appGroup string
 }
 
 // NewDaemon creates a new daemon instance
-func NewDaemon(config *Config, appUser, appGroup string) *Daemon {
+func NewDaemon(config *Config, configPath, appUser, appGroup string) *Daemon {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &Daemon{
-		config:         config,
-		serviceCmds:    make(map[string]*exec.Cmd),
-		serviceStatus:  make(map[string]*ServiceStatus),
-		serviceOutputs: make(map[string]*ServiceOutputCapture),
-		restartChan:    make(chan Service, 100),
-		ctx:            ctx,
-		cancel:         cancel,
-		appUser:        appUser,
-		appGroup:       appGroup,
+		config:          config,
+		configPath:      configPath,
+		serviceProcs:    make(map[string]*serviceProcess),
+		serviceStatus:   make(map[string]*ServiceStatus),
+		serviceLocks:    make(map[string]*sync.Mutex),
+		restarts:        newRestartQueue(),
+		reloadChan:      make(chan reloadRequest),
+		postStartChan:   make(chan postStartRequest),
+		activationChan:  make(chan activationRequest),
+		activators:      make(map[string]bool),
+		spawnChan:       make(chan spawnRequest),
+		runChan:         make(chan runRequest),
+		healthChan:      make(chan healthRequest),
+		rollouts:        make(map[string]*serviceProcess),
+		rolloutChan:     make(chan rolloutRequest),
+		crashBundleChan: make(chan crashBundleRequest),
+		scrubChan:       make(chan scrubRequest),
+		stopChan:        make(chan stopRequest),
+		startChan:       make(chan startRequest),
+		watchers:        make(map[string]*serviceWatcher),
+		watchChan:       make(chan watchRequest),
+		spawners:        make(map[string]*connectionSpawner),
+		stdinFIFOs:      make(map[string]*os.File),
+		heldRestarts:    make(map[string]restartRequest),
+		resources:       newResourceScheduler(),
+		events:          NewEventJournal(config),
+		ipcLimiter:      newIPCLimiter(config.IPC),
+		startedAt:       time.Now(),
+		logs:            make(map[string]*LogBuffer),
+		ctx:             ctx,
+		cancel:          cancel,
+		appUser:         appUser,
+		appGroup:        appGroup,
 	}
 }
 
 // Start starts the daemon and all its services
 func (d *Daemon) Start(ctx context.Context) error {
+	build := buildVersion()
+	getLogger("daemon").Info("Starting pei",
+		"version", build.Version,
+		"commit", build.Commit,
+		"schema_version", build.SchemaVersion)
+
+	// Refuse to run alongside another daemon before touching anything it
+	// may be supervising
+	lock, err := acquireDaemonLock(lockPath())
+	if err != nil {
+		return err
+	}
+	d.lock = lock
+	if err := claimSocket(SocketPath); err != nil {
+		return err
+	}
+
+	// Resolve startup ordering before anything is launched
+	tiers, err := startTiers(d.config.Services)
+	if err != nil {
+		return err
+	}
+	d.tiers = tiers
+	d.boot = NewBootTimeline()
+
+	// Check every service before starting any, reporting all problems
+	if problems := preflightServices(d.config); len(problems) > 0 {
+		for _, problem := range problems {
+			logServiceError(problem.service, "Startup validation failed", "error", problem.err)
+		}
+		return fmt.Errorf("startup validation found %d problems", len(problems))
+	}
+
+	// Bind sockets and create pipes while still root
+	shared, err := openSharedFiles(d.config)
+	if err != nil {
+		return err
+	}
+	d.shared = shared
+
+	// Bind network API listeners and read their keys while still root
+	apiListeners, err := openAPIListeners(d.config.API, d.config.IPC)
+	if err != nil {
+		return err
+	}
+	d.apiListeners = apiListeners
+	metricsListener, err := openMetricsListener(d.config.Metrics)
+	if err != nil {
+		return err
+	}
+
+	// Open each service's log spool while still root, restoring output
+	// captured before the daemon last stopped
+	if d.config.LogSpool != nil {
+		if err := prepareLogSpool(d.config.LogSpool, d.appUser, d.appGroup); err != nil {
+			return err
+		}
+		for name := range d.config.Services {
+			d.serviceLogs(name)
+		}
+	}
+
 	// Start IPC server
 	go startIPCServer(d)
+	for _, listener := range apiListeners {
+		go serveAPI(d, listener)
+	}
+	if metricsListener != nil {
+		go d.serveMetrics(metricsListener)
+	}
+	d.startNotifiers()
+	go d.runHeartbeat()
+	go d.runMaintenance()
 
-	// Start each service
-	for name, svc := range d.config.Services {
-		logServiceInfo(name, "Starting service")
-		if err := d.startService(svc); err != nil {
-			continue
+	for _, svc := range d.config.Services {
+		d.setState(svc, StatePending)
+	}
+
+	// Run setup steps to completion before any service starts
+	if err := d.runInit(); err != nil {
+		return err
+	}
+
+	// Start each service in order
+	predecessors := orderingPredecessors(d.config.Services)
+	var failed []string
+	for i, tier := range d.tiers {
+		for _, name := range tier {
+			logServiceInfo(name, "Starting service")
+			d.boot.begin(name, i, predecessors[name])
+			if err := d.startService(d.config.Services[name]); err != nil {
+				d.boot.ready(name, err)
+				failed = append(failed, name)
+			} else if d.config.Services[name].OnDemand {
+				// Ready to take connections, which will start it
+				d.boot.ready(name, nil)
+			}
 		}
+		d.waitTierReady(tier, failed)
+	}
+	d.boot.finish()
+	if len(failed) > 0 {
+		slog.Error("Some services failed to start", "services", failed)
 	}
 
 	// Start service manager
+	d.managerDone = make(chan struct{})
 	go d.serviceManager(ctx)
+	d.syncWatchers(d.config)
+	go d.pollRemoteConfig()
 
 	// Start global reaper
 	go d.globalReaper(ctx)
@@ -89,10 +347,27 @@ func (d *Daemon) Start(ctx context.Context) error {
 
 // Stop gracefully stops the daemon
 func (d *Daemon) Stop() {
-	d.cancel()
 	d.shutdownServices()
 }
 
+// shuttingDown reports whether shutdown has begun, after which services are
+// no longer restarted
+func (d *Daemon) shuttingDown() bool {
+	return d.ctx.Err() != nil
+}
+
+// sleep waits for the duration, returning false early if shutdown begins
+func (d *Daemon) sleep(duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-d.ctx.Done():
+		return false
+	}
+}
+
 // handleSignals manages signal handling for the daemon
 func (d *Daemon) handleSignals(ctx context.Context) error {
 	sigChan := make(chan os.Signal, 1)
@@ -114,6 +389,11 @@ func (d *Daemon) handleSignals(ctx context.Context) error {
 				d.shutdownServices()
 				return nil
 			case syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2:
+				if sig == syscall.SIGHUP && d.getConfig().sighupAction() == SIGHUPReload {
+					// In the background, so shutdown signals are still handled
+					go d.reloadOnSignal()
+					continue
+				}
 				slog.Info("Forwarding signal to all services", "signal", sig.String())
 				d.forwardSignalToServices(sig.(syscall.Signal))
 			case syscall.SIGCHLD:
@@ -127,19 +407,93 @@ func (d *Daemon) handleSignals(ctx context.Context) error {
 	}
 }
 
-// getServiceCmd safely gets a service command
-func (d *Daemon) getServiceCmd(name string) (*exec.Cmd, bool) {
+// serviceProcess is one running instance of a service
+type serviceProcess struct {
+	cmd     *exec.Cmd
+	capture *ServiceOutputCapture
+	exited  chan struct{}
+	ready   *readyState
+
+	// failedCheck holds why the instance's post-start check failed, if it did
+	failedCheck atomic.Pointer[string]
+
+	// detached is set while pei doesn't supervise this instance: a replacement
+	// that hasn't taken over yet, or an old instance being stopped on purpose.
+	// Its exit doesn't update status or trigger the restart policy.
+	detached atomic.Bool
+
+	// killed is set when pei kill ended the instance, so its restart is
+	// told apart from a crash
+	killed atomic.Bool
+}
+
+// running reports whether the process has not exited yet
+func (p *serviceProcess) running() bool {
+	select {
+	case <-p.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// getConfig safely gets the current configuration, which pei reload replaces
+func (d *Daemon) getConfig() *Config {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.config
+}
+
+// getServiceProcess safely gets the current instance of a service
+func (d *Daemon) getServiceProcess(name string) (*serviceProcess, bool) {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
-	cmd, exists := d.serviceCmds[name]
-	return cmd, exists
+	proc, exists := d.serviceProcs[name]
+	return proc, exists
 }
 
-// setServiceCmd safely sets a service command
-func (d *Daemon) setServiceCmd(name string, cmd *exec.Cmd) {
+// lockService serializes starting, restarting and stopping a service, so two
+// of them can't interleave and leave two supervised instances running. It
+// returns the unlock function.
+func (d *Daemon) lockService(name string) func() {
+	d.mu.Lock()
+	lock, exists := d.serviceLocks[name]
+	if !exists {
+		lock = &sync.Mutex{}
+		d.serviceLocks[name] = lock
+	}
+	d.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// setServiceProcess safely sets the current instance of a service
+func (d *Daemon) setServiceProcess(name string, proc *serviceProcess) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	d.serviceCmds[name] = cmd
+	d.serviceProcs[name] = proc
+}
+
+// getAllServiceProcesses safely gets the current instance of every service
+func (d *Daemon) getAllServiceProcesses() map[string]*serviceProcess {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	result := make(map[string]*serviceProcess)
+	for name, proc := range d.serviceProcs {
+		result[name] = proc
+	}
+	return result
+}
+
+// getServiceCmd safely gets a service command
+func (d *Daemon) getServiceCmd(name string) (*exec.Cmd, bool) {
+	proc, exists := d.getServiceProcess(name)
+	if !exists {
+		return nil, false
+	}
+	return proc.cmd, true
 }
 
 // getServiceStatus safely gets service status
@@ -159,12 +513,9 @@ func (d *Daemon) setServiceStatus(name string, status *ServiceStatus) {
 
 // getAllServiceCmds safely gets all service commands
 func (d *Daemon) getAllServiceCmds() map[string]*exec.Cmd {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
-
 	result := make(map[string]*exec.Cmd)
-	for name, cmd := range d.serviceCmds {
-		result[name] = cmd
+	for name, proc := range d.getAllServiceProcesses() {
+		result[name] = proc.cmd
 	}
 	return result
 }
@@ -176,55 +527,67 @@ func (d *Daemon) getAllServiceStatus() map[string]*ServiceStatus {
 
 	result := make(map[string]*ServiceStatus)
 	for name, status := range d.serviceStatus {
-		result[name] = status
+		result[name] = status.snapshot()
 	}
 	return result
 }
 
-// setServiceOutput safely sets service output capture
-func (d *Daemon) setServiceOutput(name string, output *ServiceOutputCapture) {
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	d.serviceOutputs[name] = output
-}
-
-// startServiceOutputCapture sets up output capture for a service
-func (d *Daemon) startServiceOutputCapture(service Service, stdoutPipe, stderrPipe io.ReadCloser, pid int) *ServiceOutputCapture {
-	capture := NewServiceOutputCapture(service, stdoutPipe, stderrPipe, pid)
-	d.setServiceOutput(service.Name, capture)
-	capture.Start()
-	return capture
+// snapshot copies a status for reporting, filling in the current uptime.
+// StartTime carries a monotonic clock reading while the daemon runs, so
+// time.Since ignores wall clock steps.
+func (s *ServiceStatus) snapshot() *ServiceStatus {
+	copied := *s
+	if s.Running {
+		copied.Uptime = time.Since(s.StartTime)
+	}
+	if !s.NextRestart.IsZero() {
+		copied.RestartIn = max(time.Until(s.NextRestart), 0)
+	}
+	return &copied
 }
 
-// stopServiceOutputCapture stops output capture for a service
-func (d *Daemon) stopServiceOutputCapture(serviceName string) {
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	if capture, exists := d.serviceOutputs[serviceName]; exists {
-		capture.Stop()
-		delete(d.serviceOutputs, serviceName)
+// getServiceOutput safely gets service output capture
+func (d *Daemon) getServiceOutput(name string) (*ServiceOutputCapture, bool) {
+	proc, exists := d.getServiceProcess(name)
+	if !exists {
+		return nil, false
 	}
+	return proc.capture, true
 }
 
 // stopAllServiceOutputCaptures stops all service output captures
 func (d *Daemon) stopAllServiceOutputCaptures() {
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	for serviceName, capture := range d.serviceOutputs {
-		capture.Stop()
-		delete(d.serviceOutputs, serviceName)
+	for _, proc := range d.getAllServiceProcesses() {
+		proc.capture.Stop()
 	}
 }
 
-// startService starts a single service with proper privilege management
-func (d *Daemon) startService(svc Service) error {
-	uid, gid, err := lookupUIDGID(svc.User, svc.Group)
-	if err != nil {
-		logServiceError(svc.Name, "Failed to look up user/group", "error", err)
-		return err
+// serviceSysProcAttr returns the process attributes a service is started with
+func serviceSysProcAttr(svc Service, uid, gid int) *syscall.SysProcAttr {
+	attr := &syscall.SysProcAttr{Credential: serviceCredential(uid, gid)}
+
+	if svc.TTY {
+		// A PTY needs a new session with the slave as controlling terminal
+		attr.Setsid = true
+		attr.Setctty = true
+		attr.Ctty = 0
+	} else if svc.NewSession {
+		// New session, which also makes the service its own process group
+		// leader, detached from any controlling terminal pei has
+		attr.Setsid = true
+	} else {
+		// Own process group so the service and its workers can be signalled together
+		attr.Setpgid = true
 	}
+	return attr
+}
 
+// buildServiceCmd prepares the command for a service without starting it
+func buildServiceCmd(svc Service, uid, gid int) *exec.Cmd {
 	cmd := exec.Command(svc.Command[0], svc.Command[1:]...)
+	if svc.ProcessTitle != "" {
+		cmd.Args[0] = svc.ProcessTitle
+	}
 
 	// Set working directory if specified
 	if svc.WorkingDir != "" {
@@ -232,254 +595,803 @@ func (d *Daemon) startService(svc Service) error {
 	}
 
 	// Set environment variables
-	if len(svc.Environment) > 0 {
-		env := os.Environ()
-		for k, v := range svc.Environment {
-			env = append(env, k+"="+v)
-		}
-		cmd.Env = env
+	cmd.Env = serviceEnvironment(svc)
+
+	// Set process credentials
+	cmd.SysProcAttr = serviceSysProcAttr(svc, uid, gid)
+	return cmd
+}
+
+// buildHelperCmd prepares a short-lived command, such as a reload or drain
+// command, that runs alongside a service as the service's user
+func buildHelperCmd(ctx context.Context, svc Service, args []string) (*exec.Cmd, error) {
+	uid, gid, err := lookupUIDGID(svc.User, svc.Group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user/group: %v", err)
 	}
 
-	// Set up pipes to capture service output
-	stdoutPipe, err := cmd.StdoutPipe()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = svc.WorkingDir
+	cmd.Env = serviceEnvironment(svc)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: serviceCredential(uid, gid)}
+	return cmd, nil
+}
+
+// serviceEnvironment returns the environment a service runs with, or nil to
+// inherit pei's own
+func serviceEnvironment(svc Service) []string {
+	if len(svc.Environment) == 0 && svc.Timezone == "" && svc.Locale == "" {
+		return nil
+	}
+	env := append(os.Environ(), localeEnvironment(svc)...)
+	for k, v := range svc.Environment {
+		env = append(env, k+"="+v)
+	}
+	return env
+}
+
+// launchService starts a new instance of a service and begins monitoring it.
+// A candidate instance starts detached and only becomes the service's current
+// instance once promoted. Must be called with elevated privileges.
+func (d *Daemon) launchService(svc Service, message string, candidate bool) (*serviceProcess, error) {
+	if len(svc.Command) == 0 {
+		return nil, fmt.Errorf("no command configured")
+	}
+	if err := verifyCommand(svc); err != nil {
+		return nil, err
+	}
+
+	uid, gid, err := lookupUIDGID(svc.User, svc.Group)
 	if err != nil {
-		logServiceError(svc.Name, "Failed to create stdout pipe", "error", err)
-		return err
+		return nil, fmt.Errorf("failed to look up user/group: %v", err)
 	}
 
-	stderrPipe, err := cmd.StderrPipe()
+	cmd := buildServiceCmd(svc, uid, gid)
+	if err := userNamespaceCommand(cmd, svc, uid, gid); err != nil {
+		return nil, err
+	}
+	join, err := d.sandboxJoins(svc)
 	if err != nil {
-		logServiceError(svc.Name, "Failed to create stderr pipe", "error", err)
-		return err
+		return nil, err
+	}
+	if err := sandboxCommand(cmd, svc, uid, gid, join); err != nil {
+		return nil, err
 	}
 
-	// Set process credentials
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Credential: &syscall.Credential{
-			Uid: uint32(uid),
-			Gid: uint32(gid),
-		},
+	stdin, releaseStdin, err := d.serviceStdin(svc, uid, gid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdin: %v", err)
+	}
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+
+	// Set up pipes (or a PTY) to capture service output
+	sio, err := setupServiceOutput(cmd, svc, uid, gid)
+	if err != nil {
+		releaseStdin()
+		return nil, fmt.Errorf("failed to set up output capture: %v", err)
+	}
+
+	// Pass any extra file descriptors
+	releaseFiles, err := d.setupExtraFiles(cmd, svc)
+	if err != nil {
+		releaseStdin()
+		sio.afterStart(false)
+		return nil, fmt.Errorf("failed to set up extra files: %v", err)
+	}
+
+	outputFiles, err := openOutputFiles(svc, uid, gid)
+	if err != nil {
+		releaseFiles()
+		releaseStdin()
+		sio.afterStart(false)
+		return nil, fmt.Errorf("failed to open output files: %v", err)
 	}
 
 	serviceLogger := getLogger("service")
-	serviceLogger.Info("Starting service",
+	serviceLogger.Info(message,
 		"service", svc.Name,
 		"user", svc.User,
 		"group", svc.Group,
 		"uid", uid,
 		"gid", gid)
 
-	if err := cmd.Start(); err != nil {
-		logServiceError(svc.Name, "Failed to start", "error", err)
-		return err
+	prepareReadiness(svc)
+	err = startLabeled(cmd, svc)
+	sio.afterStart(err == nil)
+	releaseFiles()
+	releaseStdin()
+	if err != nil {
+		outputFiles.Close()
+		return nil, err
 	}
 
-	d.setServiceCmd(svc.Name, cmd)
+	proc := &serviceProcess{cmd: cmd, exited: make(chan struct{}), ready: newReadyState()}
+	proc.detached.Store(candidate)
 
 	// Start capturing service output
-	d.startServiceOutputCapture(svc, stdoutPipe, stderrPipe, cmd.Process.Pid)
-
-	// Initialize service status
-	d.setServiceStatus(svc.Name, &ServiceStatus{
-		Name:      svc.Name,
-		Running:   true,
-		PID:       cmd.Process.Pid,
-		StartTime: time.Now(),
-		Restarts:  0,
-	})
+	proc.capture = NewServiceOutputCapture(svc, sio.stdout, sio.stderr, cmd.Process.Pid)
+	proc.capture.input = sio.input
+	proc.capture.files = outputFiles
+	proc.capture.history = d.serviceLogs(svc.Name)
+	proc.capture.onLine = d.readyLogMatcher(svc, proc)
+	proc.capture.Start()
+
+	if !candidate {
+		d.promoteProcess(svc, proc)
+	}
+	d.watchReadiness(svc, proc)
+	d.schedulePostStartCheck(svc, proc)
+	d.scheduleScrubArgs(svc, proc, uid, gid)
 
 	// Start the service monitor goroutine
-	go d.monitorService(svc, cmd)
+	go d.monitorService(svc, proc)
+	if svc.MaxRuntime > 0 {
+		go d.enforceMaxRuntime(svc, proc)
+	}
+	if svc.HealthCheck != nil && !svc.Oneshot {
+		go d.monitorHealth(svc, proc)
+	}
 
-	return nil
+	return proc, nil
+}
+
+// promoteProcess makes an instance the service's current, supervised one
+func (d *Daemon) promoteProcess(svc Service, proc *serviceProcess) {
+	d.setServiceProcess(svc.Name, proc)
+	proc.detached.Store(false)
+
+	state := StateStarting
+	if proc.ready.isReady() {
+		state = StateRunning
+	}
+	d.setState(svc, state)
+
+	d.mu.Lock()
+	status := d.serviceStatus[svc.Name]
+	status.PID = proc.cmd.Process.Pid
+	status.StartTime = time.Now()
+	// Failures in a row are counted per instance
+	if status.Health != nil {
+		health := *status.Health
+		health.ConsecutiveFailures = 0
+		status.Health = &health
+	}
+	d.mu.Unlock()
+
+	if err := d.writePIDFile(svc, proc.cmd.Process.Pid); err != nil {
+		logServiceError(svc.Name, "Failed to write pid file", "path", svc.PIDFile, "error", err)
+	}
+}
+
+// startService starts a single service with proper privilege management. A
+// service that fails to start is tried again up to start_retries times,
+// backing off between tries, before it's marked failed.
+func (d *Daemon) startService(svc Service) error {
+	if svc.OnDemand {
+		d.awaitActivation(svc)
+		return nil
+	}
+	for attempt := 1; ; attempt++ {
+		err := d.startInstance(svc)
+		if err == nil {
+			return nil
+		}
+		if attempt > svc.StartRetries {
+			logServiceError(svc.Name, "Failed to start", "error", err)
+			d.failService(svc, fmt.Sprintf("failed to start: %v", err))
+			return err
+		}
+
+		delay := startRetryDelay(svc, attempt)
+		logServiceError(svc.Name, "Failed to start, retrying",
+			"error", err,
+			"attempt", attempt,
+			"start_retries", svc.StartRetries,
+			"delay", delay.String())
+		d.setState(svc, StateBackoff)
+		d.setNextRestart(svc, delay)
+		if !d.sleep(delay) {
+			return err
+		}
+	}
+}
+
+// startInstance starts a service's instance once, or its connection spawner
+func (d *Daemon) startInstance(svc Service) error {
+	if svc.Spawn == SpawnPerConnection {
+		return d.startSpawner(svc)
+	}
+	unlock := d.lockService(svc.Name)
+	defer unlock()
+	_, err := d.launchService(svc, "Starting service", false)
+	return err
 }
 
 // monitorService monitors a service and requests restarts when needed
-func (d *Daemon) monitorService(svc Service, cmd *exec.Cmd) {
+func (d *Daemon) monitorService(svc Service, proc *serviceProcess) {
+	// Snapshot what's left of the process before it's reaped, in case it crashed
+	var remnants map[string][]byte
+	if svc.CrashBundle != nil {
+		remnants = collectProcRemnants(proc.cmd.Process.Pid)
+	}
+
 	// Wait for the service to exit
-	err := cmd.Wait()
+	err := waitChild(proc.cmd)
+	close(proc.exited)
+	d.resources.release(svc.Name)
+	removePIDFile(svc, proc.cmd.Process.Pid)
+
+	// A failed post-start check fails the start, however the instance exited
+	failedCheck := proc.failedCheck.Load()
+	if failedCheck != nil && err == nil {
+		err = fmt.Errorf("post_start_check failed: %s", *failedCheck)
+	}
+
+	// Read the rest of its output, then stop capturing for this instance
+	proc.capture.waitDrained(outputDrainTimeout)
+	proc.capture.Stop()
 
-	// Stop capturing output for this service
-	d.stopServiceOutputCapture(svc.Name)
+	// Instances pei stopped or hasn't promoted aren't supervised
+	if proc.detached.Load() {
+		logServiceInfo(svc.Name, "Unsupervised instance exited", "pid", proc.cmd.Process.Pid)
+		return
+	}
+
+	// Supervise by the current definition, which a reload may have updated
+	// without restarting the service
+	if current, exists := d.getConfig().Services[svc.Name]; exists {
+		svc = current
+	}
 
 	// Update service status to not running
-	if status, exists := d.getServiceStatus(svc.Name); exists {
-		status.Running = false
+	if d.shuttingDown() {
+		d.setState(svc, StateStopped)
+	} else if err != nil {
+		d.setState(svc, StateFailed)
+	} else {
+		d.setState(svc, StateCompleted)
+	}
+	if err != nil && !d.shuttingDown() && !proc.killed.Load() {
+		d.recordCrash(svc, proc, err)
 	}
 
-	// For oneshot services, handle differently
-	if svc.Oneshot {
-		if svc.Interval > 0 {
-			monitorLogger := getLogger("monitor")
-			monitorLogger.Info("Oneshot service completed, scheduling next run",
+	if err != nil && svc.CrashBundle != nil {
+		d.requestCrashBundle(crashBundleRequest{svc: svc, proc: proc, err: err, remnants: remnants})
+	}
+
+	// Services stopped by shutdown stay stopped
+	if d.shuttingDown() {
+		logServiceInfo(svc.Name, "Service exited during shutdown, not restarting")
+		return
+	}
+
+	if !svc.Oneshot {
+		if err != nil {
+			getLogger("monitor").Info("Service exited with error",
 				"service", svc.Name,
-				"interval", svc.Interval.String())
-			time.Sleep(svc.Interval)
-			// Request a restart through the service manager
-			select {
-			case d.restartChan <- svc:
-			case <-d.ctx.Done():
-				return // Daemon is shutting down
-			}
+				"error", err)
 		} else {
-			logServiceInfo(svc.Name, "Oneshot service completed, no interval specified")
+			logServiceInfo(svc.Name, "Service exited successfully")
 		}
-		return
 	}
 
-	// For non-oneshot services, check if we should restart
-	shouldRestart := false
-	monitorLogger := getLogger("monitor")
-	if err != nil {
-		monitorLogger.Info("Service exited with error",
+	restarts := d.failureRestartCount(svc.Name)
+	switch decideExit(svc, err, restarts) {
+	case exitSchedule:
+		getLogger("monitor").Info("Oneshot service completed, scheduling next run",
 			"service", svc.Name,
-			"error", err)
-		if svc.Restart == RestartAlways || svc.Restart == RestartOnFailure {
-			shouldRestart = true
+			"interval", svc.Interval.String())
+		d.setNextRestart(svc, svc.Interval)
+		if !d.sleep(svc.Interval) || !d.awaitResources(svc) {
+			return
 		}
-	} else {
-		logServiceInfo(svc.Name, "Service exited successfully")
-		if svc.Restart == RestartAlways {
-			shouldRestart = true
+		// Request a restart through the service manager
+		d.requestRestart(restartRequest{svc: svc, reason: RestartReasonSchedule, detail: svc.Interval.String(), instance: proc})
+
+	case exitGiveUp:
+		getLogger("monitor").Info("Service exceeded max restarts, giving up",
+			"service", svc.Name,
+			"max_restarts", svc.MaxRestarts,
+			"restart_count", restarts)
+		d.failService(svc, fmt.Sprintf("exceeded max_restarts (%d)", svc.MaxRestarts))
+
+	case exitRestart:
+		d.setState(svc, StateBackoff)
+		delay := d.scheduleRestart(svc)
+
+		// Wait for restart delay, unless shutdown begins meanwhile
+		if !d.sleep(delay) {
+			logServiceInfo(svc.Name, "Shutdown began, cancelling pending restart")
+			return
 		}
-	}
+		// Request a restart through the service manager
+		reason, detail := exitRestartReason(err)
+		if failedCheck != nil {
+			reason, detail = RestartReasonPostStartCheck, *failedCheck
+		}
+		if proc.killed.Load() {
+			reason, detail = RestartReasonKilled, ""
+		}
+		d.requestRestart(restartRequest{svc: svc, reason: reason, detail: detail, instance: proc})
 
-	// Check if we should restart and haven't exceeded limits
-	if shouldRestart {
-		// Update restart count in status
-		if status, exists := d.getServiceStatus(svc.Name); exists {
-			if svc.MaxRestarts > 0 && status.Restarts >= svc.MaxRestarts {
-				monitorLogger.Info("Service exceeded max restarts, giving up",
-					"service", svc.Name,
-					"max_restarts", svc.MaxRestarts,
-					"restart_count", status.Restarts)
-				return
-			}
-			status.Restarts++
+	default:
+		if err != nil {
+			d.failService(svc, err.Error())
+		} else if svc.Oneshot {
+			logServiceInfo(svc.Name, "Oneshot service completed, no interval specified")
 		}
+	}
+}
 
-		// Wait for restart delay
-		time.Sleep(svc.RestartDelay)
-		// Request a restart through the service manager
-		select {
-		case d.restartChan <- svc:
-		case <-d.ctx.Done():
-			return // Daemon is shutting down
+// exitAction is what supervision does after a service's instance exits
+type exitAction int
+
+const (
+	exitStay     exitAction = iota // leave the service stopped
+	exitRestart                    // restart it after its restart delay
+	exitGiveUp                     // it has used up max_restarts, so fail it
+	exitSchedule                   // run a oneshot again after its interval
+)
+
+// decideExit applies a service's restart policy to an instance that exited
+// with err, after the service was restarted restarts times
+func decideExit(svc Service, err error, restarts int) exitAction {
+	if svc.Oneshot {
+		if svc.Interval > 0 {
+			return exitSchedule
 		}
+		return exitStay
+	}
+
+	restart := svc.Restart == RestartAlways || (err != nil && svc.Restart == RestartOnFailure)
+	switch {
+	case !restart:
+		return exitStay
+	case svc.MaxRestarts > 0 && restarts >= svc.MaxRestarts:
+		return exitGiveUp
+	default:
+		return exitRestart
 	}
 }
 
-// serviceManager handles service restarts with proper privilege management
+// serviceManager handles service restarts with proper privilege management.
+// It stops when shutdown begins.
 func (d *Daemon) serviceManager(ctx context.Context) {
+	defer close(d.managerDone)
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case svc := <-d.restartChan:
-			// Elevate privileges before starting the service
+		case <-d.ctx.Done():
+			return
+		case <-d.restarts.ready:
+			if d.shuttingDown() {
+				return
+			}
+			// One restart per wake, so reloads and checks aren't held up
+			// behind a long queue
+			if req, ok := d.restarts.pop(); ok && !d.holdForMaintenance(&req) {
+				d.processRestart(req)
+			}
+		case req := <-d.reloadChan:
 			if err := elevatePrivileges(); err != nil {
-				logServiceError(svc.Name, "Failed to elevate privileges for restart", "error", err)
+				req.reply <- reloadResult{err: fmt.Errorf("failed to elevate privileges for reload: %v", err)}
 				continue
 			}
 
-			uid, gid, err := lookupUIDGID(svc.User, svc.Group)
-			if err != nil {
-				logServiceError(svc.Name, "Failed to look up user/group for restart", "error", err)
-				if dropErr := dropPrivileges(d.appUser, d.appGroup); dropErr != nil {
-					logServiceError(svc.Name, "Failed to drop privileges after error", "error", dropErr)
-				}
+			summary, err := d.reloadConfig(req.dryRun)
+			req.reply <- reloadResult{summary: summary, err: err}
+
+			if err := dropPrivileges(d.appUser, d.appGroup); err != nil {
+				slog.Error("Failed to drop privileges after reload", "error", err)
+			}
+		case req := <-d.postStartChan:
+			if err := elevatePrivileges(); err != nil {
+				logServiceError(req.svc.Name, "Failed to elevate privileges for post-start check", "error", err)
 				continue
 			}
 
-			cmd := exec.Command(svc.Command[0], svc.Command[1:]...)
+			d.runPostStartCheck(req.svc, req.proc)
 
-			// Set working directory if specified
-			if svc.WorkingDir != "" {
-				cmd.Dir = svc.WorkingDir
+			if err := dropPrivileges(d.appUser, d.appGroup); err != nil {
+				logServiceError(req.svc.Name, "Failed to drop privileges after post-start check", "error", err)
+			}
+		case req := <-d.healthChan:
+			if !req.proc.running() {
+				req.done <- fmt.Errorf("instance exited")
+				continue
+			}
+			if err := elevatePrivileges(); err != nil {
+				req.done <- fmt.Errorf("failed to elevate privileges: %v", err)
+				continue
 			}
 
-			// Set environment variables
-			if len(svc.Environment) > 0 {
-				env := os.Environ()
-				for k, v := range svc.Environment {
-					env = append(env, k+"="+v)
-				}
-				cmd.Env = env
+			req.done <- d.probeHealth(req.svc, req.proc)
+
+			if err := dropPrivileges(d.appUser, d.appGroup); err != nil {
+				logServiceError(req.svc.Name, "Failed to drop privileges after health check", "error", err)
+			}
+		case req := <-d.rolloutChan:
+			if err := elevatePrivileges(); err != nil {
+				logServiceError(req.svc.Name, "Failed to elevate privileges to finish rollout", "error", err)
+				continue
 			}
 
-			// Set up pipes to capture service output for restarted services
-			stdoutPipe, err := cmd.StdoutPipe()
-			if err != nil {
-				logServiceError(svc.Name, "Failed to create stdout pipe for restart", "error", err)
-				if dropErr := dropPrivileges(d.appUser, d.appGroup); dropErr != nil {
-					logServiceError(svc.Name, "Failed to drop privileges after error", "error", dropErr)
-				}
+			d.completeRollout(req)
+
+			if err := dropPrivileges(d.appUser, d.appGroup); err != nil {
+				logServiceError(req.svc.Name, "Failed to drop privileges after rollout", "error", err)
+			}
+		case req := <-d.crashBundleChan:
+			if err := elevatePrivileges(); err != nil {
+				logServiceError(req.svc.Name, "Failed to elevate privileges to write crash bundle", "error", err)
 				continue
 			}
 
-			stderrPipe, err := cmd.StderrPipe()
-			if err != nil {
-				logServiceError(svc.Name, "Failed to create stderr pipe for restart", "error", err)
-				if dropErr := dropPrivileges(d.appUser, d.appGroup); dropErr != nil {
-					logServiceError(svc.Name, "Failed to drop privileges after error", "error", dropErr)
-				}
+			d.recordCrashBundle(req)
+
+			if err := dropPrivileges(d.appUser, d.appGroup); err != nil {
+				logServiceError(req.svc.Name, "Failed to drop privileges after writing crash bundle", "error", err)
+			}
+		case req := <-d.spawnChan:
+			if err := elevatePrivileges(); err != nil {
+				req.reply <- spawnResult{err: fmt.Errorf("failed to elevate privileges: %v", err)}
 				continue
 			}
 
-			// Set process credentials
-			cmd.SysProcAttr = &syscall.SysProcAttr{
-				Credential: &syscall.Credential{
-					Uid: uint32(uid),
-					Gid: uint32(gid),
-				},
+			proc, err := d.launchConnection(req)
+			req.reply <- spawnResult{proc: proc, err: err}
+
+			if err := dropPrivileges(d.appUser, d.appGroup); err != nil {
+				logServiceError(req.svc.Name, "Failed to drop privileges after starting instance for connection", "error", err)
+			}
+		case req := <-d.runChan:
+			if err := elevatePrivileges(); err != nil {
+				req.reply <- spawnResult{err: fmt.Errorf("failed to elevate privileges: %v", err)}
+				continue
 			}
 
-			serviceLogger := getLogger("service")
-			serviceLogger.Info("Restarting service",
-				"service", svc.Name,
-				"user", svc.User,
-				"group", svc.Group,
-				"uid", uid,
-				"gid", gid)
-
-			if err := cmd.Start(); err != nil {
-				logServiceError(svc.Name, "Failed to restart", "error", err)
-				if dropErr := dropPrivileges(d.appUser, d.appGroup); dropErr != nil {
-					logServiceError(svc.Name, "Failed to drop privileges after error", "error", dropErr)
+			proc, err := d.launchRun(req.svc)
+			req.reply <- spawnResult{proc: proc, err: err}
+
+			if err := dropPrivileges(d.appUser, d.appGroup); err != nil {
+				logServiceError(req.svc.Name, "Failed to drop privileges after run", "error", err)
+			}
+		case req := <-d.scrubChan:
+			if err := elevatePrivileges(); err != nil {
+				logServiceError(req.svc.Name, "Failed to elevate privileges to scrub arguments", "error", err)
+				continue
+			}
+
+			d.scrubArgs(req)
+
+			if err := dropPrivileges(d.appUser, d.appGroup); err != nil {
+				logServiceError(req.svc.Name, "Failed to drop privileges after scrubbing arguments", "error", err)
+			}
+		case req := <-d.activationChan:
+			if err := elevatePrivileges(); err != nil {
+				logServiceError(req.svc.Name, "Failed to elevate privileges for on-demand start or stop", "error", err)
+				close(req.done)
+				continue
+			}
+
+			d.activate(req)
+			close(req.done)
+
+			if err := dropPrivileges(d.appUser, d.appGroup); err != nil {
+				logServiceError(req.svc.Name, "Failed to drop privileges after on-demand start or stop", "error", err)
+			}
+		case req := <-d.stopChan:
+			if err := elevatePrivileges(); err != nil {
+				logServiceError(req.svc.Name, "Failed to elevate privileges for stop", "error", err)
+				if req.done != nil {
+					close(req.done)
 				}
 				continue
 			}
 
-			// Update the service command in our tracking map
-			d.setServiceCmd(svc.Name, cmd)
-
-			// Start capturing service output for restarted service
-			d.startServiceOutputCapture(svc, stdoutPipe, stderrPipe, cmd.Process.Pid)
-
-			// Update service status
-			if status, exists := d.getServiceStatus(svc.Name); exists {
-				status.Running = true
-				status.PID = cmd.Process.Pid
-				status.StartTime = time.Now()
-			} else {
-				d.setServiceStatus(svc.Name, &ServiceStatus{
-					Name:      svc.Name,
-					Running:   true,
-					PID:       cmd.Process.Pid,
-					StartTime: time.Now(),
-					Restarts:  0,
-				})
+			d.stopInstance(req)
+			if req.done != nil {
+				close(req.done)
 			}
 
-			// Drop privileges after starting the service
 			if err := dropPrivileges(d.appUser, d.appGroup); err != nil {
-				logServiceError(svc.Name, "Failed to drop privileges after restart", "error", err)
+				logServiceError(req.svc.Name, "Failed to drop privileges after stop", "error", err)
+			}
+		case req := <-d.startChan:
+			if err := elevatePrivileges(); err != nil {
+				req.done <- fmt.Errorf("failed to elevate privileges: %v", err)
 				continue
 			}
 
-			// Start monitoring the new process
-			go d.monitorService(svc, cmd)
+			req.done <- d.startStopped(req.svc)
+
+			if err := dropPrivileges(d.appUser, d.appGroup); err != nil {
+				logServiceError(req.svc.Name, "Failed to drop privileges after start", "error", err)
+			}
+		case req := <-d.watchChan:
+			if err := elevatePrivileges(); err != nil {
+				logServiceError(req.svc.Name, "Failed to elevate privileges for watched file change", "error", err)
+				continue
+			}
+
+			if err := d.applyWatchAction(req); err != nil {
+				logServiceError(req.svc.Name, "Failed to act on watched file change", "path", req.path, "error", err)
+			}
+
+			if err := dropPrivileges(d.appUser, d.appGroup); err != nil {
+				logServiceError(req.svc.Name, "Failed to drop privileges after watched file change", "error", err)
+			}
+		}
+	}
+}
+
+// processRestart carries out a queued restart
+func (d *Daemon) processRestart(req restartRequest) {
+	// Restart with the current definition, skipping services a reload
+	// removed since the restart was requested
+	svc, exists := d.getConfig().Services[req.svc.Name]
+	if !exists {
+		return
+	}
+	req.svc = svc
+
+	unlock := d.lockService(svc.Name)
+	defer unlock()
+
+	// A restart asked for when an instance exited is done if something else
+	// has started another since
+	if req.instance != nil {
+		if current, exists := d.getServiceProcess(svc.Name); exists && current != req.instance {
+			logServiceInfo(svc.Name, "Service was restarted since this restart was requested, skipping it",
+				"reason", string(req.reason))
+			return
+		}
+	}
+	// A service an operator stopped stays stopped until they start or
+	// restart it
+	if d.serviceState(svc.Name) == StateDisabled && !req.reason.requested() {
+		logServiceInfo(svc.Name, "Service was stopped by an operator, skipping restart",
+			"reason", string(req.reason))
+		return
+	}
+	d.recordRestart(req)
+
+	// Elevate privileges before starting the service
+	if err := elevatePrivileges(); err != nil {
+		logServiceError(svc.Name, "Failed to elevate privileges for restart", "error", err)
+		return
+	}
+
+	d.restartService(svc)
+
+	// Drop privileges after starting the service
+	if err := dropPrivileges(d.appUser, d.appGroup); err != nil {
+		logServiceError(svc.Name, "Failed to drop privileges after restart", "error", err)
+	}
+}
+
+// restartService starts a service again, replacing its current instance
+// according to its restart strategy if that is still running. Must be called
+// with elevated privileges and the service locked.
+func (d *Daemon) restartService(svc Service) {
+	// Per-connection services have no instance of their own to replace;
+	// new connections get the new definition
+	if svc.Spawn == SpawnPerConnection {
+		if err := d.startSpawner(svc); err != nil {
+			logServiceError(svc.Name, "Failed to restart", "error", err)
+		}
+		return
+	}
+	d.stopSpawner(svc.Name)
+
+	// This restart supersedes a replacement still being rolled out
+	if pending := d.takeRollout(svc.Name, nil); pending != nil {
+		logServiceInfo(svc.Name, "Stopping replacement instance still being rolled out", "pid", pending.cmd.Process.Pid)
+		d.terminateProcess(svc.Name, pending, serviceStopTimeout)
+	}
+
+	old, exists := d.getServiceProcess(svc.Name)
+	if exists && old.running() {
+		switch svc.RestartStrategy {
+		case RestartStrategyStartFirst:
+			d.restartStartFirst(svc, old)
+			return
+		case RestartStrategyBlueGreen:
+			d.restartBlueGreen(svc, old)
+			return
+		}
+	}
+
+	if exists && old.running() {
+		d.stopProcess(svc.Name, old, serviceStopTimeout)
+	}
+
+	if _, err := d.launchService(svc, "Restarting service", false); err != nil {
+		logServiceError(svc.Name, "Failed to restart", "error", err)
+	}
+}
+
+// restartStartFirst starts a replacement instance while the old one keeps
+// serving, and only stops the old one once the replacement has stayed up for
+// the overlap period. Services sharing a passed listener fd or binding with
+// SO_REUSEPORT keep accepting connections throughout.
+func (d *Daemon) restartStartFirst(svc Service, old *serviceProcess) {
+	proc, err := d.launchService(svc, "Starting replacement instance", true)
+	if err != nil {
+		logServiceError(svc.Name, "Failed to start replacement instance, keeping current one", "error", err)
+		return
+	}
+
+	overlap := svc.RestartOverlap
+	if overlap <= 0 {
+		overlap = defaultRestartOverlap
+	}
+	d.rollOut(svc, old, proc, func() error {
+		select {
+		case <-proc.exited:
+			return fmt.Errorf("replacement instance exited during the %s overlap", overlap)
+		case <-time.After(overlap):
+			return nil
+		case <-d.ctx.Done():
+			return d.ctx.Err()
+		}
+	})
+}
+
+// restartBlueGreen starts a replacement instance and only switches to it once
+// it passes the service's health check. A replacement that fails is stopped
+// and the old instance keeps running; either outcome is recorded as an event.
+func (d *Daemon) restartBlueGreen(svc Service, old *serviceProcess) {
+	proc, err := d.launchService(svc, "Starting replacement instance", true)
+	if err != nil {
+		logServiceError(svc.Name, "Failed to start replacement instance, keeping current one", "error", err)
+		d.events.record(EventRolloutFailed, svc.Name, "Replacement instance failed to start, kept current instance",
+			map[string]string{"error": err.Error()})
+		return
+	}
+
+	d.rollOut(svc, old, proc, func() error {
+		if err := d.waitHealthy(svc, proc); err != nil {
+			return fmt.Errorf("replacement instance failed its health check: %v", err)
+		}
+		return nil
+	})
+}
+
+// rolloutRequest asks the service manager to finish rolling out a
+// replacement instance: to hand over to it, or if wait failed, to stop it
+type rolloutRequest struct {
+	svc       Service
+	old, proc *serviceProcess
+	err       error
+}
+
+// rollOut waits for a replacement instance to be ready to take over, off
+// the service manager so other services aren't held up meanwhile, then has
+// the service manager finish the rollout
+func (d *Daemon) rollOut(svc Service, old, proc *serviceProcess, wait func() error) {
+	d.mu.Lock()
+	d.rollouts[svc.Name] = proc
+	d.mu.Unlock()
+
+	go func() {
+		req := rolloutRequest{svc: svc, old: old, proc: proc, err: wait()}
+		select {
+		case d.rolloutChan <- req:
+		case <-d.ctx.Done():
+			// Shutdown stops the replacement
 		}
+	}()
+}
+
+// takeRollout forgets the replacement being rolled out for a service and
+// returns it, or nil if there is none. Given proc, it only does so if that
+// is the replacement, so a superseded rollout doesn't take the one after it.
+func (d *Daemon) takeRollout(name string, proc *serviceProcess) *serviceProcess {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	pending := d.rollouts[name]
+	if pending == nil || (proc != nil && pending != proc) {
+		return nil
+	}
+	delete(d.rollouts, name)
+	return pending
+}
+
+// completeRollout hands a service over to its replacement instance, or stops
+// the replacement if it isn't fit to take over or the old instance is no
+// longer current. Must be called with elevated privileges.
+func (d *Daemon) completeRollout(req rolloutRequest) {
+	svc, old, proc := req.svc, req.old, req.proc
+	unlock := d.lockService(svc.Name)
+	defer unlock()
+
+	// A restart since has already stopped this replacement
+	if d.takeRollout(svc.Name, proc) == nil {
+		return
+	}
+
+	err := req.err
+	if current, _ := d.getServiceProcess(svc.Name); err == nil && (current != old || !old.running()) {
+		err = fmt.Errorf("the current instance was stopped or replaced during the rollout")
+	}
+	if err == nil && !proc.running() {
+		err = fmt.Errorf("replacement instance exited")
+	}
+	if err != nil {
+		logServiceError(svc.Name, "Replacement instance isn't taking over, keeping current one", "error", err)
+		if proc.running() {
+			d.terminateProcess(svc.Name, proc, serviceStopTimeout)
+		}
+		if svc.RestartStrategy == RestartStrategyBlueGreen {
+			d.events.record(EventRolloutFailed, svc.Name, "Replacement instance didn't take over, kept current instance",
+				map[string]string{
+					"error":   err.Error(),
+					"pid":     fmt.Sprint(proc.cmd.Process.Pid),
+					"old_pid": fmt.Sprint(old.cmd.Process.Pid),
+				})
+		}
+		return
+	}
+
+	d.handOver(svc, old, proc)
+	if svc.RestartStrategy == RestartStrategyBlueGreen {
+		d.setState(svc, StateHealthy)
+		d.events.record(EventRolloutSucceeded, svc.Name, "Replacement instance passed its health check and took over",
+			map[string]string{
+				"pid":     fmt.Sprint(proc.cmd.Process.Pid),
+				"old_pid": fmt.Sprint(old.cmd.Process.Pid),
+			})
+	}
+}
+
+// handOver promotes a replacement instance and lets the old one drain
+func (d *Daemon) handOver(svc Service, old, proc *serviceProcess) {
+	old.detached.Store(true)
+	d.promoteProcess(svc, proc)
+	logServiceInfo(svc.Name, "Replacement instance took over, stopping old instance",
+		"old_pid", old.cmd.Process.Pid,
+		"pid", proc.cmd.Process.Pid)
+	d.drainProcess(svc, old, time.Now().Add(serviceStopTimeout))
+	if err := old.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		logServiceError(svc.Name, "Failed to send SIGTERM to old instance", "error", err)
+	}
+}
+
+// stopProcess stops an instance on purpose so it isn't restarted: SIGTERM,
+// then SIGKILL if it hasn't exited within the timeout. Must be called with
+// elevated privileges.
+func (d *Daemon) stopProcess(name string, proc *serviceProcess, timeout time.Duration) {
+	svc := d.getConfig().Services[name]
+	d.setState(svc, StateStopping)
+
+	deadline := time.Now().Add(timeout)
+	d.drainProcess(svc, proc, deadline)
+	d.terminateProcess(name, proc, time.Until(deadline))
+	d.setState(svc, StateStopped)
+}
+
+// terminateProcess stops an unsupervised instance without touching the
+// service's status. Must be called with elevated privileges.
+func (d *Daemon) terminateProcess(name string, proc *serviceProcess, timeout time.Duration) {
+	proc.detached.Store(true)
+
+	logServiceInfo(name, "Stopping service", "pid", proc.cmd.Process.Pid)
+	if err := proc.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		logServiceError(name, "Failed to send SIGTERM to service", "error", err)
+	}
+
+	select {
+	case <-proc.exited:
+	case <-time.After(timeout):
+		logServiceError(name, "Service did not stop in time, killing it", "timeout", timeout.String())
+		if err := proc.cmd.Process.Kill(); err != nil {
+			logServiceError(name, "Failed to kill service", "error", err)
+		}
+		<-proc.exited
 	}
 }
 
@@ -504,41 +1416,12 @@ func (d *Daemon) globalReaper(ctx context.Context) {
 			return
 		case <-sigchldChan:
 			// Efficient: only reap when we know children have exited
-			d.reapChildren(reaperLogger)
+			received := time.Now()
+			reaped := d.reapChildren(reaperLogger)
+			d.reapStats.record(reaped, time.Since(received))
 		case <-ticker.C:
 			// Periodic fallback reap in case we missed any signals
-			d.reapChildren(reaperLogger)
-		}
-	}
-}
-
-// reapChildren performs the actual child reaping logic
-func (d *Daemon) reapChildren(logger *slog.Logger) {
-	for {
-		var ws syscall.WaitStatus
-		var ru syscall.Rusage
-		pid, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, &ru)
-
-		if pid == 0 {
-			// No more children to reap
-			break
-		}
-
-		if pid > 0 {
-			logger.Info("Reaped child process",
-				"pid", pid,
-				"exit_status", ws.ExitStatus(),
-				"signaled", ws.Signaled())
-		}
-
-		if err == syscall.ECHILD {
-			// No children to wait for
-			break
-		}
-
-		if err != nil {
-			logger.Error("Error in child reaper", "error", err)
-			break
+			d.reapStats.reaped.Add(uint64(d.reapChildren(reaperLogger)))
 		}
 	}
 }
@@ -558,18 +1441,44 @@ func (d *Daemon) forwardSignalToServices(signal syscall.Signal) {
 		}
 	}()
 
+	config := d.getConfig()
+	rule, hasRule := config.signalRule(signal)
 	for name, cmd := range d.getAllServiceCmds() {
-		if cmd != nil && cmd.Process != nil {
-			signalLogger.Info("Forwarding signal to service",
-				"signal", signal.String(),
-				"service", name,
-				"pid", cmd.Process.Pid)
-			if err := cmd.Process.Signal(signal); err != nil {
-				signalLogger.Error("Failed to send signal to service",
-					"signal", signal.String(),
-					"service", name,
-					"error", err)
+		if cmd == nil || cmd.Process == nil {
+			continue
+		}
+		if hasRule && len(rule.Services) > 0 && !slices.Contains(rule.Services, name) {
+			continue
+		}
+
+		svc := config.Services[name]
+		group := rule.ProcessGroup || svc.SignalGroup
+		sig := signal
+		switch action := svc.signalAction(signal); action {
+		case "":
+		case SignalActionIgnore:
+			signalLogger.Debug("Service ignores forwarded signal", "signal", signal.String(), "service", name)
+			continue
+		case SignalActionReload:
+			signalLogger.Info("Running reload command for service", "signal", signal.String(), "service", name)
+			if err := d.runReloadCommand(svc); err != nil {
+				signalLogger.Error("Failed to run reload command", "service", name, "error", err)
 			}
+			continue
+		default:
+			sig, _ = parseSignal(action) // Validated at config load
+		}
+
+		signalLogger.Info("Forwarding signal to service",
+			"signal", sig.String(),
+			"service", name,
+			"pid", cmd.Process.Pid,
+			"process_group", group)
+		if err := signalProcess(cmd.Process.Pid, sig, group); err != nil {
+			signalLogger.Error("Failed to send signal to service",
+				"signal", sig.String(),
+				"service", name,
+				"error", err)
 		}
 	}
 }
@@ -579,68 +1488,141 @@ func (d *Daemon) shutdownServices() {
 	shutdownLogger := getLogger("shutdown")
 	shutdownLogger.Info("Starting graceful shutdown of all services")
 
+	// Stop restarts, then let a restart already under way finish so the
+	// service manager doesn't drop privileges while shutdown needs them
+	d.cancel()
+	if d.managerDone != nil {
+		<-d.managerDone
+	}
+
 	// Cleanup IPC socket first
 	os.Remove(SocketPath)
 
-	// Stop all service output captures
-	d.stopAllServiceOutputCaptures()
-
-	// Elevate privileges for service management
+	// Elevate privileges for the pre-shutdown hook and service management
 	if err := elevatePrivileges(); err != nil {
 		shutdownLogger.Error("Failed to elevate privileges for shutdown", "error", err)
 		return
 	}
 
-	// First, send SIGTERM to all services
-	var runningServices []*exec.Cmd
-	for name, cmd := range d.getAllServiceCmds() {
-		if cmd != nil && cmd.Process != nil {
-			shutdownLogger.Info("Sending SIGTERM to service", "service", name, "pid", cmd.Process.Pid)
-			if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
-				shutdownLogger.Error("Failed to send SIGTERM to service", "service", name, "error", err)
-			} else {
-				runningServices = append(runningServices, cmd)
+	// Run the pre-shutdown hook while services are still serving
+	d.runPreShutdown(shutdownLogger)
+
+	// Stop all service output captures
+	d.stopAllServiceOutputCaptures()
+
+	// Replacements still being rolled out never took over
+	d.mu.Lock()
+	rollouts := d.rollouts
+	d.rollouts = make(map[string]*serviceProcess)
+	d.mu.Unlock()
+	for name, proc := range rollouts {
+		d.terminateProcess(name, proc, serviceStopTimeout)
+	}
+
+	// Stop services phase by phase, and within a phase tier by tier in
+	// reverse start order, so services ordered after others are stopped
+	// before the services they were ordered after
+	graceful := true
+	for _, phase := range d.shutdownPhases() {
+		if len(phase.services()) == 0 {
+			continue
+		}
+		shutdownLogger.Info("Waiting for services to shutdown gracefully",
+			"phase", phase.name,
+			"timeout_seconds", int(phase.timeout.Seconds()))
+		deadline := time.Now().Add(phase.timeout)
+
+		for i := len(phase.tiers) - 1; i >= 0; i-- {
+			if !d.stopTier(phase.tiers[i], deadline, shutdownLogger) {
+				shutdownLogger.Warn("Timeout reached, force killing remaining services", "phase", phase.name)
+				d.killRemainingServices(phase.services(), shutdownLogger)
+				graceful = false
+				break
 			}
 		}
 	}
 
-	// Wait for services to shutdown gracefully (with timeout)
-	shutdownTimeout := 30 * time.Second
-	shutdownLogger.Info("Waiting for services to shutdown gracefully", "timeout_seconds", int(shutdownTimeout.Seconds()))
+	if graceful {
+		shutdownLogger.Info("All services shutdown gracefully")
+	}
+	shutdownLogger.Info("Service shutdown complete")
+	d.logFinalReport(shutdownLogger)
+}
 
-	done := make(chan bool, 1)
-	go func() {
-		var wg sync.WaitGroup
-		for _, cmd := range runningServices {
-			wg.Add(1)
-			go func(c *exec.Cmd) {
-				defer wg.Done()
-				_ = c.Wait() // Ignore error since we're shutting down
-			}(cmd)
-		}
-		wg.Wait()
-		done <- true
-	}()
+// stopTier drains every running service in a tier, sends each SIGTERM and
+// waits for them to exit. It returns false if the deadline passed before the
+// tier stopped.
+func (d *Daemon) stopTier(tier []string, deadline time.Time, shutdownLogger *slog.Logger) bool {
+	running := make(map[string]*serviceProcess)
+	var drains sync.WaitGroup
+	for _, name := range tier {
+		proc, exists := d.getServiceProcess(name)
+		if !exists || !proc.running() {
+			continue
+		}
+		running[name] = proc
+		d.setState(d.getConfig().Services[name], StateStopping)
+		drains.Add(1)
+		go func() {
+			defer drains.Done()
+			d.drainProcess(d.getConfig().Services[name], proc, deadline)
+		}()
+	}
+	drains.Wait()
+
+	var waiting []*serviceProcess
+	for _, name := range tier {
+		for _, proc := range d.closeSpawner(name) {
+			shutdownLogger.Info("Sending SIGTERM to connection instance", "service", name, "pid", proc.cmd.Process.Pid)
+			if err := proc.cmd.Process.Signal(syscall.SIGTERM); err == nil {
+				waiting = append(waiting, proc)
+			}
+		}
 
-	select {
-	case <-done:
-		shutdownLogger.Info("All services shutdown gracefully")
-	case <-time.After(shutdownTimeout):
-		shutdownLogger.Warn("Timeout reached, force killing remaining services")
-
-		// Force kill any remaining services
-		for name, cmd := range d.getAllServiceCmds() {
-			if cmd != nil && cmd.Process != nil {
-				shutdownLogger.Info("Force killing service", "service", name, "pid", cmd.Process.Pid)
-				if err := cmd.Process.Kill(); err != nil {
-					shutdownLogger.Error("Failed to force kill service", "service", name, "error", err)
-				}
+		proc, exists := running[name]
+		if !exists || !proc.running() {
+			continue
+		}
+
+		shutdownLogger.Info("Sending SIGTERM to service", "service", name, "pid", proc.cmd.Process.Pid)
+		if err := proc.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			shutdownLogger.Error("Failed to send SIGTERM to service", "service", name, "error", err)
+			continue
+		}
+		waiting = append(waiting, proc)
+	}
+
+	timeout := time.NewTimer(time.Until(deadline))
+	defer timeout.Stop()
+	for _, proc := range waiting {
+		select {
+		case <-proc.exited:
+		case <-timeout.C:
+			return false
+		}
+	}
+	return true
+}
+
+// killRemainingServices force kills the named services that have not exited yet
+func (d *Daemon) killRemainingServices(names []string, shutdownLogger *slog.Logger) {
+	for _, name := range names {
+		for _, proc := range d.connectionInstances(name) {
+			if proc.running() {
+				proc.cmd.Process.Kill()
 			}
 		}
 
-		// Give a short time for force kills to complete
-		time.Sleep(2 * time.Second)
+		proc, exists := d.getServiceProcess(name)
+		if !exists || !proc.running() {
+			continue
+		}
+		shutdownLogger.Info("Force killing service", "service", name, "pid", proc.cmd.Process.Pid)
+		if err := proc.cmd.Process.Kill(); err != nil {
+			shutdownLogger.Error("Failed to force kill service", "service", name, "error", err)
+		}
 	}
 
-	shutdownLogger.Info("Service shutdown complete")
+	// Give a short time for force kills to complete
+	time.Sleep(2 * time.Second)
 }
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+func TestServiceSysProcAttr(t *testing.T) {
+	for _, newSession := range []bool{false, true} {
+		cmd := exec.Command("sleep", "30")
+		cmd.SysProcAttr = serviceSysProcAttr(Service{NewSession: newSession}, os.Getuid(), os.Getgid())
+		cmd.SysProcAttr.Credential = nil // stay the current user
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		pid := cmd.Process.Pid
+		pgid, _ := syscall.Getpgid(pid)
+		sid, _ := getsid(pid)
+		own, _ := getsid(0)
+		cmd.Process.Kill()
+		cmd.Wait()
+
+		// Always its own process group, and its own session only when asked
+		if pgid != pid {
+			t.Errorf("new_session %v: expected process group %d, got %d", newSession, pid, pgid)
+		}
+		if newSession && sid != pid {
+			t.Errorf("new_session: expected session %d, got %d", pid, sid)
+		}
+		if !newSession && sid != own {
+			t.Errorf("expected pei's session %d, got %d", own, sid)
+		}
+	}
+}
+
+// getsid is the session of a process, which syscall only has on some systems
+func getsid(pid int) (int, error) {
+	sid, _, errno := syscall.RawSyscall(syscall.SYS_GETSID, uintptr(pid), 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(sid), nil
+}
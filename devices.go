@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// deviceRule allows or denies access to a device, or to every device of a
+// type when major or minor is -1
+type deviceRule struct {
+	kind         byte // 'c' or 'b'
+	major, minor int64
+	access       string // some of r, w and m (mknod)
+}
+
+// String formats the rule as the cgroup v1 devices controller expects
+func (r deviceRule) String() string {
+	number := func(n int64) string {
+		if n < 0 {
+			return "*"
+		}
+		return fmt.Sprint(n)
+	}
+	return fmt.Sprintf("%c %s:%s %s", r.kind, number(r.major), number(r.minor), r.access)
+}
+
+// defaultDeviceRules are the devices every service with a devices: list may
+// use, as in a container: the null, zero, full and random devices, the
+// terminal devices, and creating device nodes (which can't be opened
+// unless allowed too)
+var defaultDeviceRules = []deviceRule{
+	{'c', 1, 3, "rwm"},    // null
+	{'c', 1, 5, "rwm"},    // zero
+	{'c', 1, 7, "rwm"},    // full
+	{'c', 1, 8, "rwm"},    // random
+	{'c', 1, 9, "rwm"},    // urandom
+	{'c', 5, 0, "rwm"},    // tty
+	{'c', 5, 2, "rwm"},    // ptmx
+	{'c', 136, -1, "rwm"}, // pts
+	{'c', -1, -1, "m"},
+	{'b', -1, -1, "m"},
+}
+
+// parseDeviceEntry splits a devices: entry into its path and access, which
+// defaults to rw
+func parseDeviceEntry(entry string) (path, access string) {
+	path, access, found := strings.Cut(entry, ":")
+	if !found {
+		access = "rw"
+	}
+	return path, access
+}
+
+// validateDevices checks devices
+func (c *Config) validateDevices() error {
+	for name, svc := range c.Services {
+		for i, entry := range svc.Devices {
+			path, access := parseDeviceEntry(entry)
+			field := []string{"services", name, "devices", listIndex(i)}
+			if !strings.HasPrefix(filepath.Clean(path), "/dev/") {
+				return fieldErrorf(field, "must be a path under /dev")
+			}
+			if access == "" || strings.Trim(access, "rwm") != "" {
+				return fieldErrorf(field, "access must be some of r, w and m")
+			}
+		}
+	}
+	return nil
+}
+
+// serviceDeviceRules is the device cgroup policy for a service. A service
+// with a devices: list may only use those and the defaults. Any other
+// service may use every device except those listed by other services, so
+// only the service that needs /dev/kvm can open it. Without any devices:
+// lists, services are not confined.
+func serviceDeviceRules(config *Config, svc Service) (allowByDefault bool, rules []deviceRule, err error) {
+	if len(svc.Devices) > 0 {
+		rules = append(rules, defaultDeviceRules...)
+		for _, entry := range svc.Devices {
+			path, access := parseDeviceEntry(entry)
+			devices, err := resolveDevices(path, access)
+			if err != nil {
+				return false, nil, fmt.Errorf("devices %s: %v", path, err)
+			}
+			rules = append(rules, devices...)
+		}
+		return false, rules, nil
+	}
+
+	for name, other := range config.Services {
+		if name == svc.Name {
+			continue
+		}
+		for _, entry := range other.Devices {
+			path, _ := parseDeviceEntry(entry)
+			// Devices missing here can't be opened anyway
+			devices, _ := resolveDevices(path, "rwm")
+			rules = append(rules, devices...)
+		}
+	}
+	return true, rules, nil
+}
@@ -0,0 +1,20 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import "errors"
+
+// resolveDevices finds nothing here: there are no device cgroups to confine
+// services with
+func resolveDevices(path, access string) ([]deviceRule, error) {
+	return nil, errors.New("not supported on this platform")
+}
+
+// deviceCgroup is not supported here, so services with devices: fail to
+// start, and other services aren't confined
+func (d *Daemon) deviceCgroup(svc Service) (string, error) {
+	if len(svc.Devices) > 0 {
+		return "", errors.New("devices is not supported on this platform")
+	}
+	return "", nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// cgroupRoot is where the cgroup filesystem is mounted
+const cgroupRoot = "/sys/fs/cgroup"
+
+// resolveDevices finds the device a path names, or the devices directly in
+// a directory such as /dev/dri
+func resolveDevices(path, access string) ([]deviceRule, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		paths = paths[:0]
+		for _, entry := range entries {
+			paths = append(paths, filepath.Join(path, entry.Name()))
+		}
+	}
+
+	var rules []deviceRule
+	for _, p := range paths {
+		var st syscall.Stat_t
+		if err := syscall.Stat(p, &st); err != nil {
+			return nil, err
+		}
+		kind := byte(0)
+		switch st.Mode & syscall.S_IFMT {
+		case syscall.S_IFCHR:
+			kind = 'c'
+		case syscall.S_IFBLK:
+			kind = 'b'
+		default:
+			continue
+		}
+		dev := uint64(st.Rdev)
+		major := int64((dev>>8)&0xfff | (dev>>32)&^0xfff)
+		minor := int64(dev&0xff | (dev>>12)&^0xff)
+		rules = append(rules, deviceRule{kind: kind, major: major, minor: minor, access: access})
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("not a device")
+	}
+	return rules, nil
+}
+
+// deviceCgroup puts a service's device policy in place in a cgroup of its
+// own, next to pei's, and returns the cgroup's directory for the service to
+// join. It returns "" for services that aren't confined. Must be called
+// with elevated privileges.
+func (d *Daemon) deviceCgroup(svc Service) (string, error) {
+	allowByDefault, rules, err := serviceDeviceRules(d.getConfig(), svc)
+	if err != nil {
+		return "", err
+	}
+	if allowByDefault && len(rules) == 0 {
+		return "", nil
+	}
+
+	// The unified hierarchy has no devices controller; a BPF program
+	// attached to the cgroup decides instead
+	unified := true
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		unified = false
+	}
+	controller, root := "", cgroupRoot
+	if !unified {
+		controller, root = "devices", filepath.Join(cgroupRoot, "devices")
+	}
+	parent, err := ownCgroup(controller)
+	if err != nil {
+		return "", fmt.Errorf("finding pei's cgroup: %v", err)
+	}
+	dir := filepath.Join(root, parent, "pei-"+svc.Name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("creating device cgroup: %v", err)
+	}
+
+	if unified {
+		err = attachDeviceFilter(dir, allowByDefault, rules)
+	} else {
+		err = writeDeviceRules(dir, allowByDefault, rules)
+	}
+	if err != nil {
+		return "", fmt.Errorf("setting device rules: %v", err)
+	}
+	return dir, nil
+}
+
+// ownCgroup is pei's cgroup path in the hierarchy of a v1 controller, or in
+// the unified hierarchy for ""
+func ownCgroup(controller string) (string, error) {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:path
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if controller == "" && fields[0] == "0" && fields[1] == "" {
+			return fields[2], nil
+		}
+		for _, name := range strings.Split(fields[1], ",") {
+			if controller != "" && name == controller {
+				return fields[2], nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no %q entry in /proc/self/cgroup", controller)
+}
+
+// writeDeviceRules sets a v1 devices cgroup's policy, replacing any rules
+// from an earlier start
+func writeDeviceRules(dir string, allowByDefault bool, rules []deviceRule) error {
+	reset, add := "devices.deny", "devices.allow"
+	if allowByDefault {
+		reset, add = "devices.allow", "devices.deny"
+	}
+	if err := os.WriteFile(filepath.Join(dir, reset), []byte("a"), 0); err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if err := os.WriteFile(filepath.Join(dir, add), []byte(rule.String()), 0); err != nil {
+			return fmt.Errorf("%s: %v", rule, err)
+		}
+	}
+	return nil
+}
+
+// BPF constants for cgroup device programs, from linux/bpf.h
+const (
+	bpfProgLoad             = 5
+	bpfProgAttach           = 8
+	bpfProgTypeCgroupDevice = 15
+	bpfAttachCgroupDevice   = 6
+	bpfDevcgDevBlock        = 1
+	bpfDevcgDevChar         = 2
+	bpfDevcgAccMknod        = 1
+	bpfDevcgAccRead         = 2
+	bpfDevcgAccWrite        = 4
+	bpfInsnLoadWord         = 0x61 // BPF_LDX | BPF_MEM | BPF_W
+	bpfInsnMovReg           = 0xbf // BPF_ALU64 | BPF_MOV | BPF_X
+	bpfInsnMovImm           = 0xb7 // BPF_ALU64 | BPF_MOV | BPF_K
+	bpfInsnAndImm           = 0x57 // BPF_ALU64 | BPF_AND | BPF_K
+	bpfInsnRshImm           = 0x77 // BPF_ALU64 | BPF_RSH | BPF_K
+	bpfInsnJneImm           = 0x55 // BPF_JMP | BPF_JNE | BPF_K
+	bpfInsnExit             = 0x95 // BPF_JMP | BPF_EXIT
+)
+
+// bpfLicense is the license the kernel is told the device program has. It
+// lives outside the stack, which may move while the kernel reads it.
+var bpfLicense = []byte("MIT\x00")
+
+// sysBPF is the bpf system call, which the syscall package doesn't number
+var sysBPF = map[string]uintptr{
+	"386": 357, "amd64": 321, "arm": 386, "arm64": 280, "loong64": 280,
+	"ppc64": 361, "ppc64le": 361, "riscv64": 280, "s390x": 351,
+}[runtime.GOARCH]
+
+// bpfInsn is one BPF instruction
+type bpfInsn struct {
+	code   uint8
+	regs   uint8 // dst in the low nibble, src in the high
+	offset int16
+	imm    int32
+}
+
+// deviceFilter assembles a cgroup device program: the verdict of the first
+// matching rule, or the default. Registers hold the device type (r3),
+// access (r4), major (r5) and minor (r6) from struct bpf_cgroup_dev_ctx.
+func deviceFilter(allowByDefault bool, rules []deviceRule) []bpfInsn {
+	insn := func(code, dst, src uint8, offset int16, imm int32) bpfInsn {
+		return bpfInsn{code: code, regs: dst | src<<4, offset: offset, imm: imm}
+	}
+	verdict, fallback := int32(1), int32(0)
+	if allowByDefault {
+		verdict, fallback = 0, 1
+	}
+
+	prog := []bpfInsn{
+		insn(bpfInsnLoadWord, 2, 1, 0, 0),
+		insn(bpfInsnMovReg, 3, 2, 0, 0),
+		insn(bpfInsnAndImm, 3, 0, 0, 0xffff),
+		insn(bpfInsnMovReg, 4, 2, 0, 0),
+		insn(bpfInsnRshImm, 4, 0, 0, 16),
+		insn(bpfInsnLoadWord, 5, 1, 4, 0),
+		insn(bpfInsnLoadWord, 6, 1, 8, 0),
+	}
+	for _, rule := range rules {
+		kind := int32(bpfDevcgDevChar)
+		if rule.kind == 'b' {
+			kind = bpfDevcgDevBlock
+		}
+		denied := int32(bpfDevcgAccMknod | bpfDevcgAccRead | bpfDevcgAccWrite)
+		for _, c := range rule.access {
+			switch c {
+			case 'm':
+				denied &^= bpfDevcgAccMknod
+			case 'r':
+				denied &^= bpfDevcgAccRead
+			case 'w':
+				denied &^= bpfDevcgAccWrite
+			}
+		}
+
+		// Each check jumps past the rest of the rule when it fails
+		block := []bpfInsn{insn(bpfInsnJneImm, 3, 0, 0, kind)}
+		if denied != 0 {
+			block = append(block,
+				insn(bpfInsnMovReg, 7, 4, 0, 0),
+				insn(bpfInsnAndImm, 7, 0, 0, denied),
+				insn(bpfInsnJneImm, 7, 0, 0, 0))
+		}
+		if rule.major >= 0 {
+			block = append(block, insn(bpfInsnJneImm, 5, 0, 0, int32(rule.major)))
+		}
+		if rule.minor >= 0 {
+			block = append(block, insn(bpfInsnJneImm, 6, 0, 0, int32(rule.minor)))
+		}
+		block = append(block, insn(bpfInsnMovImm, 0, 0, 0, verdict), insn(bpfInsnExit, 0, 0, 0, 0))
+		for i := range block {
+			if block[i].code == bpfInsnJneImm {
+				block[i].offset = int16(len(block) - i - 1)
+			}
+		}
+		prog = append(prog, block...)
+	}
+	return append(prog, insn(bpfInsnMovImm, 0, 0, 0, fallback), insn(bpfInsnExit, 0, 0, 0, 0))
+}
+
+// attachDeviceFilter loads a device program and attaches it to a v2 cgroup,
+// replacing the one from an earlier start
+func attachDeviceFilter(dir string, allowByDefault bool, rules []deviceRule) error {
+	if sysBPF == 0 {
+		return fmt.Errorf("device cgroups are not supported on %s", runtime.GOARCH)
+	}
+	prog := deviceFilter(allowByDefault, rules)
+	insns := make([]byte, 8*len(prog))
+	for i, in := range prog {
+		insns[8*i] = in.code
+		insns[8*i+1] = in.regs
+		binary.NativeEndian.PutUint16(insns[8*i+2:], uint16(in.offset))
+		binary.NativeEndian.PutUint32(insns[8*i+4:], uint32(in.imm))
+	}
+	// union bpf_attr for BPF_PROG_LOAD, up to kern_version
+	var load [48]byte
+	binary.NativeEndian.PutUint32(load[0:], bpfProgTypeCgroupDevice)
+	binary.NativeEndian.PutUint32(load[4:], uint32(len(prog)))
+	binary.NativeEndian.PutUint64(load[8:], uint64(uintptr(unsafe.Pointer(&insns[0]))))
+	binary.NativeEndian.PutUint64(load[16:], uint64(uintptr(unsafe.Pointer(&bpfLicense[0]))))
+	fd, _, errno := syscall.Syscall(sysBPF, bpfProgLoad, uintptr(unsafe.Pointer(&load)), unsafe.Sizeof(load))
+	runtime.KeepAlive(insns)
+	if errno != 0 {
+		return fmt.Errorf("loading device filter: %v", errno)
+	}
+	defer syscall.Close(int(fd))
+
+	cgroup, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer cgroup.Close()
+
+	// union bpf_attr for BPF_PROG_ATTACH
+	var attach [16]byte
+	binary.NativeEndian.PutUint32(attach[0:], uint32(cgroup.Fd()))
+	binary.NativeEndian.PutUint32(attach[4:], uint32(fd))
+	binary.NativeEndian.PutUint32(attach[8:], bpfAttachCgroupDevice)
+	if _, _, errno := syscall.Syscall(sysBPF, bpfProgAttach, uintptr(unsafe.Pointer(&attach)), unsafe.Sizeof(attach)); errno != 0 {
+		return fmt.Errorf("attaching device filter: %v", errno)
+	}
+	return nil
+}
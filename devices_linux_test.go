@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestServiceDeviceRules(t *testing.T) {
+	config := &Config{Services: map[string]Service{
+		"vm":  {Name: "vm", Devices: []string{"/dev/null:r"}},
+		"web": {Name: "web"},
+	}}
+
+	// The service listing a device may use it and the defaults
+	allow, rules, err := serviceDeviceRules(config, config.Services["vm"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allow || len(rules) != len(defaultDeviceRules)+1 || rules[len(rules)-1].String() != "c 1:3 r" {
+		t.Errorf("expected the defaults and c 1:3 r, got allow=%v %v", allow, rules)
+	}
+
+	// Other services may use everything else
+	allow, rules, err = serviceDeviceRules(config, config.Services["web"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allow || len(rules) != 1 || rules[0].String() != "c 1:3 rwm" {
+		t.Errorf("expected only c 1:3 to be denied, got allow=%v %v", allow, rules)
+	}
+
+	// Without devices: lists nothing is confined
+	delete(config.Services, "vm")
+	if allow, rules, _ := serviceDeviceRules(config, config.Services["web"]); !allow || len(rules) != 0 {
+		t.Errorf("expected no rules, got allow=%v %v", allow, rules)
+	}
+
+	if _, _, err := serviceDeviceRules(config, Service{Name: "gpu", Devices: []string{"/dev/no-such-device"}}); err == nil {
+		t.Error("expected an error for a missing device")
+	}
+}
+
+func TestDeviceFilter(t *testing.T) {
+	prog := deviceFilter(false, []deviceRule{{'c', 10, 232, "rw"}, {'b', -1, -1, "m"}})
+
+	// Every jump lands inside the program, on the next rule or the default
+	for i, in := range prog {
+		if in.code == bpfInsnJneImm {
+			target := i + 1 + int(in.offset)
+			if target >= len(prog) || (prog[target-1].code != bpfInsnExit) {
+				t.Errorf("jump at %d lands on %d, not after a rule", i, target)
+			}
+		}
+	}
+	if last := prog[len(prog)-2]; last.code != bpfInsnMovImm || last.imm != 0 {
+		t.Errorf("expected devices to be denied by default, got %+v", last)
+	}
+}
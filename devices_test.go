@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDeviceRuleString(t *testing.T) {
+	cases := map[string]deviceRule{
+		"c 10:232 rw": {'c', 10, 232, "rw"},
+		"c 136:* rwm": {'c', 136, -1, "rwm"},
+		"b *:* m":     {'b', -1, -1, "m"},
+	}
+	for expected, rule := range cases {
+		if got := rule.String(); got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	}
+}
+
+func TestValidateDevices(t *testing.T) {
+	cases := []struct {
+		devices []string
+		err     string
+	}{
+		{[]string{"kvm"}, "must be a path under /dev"},
+		{[]string{"/dev/../etc/passwd"}, "must be a path under /dev"},
+		{[]string{"/dev/kvm:x"}, "access must be some of r, w and m"},
+		{[]string{"/dev/kvm", "/dev/dri:"}, "devices[1]: access"},
+	}
+	for _, c := range cases {
+		config := &Config{Services: map[string]Service{"vm": {Devices: c.devices}}}
+		if err := config.validateDevices(); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%v: expected error containing %q, got %v", c.devices, c.err, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// serviceFieldChanges lists the settings that differ between two definitions
+// of a service, as "key: old -> new" using the config file's key names
+func serviceFieldChanges(old, updated Service) []string {
+	var changes []string
+	forEachChangedSetting(old, updated, func(key string, before, after any) {
+		changes = append(changes, fmt.Sprintf("%s: %s -> %s", key, formatSetting(before), formatSetting(after)))
+	})
+	return changes
+}
+
+// forEachChangedSetting calls fn with the config key and both values of each
+// setting that differs between two definitions of a service
+func forEachChangedSetting(old, updated Service, fn func(key string, before, after any)) {
+	oldValue, updatedValue := reflect.ValueOf(old), reflect.ValueOf(updated)
+	serviceType := oldValue.Type()
+	for i := 0; i < serviceType.NumField(); i++ {
+		before, after := oldValue.Field(i).Interface(), updatedValue.Field(i).Interface()
+		if reflect.DeepEqual(before, after) {
+			continue
+		}
+		key, _, _ := strings.Cut(serviceType.Field(i).Tag.Get("yaml"), ",")
+		fn(key, before, after)
+	}
+}
+
+// formatSetting shows a config value compactly on one line
+func formatSetting(value any) string {
+	if reflect.ValueOf(value).IsZero() {
+		return "(unset)"
+	}
+	if stringer, ok := value.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
+
+// showConfigDiff compares the services the daemon is running with the
+// config file it would reload, showing what a reload would start, stop and
+// restart, and why
+func showConfigDiff(fallbackPath string) error {
+	resp, err := sendIPCRequest(IPCRequest{Command: "definitions"})
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("daemon error: %s", resp.Message)
+	}
+
+	// Compare against the file the daemon reloads from, not whatever -c
+	// says here. A remote daemon's file isn't here, so compare it with the
+	// local one instead, to see what deploying it would change.
+	path := resp.ConfigPath
+	if path == "" || remoteHost != "" {
+		path = fallbackPath
+	}
+	updated, err := loadConfig(path)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %v", path, err)
+	}
+	running := &Config{Services: resp.Definitions}
+	summary := diffConfigs(running, updated)
+
+	fmt.Printf("Comparing running services with %s\n\n", path)
+	for _, name := range summary.Restarted {
+		fmt.Printf("~ %s (would restart)\n", name)
+		for _, change := range serviceFieldChanges(running.Services[name], updated.Services[name]) {
+			fmt.Printf("    %s\n", change)
+		}
+	}
+	for _, name := range summary.Updated {
+		fmt.Printf("~ %s (would update without restarting)\n", name)
+		for _, change := range serviceFieldChanges(running.Services[name], updated.Services[name]) {
+			fmt.Printf("    %s\n", change)
+		}
+	}
+	for _, name := range summary.Added {
+		fmt.Printf("+ %s (would start)\n", name)
+	}
+	for _, name := range summary.Removed {
+		fmt.Printf("- %s (would stop)\n", name)
+	}
+	if len(summary.Restarted)+len(summary.Updated)+len(summary.Added)+len(summary.Removed) == 0 {
+		fmt.Println("No differences, a reload would not change anything")
+		return nil
+	}
+	fmt.Printf("\n%d to restart, %d to update, %d to start, %d to stop, %d unchanged\n",
+		len(summary.Restarted), len(summary.Updated), len(summary.Added), len(summary.Removed), len(summary.Unchanged))
+	return nil
+}
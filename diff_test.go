@@ -0,0 +1,25 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestServiceFieldChanges(t *testing.T) {
+	old := Service{Name: "web", Command: []string{"web", "--port", "80"}, User: "app", RestartDelay: time.Second}
+	updated := Service{Name: "web", Command: []string{"web", "--port", "8080"}, User: "app", Restart: RestartAlways}
+
+	changes := serviceFieldChanges(old, updated)
+	expected := []string{
+		`command: ["web","--port","80"] -> ["web","--port","8080"]`,
+		`restart: (unset) -> "always"`,
+		"restart_delay: 1s -> (unset)",
+	}
+	if !slices.Equal(changes, expected) {
+		t.Errorf("expected %q, got %q", expected, changes)
+	}
+	if changes := serviceFieldChanges(old, old); len(changes) != 0 {
+		t.Errorf("expected no changes, got %q", changes)
+	}
+}
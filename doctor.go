@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Linux capability bits pei needs to switch users and manage services
+var requiredCapabilities = []struct {
+	name string
+	bit  uint
+}{
+	{"CAP_KILL", 5},
+	{"CAP_SETGID", 6},
+	{"CAP_SETUID", 7},
+}
+
+// doctorReport collects pass/fail results of environment checks
+type doctorReport struct {
+	passed int
+	failed int
+}
+
+func (r *doctorReport) check(subject, description string, err error) {
+	if err != nil {
+		r.failed++
+		fmt.Printf("[FAIL] %-20s %s: %v\n", subject, description, err)
+		return
+	}
+	r.passed++
+	fmt.Printf("[PASS] %-20s %s\n", subject, description)
+}
+
+// permittedCapabilities reads the permitted capability set of this process
+func permittedCapabilities() (uint64, error) {
+	file, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if value, found := strings.CutPrefix(line, "CapPrm:"); found {
+			return strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("CapPrm not found in /proc/self/status")
+}
+
+// checkWritable verifies that a log path can be written, either because the
+// file itself is writable or because it can be created under its nearest
+// existing directory, as missing ones are created at start
+func checkWritable(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return syscall.Access(path, 2) // W_OK
+	}
+	dir := filepath.Dir(path)
+	for dir != "/" {
+		if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("directory %s: %v", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return syscall.Access(dir, 2) // W_OK
+}
+
+// runDoctor verifies that the runtime environment can run the configured
+// services and prints a pass/fail summary. It returns the number of failures.
+func runDoctor(config *Config, appUser, appGroup string) int {
+	report := &doctorReport{}
+
+	// Daemon-level checks
+	caps, err := permittedCapabilities()
+	for _, capability := range requiredCapabilities {
+		if err == nil && caps&(1<<capability.bit) == 0 {
+			report.check("pei", "capability "+capability.name, fmt.Errorf("not in permitted set"))
+		} else {
+			report.check("pei", "capability "+capability.name, err)
+		}
+	}
+
+	_, _, err = lookupUIDGID(appUser, appGroup)
+	report.check("pei", fmt.Sprintf("app user %s:%s resolves", appUser, appGroup), err)
+
+	_, err = startTiers(config.Services)
+	report.check("pei", "service ordering", err)
+
+	// Per-service checks, in name order for stable output
+	var names []string
+	for name := range config.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		svc := config.Services[name]
+
+		resolved, err := resolveCommand(svc)
+		if err == nil {
+			report.check(name, fmt.Sprintf("command %s is executable", resolved), nil)
+		} else {
+			report.check(name, "command is executable", err)
+		}
+
+		_, _, err = lookupUIDGID(svc.User, svc.Group)
+		report.check(name, fmt.Sprintf("user %s:%s resolves", svc.User, svc.Group), err)
+
+		if svc.WorkingDir != "" {
+			info, err := os.Stat(svc.WorkingDir)
+			if err == nil && !info.IsDir() {
+				err = fmt.Errorf("not a directory")
+			}
+			report.check(name, fmt.Sprintf("working_dir %s exists", svc.WorkingDir), err)
+		}
+
+		for _, logPath := range []string{svc.Stdout, svc.Stderr} {
+			if logPath == "" || strings.HasPrefix(logPath, "/dev/std") {
+				continue
+			}
+			report.check(name, fmt.Sprintf("log path %s is writable", logPath), checkWritable(logPath))
+		}
+	}
+
+	fmt.Printf("\n%d checks, %d passed, %d failed\n", report.passed+report.failed, report.passed, report.failed)
+	return report.failed
+}
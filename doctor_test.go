@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckWritable(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "out.log")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkWritable(file); err != nil {
+		t.Errorf("expected an existing file writable, got %v", err)
+	}
+
+	// Missing directories are created at start, so their nearest existing
+	// parent is what must be writable
+	if err := checkWritable(filepath.Join(dir, "app", "logs", "out.log")); err != nil {
+		t.Errorf("expected a path under missing directories writable, got %v", err)
+	}
+	if err := checkWritable(filepath.Join(file, "nested.log")); err == nil || !strings.Contains(err.Error(), "is not a directory") {
+		t.Errorf("expected a path under a file rejected, got %v", err)
+	}
+
+	if os.Geteuid() == 0 {
+		return // root can write anywhere
+	}
+	readOnly := filepath.Join(dir, "readonly")
+	if err := os.Mkdir(readOnly, 0555); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkWritable(filepath.Join(readOnly, "out.log")); err == nil {
+		t.Error("expected a path under a read-only directory rejected")
+	}
+}
+
+func TestRunDoctor(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := user.LookupGroupId(current.Gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	config := &Config{Services: map[string]Service{
+		"db": {Name: "db", Command: []string{"sleep", "30"}, User: current.Username, Group: group.Name,
+			Stdout: filepath.Join(dir, "db.log"), Stderr: "/dev/stderr"},
+		"web": {Name: "web", Command: []string{"pei-no-such-command"}, User: "pei-no-such-user", Group: group.Name,
+			WorkingDir: filepath.Join(dir, "db.log")},
+	}}
+	if err := os.WriteFile(filepath.Join(dir, "db.log"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var failed int
+	output := captureStdout(t, func() { failed = runDoctor(config, current.Username, group.Name) })
+
+	// Capabilities depend on how the tests are run, so only count the rest
+	if capabilities := strings.Count(output, "[FAIL] pei                  capability"); failed-capabilities != 3 {
+		t.Errorf("expected web's command, user and working_dir to fail, got %d failures:\n%s", failed-capabilities, output)
+	}
+	for _, want := range []string{
+		"[PASS] db                   user " + current.Username,
+		"[PASS] db                   log path " + filepath.Join(dir, "db.log") + " is writable",
+		"[PASS] pei                  service ordering",
+		"[FAIL] web                  command is executable",
+		"[FAIL] web                  working_dir " + filepath.Join(dir, "db.log") + " exists: not a directory",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q, got:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "/dev/stderr") {
+		t.Errorf("expected /dev/stderr not checked as a log path, got:\n%s", output)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// validateDrain checks every service's drain settings
+func (c *Config) validateDrain() error {
+	for name, svc := range c.Services {
+		if svc.DrainDelay < 0 {
+			return serviceErrorf(name, "drain_delay", "must not be negative")
+		}
+		if svc.DrainSignal != "" && len(svc.DrainCommand) > 0 {
+			return serviceErrorf(name, "drain_command", "set only one of drain_signal or drain_command")
+		}
+		if svc.DrainSignal != "" {
+			if _, err := parseSignal(svc.DrainSignal); err != nil {
+				return serviceErrorf(name, "drain_signal", "%v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// drains reports whether a service has anything to do before it is stopped
+func (s Service) drains() bool {
+	return s.DrainDelay > 0 || s.DrainSignal != "" || len(s.DrainCommand) > 0
+}
+
+// drainProcess runs a service's drain action and then waits its drain delay
+// before the caller sends the stop signal, like a Kubernetes preStop hook.
+// Time spent draining counts towards the deadline. It returns early if the
+// instance exits. Must be called with elevated privileges.
+func (d *Daemon) drainProcess(svc Service, proc *serviceProcess, deadline time.Time) {
+	if !svc.drains() {
+		return
+	}
+
+	pid := proc.cmd.Process.Pid
+	logServiceInfo(svc.Name, "Draining service", "pid", pid, "drain_delay", svc.DrainDelay.String())
+
+	switch {
+	case svc.DrainSignal != "":
+		sig, _ := parseSignal(svc.DrainSignal)
+		if err := signalProcess(pid, sig, svc.SignalGroup); err != nil {
+			logServiceError(svc.Name, "Failed to send drain signal", "signal", svc.DrainSignal, "error", err)
+		}
+	case len(svc.DrainCommand) > 0:
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		err := runDrainCommand(ctx, svc)
+		cancel()
+		if err != nil {
+			logServiceError(svc.Name, "Drain command failed", "error", err)
+		}
+	}
+
+	wait := min(svc.DrainDelay, time.Until(deadline))
+	if wait <= 0 {
+		return
+	}
+	select {
+	case <-proc.exited:
+	case <-time.After(wait):
+	}
+}
+
+// runDrainCommand runs a service's drain command as the service user and
+// waits for it to finish
+func runDrainCommand(ctx context.Context, svc Service) error {
+	cmd, err := buildHelperCmd(ctx, svc, svc.DrainCommand)
+	if err != nil {
+		return err
+	}
+	if output, err := combinedOutput(cmd); err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return nil
+}
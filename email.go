@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SMTP TLS modes
+const (
+	SMTPStartTLS = "starttls" // upgrade a plain connection; the default
+	SMTPTLS      = "tls"      // connect over TLS, usually to port 465
+	SMTPNoTLS    = "none"     // plain text, for a relay on localhost
+)
+
+// Email notifier defaults
+const (
+	defaultEmailDigest     = time.Minute
+	defaultEmailMaxPerHour = 10
+
+	// maxDigestEvents is how many events one digest lists; any more are
+	// counted but left out
+	maxDigestEvents = 200
+)
+
+// SMTPServer is the mail server an email notifier sends through
+type SMTPServer struct {
+	Address  string `yaml:"address"` // host:port
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	TLS      string `yaml:"tls"`
+}
+
+// validateEmail checks an email notifier's settings
+func (n Notifier) validateEmail(path []string) error {
+	if n.SMTP == nil {
+		return fieldErrorf(append(path, "smtp"), "is required for email notifiers")
+	}
+	if host, port, err := net.SplitHostPort(n.SMTP.Address); err != nil || host == "" || port == "" {
+		return fieldErrorf(append(path, "smtp", "address"), "must be host:port")
+	}
+	switch n.SMTP.TLS {
+	case "", SMTPStartTLS, SMTPTLS, SMTPNoTLS:
+	default:
+		return fieldErrorf(append(path, "smtp", "tls"), "must be starttls, tls or none")
+	}
+	if n.From == "" {
+		return fieldErrorf(append(path, "from"), "is required for email notifiers")
+	}
+	if _, err := mail.ParseAddress(n.From); err != nil {
+		return fieldErrorf(append(path, "from"), "%v", err)
+	}
+	if len(n.To) == 0 {
+		return fieldErrorf(append(path, "to"), "must list at least one recipient")
+	}
+	for i, to := range n.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fieldErrorf(append(path, "to", listIndex(i)), "%v", err)
+		}
+	}
+	if n.Digest < 0 {
+		return fieldErrorf(append(path, "digest"), "must not be negative")
+	}
+	if n.MaxPerHour < 0 {
+		return fieldErrorf(append(path, "max_per_hour"), "must not be negative")
+	}
+	return nil
+}
+
+// emailDigest collects an email notifier's events and mails them together:
+// the first event waits digest for others to join it, and once max_per_hour
+// mails have gone out in the last hour, events wait for the next allowed
+// send. A crash loop makes a mail every few minutes at most, not one per
+// restart.
+type emailDigest struct {
+	mu       sync.Mutex
+	notifier Notifier
+	pending  []Event
+	omitted  int
+	sent     []time.Time // when mails went out in the last hour
+	timer    *time.Timer
+
+	send func(n Notifier, events []Event, omitted int) error
+}
+
+func newEmailDigest() *emailDigest {
+	return &emailDigest{send: sendDigest}
+}
+
+// add queues an event, sent with the notifier's settings as they are when
+// the digest goes out
+func (e *emailDigest) add(n Notifier, event Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.notifier = n
+	if len(e.pending) < maxDigestEvents {
+		e.pending = append(e.pending, event)
+	} else {
+		e.omitted++
+	}
+	if e.timer == nil {
+		e.timer = time.AfterFunc(e.delay(time.Now()), e.flush)
+	}
+}
+
+// delay is how long a new digest waits before going out. Called with the
+// digest locked.
+func (e *emailDigest) delay(now time.Time) time.Duration {
+	delay := e.notifier.Digest
+	if delay == 0 {
+		delay = defaultEmailDigest
+	}
+	limit := e.notifier.MaxPerHour
+	if limit == 0 {
+		limit = defaultEmailMaxPerHour
+	}
+
+	recent := e.sent[:0]
+	for _, sent := range e.sent {
+		if now.Sub(sent) < time.Hour {
+			recent = append(recent, sent)
+		}
+	}
+	e.sent = recent
+	if len(e.sent) >= limit {
+		if wait := e.sent[len(e.sent)-limit].Add(time.Hour).Sub(now); wait > delay {
+			delay = wait
+		}
+	}
+	return delay
+}
+
+// flush mails the pending events
+func (e *emailDigest) flush() {
+	e.mu.Lock()
+	n, events, omitted := e.notifier, e.pending, e.omitted
+	e.pending, e.omitted, e.timer = nil, 0, nil
+	e.sent = append(e.sent, time.Now())
+	e.mu.Unlock()
+
+	if err := e.send(n, events, omitted); err != nil {
+		getLogger("notify").Warn("Failed to send notification email",
+			"notifier", n.Name,
+			"events", len(events)+omitted,
+			"error", err)
+	}
+}
+
+// sendDigest composes and sends one digest email
+func sendDigest(n Notifier, events []Event, omitted int) error {
+	message, err := n.digestMessage(events, omitted, time.Now())
+	if err != nil {
+		return err
+	}
+	return n.SMTP.send(n.From, n.To, message)
+}
+
+// digestMessage composes a digest as a plain text email, one line per event
+func (n Notifier) digestMessage(events []Event, omitted int, now time.Time) ([]byte, error) {
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "pei"
+	}
+
+	var body strings.Builder
+	var first string
+	for _, event := range events {
+		line, err := n.message(event)
+		if err != nil {
+			return nil, err
+		}
+		if first == "" {
+			first = line
+		}
+		fmt.Fprintf(&body, "%s  %s\r\n", event.Time.Format(time.RFC3339), line)
+	}
+	if omitted > 0 {
+		fmt.Fprintf(&body, "... and %d more events\r\n", omitted)
+	}
+
+	subject := fmt.Sprintf("[pei %s] %s", host, first)
+	if total := len(events) + omitted; total > 1 {
+		subject = fmt.Sprintf("[pei %s] %d events", host, total)
+	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "From: %s\r\n", n.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	message.WriteString("\r\n")
+	message.WriteString(body.String())
+	return []byte(message.String()), nil
+}
+
+// send delivers a message through the server
+func (s *SMTPServer) send(from string, to []string, message []byte) error {
+	host, _, err := net.SplitHostPort(s.Address)
+	if err != nil {
+		return err
+	}
+	dialer := &net.Dialer{Timeout: notifyTimeout}
+	tlsConfig := &tls.Config{ServerName: host}
+
+	var conn net.Conn
+	if s.TLS == SMTPTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.Address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", s.Address)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(notifyTimeout))
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if s.TLS == "" || s.TLS == SMTPStartTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %v", err)
+		}
+	}
+	if s.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(envelopeAddress(from)); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err := client.Rcpt(envelopeAddress(recipient)); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// envelopeAddress strips a display name, as in "Ops <ops@example.com>"
+func envelopeAddress(address string) string {
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		return parsed.Address
+	}
+	return address
+}
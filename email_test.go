@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailDigest(t *testing.T) {
+	type digest struct {
+		events  []Event
+		omitted int
+	}
+	sent := make(chan digest, 10)
+	e := newEmailDigest()
+	e.send = func(n Notifier, events []Event, omitted int) error {
+		sent <- digest{events, omitted}
+		return nil
+	}
+	n := Notifier{Name: "mail", Type: NotifierEmail, Digest: 20 * time.Millisecond, MaxPerHour: 1}
+
+	for i := range maxDigestEvents + 3 {
+		e.add(n, Event{Type: EventRestart, Service: "web", Message: string(rune('a' + i%26))})
+	}
+	select {
+	case got := <-sent:
+		if len(got.events) != maxDigestEvents || got.omitted != 3 {
+			t.Errorf("expected one digest of %d events and 3 omitted, got %d and %d", maxDigestEvents, len(got.events), got.omitted)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a digest to be sent")
+	}
+
+	// Over max_per_hour, the next digest waits out the hour
+	e.add(n, Event{Type: EventCrashLoop, Service: "web"})
+	select {
+	case <-sent:
+		t.Fatal("expected the rate limit to hold the next digest")
+	case <-time.After(100 * time.Millisecond):
+	}
+	e.mu.Lock()
+	if delay := e.delay(time.Now()); delay < 59*time.Minute {
+		t.Errorf("expected the digest to wait about an hour, got %v", delay)
+	}
+	e.timer.Stop()
+	e.mu.Unlock()
+}
+
+func TestDigestMessage(t *testing.T) {
+	n := Notifier{Name: "mail", From: "pei <pei@example.com>", To: []string{"ops@example.com", "dev@example.com"}}
+	at := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	one, err := n.digestMessage([]Event{{Time: at, Service: "web", Message: "Service failed"}}, 0, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"To: ops@example.com, dev@example.com\r\n",
+		"] [web] Service failed\r\n",
+		"\r\n\r\n2025-01-02T03:04:05Z  [web] Service failed\r\n",
+	} {
+		if !strings.Contains(string(one), want) {
+			t.Errorf("expected message to contain %q:\n%s", want, one)
+		}
+	}
+
+	many, err := n.digestMessage([]Event{{Time: at, Message: "a"}, {Time: at, Message: "b"}}, 5, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(many), "] 7 events\r\n") || !strings.Contains(string(many), "... and 5 more events") {
+		t.Errorf("expected a digest subject and omitted count:\n%s", many)
+	}
+}
+
+func TestSMTPSend(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		var lines []string
+		reply("220 test")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250 test")
+			case line == "DATA":
+				reply("354 go ahead")
+				for {
+					data, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if data == ".\r\n" {
+						break
+					}
+					lines = append(lines, strings.TrimRight(data, "\r\n"))
+				}
+				reply("250 queued")
+			case line == "QUIT":
+				reply("221 bye")
+				received <- lines
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	server := &SMTPServer{Address: listener.Addr().String(), TLS: SMTPNoTLS}
+	if err := server.send("pei <pei@example.com>", []string{"ops@example.com"}, []byte("Subject: hi\r\n\r\nbody\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	lines := <-received
+	joined := strings.Join(lines, "\n")
+	for _, want := range []string{"MAIL FROM:<pei@example.com>", "RCPT TO:<ops@example.com>", "Subject: hi", "body"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("expected server to receive %q, got:\n%s", want, joined)
+		}
+	}
+}
+
+func TestValidateEmailNotifier(t *testing.T) {
+	valid := Notifier{
+		Name: "mail", Type: NotifierEmail, Events: []string{EventCrashLoop},
+		SMTP: &SMTPServer{Address: "smtp.example.com:587"}, From: "pei@example.com", To: []string{"ops@example.com"},
+	}
+	config := &Config{Notifiers: []Notifier{valid}}
+	if err := config.validateNotifiers(); err != nil {
+		t.Fatalf("expected a valid email notifier, got %v", err)
+	}
+
+	cases := []struct {
+		change func(n *Notifier)
+		err    string
+	}{
+		{func(n *Notifier) { n.SMTP = nil }, "smtp: is required"},
+		{func(n *Notifier) { n.SMTP = &SMTPServer{Address: "smtp.example.com"} }, "must be host:port"},
+		{func(n *Notifier) { n.SMTP = &SMTPServer{Address: "smtp.example.com:25", TLS: "ssl"} }, "must be starttls, tls or none"},
+		{func(n *Notifier) { n.To = nil }, "at least one recipient"},
+		{func(n *Notifier) { n.To = []string{"not an address"} }, "to[0]"},
+		{func(n *Notifier) { n.Digest = -time.Second }, "digest: must not be negative"},
+	}
+	for _, c := range cases {
+		n := valid
+		c.change(&n)
+		config := &Config{Notifiers: []Notifier{n}}
+		if err := config.validateNotifiers(); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("expected error containing %q, got %v", c.err, err)
+		}
+	}
+}
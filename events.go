@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// eventJournalSize is how many recent events the daemon keeps in memory
+const eventJournalSize = 1000
+
+// Event types recorded in the journal
+const (
+	EventRolloutSucceeded     = "rollout_succeeded"
+	EventRolloutFailed        = "rollout_failed"
+	EventCrashBundle          = "crash_bundle"
+	EventServiceCrashed       = "crashed"
+	EventRestart              = "restart"
+	EventConfigReload         = "config_reload"
+	EventServiceReady         = "ready"
+	EventPostStartCheckFailed = "post_start_check_failed"
+	EventHealthy              = "healthy"
+	EventUnhealthy            = "unhealthy"
+	EventCrashLoop            = "crash_loop"
+	EventServiceFailed        = "failed"
+	EventServiceStopped       = "stopped"
+	EventServiceStarted       = "started"
+	EventServiceKilled        = "killed"
+	EventMaintenanceStarted   = "maintenance_started"
+	EventMaintenanceEnded     = "maintenance_ended"
+	EventRestartHeld          = "restart_held"
+	EventRestartSuppressed    = "restart_suppressed"
+	EventUnexpectedOrphan     = "unexpected_orphan"
+)
+
+// eventTypes lists every event type, for validating notifier filters
+var eventTypes = []string{
+	EventRolloutSucceeded, EventRolloutFailed, EventCrashBundle, EventServiceCrashed, EventRestart,
+	EventConfigReload, EventServiceReady, EventPostStartCheckFailed,
+	EventHealthy, EventUnhealthy, EventCrashLoop, EventServiceFailed,
+	EventServiceStopped, EventServiceStarted, EventServiceKilled, EventMaintenanceStarted, EventMaintenanceEnded,
+	EventRestartHeld, EventRestartSuppressed, EventUnexpectedOrphan,
+}
+
+// Event is something notable that happened to the daemon or a service
+type Event struct {
+	Time    time.Time         `json:"time"`
+	Type    string            `json:"type"`
+	Service string            `json:"service,omitempty"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+
+	// Output is the service's last lines of output, for crashes
+	Output []string `json:"output,omitempty"`
+}
+
+// EventJournal keeps a bounded, in-memory history of recent events
+type EventJournal struct {
+	mu     sync.Mutex
+	events []Event
+
+	// labels are attached to the events of each service
+	labels map[string]map[string]string
+
+	// Live subscribers, such as pei events -f clients
+	subscribers map[int]func(Event)
+	nextSub     int
+}
+
+// NewEventJournal creates an empty journal that labels events with each
+// service's configured labels
+func NewEventJournal(config *Config) *EventJournal {
+	j := &EventJournal{}
+	j.setLabels(config)
+	return j
+}
+
+// setLabels takes each service's labels from a new config
+func (j *EventJournal) setLabels(config *Config) {
+	labels := make(map[string]map[string]string)
+	for name, svc := range config.Services {
+		if len(svc.Labels) > 0 {
+			labels[name] = svc.Labels
+		}
+	}
+	j.mu.Lock()
+	j.labels = labels
+	j.mu.Unlock()
+}
+
+// record adds an event to the journal and logs it
+func (j *EventJournal) record(eventType, service, message string, fields map[string]string) {
+	j.recordOutput(eventType, service, message, fields, nil)
+}
+
+// recordOutput records an event with the service output that led up to it.
+// The output isn't logged again, as it was logged when the service wrote it.
+func (j *EventJournal) recordOutput(eventType, service, message string, fields map[string]string, output []string) {
+	j.mu.Lock()
+	event := Event{
+		Time:    time.Now(),
+		Type:    eventType,
+		Service: service,
+		Message: message,
+		Fields:  fields,
+		Labels:  j.labels[service],
+		Output:  output,
+	}
+	j.events = append(j.events, event)
+	if len(j.events) > eventJournalSize {
+		j.events = j.events[len(j.events)-eventJournalSize:]
+	}
+	for _, fn := range j.subscribers {
+		fn(event)
+	}
+	j.mu.Unlock()
+
+	args := []any{"event", eventType}
+	if service != "" {
+		args = append(args, "service", service)
+	}
+	for k, v := range fields {
+		args = append(args, k, v)
+	}
+	args = append(args, labelAttrs(event.Labels)...)
+	getLogger("events").Info(message, args...)
+}
+
+// list returns recorded events, oldest first, optionally only those for one
+// service and only the most recent limit of them
+func (j *EventJournal) list(service string, limit int) []Event {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var result []Event
+	for _, event := range j.events {
+		if service == "" || event.Service == service {
+			result = append(result, event)
+		}
+	}
+	if limit > 0 && len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return result
+}
+
+// subscribe calls fn with every event recorded from now on, until the
+// returned function is called. fn is called with the journal locked, so it
+// must not block.
+func (j *EventJournal) subscribe(fn func(Event)) (unsubscribe func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.subscribers == nil {
+		j.subscribers = make(map[int]func(Event))
+	}
+	id := j.nextSub
+	j.nextSub++
+	j.subscribers[id] = fn
+	return func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		delete(j.subscribers, id)
+	}
+}
+
+// showEventsIPC prints recent events, following new ones when follow is set
+func showEventsIPC(serviceName string, limit int, follow bool) error {
+	client, err := dialIPCClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	resp, stream, err := client.openStream(IPCRequest{Command: "events", Service: serviceName, Limit: limit, Follow: follow})
+	if err != nil {
+		return err
+	}
+
+	if !resp.Success {
+		return fmt.Errorf("daemon error: %s", resp.Message)
+	}
+
+	if len(resp.Events) == 0 && stream == nil {
+		fmt.Println("No events recorded")
+		return nil
+	}
+
+	fmt.Printf("%-25s %-20s %-20s %s\n", "TIME", "SERVICE", "EVENT", "MESSAGE")
+	fmt.Printf("%-25s %-20s %-20s %s\n", "----", "-------", "-----", "-------")
+	for _, event := range resp.Events {
+		printEvent(event)
+	}
+	if stream == nil {
+		return nil
+	}
+	for {
+		var event Event
+		if err := stream.next(&event); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		printEvent(event)
+	}
+}
+
+func printEvent(event Event) {
+	service := event.Service
+	if service == "" {
+		service = "-"
+	}
+	fmt.Printf("%-25s %-20s %-20s %s\n", event.Time.Format(time.RFC3339), service, event.Type, event.Message)
+	for _, line := range event.Output {
+		fmt.Printf("%-25s | %s\n", "", line)
+	}
+}
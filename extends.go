@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// extensionPrefix marks top-level keys pei ignores, such as x-defaults, so
+// they can hold YAML anchors and shared definitions
+const extensionPrefix = "x-"
+
+// resolveExtends applies services' extends fields and merge keys, and drops
+// top-level x- keys, in the parsed document. A service that extends a base,
+// named in x-defaults or another service, starts from the base's settings
+// and overrides them key by key. Merged values keep their positions, so
+// later errors still point at the right line.
+func resolveExtends(doc *yaml.Node) error {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil
+	}
+	root := resolveAlias(doc.Content[0])
+	if root.Kind != yaml.MappingNode {
+		// Leave reporting the wrong shape to the typed decode
+		return nil
+	}
+
+	_, services := mappingEntry(root, "services")
+	services = resolveAlias(services)
+	if services != nil && services.Kind == yaml.MappingNode {
+		if err := flattenServices(services); err != nil {
+			return err
+		}
+	}
+	_, defaults := mappingEntry(root, "x-defaults")
+	defaults = resolveAlias(defaults)
+
+	resolved := make(map[string]*yaml.Node)
+	var resolve func(name, kind string, chain []string) (*yaml.Node, error)
+	resolve = func(name, kind string, chain []string) (*yaml.Node, error) {
+		path := []string{"services", name}
+		if kind != "service" {
+			path = []string{"x-defaults", name}
+		}
+		for _, seen := range chain {
+			if seen == name {
+				return nil, fieldErrorf(append(path, "extends"), "extends cycle: %s -> %s", strings.Join(chain, " -> "), name)
+			}
+		}
+		chain = append(chain, name)
+
+		var base *yaml.Node
+		if kind == "service" {
+			if done, ok := resolved[name]; ok {
+				return done, nil
+			}
+			_, base = mappingEntry(services, name)
+			base = resolveAlias(base)
+			if base != nil && base.Tag == "!!null" {
+				base = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: base.Line, Column: base.Column}
+			}
+		} else {
+			_, base = mappingEntry(defaults, name)
+			base = resolveAlias(base)
+		}
+		if base == nil || base.Kind != yaml.MappingNode {
+			return nil, fieldErrorf(path, "%s %s is not a mapping", kind, name)
+		}
+
+		if duplicate, first := duplicateKey(base); duplicate != nil {
+			return nil, &ConfigError{
+				Path:   append(path, duplicate.Value),
+				Line:   duplicate.Line,
+				Column: duplicate.Column,
+				Err:    fmt.Errorf("declared twice, first at line %d", first.Line),
+			}
+		}
+
+		merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: base.Line, Column: base.Column}
+		pairs := mappingPairs(base)
+		for _, pair := range pairs {
+			if pair[0].Value != "extends" {
+				continue
+			}
+			parentName := pair[1].Value
+			if pair[1].Kind != yaml.ScalarNode || parentName == "" {
+				return nil, fieldErrorf(append(path, "extends"), "extends must be a name")
+			}
+			parentKind := "x-defaults entry"
+			if key, _ := mappingEntry(defaults, parentName); key == nil {
+				if key, _ := mappingEntry(services, parentName); key == nil {
+					return nil, fieldErrorf(append(path, "extends"), "extends unknown base %q", parentName)
+				}
+				parentKind = "service"
+			}
+			inherited, err := resolve(parentName, parentKind, chain)
+			if err != nil {
+				return nil, err
+			}
+			for _, inheritedPair := range mappingPairs(inherited) {
+				setMappingEntry(merged, inheritedPair[0], inheritedPair[1])
+			}
+		}
+		for _, pair := range pairs {
+			if pair[0].Value != "extends" {
+				setMappingEntry(merged, pair[0], pair[1])
+			}
+		}
+
+		if kind == "service" {
+			resolved[name] = merged
+		}
+		return merged, nil
+	}
+
+	if services != nil && services.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(services.Content); i += 2 {
+			merged, err := resolve(services.Content[i].Value, "service", nil)
+			if err != nil {
+				return err
+			}
+			services.Content[i+1] = merged
+		}
+	}
+
+	content := root.Content[:0:0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if !strings.HasPrefix(root.Content[i].Value, extensionPrefix) {
+			content = append(content, root.Content[i], root.Content[i+1])
+		}
+	}
+	root.Content = content
+	return nil
+}
+
+// flattenServices expands merge keys in the services mapping, so services
+// pulled in from an anchor are resolved like the rest, and rejects a service
+// declared twice, whether directly or through a merge
+func flattenServices(services *yaml.Node) error {
+	declared := make(map[string]*yaml.Node)
+	declare := func(key *yaml.Node) error {
+		first, exists := declared[key.Value]
+		if !exists {
+			declared[key.Value] = key
+			return nil
+		}
+		return &ConfigError{
+			Path:   []string{"services", key.Value},
+			Line:   key.Line,
+			Column: key.Column,
+			Err:    fmt.Errorf("service declared twice, first at line %d", first.Line),
+		}
+	}
+
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		key, value := services.Content[i], resolveAlias(services.Content[i+1])
+		if !isMergeKey(key) {
+			if err := declare(key); err != nil {
+				return err
+			}
+			continue
+		}
+		sources := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			sources = value.Content
+		}
+		for _, source := range sources {
+			if source = resolveAlias(source); source.Kind != yaml.MappingNode {
+				continue
+			}
+			for _, pair := range mappingPairs(source) {
+				if err := declare(pair[0]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	pairs := mappingPairs(services)
+	services.Content = services.Content[:0:0]
+	for _, pair := range pairs {
+		services.Content = append(services.Content, pair[0], pair[1])
+	}
+	return nil
+}
+
+// mappingPairs returns a mapping's key and value nodes with merge keys (<<)
+// expanded, later keys overriding earlier ones as when decoding
+func mappingPairs(mapping *yaml.Node) [][2]*yaml.Node {
+	result := &yaml.Node{Kind: yaml.MappingNode}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key, value := mapping.Content[i], resolveAlias(mapping.Content[i+1])
+		if !isMergeKey(key) {
+			continue
+		}
+		sources := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			sources = value.Content
+		}
+		// Earlier sources in a merge list take precedence
+		for j := len(sources) - 1; j >= 0; j-- {
+			if source := resolveAlias(sources[j]); source.Kind == yaml.MappingNode {
+				for _, pair := range mappingPairs(source) {
+					setMappingEntry(result, pair[0], pair[1])
+				}
+			}
+		}
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if !isMergeKey(mapping.Content[i]) {
+			setMappingEntry(result, mapping.Content[i], mapping.Content[i+1])
+		}
+	}
+
+	pairs := make([][2]*yaml.Node, 0, len(result.Content)/2)
+	for i := 0; i+1 < len(result.Content); i += 2 {
+		pairs = append(pairs, [2]*yaml.Node{result.Content[i], result.Content[i+1]})
+	}
+	return pairs
+}
+
+// isMergeKey reports whether a mapping key is the YAML merge key <<
+func isMergeKey(key *yaml.Node) bool {
+	return key.Kind == yaml.ScalarNode && key.Tag == "!!merge"
+}
+
+// duplicateKey returns the second occurrence of a key that appears twice in
+// a mapping, and the first
+func duplicateKey(mapping *yaml.Node) (duplicate, first *yaml.Node) {
+	seen := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key := mapping.Content[i]
+		if isMergeKey(key) {
+			continue
+		}
+		if earlier, exists := seen[key.Value]; exists {
+			return key, earlier
+		}
+		seen[key.Value] = key
+	}
+	return nil, nil
+}
+
+// setMappingEntry sets a key in a mapping, replacing any existing value
+func setMappingEntry(mapping, key, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key.Value {
+			mapping.Content[i], mapping.Content[i+1] = key, value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, key, value)
+}
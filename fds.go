@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// File modes for files opened and passed to a service
+const (
+	FileModeRead      = "read"
+	FileModeWrite     = "write"
+	FileModeAppend    = "append"
+	FileModeReadWrite = "readwrite"
+)
+
+// Pipe ends a service can receive
+const (
+	PipeEndRead  = "read"
+	PipeEndWrite = "write"
+)
+
+// FileDescriptor describes an extra file descriptor passed to a service at a
+// specific number. Exactly one of Path, Listen or Pipe must be set.
+type FileDescriptor struct {
+	FD     int    `yaml:"fd"`
+	Path   string `yaml:"path"`
+	Mode   string `yaml:"mode"`
+	Listen string `yaml:"listen"`
+	Pipe   string `yaml:"pipe"`
+	End    string `yaml:"end"`
+}
+
+// validateFiles checks every service's extra file descriptors
+func (c *Config) validateFiles() error {
+	for name, svc := range c.Services {
+		seen := make(map[int]bool)
+		for i, f := range svc.Files {
+			path := []string{"services", name, "files", listIndex(i)}
+			if f.FD < 3 {
+				return fieldErrorf(append(path, "fd"), "fd %d is reserved, use 3 or above", f.FD)
+			}
+			if seen[f.FD] {
+				return fieldErrorf(append(path, "fd"), "fd %d declared twice", f.FD)
+			}
+			seen[f.FD] = true
+
+			sources := 0
+			for _, set := range []bool{f.Path != "", f.Listen != "", f.Pipe != ""} {
+				if set {
+					sources++
+				}
+			}
+			if sources != 1 {
+				return fieldErrorf(path, "needs exactly one of path, listen or pipe")
+			}
+
+			switch {
+			case f.Path != "":
+				switch f.Mode {
+				case "", FileModeRead, FileModeWrite, FileModeAppend, FileModeReadWrite:
+				default:
+					return fieldErrorf(append(path, "mode"), "unknown mode %q", f.Mode)
+				}
+			case f.Listen != "":
+				if _, _, err := parseListenAddress(f.Listen); err != nil {
+					return fieldErrorf(append(path, "listen"), "%v", err)
+				}
+			case f.Pipe != "":
+				if f.End != PipeEndRead && f.End != PipeEndWrite {
+					return fieldErrorf(append(path, "end"), "pipe end must be read or write")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// parseListenAddress splits an address like tcp://0.0.0.0:8080 or
+// unix:///run/app.sock into a network and address
+func parseListenAddress(listen string) (network, address string, err error) {
+	network, address, found := strings.Cut(listen, "://")
+	if !found || address == "" {
+		return "", "", fmt.Errorf("listen address %q must look like tcp://host:port or unix:///path", listen)
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6", "unix", "unixgram", "unixpacket":
+		return network, address, nil
+	default:
+		return "", "", fmt.Errorf("listen address %q has unsupported network %q", listen, network)
+	}
+}
+
+// sharedFiles holds sockets and pipes pei keeps open for its whole lifetime,
+// so they survive service restarts and can be shared between services
+type sharedFiles struct {
+	listeners map[string]*os.File
+	pipes     map[string][2]*os.File
+}
+
+// openSharedFiles binds every configured socket and creates every named pipe.
+// It runs before privileges are dropped so services can be handed ports they
+// couldn't bind themselves.
+func openSharedFiles(config *Config) (*sharedFiles, error) {
+	shared := &sharedFiles{
+		listeners: make(map[string]*os.File),
+		pipes:     make(map[string][2]*os.File),
+	}
+	if err := shared.open(config); err != nil {
+		return nil, err
+	}
+	return shared, nil
+}
+
+// open binds the sockets and creates the pipes in config that aren't open
+// yet, such as ones added by a config reload. Must be called with elevated
+// privileges.
+func (shared *sharedFiles) open(config *Config) error {
+	for _, svc := range config.Services {
+		for _, f := range svc.Files {
+			switch {
+			case f.Listen != "":
+				if _, exists := shared.listeners[f.Listen]; exists {
+					continue
+				}
+				file, err := listenFile(f.Listen)
+				if err != nil {
+					return fmt.Errorf("service %s: files: fd %d: %v", svc.Name, f.FD, err)
+				}
+				shared.listeners[f.Listen] = file
+			case f.Pipe != "":
+				if _, exists := shared.pipes[f.Pipe]; exists {
+					continue
+				}
+				r, w, err := os.Pipe()
+				if err != nil {
+					return fmt.Errorf("service %s: files: pipe %s: %v", svc.Name, f.Pipe, err)
+				}
+				shared.pipes[f.Pipe] = [2]*os.File{r, w}
+			}
+		}
+	}
+	return nil
+}
+
+// listenFile binds a socket and returns it as a file that can be passed on
+func listenFile(listen string) (*os.File, error) {
+	network, address, err := parseListenAddress(listen)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(network, "unix") {
+		os.Remove(address)
+	}
+
+	switch network {
+	case "udp", "udp4", "udp6", "unixgram":
+		conn, err := net.ListenPacket(network, address)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		return conn.(interface{ File() (*os.File, error) }).File()
+	default:
+		listener, err := net.Listen(network, address)
+		if err != nil {
+			return nil, err
+		}
+		defer listener.Close()
+		return listener.(interface{ File() (*os.File, error) }).File()
+	}
+}
+
+// setupExtraFiles places a service's extra file descriptors on the command.
+// The returned release function closes files opened just for this start and
+// must be called once the command has been started, or has failed to start.
+func (d *Daemon) setupExtraFiles(cmd *exec.Cmd, svc Service) (release func(), err error) {
+	var opened []*os.File
+	release = func() {
+		for _, f := range opened {
+			f.Close()
+		}
+	}
+	if len(svc.Files) == 0 {
+		return release, nil
+	}
+
+	highest := 0
+	for _, f := range svc.Files {
+		highest = max(highest, f.FD)
+	}
+	// Entry i becomes fd 3+i in the child; unset entries are closed
+	extra := make([]*os.File, highest-2)
+
+	for _, f := range svc.Files {
+		var file *os.File
+		switch {
+		case f.Path != "":
+			flags := os.O_RDONLY
+			switch f.Mode {
+			case FileModeWrite:
+				flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+			case FileModeAppend:
+				flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+			case FileModeReadWrite:
+				flags = os.O_RDWR | os.O_CREATE
+			}
+			file, err = os.OpenFile(f.Path, flags, 0644)
+			if err != nil {
+				release()
+				return nil, fmt.Errorf("fd %d: %v", f.FD, err)
+			}
+			opened = append(opened, file)
+		case f.Listen != "":
+			file = d.shared.listeners[f.Listen]
+		case f.Pipe != "":
+			ends := d.shared.pipes[f.Pipe]
+			if f.End == PipeEndRead {
+				file = ends[0]
+			} else {
+				file = ends[1]
+			}
+		}
+		if file == nil {
+			release()
+			return nil, fmt.Errorf("fd %d: shared file not open", f.FD)
+		}
+		extra[f.FD-3] = file
+	}
+
+	cmd.ExtraFiles = extra
+	return release, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// graphEdge is an edge of the service graph: then starts after first, or
+// needs it, or both
+type graphEdge struct {
+	first, then string
+	ordered     bool // by after, before or depends_on
+	priority    bool // only by priority
+	dependency  bool // by depends_on
+}
+
+// condition is what then waits for from first during boot: readiness for
+// services that signal it, being started for the rest
+func (e graphEdge) condition(services map[string]Service) string {
+	svc := services[e.first]
+	switch {
+	case svc.OnDemand:
+		return "listening"
+	case svc.ReadyFile != "" || svc.ReadyLogPattern != "":
+		return "ready"
+	}
+	return "started"
+}
+
+// label describes why the edge exists
+func (e graphEdge) label(services map[string]Service) string {
+	var parts []string
+	if e.dependency {
+		parts = append(parts, "depends_on")
+	}
+	if e.ordered || e.priority {
+		parts = append(parts, e.condition(services))
+	}
+	if e.priority {
+		parts = append(parts, "priority")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// serviceGraph is the services of a config and the edges between them
+type serviceGraph struct {
+	config *Config
+	tiers  [][]string
+	edges  []graphEdge // sorted by then, then first
+}
+
+// buildServiceGraph collects the ordering and dependency edges between a
+// config's services
+func buildServiceGraph(config *Config) (*serviceGraph, error) {
+	tiers, err := startTiers(config.Services)
+	if err != nil {
+		return nil, err
+	}
+
+	edges := make(map[[2]string]*graphEdge)
+	edge := func(first, then string) *graphEdge {
+		key := [2]string{first, then}
+		if edges[key] == nil {
+			edges[key] = &graphEdge{first: first, then: then}
+		}
+		return edges[key]
+	}
+	exists := func(name string) bool {
+		_, ok := config.Services[name]
+		return ok
+	}
+	for name, svc := range config.Services {
+		for _, other := range svc.After {
+			if exists(other) {
+				edge(other, name).ordered = true
+			}
+		}
+		for _, other := range svc.Before {
+			if exists(other) {
+				edge(name, other).ordered = true
+			}
+		}
+		for _, other := range svc.DependsOn {
+			if exists(other) {
+				e := edge(other, name)
+				e.ordered, e.dependency = true, true
+			}
+		}
+		if join := svc.JoinNamespaces; join != nil && exists(join.Service) {
+			edge(join.Service, name).ordered = true
+		}
+	}
+	for then, firsts := range orderingPredecessors(config.Services) {
+		for _, first := range firsts {
+			if e := edges[[2]string{first, then}]; e == nil || !e.ordered {
+				edge(first, then).priority = true
+			}
+		}
+	}
+
+	g := &serviceGraph{config: config, tiers: tiers}
+	for _, e := range edges {
+		g.edges = append(g.edges, *e)
+	}
+	slices.SortFunc(g.edges, func(a, b graphEdge) int {
+		if c := strings.Compare(a.then, b.then); c != 0 {
+			return c
+		}
+		return strings.Compare(a.first, b.first)
+	})
+	return g, nil
+}
+
+// nodeNotes lists what is notable about how a service runs, for its node
+func nodeNotes(svc Service) []string {
+	var notes []string
+	switch {
+	case svc.Oneshot && svc.Interval > 0:
+		notes = append(notes, "every "+svc.Interval.String())
+	case svc.Oneshot:
+		notes = append(notes, "oneshot")
+	}
+	if svc.OnDemand {
+		notes = append(notes, "on demand")
+	}
+	if svc.Spawn != "" {
+		notes = append(notes, "spawn "+svc.Spawn)
+	}
+	if svc.Priority != 0 {
+		notes = append(notes, fmt.Sprintf("priority %d", svc.Priority))
+	}
+	return notes
+}
+
+// writeDOT writes the graph in Graphviz DOT. Services in a stop phase are
+// grouped in a cluster per phase; edges only from priority are dashed and
+// those from depends_on bold.
+func (g *serviceGraph) writeDOT(w io.Writer) {
+	fmt.Fprintln(w, "digraph pei {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	fmt.Fprintln(w, "  node [shape=box];")
+
+	node := func(indent, name string) {
+		label := strings.Join(append([]string{name}, nodeNotes(g.config.Services[name])...), "\n")
+		fmt.Fprintf(w, "%s%s [label=%s];\n", indent, strconv.Quote(name), strconv.Quote(label))
+	}
+	var order []string
+	for _, tier := range g.tiers {
+		order = append(order, tier...)
+	}
+	for i, phase := range g.config.StopPhases {
+		fmt.Fprintf(w, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(w, "    label=%s;\n", strconv.Quote("stop phase "+phase.Name))
+		for _, name := range order {
+			if g.config.Services[name].StopPhase == phase.Name {
+				node("    ", name)
+			}
+		}
+		fmt.Fprintln(w, "  }")
+	}
+	for _, name := range order {
+		if g.config.Services[name].StopPhase == "" {
+			node("  ", name)
+		}
+	}
+
+	for _, e := range g.edges {
+		attrs := []string{"label=" + strconv.Quote(e.label(g.config.Services))}
+		switch {
+		case e.dependency:
+			attrs = append(attrs, "style=bold")
+		case e.priority:
+			attrs = append(attrs, "style=dashed")
+		}
+		fmt.Fprintf(w, "  %s -> %s [%s];\n", strconv.Quote(e.first), strconv.Quote(e.then), strings.Join(attrs, ", "))
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// writeASCII writes the graph as text: services by start tier, each with
+// what it waits for and its stop phase
+func (g *serviceGraph) writeASCII(w io.Writer) {
+	for i, tier := range g.tiers {
+		if i > 0 {
+			fmt.Fprintln(w, "    |")
+			fmt.Fprintln(w, "    v")
+		}
+		fmt.Fprintf(w, "Tier %d\n", i+1)
+		for _, name := range tier {
+			svc := g.config.Services[name]
+			line := "  " + name
+			if notes := nodeNotes(svc); len(notes) > 0 {
+				line += " (" + strings.Join(notes, ", ") + ")"
+			}
+			if svc.StopPhase != "" {
+				line += " [stop phase " + svc.StopPhase + "]"
+			}
+			fmt.Fprintln(w, line)
+			for _, e := range g.edges {
+				if e.then == name {
+					fmt.Fprintf(w, "    <- %s: %s\n", e.first, e.label(g.config.Services))
+				}
+			}
+		}
+	}
+}
+
+// showGraph prints the service graph of a config in format dot or ascii
+func showGraph(config *Config, format string, w io.Writer) error {
+	g, err := buildServiceGraph(config)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "dot":
+		g.writeDOT(w)
+	case "ascii":
+		g.writeASCII(w)
+	default:
+		return fmt.Errorf("unknown format %q, expected dot or ascii", format)
+	}
+	return nil
+}
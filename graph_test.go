@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestServiceGraph(t *testing.T) {
+	config := &Config{
+		StopPhases: []StopPhase{{Name: "ingest"}},
+		Services: map[string]Service{
+			"db":    {Name: "db", ReadyFile: "/run/db.ready", Priority: -1},
+			"cache": {Name: "cache"},
+			"web":   {Name: "web", After: []string{"cache"}, DependsOn: []string{"db"}, StopPhase: "ingest"},
+		},
+	}
+
+	var dot strings.Builder
+	if err := showGraph(config, "dot", &dot); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"subgraph cluster_0 {\n    label=\"stop phase ingest\";\n    \"web\" [label=\"web\"];\n  }",
+		`"db" [label="db\npriority -1"];`,
+		`"cache" -> "web" [label="started"];`,
+		`"db" -> "web" [label="depends_on, ready", style=bold];`,
+		`"db" -> "cache" [label="ready, priority", style=dashed];`,
+	} {
+		if !strings.Contains(dot.String(), want) {
+			t.Errorf("expected DOT output to contain %q:\n%s", want, dot.String())
+		}
+	}
+
+	var ascii strings.Builder
+	if err := showGraph(config, "ascii", &ascii); err != nil {
+		t.Fatal(err)
+	}
+	want := "Tier 3\n  web [stop phase ingest]\n    <- cache: started\n    <- db: depends_on, ready\n"
+	if !strings.HasSuffix(ascii.String(), want) {
+		t.Errorf("expected text output to end with %q:\n%s", want, ascii.String())
+	}
+
+	if err := showGraph(config, "svg", &ascii); err == nil {
+		t.Error("expected an unknown format to be rejected")
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Health check defaults
+const (
+	defaultHealthInterval = 2 * time.Second
+	defaultHealthTimeout  = 5 * time.Second
+	defaultHealthRetries  = 3
+)
+
+// Health a service's probes have settled on
+const (
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
+
+// HealthCheck describes how to tell whether a service instance is healthy.
+// Exactly one of Command, TCP or HTTP must be set.
+type HealthCheck struct {
+	Command     []string      `yaml:"command"`
+	TCP         string        `yaml:"tcp"`
+	HTTP        string        `yaml:"http"`
+	Interval    time.Duration `yaml:"interval"`
+	Timeout     time.Duration `yaml:"timeout"`
+	Retries     int           `yaml:"retries"`
+	StartPeriod time.Duration `yaml:"start_period"`
+}
+
+// validate checks that a health check has exactly one probe
+func (h *HealthCheck) validate() error {
+	probes := 0
+	for _, set := range []bool{len(h.Command) > 0, h.TCP != "", h.HTTP != ""} {
+		if set {
+			probes++
+		}
+	}
+	if probes != 1 {
+		return fmt.Errorf("needs exactly one of command, tcp or http")
+	}
+	if h.Retries < 0 {
+		return fmt.Errorf("retries must not be negative")
+	}
+	return nil
+}
+
+func (h *HealthCheck) interval() time.Duration {
+	if h.Interval > 0 {
+		return h.Interval
+	}
+	return defaultHealthInterval
+}
+
+func (h *HealthCheck) timeout() time.Duration {
+	if h.Timeout > 0 {
+		return h.Timeout
+	}
+	return defaultHealthTimeout
+}
+
+func (h *HealthCheck) retries() int {
+	if h.Retries > 0 {
+		return h.Retries
+	}
+	return defaultHealthRetries
+}
+
+// probe runs a health check once. Command checks run as the service's user,
+// so it must be called with elevated privileges.
+func (h *HealthCheck) probe(svc Service) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout())
+	defer cancel()
+
+	switch {
+	case len(h.Command) > 0:
+		cmd, err := buildHelperCmd(ctx, svc, h.Command)
+		if err != nil {
+			return err
+		}
+		if output, err := combinedOutput(cmd); err != nil {
+			return fmt.Errorf("command failed: %v: %s", err, output)
+		}
+		return nil
+	case h.TCP != "":
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", h.TCP)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	default:
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.HTTP, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("unhealthy status %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+// HealthStatus is the outcome of a service's recent health probes. Status is
+// healthy after a probe passes and unhealthy once retries probes in a row
+// have failed; until either, it is empty.
+type HealthStatus struct {
+	Status              string        `json:"status,omitempty"`
+	PID                 int           `json:"pid"` // the instance last probed
+	LastCheck           time.Time     `json:"last_check"`
+	LastLatency         time.Duration `json:"last_latency"`
+	LastError           string        `json:"last_error,omitempty"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	Checks              uint64        `json:"checks"`
+	Failures            uint64        `json:"failures"`
+}
+
+// probeHealth runs a service's health check once against one of its
+// instances and records the result, unless the instance exited meanwhile or
+// isn't the supervised one: a replacement's failures aren't the service's.
+// Must be called with elevated privileges.
+func (d *Daemon) probeHealth(svc Service, proc *serviceProcess) error {
+	started := time.Now()
+	err := svc.HealthCheck.probe(svc)
+	if proc.running() && !proc.detached.Load() {
+		d.recordHealth(svc, proc.cmd.Process.Pid, time.Since(started), err)
+	}
+	return err
+}
+
+// recordHealth adds a probe result to a service's health, recording an
+// event when it turns healthy or unhealthy
+func (d *Daemon) recordHealth(svc Service, pid int, latency time.Duration, err error) {
+	d.mu.Lock()
+	status, exists := d.serviceStatus[svc.Name]
+	if !exists {
+		d.mu.Unlock()
+		return
+	}
+	// Replaced rather than changed, so status snapshots stay as they were
+	var health HealthStatus
+	if status.Health != nil {
+		health = *status.Health
+	}
+	previous := health.Status
+	health.PID = pid
+	health.LastCheck = time.Now()
+	health.LastLatency = latency
+	health.Checks++
+	if err == nil {
+		health.Status = HealthHealthy
+		health.LastError = ""
+		health.ConsecutiveFailures = 0
+	} else {
+		health.LastError = err.Error()
+		health.ConsecutiveFailures++
+		health.Failures++
+		if health.ConsecutiveFailures >= svc.HealthCheck.retries() {
+			health.Status = HealthUnhealthy
+		}
+	}
+	status.Health = &health
+	d.mu.Unlock()
+
+	if health.Status == previous {
+		return
+	}
+	fields := map[string]string{
+		"pid":     fmt.Sprint(pid),
+		"latency": latency.String(),
+	}
+	if health.Status == HealthHealthy {
+		d.events.record(EventHealthy, svc.Name, "Health check passed", fields)
+		return
+	}
+	fields["error"] = health.LastError
+	fields["consecutive_failures"] = fmt.Sprint(health.ConsecutiveFailures)
+	d.events.record(EventUnhealthy, svc.Name, "Health check failed", fields)
+}
+
+// waitHealthy waits for a freshly started instance to pass its health check.
+// It gives up once the check has failed retries times in a row, the instance
+// exits or shutdown begins.
+func (d *Daemon) waitHealthy(svc Service, proc *serviceProcess) error {
+	h := svc.HealthCheck
+	select {
+	case <-proc.exited:
+		return fmt.Errorf("instance exited before its health check ran")
+	case <-time.After(h.StartPeriod):
+	case <-d.ctx.Done():
+		return d.ctx.Err()
+	}
+
+	var err error
+	for attempt := 1; attempt <= h.retries(); attempt++ {
+		if err = d.requestProbe(svc, proc); err == nil {
+			return nil
+		}
+		logServiceInfo(svc.Name, "Health check failed",
+			"pid", proc.cmd.Process.Pid,
+			"attempt", attempt,
+			"error", err)
+
+		if attempt == h.retries() {
+			break
+		}
+		select {
+		case <-proc.exited:
+			return fmt.Errorf("instance exited before passing its health check")
+		case <-time.After(h.interval()):
+		case <-d.ctx.Done():
+			return d.ctx.Err()
+		}
+	}
+	return fmt.Errorf("health check failed %d times: %v", h.retries(), err)
+}
+
+// healthFailures is how many health probes in a row a service's current
+// instance has failed
+func (d *Daemon) healthFailures(name string) int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if status, exists := d.serviceStatus[name]; exists && status.Health != nil {
+		return status.Health.ConsecutiveFailures
+	}
+	return 0
+}
+
+// requestProbe probes an instance once, through the service manager if the
+// check is a command, which runs as the service's user
+func (d *Daemon) requestProbe(svc Service, proc *serviceProcess) error {
+	if len(svc.HealthCheck.Command) == 0 {
+		return d.probeHealth(svc, proc)
+	}
+	req := healthRequest{svc: svc, proc: proc, done: make(chan error, 1)}
+	select {
+	case d.healthChan <- req:
+	case <-proc.exited:
+		return fmt.Errorf("instance exited")
+	case <-d.ctx.Done():
+		return d.ctx.Err()
+	}
+	return <-req.done
+}
+
+// healthRequest asks the service manager to probe an instance with its
+// service's health check command, which runs as the service's user
+type healthRequest struct {
+	svc  Service
+	proc *serviceProcess
+	done chan error
+}
+
+// monitorHealth probes an instance every interval of its service's health
+// check, from once its start period has passed and it's ready until it exits
+// or shutdown begins. When the instance turns unhealthy, the service is
+// restarted if its restart policy would restart it after a failure.
+func (d *Daemon) monitorHealth(svc Service, proc *serviceProcess) {
+	wait := func(delay time.Duration) bool {
+		select {
+		case <-time.After(delay):
+			return true
+		case <-proc.exited:
+			return false
+		case <-d.ctx.Done():
+			return false
+		}
+	}
+	if !wait(svc.HealthCheck.StartPeriod) {
+		return
+	}
+	select {
+	case <-proc.ready.ch:
+	case <-proc.exited:
+		return
+	case <-d.ctx.Done():
+		return
+	}
+
+	for {
+		// A reload can change or remove the check without restarting
+		current, exists := d.getConfig().Services[svc.Name]
+		if !exists || current.HealthCheck == nil {
+			return
+		}
+		svc = current
+
+		// A replacement being rolled out is probed by the rollout
+		if !proc.detached.Load() {
+			d.checkHealth(svc, proc)
+		}
+		if !wait(svc.HealthCheck.interval()) {
+			return
+		}
+	}
+}
+
+// checkHealth probes a service's current instance once and acts on the
+// result: a passing running service is healthy, and one that has failed
+// retries probes in a row is restarted according to its restart policy
+func (d *Daemon) checkHealth(svc Service, proc *serviceProcess) {
+	err := d.requestProbe(svc, proc)
+	if !proc.running() || d.shuttingDown() {
+		return
+	}
+
+	if err == nil {
+		d.setStateIf(svc, StateRunning, StateHealthy)
+		return
+	}
+	if d.healthFailures(svc.Name) != svc.HealthCheck.retries() {
+		return
+	}
+	d.setStateIf(svc, StateHealthy, StateRunning)
+
+	switch decideExit(svc, err, d.failureRestartCount(svc.Name)) {
+	case exitRestart:
+		logServiceError(svc.Name, "Service is unhealthy, restarting it", "pid", proc.cmd.Process.Pid, "error", err)
+		d.requestRestart(restartRequest{svc: svc, reason: RestartReasonHealthCheck, detail: err.Error(), instance: proc})
+	case exitGiveUp:
+		logServiceError(svc.Name, "Service is unhealthy but has used up its max_restarts, leaving it running",
+			"pid", proc.cmd.Process.Pid, "max_restarts", svc.MaxRestarts, "error", err)
+	default:
+		logServiceError(svc.Name, "Service is unhealthy", "pid", proc.cmd.Process.Pid, "error", err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordHealth(t *testing.T) {
+	svc := Service{Name: "web", HealthCheck: &HealthCheck{HTTP: "http://127.0.0.1/health", Retries: 2}}
+	d := NewDaemon(&Config{Services: map[string]Service{"web": svc}}, "", "", "")
+	defer d.cancel()
+	d.setState(svc, StateRunning)
+
+	d.recordHealth(svc, 10, 5*time.Millisecond, nil)
+	d.recordHealth(svc, 10, 7*time.Millisecond, errors.New("connection refused"))
+	status, _ := d.getServiceStatus("web")
+	if status.Health.Status != HealthHealthy || status.Health.ConsecutiveFailures != 1 {
+		t.Fatalf("expected one failure short of retries to stay healthy, got %+v", status.Health)
+	}
+
+	d.recordHealth(svc, 10, 9*time.Millisecond, errors.New("connection refused"))
+	status, _ = d.getServiceStatus("web")
+	if status.Health.Status != HealthUnhealthy || status.Health.Checks != 3 || status.Health.Failures != 2 {
+		t.Fatalf("expected unhealthy after retries failures, got %+v", status.Health)
+	}
+
+	// Only the transitions are events
+	var types []string
+	for _, event := range d.events.list("web", 0) {
+		types = append(types, event.Type)
+	}
+	if strings.Join(types, ",") != EventHealthy+","+EventUnhealthy {
+		t.Errorf("expected healthy then unhealthy events, got %v", types)
+	}
+
+	var metrics bytes.Buffer
+	d.selfMetrics().writePrometheus(&metrics)
+	for _, line := range []string{
+		`pei_service_healthy{service="web"} 0`,
+		`pei_service_health_consecutive_failures{service="web"} 2`,
+		`pei_service_health_probe_latency_seconds{service="web"} 0.009`,
+		`pei_service_health_failures_total{service="web"} 2`,
+	} {
+		if !strings.Contains(metrics.String(), "\n"+line+"\n") {
+			t.Errorf("expected metrics to contain %q:\n%s", line, metrics.String())
+		}
+	}
+}
+
+func TestCheckHealthWhileRecording(t *testing.T) {
+	svc := Service{Name: "web", Restart: RestartNever, HealthCheck: &HealthCheck{TCP: "127.0.0.1:1", Retries: 2, Timeout: time.Second}}
+	d := NewDaemon(&Config{Services: map[string]Service{"web": svc}}, "", "", "")
+	defer d.cancel()
+	proc := &serviceProcess{cmd: &exec.Cmd{Process: &os.Process{Pid: 42}}, exited: make(chan struct{}), ready: newReadyState()}
+	d.setState(svc, StateRunning)
+
+	// Probes recorded from elsewhere while the check reads the failures,
+	// for go test -race
+	stop := make(chan struct{})
+	recorded := make(chan int)
+	go func() {
+		count := 0
+		for {
+			select {
+			case <-stop:
+				recorded <- count
+				return
+			default:
+				d.recordHealth(svc, 42, time.Millisecond, errors.New("connection refused"))
+				count++
+			}
+		}
+	}()
+	for range 10 {
+		d.checkHealth(svc, proc)
+	}
+	close(stop)
+	if count := <-recorded; d.healthFailures("web") != count+10 {
+		t.Errorf("expected every failure counted, got %d of %d", d.healthFailures("web"), count+10)
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	svc := Service{Name: "web", Restart: RestartOnFailure, HealthCheck: &HealthCheck{TCP: listener.Addr().String(), Retries: 2, Timeout: time.Second}}
+	d := NewDaemon(&Config{Services: map[string]Service{"web": svc}}, "", "", "")
+	defer d.cancel()
+	proc := &serviceProcess{cmd: &exec.Cmd{Process: &os.Process{Pid: 42}}, exited: make(chan struct{}), ready: newReadyState()}
+	d.setState(svc, StateRunning)
+
+	// A passing probe makes a running service healthy
+	d.checkHealth(svc, proc)
+	if state := d.serviceState("web"); state != StateHealthy {
+		t.Fatalf("expected healthy, got %s", state)
+	}
+
+	// It's restarted once retries probes in a row fail, and only then
+	listener.Close()
+	d.checkHealth(svc, proc)
+	if d.restarts.depth() != 0 || d.serviceState("web") != StateHealthy {
+		t.Fatal("expected no restart before retries probes fail")
+	}
+	d.checkHealth(svc, proc)
+	req, ok := d.restarts.pop()
+	if !ok || req.reason != RestartReasonHealthCheck || req.instance != proc {
+		t.Fatalf("expected a health-check restart, got %+v", req)
+	}
+	if state := d.serviceState("web"); state != StateRunning {
+		t.Errorf("expected an unhealthy service back to running, got %s", state)
+	}
+	d.checkHealth(svc, proc)
+	if d.restarts.depth() != 0 {
+		t.Error("expected one restart per run of failures")
+	}
+
+	// Services that are never restarted are only marked unhealthy
+	svc.Restart = RestartNever
+	d.promoteProcess(svc, proc)
+	d.checkHealth(svc, proc)
+	d.checkHealth(svc, proc)
+	if status, _ := d.getServiceStatus("web"); d.restarts.depth() != 0 || status.Health.Status != HealthUnhealthy {
+		t.Errorf("expected an unhealthy service left running, got %+v", status.Health)
+	}
+}
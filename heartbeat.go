@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// defaultHeartbeatInterval is how often pei pings when no interval is set
+const defaultHeartbeatInterval = time.Minute
+
+// Heartbeat pings a dead man's switch, such as healthchecks.io or Dead Man's
+// Snitch, while the services it watches are all up. The service alerts when
+// the pings stop, which catches the container dying silently along with pei.
+type Heartbeat struct {
+	URL      string        `yaml:"url"`
+	FailURL  string        `yaml:"fail_url"` // pinged instead while a service is down
+	Interval time.Duration `yaml:"interval"`
+	Services []string      `yaml:"services"` // all services, if empty
+}
+
+// validateHeartbeat checks the heartbeat settings
+func (c *Config) validateHeartbeat() error {
+	if c.Heartbeat == nil {
+		return nil
+	}
+	path := []string{"heartbeat"}
+	if c.Heartbeat.URL == "" {
+		return fieldErrorf(append(path, "url"), "is required")
+	}
+	if !isHTTPURL(c.Heartbeat.URL) {
+		return fieldErrorf(append(path, "url"), "must be an http:// or https:// URL")
+	}
+	if c.Heartbeat.FailURL != "" && !isHTTPURL(c.Heartbeat.FailURL) {
+		return fieldErrorf(append(path, "fail_url"), "must be an http:// or https:// URL")
+	}
+	if c.Heartbeat.Interval < 0 {
+		return fieldErrorf(append(path, "interval"), "must not be negative")
+	}
+	for i, name := range c.Heartbeat.Services {
+		if _, exists := c.Services[name]; !exists {
+			return fieldErrorf(append(path, "services", listIndex(i)), "unknown service %q", name)
+		}
+	}
+	return nil
+}
+
+// runHeartbeat pings the heartbeat URL every interval until shutdown begins,
+// following the heartbeat settings of the current config
+func (d *Daemon) runHeartbeat() {
+	client := &http.Client{Timeout: notifyTimeout}
+	var withheld string
+	for {
+		config := d.getConfig()
+		interval := defaultHeartbeatInterval
+		if config.Heartbeat != nil && config.Heartbeat.Interval > 0 {
+			interval = config.Heartbeat.Interval
+		}
+		if !d.sleep(interval) {
+			return
+		}
+		config = d.getConfig()
+		if config.Heartbeat == nil {
+			continue
+		}
+
+		target := config.Heartbeat.URL
+		down := d.heartbeatDown(config)
+		if down != "" {
+			if down != withheld {
+				getLogger("heartbeat").Warn("Withholding heartbeat while a service is down", "reason", down)
+			}
+			target = config.Heartbeat.FailURL
+		} else if withheld != "" {
+			getLogger("heartbeat").Info("All services up, resuming heartbeat")
+		}
+		withheld = down
+		if target == "" {
+			continue
+		}
+		if err := ping(client, target); err != nil {
+			getLogger("heartbeat").Warn("Heartbeat ping failed", "error", err)
+		}
+	}
+}
+
+// heartbeatDown describes the first watched service that isn't up, or
+// returns "" if they all are. Services are up while running and not
+// unhealthy, and on-demand services while idle and oneshots once completed.
+func (d *Daemon) heartbeatDown(config *Config) string {
+	names := config.Heartbeat.Services
+	if len(names) == 0 {
+		names = slices.Sorted(maps.Keys(config.Services))
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, name := range names {
+		status, exists := d.serviceStatus[name]
+		if !exists {
+			return fmt.Sprintf("%s is not started", name)
+		}
+		up := []ServiceState{StateRunning, StateHealthy, StateIdle, StateCompleted}
+		if !slices.Contains(up, status.State) {
+			return fmt.Sprintf("%s is %s", name, status.State)
+		}
+		if status.Health != nil && status.Health.Status == HealthUnhealthy {
+			return fmt.Sprintf("%s is unhealthy", name)
+		}
+	}
+	return ""
+}
+
+// ping GETs a URL, treating any status but 2xx as a failure
+func ping(client *http.Client, target string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	pings := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pings <- r.URL.Path
+	}))
+	defer server.Close()
+
+	config := &Config{
+		Services: map[string]Service{"web": {Name: "web"}, "job": {Name: "job"}},
+		Heartbeat: &Heartbeat{
+			URL:      server.URL + "/ping",
+			FailURL:  server.URL + "/ping/fail",
+			Interval: 10 * time.Millisecond,
+			Services: []string{"web"},
+		},
+	}
+	d := NewDaemon(config, "", "", "")
+	defer d.cancel()
+	d.setState(config.Services["web"], StateRunning)
+	d.setState(config.Services["job"], StateFailed)
+	go d.runHeartbeat()
+
+	next := func() string {
+		select {
+		case path := <-pings:
+			return path
+		case <-time.After(time.Second):
+			t.Fatal("expected a heartbeat ping")
+			return ""
+		}
+	}
+	if path := next(); path != "/ping" {
+		t.Errorf("expected a ping while web is up, got %s", path)
+	}
+
+	d.mu.Lock()
+	d.serviceStatus["web"].Health = &HealthStatus{Status: HealthUnhealthy}
+	d.mu.Unlock()
+	for path := next(); path != "/ping/fail"; path = next() {
+	}
+
+	// Without a fail_url, pings stop while a service is down
+	updated := *config
+	updated.Heartbeat = &Heartbeat{URL: server.URL + "/ping", Interval: 10 * time.Millisecond, Services: []string{"web"}}
+	d.mu.Lock()
+	d.config = &updated
+	d.mu.Unlock()
+	for len(pings) > 0 {
+		<-pings
+	}
+	select {
+	case path := <-pings:
+		// One ping may have been on its way as the config changed
+		if path != "/ping/fail" {
+			t.Errorf("expected no pings while web is unhealthy, got %s", path)
+		}
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHeartbeatDown(t *testing.T) {
+	config := &Config{
+		Services:  map[string]Service{"web": {Name: "web"}, "db": {Name: "db"}, "migrate": {Name: "migrate"}},
+		Heartbeat: &Heartbeat{URL: "http://example.com"},
+	}
+	d := NewDaemon(config, "", "", "")
+	defer d.cancel()
+
+	if down := d.heartbeatDown(config); down != "db is not started" {
+		t.Errorf("expected db to be reported first, got %q", down)
+	}
+	d.setState(config.Services["web"], StateHealthy)
+	d.setState(config.Services["db"], StateRunning)
+	d.setState(config.Services["migrate"], StateCompleted)
+	if down := d.heartbeatDown(config); down != "" {
+		t.Errorf("expected all services up, got %q", down)
+	}
+	d.setState(config.Services["db"], StateBackoff)
+	if down := d.heartbeatDown(config); down != "db is backoff" {
+		t.Errorf("expected db to be down, got %q", down)
+	}
+}
+
+func TestValidateHeartbeat(t *testing.T) {
+	cases := []struct {
+		heartbeat Heartbeat
+		err       string
+	}{
+		{Heartbeat{}, "heartbeat.url: is required"},
+		{Heartbeat{URL: "example.com/ping"}, "http:// or https://"},
+		{Heartbeat{URL: "https://example.com", FailURL: "/fail"}, "fail_url"},
+		{Heartbeat{URL: "https://example.com", Interval: -time.Second}, "must not be negative"},
+		{Heartbeat{URL: "https://example.com", Services: []string{"db"}}, `unknown service "db"`},
+	}
+	for _, c := range cases {
+		config := &Config{Services: map[string]Service{"web": {Name: "web"}}, Heartbeat: &c.heartbeat}
+		if err := config.validateHeartbeat(); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%+v: expected error containing %q, got %v", c.heartbeat, c.err, err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// InitStep is a run-once setup command, such as a migration or a chown of a
+// mounted volume. Init steps run in order before any service starts, and if
+// one fails pei exits so the container fails.
+type InitStep struct {
+	Name        string            `yaml:"name"`
+	Command     []string          `yaml:"command"`
+	User        string            `yaml:"user"`
+	Group       string            `yaml:"group"`
+	WorkingDir  string            `yaml:"working_dir"`
+	Environment map[string]string `yaml:"environment"`
+	Timeout     time.Duration     `yaml:"timeout"`
+}
+
+// service describes the step as a oneshot service, to run it the same way
+func (s InitStep) service() Service {
+	return Service{
+		Name:        s.Name,
+		Command:     s.Command,
+		User:        s.User,
+		Group:       s.Group,
+		WorkingDir:  s.WorkingDir,
+		Environment: s.Environment,
+		Oneshot:     true,
+	}
+}
+
+// validateInit checks the init steps
+func (c *Config) validateInit() error {
+	seen := make(map[string]bool)
+	for i, step := range c.Init {
+		if step.Name == "" {
+			return fieldErrorf([]string{"init", listIndex(i)}, "step needs a name")
+		}
+		if seen[step.Name] {
+			return fieldErrorf([]string{"init", listIndex(i), "name"}, "step %s declared twice", step.Name)
+		}
+		seen[step.Name] = true
+		if _, exists := c.Services[step.Name]; exists {
+			return fieldErrorf([]string{"init", listIndex(i), "name"}, "step %s has the same name as a service", step.Name)
+		}
+		if len(step.Command) == 0 {
+			return fieldErrorf([]string{"init", listIndex(i)}, "step %s needs a command", step.Name)
+		}
+		if step.Timeout < 0 {
+			return fieldErrorf([]string{"init", listIndex(i), "timeout"}, "must not be negative")
+		}
+	}
+	return nil
+}
+
+// runInit runs the init steps in order, stopping at the first failure. Must
+// be called with elevated privileges, before the global reaper starts.
+func (d *Daemon) runInit() error {
+	for i, step := range d.config.Init {
+		logServiceInfo(step.Name, "Running init step", "step", i+1, "steps", len(d.config.Init))
+		started := time.Now()
+		if err := d.runOnce(step.service(), step.Timeout); err != nil {
+			logServiceError(step.Name, "Init step failed", "error", err)
+			return fmt.Errorf("init step %s failed: %v", step.Name, err)
+		}
+		logServiceInfo(step.Name, "Init step completed", "duration", time.Since(started).String())
+	}
+	return nil
+}
+
+// runOnce runs a command described as a oneshot service to completion, such
+// as an init step, capturing its output like a service's so it shows up in
+// pei logs. A zero timeout means no limit. Must be called with elevated
+// privileges.
+func (d *Daemon) runOnce(svc Service, timeout time.Duration) error {
+	uid, gid, err := lookupUIDGID(svc.User, svc.Group)
+	if err != nil {
+		return fmt.Errorf("failed to look up user/group: %v", err)
+	}
+
+	cmd := buildServiceCmd(svc, uid, gid)
+	sio, err := setupServiceOutput(cmd, svc, uid, gid)
+	if err != nil {
+		return fmt.Errorf("failed to set up output capture: %v", err)
+	}
+	err = startChild(cmd)
+	sio.afterStart(err == nil)
+	if err != nil {
+		return err
+	}
+
+	capture := NewServiceOutputCapture(svc, sio.stdout, sio.stderr, cmd.Process.Pid)
+	capture.history = d.serviceLogs(svc.Name)
+	capture.Start()
+
+	// Kill the whole process group if it runs too long
+	var timedOut atomic.Bool
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			timedOut.Store(true)
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		})
+		defer timer.Stop()
+	}
+
+	err = waitChild(cmd)
+	capture.waitDrained(outputDrainTimeout)
+	capture.Stop()
+
+	if timedOut.Load() {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunInit(t *testing.T) {
+	current, err := user.Current()
+	if err != nil || current.Uid != "0" {
+		t.Skip("Running init steps as another user needs root")
+	}
+
+	marker := filepath.Join(t.TempDir(), "ran")
+	step := func(name string, command ...string) InitStep {
+		return InitStep{Name: name, Command: command, User: "root", Group: "root"}
+	}
+
+	tests := []struct {
+		name  string
+		steps []InitStep
+		err   string
+	}{
+		{"success", []InitStep{step("first", "true"), step("second", "sh", "-c", "echo done")}, ""},
+		{"failure stops", []InitStep{step("broken", "false"), step("after", "touch", marker)}, "init step broken failed"},
+		{"timeout", []InitStep{{Name: "slow", Command: []string{"sleep", "10"}, User: "root", Group: "root", Timeout: 100 * time.Millisecond}}, "timed out"},
+	}
+
+	for _, tt := range tests {
+		d := &Daemon{config: &Config{Init: tt.steps}, logs: make(map[string]*LogBuffer)}
+		err := d.runInit()
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: expected success, got %v", tt.name, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.err, err)
+		}
+	}
+
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("Expected steps after a failed step not to run")
+	}
+}
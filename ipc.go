@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
 	"os"
-	"syscall"
+	"path/filepath"
+	"time"
 )
 
 // IPCRequest represents a request sent to the daemon
@@ -14,6 +17,37 @@ type IPCRequest struct {
 	Command string `json:"command"`
 	Service string `json:"service,omitempty"`
 	Signal  string `json:"signal,omitempty"`
+	Group   bool   `json:"group,omitempty"`
+	Rows    uint16 `json:"rows,omitempty"`
+	Cols    uint16 `json:"cols,omitempty"`
+	Limit   int    `json:"limit,omitempty"`
+	DryRun  bool   `json:"dry_run,omitempty"`
+	Force   bool   `json:"force,omitempty"`
+	Reason  string `json:"reason,omitempty"` // why an operator restarted a service
+	Usage   bool   `json:"usage,omitempty"`  // include resource use in list
+
+	// ID switches the connection to multiplexed framing, see IPCFrame.
+	// Data carries terminal input for an attach stream.
+	ID   uint64 `json:"id,omitempty"`
+	Data []byte `json:"data,omitempty"`
+
+	// Timeout is how long the client will wait for the response, so the
+	// daemon can give up on a request nobody is waiting for
+	Timeout time.Duration `json:"timeout,omitempty"`
+
+	// Token identifies clients of the network API, see ipcPeer
+	Token string `json:"token,omitempty"`
+
+	// Bulk commands act on several services: those in Services, or every
+	// one with All, narrowed to those with all of Labels
+	All    bool              `json:"all,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// pei tail, and bulk commands
+	Services []string `json:"services,omitempty"`
+	Stream   string   `json:"stream,omitempty"`
+	Level    string   `json:"level,omitempty"`
+	Follow   bool     `json:"follow,omitempty"`
 }
 
 // IPCResponse represents a response from the daemon
@@ -22,20 +56,64 @@ type IPCResponse struct {
 	Message  string                    `json:"message,omitempty"`
 	Services map[string]*ServiceStatus `json:"services,omitempty"`
 	Service  *ServiceStatus            `json:"service,omitempty"`
+	Boot     *BootTimeline             `json:"boot,omitempty"`
+	Events   []Event                   `json:"events,omitempty"`
+	Logs     []LogLine                 `json:"logs,omitempty"`
+	Reload   *ReloadSummary            `json:"reload,omitempty"`
+
+	// pei diff
+	Definitions map[string]Service `json:"definitions,omitempty"`
+	ConfigPath  string             `json:"config_path,omitempty"`
+
+	// pei snapshot
+	Config *Config `json:"config,omitempty"`
+
+	// pei metrics and pei debug dump
+	Metrics *DaemonMetrics `json:"metrics,omitempty"`
+
+	// pei version
+	Version *VersionInfo `json:"version,omitempty"`
 }
 
 const (
 	SocketPath = "/tmp/pei.sock"
+
+	// ipcReadTimeout is how long a client has to send its request after
+	// connecting
+	ipcReadTimeout = 10 * time.Second
+
+	// ipcRequestTimeout is the longest the daemon works on a request that
+	// has a single response
+	ipcRequestTimeout = time.Minute
+
+	defaultIPCTimeout = 30 * time.Second
 )
 
+// ipcTimeout is how long CLI commands wait for the daemon to answer, set by
+// --timeout. Zero waits forever.
+var ipcTimeout = defaultIPCTimeout
+
+// handleIPCRequest serves a connection to the local socket
 func handleIPCRequest(conn net.Conn, daemon *Daemon) {
+	peer := localPeer
+	peer.limiter = daemon.ipcLimiter
+	serveIPC(conn, daemon, &peer)
+}
+
+// serveIPC answers the requests on a control connection from peer
+func serveIPC(conn net.Conn, daemon *Daemon, peer *ipcPeer) {
 	defer conn.Close()
+	peer.key = connPeer(conn)
 
 	decoder := json.NewDecoder(conn)
 	encoder := json.NewEncoder(conn)
 
+	// Don't let idle clients hold connections open
+	conn.SetReadDeadline(time.Now().Add(ipcReadTimeout))
 	var req IPCRequest
-	if err := decoder.Decode(&req); err != nil {
+	err := decoder.Decode(&req)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
 		response := IPCResponse{Success: false, Message: "Invalid request format"}
 		if err := encoder.Encode(response); err != nil {
 			slog.Error("Failed to encode IPC response", "error", err)
@@ -43,6 +121,54 @@ func handleIPCRequest(conn net.Conn, daemon *Daemon) {
 		return
 	}
 
+	// Clients that number their requests get the multiplexed protocol
+	if req.ID != 0 {
+		daemon.serveMux(conn, decoder, req, peer)
+		return
+	}
+
+	if err := peer.authorize(conn, req); err != nil {
+		conn.SetWriteDeadline(time.Now().Add(attachWriteTimeout))
+		encoder.Encode(IPCResponse{Success: false, Message: err.Error()})
+		return
+	}
+
+	switch req.Command {
+	case "attach":
+		// Attach takes over the connection for raw terminal traffic
+		daemon.attachService(conn, encoder, req)
+	case "tail":
+		// Tail takes over the connection to stream log lines
+		daemon.tailLogs(conn, encoder, req)
+	default:
+		ctx, cancel := daemon.requestContext(req)
+		response := handleCommand(ctx, daemon, req)
+		cancel()
+		conn.SetWriteDeadline(time.Now().Add(attachWriteTimeout))
+		if err := encoder.Encode(response); err != nil {
+			slog.Error("Failed to encode IPC response", "error", err)
+		}
+	}
+}
+
+// requestContext bounds how long the daemon works on a request with a single
+// response: the client's own timeout, if it sent one, capped at
+// ipcRequestTimeout
+func (d *Daemon) requestContext(req IPCRequest) (context.Context, context.CancelFunc) {
+	timeout := ipcRequestTimeout
+	if req.Timeout > 0 {
+		timeout = min(timeout, req.Timeout)
+	}
+	return context.WithTimeout(d.ctx, timeout)
+}
+
+// handleCommand answers a request that has a single response, giving up on
+// anything that would block once ctx is done
+func handleCommand(ctx context.Context, daemon *Daemon, req IPCRequest) IPCResponse {
+	if isBulkRequest(req) {
+		return handleBulkCommand(ctx, daemon, req)
+	}
+
 	var response IPCResponse
 
 	switch req.Command {
@@ -51,6 +177,12 @@ func handleIPCRequest(conn net.Conn, daemon *Daemon) {
 			Success:  true,
 			Services: daemon.getAllServiceStatus(),
 		}
+		if req.Usage {
+			for name, status := range response.Services {
+				usage := daemon.serviceUsage(name)
+				status.Usage = &usage
+			}
+		}
 	case "status":
 		if req.Service == "" {
 			response = IPCResponse{
@@ -59,10 +191,12 @@ func handleIPCRequest(conn net.Conn, daemon *Daemon) {
 			}
 		} else {
 			if status, exists := daemon.getServiceStatus(req.Service); exists {
+				daemon.mu.RLock()
 				response = IPCResponse{
 					Success: true,
-					Service: status,
+					Service: status.snapshot(),
 				}
+				daemon.mu.RUnlock()
 			} else {
 				response = IPCResponse{
 					Success: false,
@@ -73,18 +207,21 @@ func handleIPCRequest(conn net.Conn, daemon *Daemon) {
 	case "restart":
 		if req.Service == "" {
 			response = IPCResponse{Success: false, Message: "Service name required"}
-		} else if svc, exists := daemon.config.Services[req.Service]; exists {
-			// Send restart request
-			select {
-			case daemon.restartChan <- svc:
+		} else if daemon.shuttingDown() {
+			response = IPCResponse{Success: false, Message: "Daemon is shutting down"}
+		} else if len(req.Reason) > maxRestartNote {
+			response = IPCResponse{Success: false, Message: fmt.Sprintf("Reason is longer than %d characters", maxRestartNote)}
+		} else if svc, exists := daemon.getConfig().Services[req.Service]; exists {
+			getLogger("audit").Info("Operator requested restart", "service", req.Service, "reason", req.Reason)
+			if daemon.requestRestart(restartRequest{svc: svc, reason: RestartReasonOperator, detail: req.Reason}) {
 				response = IPCResponse{
 					Success: true,
 					Message: fmt.Sprintf("Restart requested for service '%s'", req.Service),
 				}
-			default:
+			} else {
 				response = IPCResponse{
-					Success: false,
-					Message: "Restart channel is full, try again later",
+					Success: true,
+					Message: fmt.Sprintf("Restart already queued for service '%s'", req.Service),
 				}
 			}
 		} else {
@@ -93,33 +230,46 @@ func handleIPCRequest(conn net.Conn, daemon *Daemon) {
 				Message: fmt.Sprintf("Service '%s' not found", req.Service),
 			}
 		}
+	case "stop", "kill":
+		mode, verb := stopGraceful, "Stopped"
+		if req.Command == "kill" {
+			mode, verb = stopKill, "Killed"
+		} else if req.Force {
+			mode, verb = stopForce, "Force stopped"
+		}
+		if req.Service == "" {
+			response = IPCResponse{Success: false, Message: "Service name required"}
+		} else if err := daemon.requestStop(ctx, req.Service, mode); err != nil {
+			response = IPCResponse{Success: false, Message: err.Error()}
+		} else {
+			response = IPCResponse{
+				Success: true,
+				Message: fmt.Sprintf("%s service '%s'", verb, req.Service),
+			}
+		}
+	case "start":
+		if req.Service == "" {
+			response = IPCResponse{Success: false, Message: "Service name required"}
+		} else if err := daemon.requestStart(ctx, req.Service); err != nil {
+			response = IPCResponse{Success: false, Message: err.Error()}
+		} else {
+			response = IPCResponse{
+				Success: true,
+				Message: fmt.Sprintf("Started service '%s'", req.Service),
+			}
+		}
 	case "signal":
 		if req.Service == "" || req.Signal == "" {
 			response = IPCResponse{Success: false, Message: "Service name and signal required"}
 		} else if cmd, exists := daemon.getServiceCmd(req.Service); exists && cmd.Process != nil {
 			// Parse signal
-			var sig syscall.Signal
-			var validSignal bool = true
-			switch req.Signal {
-			case "HUP", "SIGHUP":
-				sig = syscall.SIGHUP
-			case "TERM", "SIGTERM":
-				sig = syscall.SIGTERM
-			case "KILL", "SIGKILL":
-				sig = syscall.SIGKILL
-			case "USR1", "SIGUSR1":
-				sig = syscall.SIGUSR1
-			case "USR2", "SIGUSR2":
-				sig = syscall.SIGUSR2
-			default:
+			sig, parseErr := parseSignal(req.Signal)
+			if parseErr != nil {
 				response = IPCResponse{
 					Success: false,
-					Message: fmt.Sprintf("Unsupported signal: %s", req.Signal),
+					Message: fmt.Sprintf("Invalid signal: %v", parseErr),
 				}
-				validSignal = false
-			}
-
-			if validSignal {
+			} else {
 				// Elevate privileges to send signal to process running as different user
 				if err := elevatePrivileges(); err != nil {
 					response = IPCResponse{
@@ -127,8 +277,9 @@ func handleIPCRequest(conn net.Conn, daemon *Daemon) {
 						Message: fmt.Sprintf("Failed to elevate privileges for signal: %v", err),
 					}
 				} else {
-					// Send the signal
-					signalErr := cmd.Process.Signal(sig)
+					// Send the signal, to the whole process group if requested
+					group := req.Group || daemon.getConfig().Services[req.Service].SignalGroup
+					signalErr := signalProcess(cmd.Process.Pid, sig, group)
 
 					// Drop privileges back down
 					if dropErr := dropPrivileges(daemon.appUser, daemon.appGroup); dropErr != nil {
@@ -154,22 +305,84 @@ func handleIPCRequest(conn net.Conn, daemon *Daemon) {
 				Message: fmt.Sprintf("Service '%s' not running", req.Service),
 			}
 		}
+	case "boot-analyze":
+		if daemon.boot == nil {
+			response = IPCResponse{Success: false, Message: "Boot timeline not recorded yet"}
+		} else {
+			response = IPCResponse{
+				Success: true,
+				Boot:    daemon.boot.snapshot(),
+			}
+		}
+	case "logs":
+		if _, exists := daemon.getConfig().Services[req.Service]; !exists {
+			response = IPCResponse{
+				Success: false,
+				Message: fmt.Sprintf("Service '%s' not found", req.Service),
+			}
+		} else {
+			response = IPCResponse{
+				Success: true,
+				Logs:    daemon.serviceLogs(req.Service).last(req.Limit),
+			}
+		}
+	case "reload":
+		if summary, err := daemon.requestReload(ctx, req.DryRun); err != nil {
+			response = IPCResponse{Success: false, Message: err.Error()}
+		} else {
+			response = IPCResponse{Success: true, Reload: summary}
+		}
+	case "events":
+		response = IPCResponse{
+			Success: true,
+			Events:  daemon.events.list(req.Service, req.Limit),
+		}
+	case "definitions":
+		response = IPCResponse{
+			Success:     true,
+			Definitions: daemon.getConfig().Services,
+			ConfigPath:  daemon.absConfigPath(),
+		}
+	case "metrics":
+		response = IPCResponse{Success: true, Metrics: daemon.selfMetrics()}
+	case "debug-dump":
+		metrics, stacks := daemon.debugDump()
+		response = IPCResponse{Success: true, Metrics: metrics, Message: stacks}
+	case "snapshot":
+		response = IPCResponse{
+			Success:    true,
+			Config:     daemon.getConfig(),
+			Services:   daemon.getAllServiceStatus(),
+			ConfigPath: daemon.absConfigPath(),
+		}
+	case "version":
+		info := buildVersion()
+		response = IPCResponse{Success: true, Version: &info}
+	case "run":
+		response = IPCResponse{Success: false, Message: "run streams the service's output and needs a multiplexed connection"}
 	default:
 		response = IPCResponse{
 			Success: false,
 			Message: fmt.Sprintf("Unknown command: %s", req.Command),
 		}
 	}
+	return response
+}
 
-	if err := encoder.Encode(response); err != nil {
-		slog.Error("Failed to encode IPC response", "error", err)
+// absConfigPath is the daemon's config file path for clients, which may be
+// in another directory
+func (d *Daemon) absConfigPath() string {
+	if isRemoteConfig(d.configPath) {
+		return d.configPath
+	}
+	if abs, err := filepath.Abs(d.configPath); err == nil && d.configPath != "" {
+		return abs
 	}
+	return d.configPath
 }
 
 func startIPCServer(daemon *Daemon) {
-	// Remove existing socket if it exists
-	os.Remove(SocketPath)
-
+	// Start has already made sure no other daemon uses the socket
 	listener, err := net.Listen("unix", SocketPath)
 	if err != nil {
 		slog.Error("Failed to create IPC socket", "error", err)
@@ -180,34 +393,55 @@ func startIPCServer(daemon *Daemon) {
 
 	go func() {
 		defer listener.Close()
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				slog.Error("IPC accept error", "error", err)
-				continue
-			}
-			go handleIPCRequest(conn, daemon)
-		}
+		daemon.ipcLimiter.serve(daemon.ctx, listener, "local", func(conn net.Conn) {
+			handleIPCRequest(conn, daemon)
+		})
 	}()
 }
 
-func sendIPCRequest(req IPCRequest) (*IPCResponse, error) {
-	conn, err := net.Dial("unix", SocketPath)
+// dialDaemon connects to the daemon's IPC socket, or to a remote daemon
+// through SSH if --host is set
+func dialDaemon() (net.Conn, error) {
+	if remoteHost != "" {
+		return dialRemote()
+	}
+	conn, err := net.DialTimeout("unix", SocketPath, ipcTimeout)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to pei daemon: %v", err)
 	}
+	return conn, nil
+}
+
+// timeoutError explains a request that hit ipcTimeout
+func timeoutError(err error) error {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("daemon did not answer within %s (see --timeout)", ipcTimeout)
+	}
+	return err
+}
+
+func sendIPCRequest(req IPCRequest) (*IPCResponse, error) {
+	conn, err := dialDaemon()
+	if err != nil {
+		return nil, err
+	}
 	defer conn.Close()
 
+	if ipcTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(ipcTimeout))
+		req.Timeout = ipcTimeout
+	}
+	req.Token = apiToken
 	encoder := json.NewEncoder(conn)
 	decoder := json.NewDecoder(conn)
 
 	if err := encoder.Encode(req); err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
+		return nil, fmt.Errorf("failed to send request: %v", timeoutError(err))
 	}
 
 	var response IPCResponse
 	if err := decoder.Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %v", err)
+		return nil, fmt.Errorf("failed to decode response: %v", timeoutError(err))
 	}
 
 	return &response, nil
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultIPCMaxConnections    = 64
+	defaultIPCRequestsPerSecond = 20
+	defaultIPCBurst             = 40
+
+	// maxAcceptBackoff caps how long an accept loop waits after an error,
+	// such as running out of file descriptors
+	maxAcceptBackoff = time.Second
+
+	// maxTrackedPeers bounds the rate limiter's memory, dropping idle peers
+	// once exceeded
+	maxTrackedPeers = 1024
+)
+
+// IPCLimits bounds how much of the daemon control clients can use. They
+// apply to the local socket and to each API listener separately.
+type IPCLimits struct {
+	MaxConnections    int     `yaml:"max_connections"`
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+func (l *IPCLimits) maxConnections() int {
+	if l != nil && l.MaxConnections > 0 {
+		return l.MaxConnections
+	}
+	return defaultIPCMaxConnections
+}
+
+func (l *IPCLimits) requestsPerSecond() float64 {
+	if l != nil && l.RequestsPerSecond > 0 {
+		return l.RequestsPerSecond
+	}
+	return defaultIPCRequestsPerSecond
+}
+
+func (l *IPCLimits) burst() int {
+	if l != nil && l.Burst > 0 {
+		return l.Burst
+	}
+	return defaultIPCBurst
+}
+
+// validateIPCLimits checks the ipc settings
+func (c *Config) validateIPCLimits() error {
+	if c.IPC == nil {
+		return nil
+	}
+	if c.IPC.MaxConnections < 0 || c.IPC.RequestsPerSecond < 0 || c.IPC.Burst < 0 {
+		return fieldErrorf([]string{"ipc"}, "max_connections, requests_per_second and burst must not be negative")
+	}
+	return nil
+}
+
+// ipcLimiter enforces IPCLimits on one listener: a fixed number of open
+// connections, and a token bucket per peer for requests
+type ipcLimiter struct {
+	slots chan struct{}
+	rate  float64
+	burst float64
+
+	mu    sync.Mutex
+	peers map[string]*tokenBucket
+
+	// Counters for metrics
+	requests    atomic.Uint64
+	rateLimited atomic.Uint64
+	refused     atomic.Uint64
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newIPCLimiter(limits *IPCLimits) *ipcLimiter {
+	return &ipcLimiter{
+		slots: make(chan struct{}, limits.maxConnections()),
+		rate:  limits.requestsPerSecond(),
+		burst: float64(limits.burst()),
+		peers: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a token from a peer's bucket, reporting false if it has none
+// left
+func (l *ipcLimiter) allow(peer string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.requests.Add(1)
+
+	now := time.Now()
+	bucket, exists := l.peers[peer]
+	if !exists {
+		if len(l.peers) >= maxTrackedPeers {
+			l.forgetIdlePeers(now)
+		}
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.peers[peer] = bucket
+	}
+	bucket.tokens = min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		l.rateLimited.Add(1)
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// forgetIdlePeers drops peers whose buckets have refilled, which behave the
+// same as new ones. Must be called with l.mu held.
+func (l *ipcLimiter) forgetIdlePeers(now time.Time) {
+	for peer, bucket := range l.peers {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.peers, peer)
+		}
+	}
+}
+
+// serve accepts connections on listener until ctx is done, handing each to
+// handle while a connection slot is free and turning the rest away
+func (l *ipcLimiter) serve(ctx context.Context, listener net.Listener, name string, handle func(net.Conn)) {
+	var backoff time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Don't spin on errors like running out of file descriptors
+			backoff = min(max(2*backoff, 5*time.Millisecond), maxAcceptBackoff)
+			slog.Error("IPC accept error", "listener", name, "error", err, "retry_in", backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		backoff = 0
+
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			l.refused.Add(1)
+			slog.Warn("Refusing IPC connection, too many open", "listener", name, "max_connections", cap(l.slots))
+			refuseConn(conn, fmt.Sprintf("too many connections (max %d), try again later", cap(l.slots)))
+			continue
+		}
+		go func() {
+			defer func() { <-l.slots }()
+			handle(conn)
+		}()
+	}
+}
+
+// metrics reports the listener's connections and request counts
+func (l *ipcLimiter) metrics(name string) ListenerMetrics {
+	return ListenerMetrics{
+		Name:               name,
+		Connections:        len(l.slots),
+		MaxConnections:     cap(l.slots),
+		Requests:           l.requests.Load(),
+		RateLimited:        l.rateLimited.Load(),
+		RefusedConnections: l.refused.Load(),
+	}
+}
+
+// refuseConn answers a connection with an error instead of serving it
+func refuseConn(conn net.Conn, message string) {
+	conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	json.NewEncoder(conn).Encode(IPCResponse{Success: false, Message: message})
+	conn.Close()
+}
+
+// connPeer names who is on the other end of a connection for rate limiting:
+// the user for unix sockets, where the platform says, and the address for
+// network connections
+func connPeer(conn net.Conn) string {
+	if unixConn, ok := conn.(*net.UnixConn); ok {
+		if uid, ok := peerUID(unixConn); ok {
+			return fmt.Sprintf("uid %d", uid)
+		}
+		return "local"
+	}
+	address := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIPCLimiterRate(t *testing.T) {
+	limiter := newIPCLimiter(&IPCLimits{RequestsPerSecond: 0.001, Burst: 2})
+	if !limiter.allow("a") || !limiter.allow("a") {
+		t.Fatal("expected the burst to be allowed")
+	}
+	if limiter.allow("a") {
+		t.Error("expected a third request to be refused")
+	}
+	if !limiter.allow("b") {
+		t.Error("expected another peer to have its own bucket")
+	}
+}
+
+func TestIPCRateLimitedRequests(t *testing.T) {
+	d := NewDaemon(&Config{IPC: &IPCLimits{RequestsPerSecond: 0.001, Burst: 1}}, "", "", "")
+	defer d.cancel()
+
+	request := func() IPCResponse {
+		server, conn := net.Pipe()
+		defer conn.Close()
+		go handleIPCRequest(server, d)
+		json.NewEncoder(conn).Encode(IPCRequest{Command: "list"})
+		var response IPCResponse
+		json.NewDecoder(conn).Decode(&response)
+		return response
+	}
+	if response := request(); !response.Success {
+		t.Fatalf("expected the first request to be answered, got %+v", response)
+	}
+	if response := request(); response.Success || !strings.Contains(response.Message, "rate limit exceeded") {
+		t.Errorf("expected the second request to be rate limited, got %+v", response)
+	}
+}
+
+func TestIPCConnectionLimit(t *testing.T) {
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "pei.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer listener.Close()
+
+	held := make(chan struct{})
+	limiter := newIPCLimiter(&IPCLimits{MaxConnections: 1})
+	go limiter.serve(ctx, listener, "test", func(conn net.Conn) {
+		<-held
+		conn.Close()
+	})
+
+	first, err := net.Dial("unix", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := net.Dial("unix", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	var response IPCResponse
+	if err := json.NewDecoder(second).Decode(&response); err != nil || !strings.Contains(response.Message, "too many connections") {
+		t.Errorf("expected the second connection to be refused, got %+v, %v", response, err)
+	}
+	close(held)
+}
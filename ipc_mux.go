@@ -0,0 +1,503 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// eventStreamBuffer is how many events a slow events -f client may fall
+// behind before events are dropped for it
+const eventStreamBuffer = 256
+
+// Frame types on a multiplexed IPC connection
+const (
+	FrameResponse = "response" // the only answer to a request
+	FrameStream   = "stream"   // the answer to a request that streams; data frames and an end frame follow
+	FrameData     = "data"     // one item of a stream, such as a log line
+	FrameEnd      = "end"      // the stream is over
+)
+
+// IPCFrame is a message from the daemon on a multiplexed connection.
+//
+// A client switches a connection to multiplexed framing by giving its
+// requests an ID. It can then send further requests without waiting for
+// answers, and the daemon answers each with frames carrying its ID,
+// interleaved with the frames of other requests: either a single response
+// frame, or a stream frame followed by data frames and an end frame.
+// Streams run until they finish or the client sends a "cancel" request with
+// the stream's ID; "input" requests feed terminal input to an attach stream.
+type IPCFrame struct {
+	ID       uint64          `json:"id"`
+	Type     string          `json:"type"`
+	Response *IPCResponse    `json:"response,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+// muxConn is the daemon's side of a multiplexed IPC connection
+type muxConn struct {
+	d    *Daemon
+	peer *ipcPeer
+	conn net.Conn
+
+	writeMu sync.Mutex
+	encoder *json.Encoder
+
+	mu      sync.Mutex
+	streams map[uint64]*muxStream
+}
+
+// muxStream is a stream in progress on a multiplexed connection
+type muxStream struct {
+	cancel context.CancelFunc
+	input  io.Writer
+}
+
+// serveMux answers requests on a multiplexed connection, starting with
+// first, until the client closes it. Each request is handled concurrently.
+func (d *Daemon) serveMux(conn net.Conn, decoder *json.Decoder, first IPCRequest, peer *ipcPeer) {
+	m := &muxConn{
+		d:       d,
+		peer:    peer,
+		conn:    conn,
+		encoder: json.NewEncoder(conn),
+		streams: make(map[uint64]*muxStream),
+	}
+	ctx, cancel := context.WithCancel(d.ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	req := first
+	for {
+		m.dispatch(ctx, &wg, req)
+		req = IPCRequest{}
+		if err := decoder.Decode(&req); err != nil {
+			return
+		}
+	}
+}
+
+// dispatch starts answering a request, or applies a control request to the
+// stream it names
+func (m *muxConn) dispatch(ctx context.Context, wg *sync.WaitGroup, req IPCRequest) {
+	if err := m.peer.authorize(m.conn, req); err != nil {
+		m.respond(req.ID, IPCResponse{Success: false, Message: err.Error()})
+		return
+	}
+
+	switch req.Command {
+	case "cancel":
+		if s := m.stream(req.ID); s != nil {
+			s.cancel()
+		}
+		return
+	case "input":
+		if s := m.stream(req.ID); s != nil && s.input != nil {
+			s.input.Write(req.Data)
+		}
+		return
+	}
+
+	if req.ID == 0 {
+		m.respond(0, IPCResponse{Success: false, Message: "Request ID required"})
+		return
+	}
+
+	var serve func(context.Context, IPCRequest) bool
+	switch {
+	case req.Command == "tail":
+		serve = m.streamTail
+	case req.Command == "attach":
+		serve = m.streamAttach
+	case req.Command == "run":
+		serve = m.streamRun
+	case req.Command == "events" && req.Follow:
+		serve = m.streamEvents
+	default:
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reqCtx, cancel := m.d.requestContext(req)
+			defer cancel()
+			m.respond(req.ID, handleCommand(reqCtx, m.d, req))
+		}()
+		return
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	m.mu.Lock()
+	if _, exists := m.streams[req.ID]; exists {
+		m.mu.Unlock()
+		cancel()
+		m.respond(req.ID, IPCResponse{Success: false, Message: fmt.Sprintf("Request ID %d already in use", req.ID)})
+		return
+	}
+	m.streams[req.ID] = &muxStream{cancel: cancel}
+	m.mu.Unlock()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			cancel()
+			m.mu.Lock()
+			delete(m.streams, req.ID)
+			m.mu.Unlock()
+		}()
+		if serve(streamCtx, req) {
+			m.send(IPCFrame{ID: req.ID, Type: FrameEnd})
+		}
+	}()
+}
+
+// stream returns the stream in progress with an ID, if any
+func (m *muxConn) stream(id uint64) *muxStream {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.streams[id]
+}
+
+// setInput directs input requests for a stream to w
+func (m *muxConn) setInput(id uint64, w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, exists := m.streams[id]; exists {
+		s.input = w
+	}
+}
+
+// send writes a frame. A client that stops reading would hold up every
+// request on the connection, so a failed write closes it.
+func (m *muxConn) send(frame IPCFrame) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	m.conn.SetWriteDeadline(time.Now().Add(attachWriteTimeout))
+	if err := m.encoder.Encode(frame); err != nil {
+		m.conn.Close()
+		return err
+	}
+	return nil
+}
+
+func (m *muxConn) respond(id uint64, response IPCResponse) error {
+	return m.send(IPCFrame{ID: id, Type: FrameResponse, Response: &response})
+}
+
+func (m *muxConn) open(id uint64, response IPCResponse) error {
+	return m.send(IPCFrame{ID: id, Type: FrameStream, Response: &response})
+}
+
+func (m *muxConn) sendData(id uint64, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return m.send(IPCFrame{ID: id, Type: FrameData, Data: data})
+}
+
+// The stream handlers below answer a request and report whether they opened
+// a stream, which then needs an end frame
+
+// streamTail streams log lines, like tailLogs
+func (m *muxConn) streamTail(ctx context.Context, req IPCRequest) bool {
+	t, err := m.d.openTail(req)
+	if err != nil {
+		m.respond(req.ID, IPCResponse{Success: false, Message: err.Error()})
+		return false
+	}
+	defer t.close()
+
+	if err := m.open(req.ID, IPCResponse{Success: true}); err != nil {
+		return false
+	}
+	t.stream(ctx, func(line LogLine) error {
+		return m.sendData(req.ID, line)
+	})
+	return true
+}
+
+// streamEvents answers with recent events, then streams new ones as they
+// are recorded
+func (m *muxConn) streamEvents(ctx context.Context, req IPCRequest) bool {
+	live := make(chan Event, eventStreamBuffer)
+	unsubscribe := m.d.events.subscribe(func(event Event) {
+		if req.Service != "" && event.Service != req.Service {
+			return
+		}
+		select {
+		case live <- event:
+		default:
+		}
+	})
+	defer unsubscribe()
+
+	history := m.d.events.list(req.Service, req.Limit)
+	var seen time.Time
+	if len(history) > 0 {
+		seen = history[len(history)-1].Time
+	}
+	if err := m.open(req.ID, IPCResponse{Success: true, Events: history}); err != nil {
+		return false
+	}
+
+	for {
+		select {
+		case event := <-live:
+			if !event.Time.After(seen) {
+				continue
+			}
+			if err := m.sendData(req.ID, event); err != nil {
+				return true
+			}
+		case <-ctx.Done():
+			return true
+		}
+	}
+}
+
+// streamAttach streams a service's raw output, like attachService, taking
+// terminal input from input requests
+func (m *muxConn) streamAttach(ctx context.Context, req IPCRequest) bool {
+	capture, exists := m.d.getServiceOutput(req.Service)
+	if !exists {
+		m.respond(req.ID, IPCResponse{Success: false, Message: fmt.Sprintf("Service '%s' not running", req.Service)})
+		return false
+	}
+
+	message := prepareAttach(capture, req)
+	if capture.input == nil {
+		m.setInput(req.ID, io.Discard)
+	} else {
+		m.setInput(req.ID, capture.input)
+	}
+	if err := m.open(req.ID, IPCResponse{Success: true, Message: message}); err != nil {
+		return false
+	}
+
+	logServiceInfo(req.Service, "Client attached")
+	detach := capture.Attach(muxWriter{m: m, id: req.ID})
+	defer detach()
+
+	select {
+	case <-ctx.Done():
+	case <-capture.Done():
+	}
+	logServiceInfo(req.Service, "Client detached")
+	return true
+}
+
+// muxWriter sends whatever is written to it as data frames of a stream
+type muxWriter struct {
+	m  *muxConn
+	id uint64
+}
+
+func (w muxWriter) Write(p []byte) (int, error) {
+	if err := w.m.sendData(w.id, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ipcClient is a multiplexed connection to the daemon, on which several
+// requests and streams can be in flight at once
+type ipcClient struct {
+	conn net.Conn
+
+	writeMu sync.Mutex
+	encoder *json.Encoder
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan IPCFrame
+	err     error
+}
+
+// dialIPCClient opens a multiplexed connection to the daemon
+func dialIPCClient() (*ipcClient, error) {
+	conn, err := dialDaemon()
+	if err != nil {
+		return nil, err
+	}
+	return newIPCClient(conn), nil
+}
+
+func newIPCClient(conn net.Conn) *ipcClient {
+	c := &ipcClient{
+		conn:    conn,
+		encoder: json.NewEncoder(conn),
+		pending: make(map[uint64]chan IPCFrame),
+	}
+	go c.readFrames()
+	return c
+}
+
+func (c *ipcClient) Close() error {
+	return c.conn.Close()
+}
+
+// readFrames hands each frame to the request it answers until the
+// connection is closed, then closes the channels of requests still pending
+func (c *ipcClient) readFrames() {
+	decoder := json.NewDecoder(c.conn)
+	for {
+		var frame IPCFrame
+		if err := decoder.Decode(&frame); err != nil {
+			c.mu.Lock()
+			c.err = fmt.Errorf("connection to daemon lost: %v", err)
+			for id, frames := range c.pending {
+				close(frames)
+				delete(c.pending, id)
+			}
+			c.mu.Unlock()
+			return
+		}
+
+		last := frame.Type == FrameResponse || frame.Type == FrameEnd
+		c.mu.Lock()
+		frames := c.pending[frame.ID]
+		if last {
+			delete(c.pending, frame.ID)
+		}
+		c.mu.Unlock()
+		if frames == nil {
+			continue
+		}
+		frames <- frame
+		if last {
+			close(frames)
+		}
+	}
+}
+
+// failure is why the frames of a request stopped arriving early
+func (c *ipcClient) failure() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return errors.New("connection to daemon lost")
+}
+
+func (c *ipcClient) write(req IPCRequest) error {
+	req.Token = apiToken
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.encoder.Encode(req)
+}
+
+// send sends a request under a new ID and returns the channel its frames
+// arrive on, which is closed after the last one
+func (c *ipcClient) send(req IPCRequest) (uint64, <-chan IPCFrame, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return 0, nil, c.err
+	}
+	c.nextID++
+	req.ID = c.nextID
+	frames := make(chan IPCFrame, 16)
+	c.pending[req.ID] = frames
+	c.mu.Unlock()
+
+	if err := c.write(req); err != nil {
+		c.mu.Lock()
+		delete(c.pending, req.ID)
+		c.mu.Unlock()
+		return 0, nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	return req.ID, frames, nil
+}
+
+// request sends a request and waits for its response
+func (c *ipcClient) request(req IPCRequest) (*IPCResponse, error) {
+	response, stream, err := c.openStream(req)
+	if stream != nil {
+		stream.close()
+	}
+	return response, err
+}
+
+// openStream sends a request that may stream. It returns the daemon's
+// response, and the stream if the daemon opened one. Only the response is
+// subject to ipcTimeout; a stream lasts until it ends.
+func (c *ipcClient) openStream(req IPCRequest) (*IPCResponse, *ipcStream, error) {
+	var timeout <-chan time.Time
+	if ipcTimeout > 0 {
+		req.Timeout = ipcTimeout
+		timer := time.NewTimer(ipcTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	id, frames, err := c.send(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var frame IPCFrame
+	var ok bool
+	select {
+	case frame, ok = <-frames:
+	case <-timeout:
+		// Whatever arrives late is discarded
+		c.write(IPCRequest{Command: "cancel", ID: id})
+		go func() {
+			for range frames {
+			}
+		}()
+		return nil, nil, timeoutError(os.ErrDeadlineExceeded)
+	}
+	if !ok {
+		return nil, nil, c.failure()
+	}
+	if frame.Response == nil {
+		return nil, nil, fmt.Errorf("unexpected %s frame from daemon", frame.Type)
+	}
+	if frame.Type != FrameStream {
+		return frame.Response, nil, nil
+	}
+	return frame.Response, &ipcStream{c: c, id: id, frames: frames}, nil
+}
+
+// ipcStream is a stream opened on an ipcClient
+type ipcStream struct {
+	c      *ipcClient
+	id     uint64
+	frames <-chan IPCFrame
+}
+
+// next decodes the next item of the stream into v, returning io.EOF once
+// the stream has ended
+func (s *ipcStream) next(v any) error {
+	frame, ok := <-s.frames
+	if !ok {
+		return s.c.failure()
+	}
+	if frame.Type == FrameEnd {
+		return io.EOF
+	}
+	return json.Unmarshal(frame.Data, v)
+}
+
+// input sends terminal input to an attach stream
+func (s *ipcStream) input(data []byte) error {
+	return s.c.write(IPCRequest{Command: "input", ID: s.id, Data: data})
+}
+
+// close asks the daemon to end the stream, discarding anything it sends
+// until it does
+func (s *ipcStream) close() {
+	s.c.write(IPCRequest{Command: "cancel", ID: s.id})
+	go func() {
+		for range s.frames {
+		}
+	}()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultiplexedIPC(t *testing.T) {
+	config := &Config{Services: map[string]Service{"web": {Name: "web"}}}
+	d := NewDaemon(config, "", "", "")
+	defer d.cancel()
+
+	server, conn := net.Pipe()
+	go handleIPCRequest(server, d)
+	client := newIPCClient(conn)
+	defer client.Close()
+
+	d.serviceLogs("web").add("stdout", "INFO", "before")
+
+	response, tail, err := client.openStream(IPCRequest{Command: "tail", Follow: true})
+	if err != nil || !response.Success || tail == nil {
+		t.Fatalf("expected a tail stream, got %+v, %v", response, err)
+	}
+	var line LogLine
+	if err := tail.next(&line); err != nil || line.Text != "before" || line.Service != "web" {
+		t.Fatalf("expected history line, got %+v, %v", line, err)
+	}
+
+	events, eventStream, err := client.openStream(IPCRequest{Command: "events", Follow: true})
+	if err != nil || !events.Success || eventStream == nil {
+		t.Fatalf("expected an events stream, got %+v, %v", events, err)
+	}
+
+	// One-shot requests are answered while streams are open
+	list, err := client.request(IPCRequest{Command: "list"})
+	if err != nil || !list.Success {
+		t.Fatalf("expected list to succeed alongside streams, got %+v, %v", list, err)
+	}
+	missing, err := client.request(IPCRequest{Command: "tail", Services: []string{"db"}})
+	if err != nil || missing.Success {
+		t.Fatalf("expected tail of an unknown service to fail, got %+v, %v", missing, err)
+	}
+
+	d.serviceLogs("web").add("stdout", "INFO", "after")
+	if err := tail.next(&line); err != nil || line.Text != "after" {
+		t.Fatalf("expected live line, got %+v, %v", line, err)
+	}
+
+	d.events.record(EventRestart, "web", "Restarting", nil)
+	var event Event
+	if err := eventStream.next(&event); err != nil || event.Type != EventRestart {
+		t.Fatalf("expected live event, got %+v, %v", event, err)
+	}
+
+	// Cancelling one stream ends it and leaves the other open
+	if err := client.write(IPCRequest{Command: "cancel", ID: tail.id}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tail.next(&line); err != io.EOF {
+		t.Fatalf("expected cancelled stream to end, got %+v, %v", line, err)
+	}
+	d.serviceLogs("web").add("stdout", "INFO", "ignored")
+	d.events.record(EventRestart, "web", "Restarting again", nil)
+	if err := eventStream.next(&event); err != nil || event.Message != "Restarting again" {
+		t.Fatalf("expected events stream to stay open, got %+v, %v", event, err)
+	}
+}
+
+func TestLegacyIPC(t *testing.T) {
+	d := NewDaemon(&Config{}, "", "", "")
+	defer d.cancel()
+
+	server, conn := net.Pipe()
+	go handleIPCRequest(server, d)
+	defer conn.Close()
+
+	// Requests without an ID keep the one-shot protocol
+	if err := json.NewEncoder(conn).Encode(IPCRequest{Command: "list"}); err != nil {
+		t.Fatal(err)
+	}
+	var response IPCResponse
+	if err := json.NewDecoder(conn).Decode(&response); err != nil || !response.Success {
+		t.Fatalf("expected a bare response, got %+v, %v", response, err)
+	}
+}
+
+func TestIPCClientTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { ipcTimeout = timeout }(ipcTimeout)
+	ipcTimeout = 50 * time.Millisecond
+
+	// A daemon that reads requests but never answers
+	server, conn := net.Pipe()
+	go io.Copy(io.Discard, server)
+	client := newIPCClient(conn)
+	defer client.Close()
+
+	_, err := client.request(IPCRequest{Command: "list"})
+	if err == nil || !strings.Contains(err.Error(), "did not answer within 50ms") {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+}
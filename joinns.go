@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// JoinNamespaces makes a service join the namespaces of another service or
+// process when it starts, such as a sidecar sharing an app's network
+type JoinNamespaces struct {
+	Service string   `yaml:"service"`
+	PID     int      `yaml:"pid"`
+	Types   []string `yaml:"types"`
+}
+
+// joinableNamespaces are the namespace types a service can join. Joining a
+// mount, user or PID namespace needs a single-threaded process, which pei's
+// Go helper isn't.
+var joinableNamespaces = []string{"net", "ipc", "uts", "cgroup"}
+
+// validateJoinNamespaces checks join_ns
+func (c *Config) validateJoinNamespaces() error {
+	for name, svc := range c.Services {
+		join := svc.JoinNamespaces
+		if join == nil {
+			continue
+		}
+		path := []string{"services", name, "join_ns"}
+		if (join.Service == "") == (join.PID == 0) {
+			return fieldErrorf(path, "needs exactly one of service or pid")
+		}
+		if join.Service != "" {
+			target, exists := c.Services[join.Service]
+			if !exists {
+				return fieldErrorf(append(path, "service"), "unknown service %q", join.Service)
+			}
+			if join.Service == name {
+				return fieldErrorf(append(path, "service"), "can't join its own namespaces")
+			}
+			if target.OnDemand || target.Spawn != "" || target.Oneshot {
+				return fieldErrorf(append(path, "service"), "must be a long-running service")
+			}
+		}
+		if join.PID < 0 {
+			return fieldErrorf(append(path, "pid"), "must be positive")
+		}
+		if len(join.Types) == 0 {
+			return fieldErrorf(append(path, "types"), "is required")
+		}
+		for i, kind := range join.Types {
+			if !slices.Contains(joinableNamespaces, kind) {
+				return fieldErrorf(append(path, "types", listIndex(i)), "must be one of net, ipc, uts or cgroup")
+			}
+		}
+		if svc.UserNamespace != nil {
+			return serviceErrorf(name, "join_ns", "can't be combined with user_namespace")
+		}
+	}
+	return nil
+}
+
+// joinNamespacePaths finds the namespace files a service joins, of its
+// target service's current instance or its target PID
+func (d *Daemon) joinNamespacePaths(svc Service) ([]string, error) {
+	join := svc.JoinNamespaces
+	if join == nil {
+		return nil, nil
+	}
+	pid := join.PID
+	if join.Service != "" {
+		proc, exists := d.getServiceProcess(join.Service)
+		if !exists || !proc.running() {
+			return nil, fmt.Errorf("join_ns: service %s is not running", join.Service)
+		}
+		pid = proc.cmd.Process.Pid
+	}
+	paths := make([]string, len(join.Types))
+	for i, kind := range join.Types {
+		paths[i] = fmt.Sprintf("/proc/%d/ns/%s", pid, kind)
+	}
+	return paths, nil
+}
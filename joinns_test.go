@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestJoinNamespacePaths(t *testing.T) {
+	app := Service{Name: "app"}
+	sidecar := Service{Name: "sidecar", JoinNamespaces: &JoinNamespaces{Service: "app", Types: []string{"net", "ipc"}}}
+	d := NewDaemon(&Config{Services: map[string]Service{"app": app, "sidecar": sidecar}}, "", "", "")
+	defer d.cancel()
+
+	if _, err := d.joinNamespacePaths(sidecar); err == nil || !strings.Contains(err.Error(), "not running") {
+		t.Errorf("expected an error while app isn't running, got %v", err)
+	}
+
+	d.setServiceProcess("app", &serviceProcess{cmd: &exec.Cmd{Process: &os.Process{Pid: 42}}, exited: make(chan struct{})})
+	paths, err := d.joinNamespacePaths(sidecar)
+	if err != nil || !slices.Equal(paths, []string{"/proc/42/ns/net", "/proc/42/ns/ipc"}) {
+		t.Errorf("expected app's namespaces, got %v %v", paths, err)
+	}
+}
+
+func TestJoinNamespacesOrdering(t *testing.T) {
+	services := map[string]Service{
+		"app":     {Name: "app"},
+		"sidecar": {Name: "sidecar", JoinNamespaces: &JoinNamespaces{Service: "app", Types: []string{"net"}}},
+	}
+	tiers, err := startTiers(services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tiers) != 2 || tiers[0][0] != "app" || tiers[1][0] != "sidecar" {
+		t.Errorf("expected sidecar to start after app, got %v", tiers)
+	}
+}
+
+func TestValidateJoinNamespaces(t *testing.T) {
+	cases := []struct {
+		join JoinNamespaces
+		err  string
+	}{
+		{JoinNamespaces{Types: []string{"net"}}, "needs exactly one of service or pid"},
+		{JoinNamespaces{Service: "app", PID: 1, Types: []string{"net"}}, "needs exactly one of service or pid"},
+		{JoinNamespaces{Service: "db", Types: []string{"net"}}, `unknown service "db"`},
+		{JoinNamespaces{Service: "sidecar", Types: []string{"net"}}, "can't join its own"},
+		{JoinNamespaces{Service: "app"}, "types: is required"},
+		{JoinNamespaces{PID: 1, Types: []string{"net", "mnt"}}, "types[1]: must be one of"},
+	}
+	for _, c := range cases {
+		config := &Config{Services: map[string]Service{"app": {Name: "app"}, "sidecar": {Name: "sidecar", JoinNamespaces: &c.join}}}
+		if err := config.validateJoinNamespaces(); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%+v: expected error containing %q, got %v", c.join, c.err, err)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Where pod details are found when pei runs in Kubernetes
+const (
+	defaultDownwardAPIDir = "/etc/podinfo"
+	serviceAccountNSFile  = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// Kubernetes reads the pod pei runs in from the downward API, so its
+// services' settings can refer to the pod, and pei's logs and metrics say
+// which pod they came from. Each detail is read from a file in DownwardAPI,
+// then from the environment variable conventionally set from it:
+//
+//	name       POD_NAME (then the hostname)
+//	namespace  POD_NAMESPACE (then the service account's namespace)
+//	uid        POD_UID
+//	node       NODE_NAME
+//	ip         POD_IP
+//	labels     a downward API labels file, as key="value" lines
+type Kubernetes struct {
+	DownwardAPI string   `yaml:"downward_api"` // the volume's mount path; /etc/podinfo if empty
+	Labels      []string `yaml:"labels"`       // pod labels added to log records and metrics
+}
+
+// podInfo is what pei knows about its pod. Details it couldn't find are empty.
+type podInfo struct {
+	Name      string
+	Namespace string
+	UID       string
+	Node      string
+	IP        string
+	Labels    map[string]string
+}
+
+// pod reads the pod's details
+func (k *Kubernetes) pod() podInfo {
+	dir := k.DownwardAPI
+	if dir == "" {
+		dir = defaultDownwardAPIDir
+	}
+	read := func(file, env string, fallback func() string) string {
+		if data, err := os.ReadFile(filepath.Join(dir, file)); err == nil {
+			if value := strings.TrimSpace(string(data)); value != "" {
+				return value
+			}
+		}
+		if value := os.Getenv(env); value != "" {
+			return value
+		}
+		if fallback != nil {
+			return fallback()
+		}
+		return ""
+	}
+	pod := podInfo{
+		Name: read("name", "POD_NAME", func() string {
+			hostname, _ := os.Hostname()
+			return hostname
+		}),
+		Namespace: read("namespace", "POD_NAMESPACE", func() string {
+			data, _ := os.ReadFile(serviceAccountNSFile)
+			return strings.TrimSpace(string(data))
+		}),
+		UID:  read("uid", "POD_UID", nil),
+		Node: read("node", "NODE_NAME", nil),
+		IP:   read("ip", "POD_IP", nil),
+	}
+	pod.Labels, _ = readDownwardAPIMap(filepath.Join(dir, "labels"))
+	return pod
+}
+
+// readDownwardAPIMap reads a downward API labels or annotations file, which
+// holds one key="value" per line with the value quoted like a Go string
+func readDownwardAPIMap(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, quoted, found := strings.Cut(scanner.Text(), "=")
+		if !found {
+			continue
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			value = quoted
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+// fields are the pod details added to log records and metrics: its name,
+// namespace and node, and the labels asked for
+func (k *Kubernetes) fields(pod podInfo) map[string]string {
+	fields := make(map[string]string)
+	for name, value := range map[string]string{"pod": pod.Name, "namespace": pod.Namespace, "node": pod.Node} {
+		if value != "" {
+			fields[name] = value
+		}
+	}
+	for _, label := range k.Labels {
+		if value, exists := pod.Labels[label]; exists {
+			fields[podLabelField(label)] = value
+		}
+	}
+	return fields
+}
+
+// podLabelField names the field for a pod label, such as label_app_kubernetes_io_name
+// for app.kubernetes.io/name
+func podLabelField(label string) string {
+	return "label_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, label)
+}
+
+// podVariablePattern matches ${k8s.pod}, ${k8s.label.app} and the like in
+// services' settings
+var podVariablePattern = regexp.MustCompile(`\$\{k8s\.([a-z]+)(?:\.([^}]+))?\}`)
+
+// value returns a pod variable's value, given its name and, for labels, key
+func (p podInfo) value(name, key string) (string, error) {
+	if name == "label" {
+		if key == "" {
+			return "", fmt.Errorf("${k8s.label} needs a label name, such as ${k8s.label.app}")
+		}
+		return p.Labels[key], nil
+	}
+	values := map[string]string{"pod": p.Name, "namespace": p.Namespace, "uid": p.UID, "node": p.Node, "ip": p.IP}
+	value, known := values[name]
+	if !known || key != "" {
+		return "", fmt.Errorf("unknown variable ${k8s.%s}, use pod, namespace, uid, node, ip or label.<name>", strings.TrimSuffix(name+"."+key, "."))
+	}
+	return value, nil
+}
+
+// resolveKubernetes replaces pod variables in services' settings, in the
+// parsed document, when it has a kubernetes block. Variables the pod doesn't
+// have, such as a label it doesn't carry, are replaced with nothing.
+func resolveKubernetes(doc *yaml.Node) error {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil
+	}
+	root := resolveAlias(doc.Content[0])
+	_, block := mappingEntry(root, "kubernetes")
+	_, services := mappingEntry(root, "services")
+	if block == nil || services == nil {
+		return nil
+	}
+	var k Kubernetes
+	if err := block.Decode(&k); err != nil {
+		// Leave reporting the wrong shape to the typed decode
+		return nil
+	}
+	pod := k.pod()
+
+	var substitute func(node *yaml.Node, path []string) error
+	substitute = func(node *yaml.Node, path []string) error {
+		node = resolveAlias(node)
+		switch node.Kind {
+		case yaml.ScalarNode:
+			var err error
+			node.Value = podVariablePattern.ReplaceAllStringFunc(node.Value, func(match string) string {
+				groups := podVariablePattern.FindStringSubmatch(match)
+				value, valueErr := pod.value(groups[1], groups[2])
+				if valueErr != nil && err == nil {
+					err = fieldErrorf(path, "%v", valueErr)
+				}
+				return value
+			})
+			return err
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if err := substitute(node.Content[i+1], append(path, node.Content[i].Value)); err != nil {
+					return err
+				}
+			}
+		case yaml.SequenceNode:
+			for i, item := range node.Content {
+				if err := substitute(item, append(path, listIndex(i))); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return substitute(services, []string{"services"})
+}
+
+// validateKubernetes checks the kubernetes settings
+func (c *Config) validateKubernetes() error {
+	if c.Kubernetes == nil {
+		return nil
+	}
+	if c.Kubernetes.DownwardAPI != "" && !filepath.IsAbs(c.Kubernetes.DownwardAPI) {
+		return fieldErrorf([]string{"kubernetes", "downward_api"}, "must be an absolute path")
+	}
+	for i, label := range c.Kubernetes.Labels {
+		if label == "" {
+			return fieldErrorf([]string{"kubernetes", "labels", listIndex(i)}, "must not be empty")
+		}
+	}
+	return nil
+}
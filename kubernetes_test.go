@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeDownwardAPI fills a directory like a downward API volume
+func writeDownwardAPI(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestKubernetesPod(t *testing.T) {
+	dir := writeDownwardAPI(t, map[string]string{
+		"name":      "web-7d9f\n",
+		"namespace": "shop",
+		"labels":    "app=\"web\"\napp.kubernetes.io/version=\"1.2.3\"\nnote=\"a \\\"quoted\\\" value\"\n",
+	})
+	t.Setenv("NODE_NAME", "node-1")
+	t.Setenv("POD_NAMESPACE", "ignored")
+
+	k := &Kubernetes{DownwardAPI: dir, Labels: []string{"app.kubernetes.io/version", "missing"}}
+	pod := k.pod()
+	if pod.Name != "web-7d9f" || pod.Namespace != "shop" || pod.Node != "node-1" {
+		t.Errorf("expected files to win over the environment, got %+v", pod)
+	}
+	if pod.Labels["app"] != "web" || pod.Labels["note"] != `a "quoted" value` {
+		t.Errorf("expected labels unquoted, got %v", pod.Labels)
+	}
+
+	fields := k.fields(pod)
+	want := map[string]string{"pod": "web-7d9f", "namespace": "shop", "node": "node-1", "label_app_kubernetes_io_version": "1.2.3"}
+	if len(fields) != len(want) {
+		t.Errorf("expected fields %v, got %v", want, fields)
+	}
+	for name, value := range want {
+		if fields[name] != value {
+			t.Errorf("expected %s=%q, got %q", name, value, fields[name])
+		}
+	}
+}
+
+func TestLoadConfigKubernetesVariables(t *testing.T) {
+	dir := writeDownwardAPI(t, map[string]string{"name": "worker-abc", "namespace": "jobs", "labels": "tier=\"batch\"\n"})
+	contents := `kubernetes:
+  downward_api: ` + dir + `
+services:
+  worker:
+    command: ["worker", "--id", "${k8s.namespace}/${k8s.pod}"]
+    environment:
+      TIER: ${k8s.label.tier}
+      ZONE: ${k8s.label.zone}
+`
+	config, err := loadConfig(writeConfig(t, contents))
+	if err != nil {
+		t.Fatalf("Expected config with pod variables to load, got: %v", err)
+	}
+	worker := config.Services["worker"]
+	if worker.Command[2] != "jobs/worker-abc" || worker.Environment["TIER"] != "batch" || worker.Environment["ZONE"] != "" {
+		t.Errorf("Expected pod variables replaced, got %v %v", worker.Command, worker.Environment)
+	}
+
+	bad := strings.Replace(contents, "${k8s.pod}", "${k8s.cluster}", 1)
+	if _, err := loadConfig(writeConfig(t, bad)); err == nil || !strings.Contains(err.Error(), "services.worker.command[2]: unknown variable ${k8s.cluster}") {
+		t.Errorf("Expected an unknown variable located, got %v", err)
+	}
+
+	// Without a kubernetes block, the text is left alone
+	plain := strings.Replace(contents, "kubernetes:\n  downward_api: "+dir+"\n", "", 1)
+	config, err = loadConfig(writeConfig(t, plain))
+	if err != nil || config.Services["worker"].Command[2] != "${k8s.namespace}/${k8s.pod}" {
+		t.Errorf("Expected variables left alone without a kubernetes block, got %v, %v", config, err)
+	}
+}
+
+func TestPodInfoMetric(t *testing.T) {
+	var out strings.Builder
+	(&DaemonMetrics{Pod: map[string]string{"pod": "web-1", "namespace": "shop"}}).writePrometheus(&out)
+	if !strings.Contains(out.String(), `pei_pod_info{namespace="shop",pod="web-1"} 1`) {
+		t.Errorf("expected a pod info metric, got:\n%s", out.String())
+	}
+}
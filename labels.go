@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log/slog"
+	"regexp"
+	"sort"
+)
+
+// labelNamePattern keeps label names usable as metric label names
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// validateLabels checks every service's label names
+func (c *Config) validateLabels() error {
+	for name, svc := range c.Services {
+		for label := range svc.Labels {
+			if !labelNamePattern.MatchString(label) {
+				return fieldErrorf([]string{"services", name, "labels", label}, "label %q must be letters, digits and underscores, not starting with a digit", label)
+			}
+			if label == "service" {
+				return fieldErrorf([]string{"services", name, "labels", label}, "label service is reserved for the service name in metrics")
+			}
+		}
+	}
+	return nil
+}
+
+// labelAttrs returns labels as a single "labels" log attribute group, or
+// nothing if there are none
+func labelAttrs(labels map[string]string) []any {
+	if len(labels) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	attrs := make([]any, 0, len(names))
+	for _, name := range names {
+		attrs = append(attrs, slog.String(name, labels[name]))
+	}
+	return []any{slog.Group("labels", attrs...)}
+}
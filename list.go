@@ -0,0 +1,223 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// listColumn is a column pei list can show
+type listColumn struct {
+	header string
+	width  int
+	value  func(*ServiceStatus) string
+
+	// compare orders services for --sort; numeric columns sort highest first
+	compare func(a, b *ServiceStatus) int
+
+	// usage is set for columns that need the daemon to read resource use
+	usage bool
+}
+
+// listColumns are the columns pei list -o can show, by name
+var listColumns = map[string]listColumn{
+	"name": {header: "NAME", width: 20,
+		value:   func(s *ServiceStatus) string { return s.Name },
+		compare: func(a, b *ServiceStatus) int { return strings.Compare(a.Name, b.Name) }},
+	"state": {header: "STATUS", width: 10,
+		value:   listState,
+		compare: func(a, b *ServiceStatus) int { return strings.Compare(string(a.State), string(b.State)) }},
+	"pid": {header: "PID", width: 8,
+		value: func(s *ServiceStatus) string {
+			if !s.Running || s.PID <= 0 {
+				return "-"
+			}
+			return fmt.Sprint(s.PID)
+		},
+		compare: func(a, b *ServiceStatus) int { return cmp.Compare(b.PID, a.PID) }},
+	"restarts": {header: "RESTARTS", width: 12,
+		value:   func(s *ServiceStatus) string { return fmt.Sprint(s.Restarts) },
+		compare: func(a, b *ServiceStatus) int { return cmp.Compare(b.Restarts, a.Restarts) }},
+	"uptime": {header: "UPTIME", width: 10,
+		value: func(s *ServiceStatus) string {
+			if !s.Running {
+				return "-"
+			}
+			return formatUptime(s.Uptime)
+		},
+		compare: func(a, b *ServiceStatus) int { return cmp.Compare(b.Uptime, a.Uptime) }},
+	"cpu": {header: "CPU", width: 10, usage: true,
+		value: func(s *ServiceStatus) string {
+			if s.Usage == nil || s.Usage.CPUSeconds < 0 {
+				return "-"
+			}
+			return (time.Duration(s.Usage.CPUSeconds * float64(time.Second))).Round(10 * time.Millisecond).String()
+		},
+		compare: func(a, b *ServiceStatus) int { return cmp.Compare(usageOf(b).CPUSeconds, usageOf(a).CPUSeconds) }},
+	"mem": {header: "MEM", width: 10, usage: true,
+		value: func(s *ServiceStatus) string {
+			if s.Usage == nil || s.Usage.MemoryBytes < 0 {
+				return "-"
+			}
+			return formatBytes(s.Usage.MemoryBytes)
+		},
+		compare: func(a, b *ServiceStatus) int { return cmp.Compare(usageOf(b).MemoryBytes, usageOf(a).MemoryBytes) }},
+	"fds": {header: "FDS", width: 6, usage: true,
+		value: func(s *ServiceStatus) string {
+			if s.Usage == nil || s.Usage.OpenFDs < 0 {
+				return "-"
+			}
+			return fmt.Sprint(s.Usage.OpenFDs)
+		},
+		compare: func(a, b *ServiceStatus) int { return cmp.Compare(usageOf(b).OpenFDs, usageOf(a).OpenFDs) }},
+	"health": {header: "HEALTH", width: 10,
+		value: func(s *ServiceStatus) string {
+			if s.Health == nil || s.Health.Status == "" {
+				return "-"
+			}
+			return s.Health.Status
+		},
+		compare: func(a, b *ServiceStatus) int { return strings.Compare(healthOf(a), healthOf(b)) }},
+	"reason": {header: "LAST RESTART", width: 16,
+		value: func(s *ServiceStatus) string {
+			if s.LastRestartReason == "" {
+				return "-"
+			}
+			return string(s.LastRestartReason)
+		},
+		compare: func(a, b *ServiceStatus) int {
+			return strings.Compare(string(a.LastRestartReason), string(b.LastRestartReason))
+		}},
+	"labels": {header: "LABELS", width: 0,
+		value: func(s *ServiceStatus) string {
+			if len(s.Labels) == 0 {
+				return "-"
+			}
+			var labels []string
+			for name, value := range s.Labels {
+				labels = append(labels, name+"="+value)
+			}
+			sort.Strings(labels)
+			return strings.Join(labels, ",")
+		}},
+}
+
+// Column sets for pei list: the default, and -o wide
+var (
+	defaultListColumns = []string{"name", "state", "pid", "restarts", "uptime"}
+	wideListColumns    = []string{"name", "state", "pid", "restarts", "uptime", "cpu", "mem", "fds", "health", "reason", "labels"}
+)
+
+// listColumnAliases are other names columns go by
+var listColumnAliases = map[string]string{"status": "state", "memory": "mem", "restart": "restarts", "age": "uptime"}
+
+// parseListColumns turns pei list -o into column names: a comma-separated
+// list, or wide for every column
+func parseListColumns(spec string) ([]string, error) {
+	switch spec {
+	case "":
+		return defaultListColumns, nil
+	case "wide":
+		return wideListColumns, nil
+	}
+	var columns []string
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if alias, exists := listColumnAliases[name]; exists {
+			name = alias
+		}
+		if _, exists := listColumns[name]; !exists {
+			return nil, fmt.Errorf("unknown column %q (known: %s, or wide)", name, strings.Join(wideListColumns, ", "))
+		}
+		columns = append(columns, name)
+	}
+	return columns, nil
+}
+
+// sortServiceStatus orders services by a column, then by name. Numeric
+// columns such as cpu and mem put the highest first.
+func sortServiceStatus(statuses []*ServiceStatus, by string) error {
+	if alias, exists := listColumnAliases[by]; exists {
+		by = alias
+	}
+	column, exists := listColumns[cmp.Or(by, "name")]
+	if !exists || column.compare == nil {
+		return fmt.Errorf("cannot sort by %q", by)
+	}
+	slices.SortStableFunc(statuses, func(a, b *ServiceStatus) int {
+		return cmp.Or(column.compare(a, b), strings.Compare(a.Name, b.Name))
+	})
+	return nil
+}
+
+// writeServiceTable prints services as a table of the given columns
+func writeServiceTable(w io.Writer, statuses []*ServiceStatus, columns []string) {
+	row := func(cell func(listColumn) string) {
+		var line strings.Builder
+		for i, name := range columns {
+			column := listColumns[name]
+			if i < len(columns)-1 {
+				fmt.Fprintf(&line, "%-*s ", column.width, cell(column))
+			} else {
+				line.WriteString(cell(column))
+			}
+		}
+		fmt.Fprintln(w, strings.TrimRight(line.String(), " "))
+	}
+	row(func(c listColumn) string { return c.header })
+	row(func(c listColumn) string { return strings.Repeat("-", len(c.header)) })
+	for _, status := range statuses {
+		row(func(c listColumn) string { return c.value(status) })
+	}
+}
+
+// needsUsage reports whether any of columns shows resource use
+func needsUsage(columns []string) bool {
+	return slices.ContainsFunc(columns, func(name string) bool { return listColumns[name].usage })
+}
+
+// listState is what pei list shows for a service's state, including when a
+// service waiting to start again will
+func listState(s *ServiceStatus) string {
+	if !s.NextRestart.IsZero() {
+		return nextRestartSummary(s)
+	}
+	if s.State == "" {
+		return "stopped"
+	}
+	return string(s.State)
+}
+
+func usageOf(s *ServiceStatus) processUsage {
+	if s.Usage == nil {
+		return processUsage{CPUSeconds: -1, MemoryBytes: -1, OpenFDs: -1}
+	}
+	return *s.Usage
+}
+
+func healthOf(s *ServiceStatus) string {
+	if s.Health == nil {
+		return ""
+	}
+	return s.Health.Status
+}
+
+// formatBytes formats a size in binary units, such as 12.5Mi
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	value, suffix := float64(n)/unit, "Ki"
+	for _, next := range []string{"Mi", "Gi", "Ti"} {
+		if value < unit {
+			break
+		}
+		value, suffix = value/unit, next
+	}
+	return fmt.Sprintf("%.1f%s", value, suffix)
+}
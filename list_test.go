@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseListColumns(t *testing.T) {
+	columns, err := parseListColumns("name, STATUS,memory")
+	if err != nil || !slices.Equal(columns, []string{"name", "state", "mem"}) {
+		t.Errorf("expected name, state and mem, got %v (%v)", columns, err)
+	}
+	if columns, _ := parseListColumns(""); !slices.Equal(columns, defaultListColumns) {
+		t.Errorf("expected the default columns, got %v", columns)
+	}
+	if columns, _ := parseListColumns("wide"); !needsUsage(columns) {
+		t.Error("expected wide to include resource columns")
+	}
+	if _, err := parseListColumns("name,bogus"); err == nil {
+		t.Error("expected an unknown column to be rejected")
+	}
+}
+
+func TestServiceTable(t *testing.T) {
+	statuses := []*ServiceStatus{
+		{Name: "web", State: StateRunning, Running: true, PID: 10, Usage: &processUsage{CPUSeconds: 1.5, MemoryBytes: 3 << 20, OpenFDs: 4}},
+		{Name: "db", State: StateRunning, Running: true, PID: 11, Usage: &processUsage{CPUSeconds: 9, MemoryBytes: 1 << 30, OpenFDs: 8}},
+		{Name: "cron", State: StateStopped, Usage: &processUsage{CPUSeconds: -1, MemoryBytes: -1, OpenFDs: -1}},
+	}
+	if err := sortServiceStatus(statuses, "cpu"); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, status := range statuses {
+		names = append(names, status.Name)
+	}
+	if !slices.Equal(names, []string{"db", "web", "cron"}) {
+		t.Errorf("expected the busiest service first, got %v", names)
+	}
+
+	var out bytes.Buffer
+	writeServiceTable(&out, statuses, []string{"name", "pid", "mem"})
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{
+		"NAME                 PID      MEM",
+		"----                 ---      ---",
+		"db                   11       1.0Gi",
+		"web                  10       3.0Mi",
+		"cron                 -        -",
+	}
+	if !slices.Equal(lines, want) {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(want, "\n"), out.String())
+	}
+
+	if err := sortServiceStatus(statuses, "labels"); err == nil {
+		t.Error("expected labels not to be sortable")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"regexp"
+	"time"
+)
+
+// localePattern matches locale names such as C, POSIX, C.UTF-8, en_US.UTF-8
+// and sr_RS@latin
+var localePattern = regexp.MustCompile(`^(C|POSIX|[a-z]{2,3}(_[A-Z]{2})?)(\.[A-Za-z0-9-]+)?(@[A-Za-z0-9]+)?$`)
+
+// validateLocales checks services' timezone and locale. A timezone must be
+// in the zoneinfo database pei sees, which is the one its services see.
+func (c *Config) validateLocales() error {
+	for name, svc := range c.Services {
+		if svc.Timezone != "" {
+			if _, set := svc.Environment["TZ"]; set {
+				return serviceErrorf(name, "timezone", "cannot be used with TZ in environment")
+			}
+			if svc.Timezone == "Local" {
+				return serviceErrorf(name, "timezone", "must be a zone name such as UTC or Europe/Berlin")
+			}
+			if _, err := time.LoadLocation(svc.Timezone); err != nil {
+				return serviceErrorf(name, "timezone", "%q is not in the zoneinfo database", svc.Timezone)
+			}
+		}
+		if svc.Locale != "" {
+			if _, set := svc.Environment["LC_ALL"]; set {
+				return serviceErrorf(name, "locale", "cannot be used with LC_ALL in environment")
+			}
+			if !localePattern.MatchString(svc.Locale) {
+				return serviceErrorf(name, "locale", "%q is not a locale name such as C.UTF-8 or en_US.UTF-8", svc.Locale)
+			}
+		}
+	}
+	return nil
+}
+
+// localeEnvironment is the environment a service's timezone and locale set
+func localeEnvironment(svc Service) []string {
+	var env []string
+	if svc.Timezone != "" {
+		env = append(env, "TZ="+svc.Timezone)
+	}
+	if svc.Locale != "" {
+		env = append(env, "LC_ALL="+svc.Locale)
+	}
+	return env
+}
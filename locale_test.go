@@ -0,0 +1,46 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateLocales(t *testing.T) {
+	tests := []struct {
+		svc Service
+		err string
+	}{
+		{Service{Timezone: "Europe/Berlin", Locale: "de_DE.UTF-8"}, ""},
+		{Service{Timezone: "UTC", Locale: "C.UTF-8"}, ""},
+		{Service{Locale: "sr_RS@latin"}, ""},
+		{Service{Timezone: "Mars/Olympus"}, "not in the zoneinfo database"},
+		{Service{Timezone: "Local"}, "must be a zone name"},
+		{Service{Timezone: "UTC", Environment: map[string]string{"TZ": "UTC"}}, "cannot be used with TZ"},
+		{Service{Locale: "english"}, "not a locale name"},
+		{Service{Locale: "C", Environment: map[string]string{"LC_ALL": "C"}}, "cannot be used with LC_ALL"},
+	}
+	for _, tt := range tests {
+		config := &Config{Services: map[string]Service{"web": tt.svc}}
+		err := config.validateLocales()
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%+v: unexpected error: %v", tt.svc, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%+v: expected error containing %q, got %v", tt.svc, tt.err, err)
+		}
+	}
+}
+
+func TestLocaleEnvironment(t *testing.T) {
+	t.Setenv("TZ", "America/New_York")
+	env := serviceEnvironment(Service{Timezone: "Asia/Tokyo", Locale: "ja_JP.UTF-8"})
+	tz := slices.Index(env, "TZ=Asia/Tokyo")
+	if tz < 0 || tz < slices.Index(env, "TZ=America/New_York") || !slices.Contains(env, "LC_ALL=ja_JP.UTF-8") {
+		t.Errorf("expected the service's TZ to override pei's, and LC_ALL set, got %v", env)
+	}
+	if env := serviceEnvironment(Service{}); env != nil {
+		t.Errorf("expected pei's environment inherited, got %v", env)
+	}
+}
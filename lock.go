@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// lockPath holds the running daemon's PID, and is locked for as long as it
+// runs so two daemons never supervise the same children. It lives in
+// /run/pei, which only root can write to, rather than a world-writable
+// directory where anyone could leave a symlink for root to follow. pei
+// running as an ordinary user keeps it in a directory of that user's own.
+func lockPath() string {
+	if os.Geteuid() == 0 {
+		return "/run/pei/pei.pid"
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("pei-%d", os.Geteuid()), "pei.pid")
+}
+
+// socketProbeTimeout is how long a daemon that may still own the IPC socket
+// gets to answer before the socket is taken for stale
+const socketProbeTimeout = time.Second
+
+// acquireDaemonLock locks the PID file and writes this process's PID to it.
+// The lock lasts as long as the returned file stays open, which is until
+// the daemon exits, however it exits.
+func acquireDaemonLock(path string) (*os.File, error) {
+	if err := privateDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|syscall.O_NOFOLLOW, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file: %v", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			owner := "another pei"
+			if data, readErr := os.ReadFile(path); readErr == nil {
+				if pid, convErr := strconv.Atoi(strings.TrimSpace(string(data))); convErr == nil {
+					owner = fmt.Sprintf("another pei (pid %d)", pid)
+				}
+			}
+			return nil, fmt.Errorf("%s is already running and holds %s", owner, path)
+		}
+		return nil, fmt.Errorf("locking %s: %v", path, err)
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// privateDir creates dir if it's missing and makes sure nobody but pei's own
+// user can add or replace files in it
+func privateDir(dir string) error {
+	if err := os.Mkdir(dir, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || int(stat.Uid) != os.Geteuid() || info.Mode().Perm()&0o022 != 0 {
+		return fmt.Errorf("%s must be a directory only pei's user can write to", dir)
+	}
+	return nil
+}
+
+// claimSocket makes sure nothing else serves the IPC socket before the
+// daemon binds it: a daemon that still answers there is refused, and a
+// socket left behind by one that died is removed
+func claimSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, socketProbeTimeout)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("a pei daemon is already listening on %s", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) && !errors.Is(err, syscall.ENOENT) {
+		return fmt.Errorf("probing %s: %v", path, err)
+	}
+	getLogger("daemon").Warn("Removing stale IPC socket", "socket_path", path)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing stale socket: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAcquireDaemonLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pei.pid")
+	lock, err := acquireDaemonLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("expected our PID in the lock file, got %q", data)
+	}
+
+	// A second daemon is refused while the first holds the lock
+	if _, err := acquireDaemonLock(path); err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Errorf("expected a second lock to be refused, got %v", err)
+	}
+
+	// The lock goes with the daemon, leaving the file behind
+	lock.Close()
+	lock, err = acquireDaemonLock(path)
+	if err != nil {
+		t.Fatalf("expected the lock to be free once released, got %v", err)
+	}
+	lock.Close()
+}
+
+func TestAcquireDaemonLockRefusesPlantedFiles(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "victim")
+	os.WriteFile(target, []byte("keep me\n"), 0o644)
+	path := filepath.Join(dir, "pei.pid")
+	os.Symlink(target, path)
+	if _, err := acquireDaemonLock(path); err == nil {
+		t.Error("expected a symlinked lock file to be refused")
+	}
+	if data, _ := os.ReadFile(target); string(data) != "keep me\n" {
+		t.Errorf("expected the symlink's target to be left alone, got %q", data)
+	}
+
+	// Nor is a directory others can write to trusted
+	shared := filepath.Join(dir, "shared")
+	os.Mkdir(shared, 0o777)
+	os.Chmod(shared, 0o1777)
+	if _, err := acquireDaemonLock(filepath.Join(shared, "pei.pid")); err == nil || !strings.Contains(err.Error(), "only pei's user can write to") {
+		t.Errorf("expected a world-writable directory to be refused, got %v", err)
+	}
+}
+
+func TestClaimSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "pei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "s")
+
+	if err := claimSocket(path); err != nil {
+		t.Errorf("expected a missing socket to be fine, got %v", err)
+	}
+
+	// A daemon still listening keeps its socket
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := claimSocket(path); err == nil || !strings.Contains(err.Error(), "already listening") {
+		t.Errorf("expected a live socket to be refused, got %v", err)
+	}
+
+	// One that died leaves a socket nobody answers, which is removed
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	if err := claimSocket(path); err != nil {
+		t.Errorf("expected a stale socket to be claimed, got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected the stale socket to be removed")
+	}
+
+	// Anything else is left alone
+	os.WriteFile(path, nil, 0o644)
+	if err := claimSocket(path); err == nil {
+		t.Error("expected a regular file to be refused")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// serviceLogLines is how many recent output lines are kept for each service
+const serviceLogLines = 1000
+
+// LogLine is one line of captured service output
+type LogLine struct {
+	Time    time.Time `json:"time"`
+	Service string    `json:"service,omitempty"`
+	Stream  string    `json:"stream"`
+	Level   string    `json:"level,omitempty"`
+	Text    string    `json:"text"`
+}
+
+// LogBuffer keeps the most recent output lines of a service across restarts
+type LogBuffer struct {
+	mu    sync.Mutex
+	lines []LogLine
+	size  int
+
+	// spool persists lines to disk when log_spool is configured
+	spool *logSpoolFile
+
+	// Live subscribers, such as pei tail -f clients
+	subscribers map[int]func(LogLine)
+	nextSub     int
+}
+
+// NewLogBuffer creates a buffer holding at most size lines
+func NewLogBuffer(size int) *LogBuffer {
+	return &LogBuffer{size: size}
+}
+
+// add appends a line, dropping the oldest once the buffer is full
+func (b *LogBuffer) add(stream, level, text string) {
+	line := LogLine{Time: time.Now(), Stream: stream, Level: level, Text: text}
+
+	b.mu.Lock()
+	b.lines = append(b.lines, line)
+	if len(b.lines) > b.size {
+		b.lines = b.lines[len(b.lines)-b.size:]
+	}
+	for _, fn := range b.subscribers {
+		fn(line)
+	}
+	b.mu.Unlock()
+
+	if b.spool != nil {
+		if err := b.spool.write(line); err != nil {
+			getLogger("logs").Error("Failed to write log spool", "path", b.spool.path, "error", err)
+		}
+	}
+}
+
+// last returns up to the n most recent lines, oldest first, or all of them
+// if n is zero
+func (b *LogBuffer) last(n int) []LogLine {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	lines := b.lines
+	if n > 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return append([]LogLine(nil), lines...)
+}
+
+// subscribe calls fn with every line added until the returned function is
+// called. fn runs with the buffer locked, so it must not block.
+func (b *LogBuffer) subscribe(fn func(LogLine)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[int]func(LogLine))
+	}
+	id := b.nextSub
+	b.nextSub++
+	b.subscribers[id] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, id)
+	}
+}
+
+// serviceLogs returns the output history of a service, creating it on first use
+func (d *Daemon) serviceLogs(name string) *LogBuffer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	logs, exists := d.logs[name]
+	if !exists {
+		logs = NewLogBuffer(serviceLogLines)
+		if d.config.LogSpool != nil {
+			spool, previous, err := openLogSpoolFile(d.config.LogSpool, name)
+			if err != nil {
+				logServiceError(name, "Failed to open log spool", "error", err)
+			}
+			logs.spool = spool
+			logs.lines = previous
+			if len(logs.lines) > logs.size {
+				logs.lines = logs.lines[len(logs.lines)-logs.size:]
+			}
+		}
+		d.logs[name] = logs
+	}
+	return logs
+}
+
+func showLogsIPC(serviceName string, limit int) error {
+	resp, err := sendIPCRequest(IPCRequest{Command: "logs", Service: serviceName, Limit: limit})
+	if err != nil {
+		return err
+	}
+
+	if !resp.Success {
+		return fmt.Errorf("daemon error: %s", resp.Message)
+	}
+
+	for _, line := range resp.Logs {
+		fmt.Printf("%s %-6s %s\n", line.Time.Format(time.RFC3339), line.Stream, line.Text)
+	}
+	return nil
+}
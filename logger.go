@@ -1,8 +1,10 @@
 package main
 
 import (
+	"io"
 	"log/slog"
 	"os"
+	"sync"
 )
 
 // initLogger initializes the global slog logger based on environment variables
@@ -28,11 +30,11 @@ func initLogger() {
 
 	var handler slog.Handler
 	if logFormat == "json" {
-		handler = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		handler = slog.NewJSONHandler(logOutput, &slog.HandlerOptions{
 			Level: level,
 		})
 	} else {
-		handler = slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		handler = slog.NewTextHandler(logOutput, &slog.HandlerOptions{
 			Level: level,
 		})
 	}
@@ -41,6 +43,62 @@ func initLogger() {
 	slog.SetDefault(logger)
 }
 
+// maxLogBatch is how much log output is held before it is written, while
+// output capture holds it
+const maxLogBatch = 64 << 10
+
+// logOutput is where pei's logs go. Output capture holds it while logging a
+// burst of service output, so the burst is written with one write rather
+// than one per line; other logs go straight through.
+var logOutput = &batchWriter{w: os.Stdout}
+
+// batchWriter collects writes while held and writes them together once the
+// last holder releases it
+type batchWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	buf     []byte
+	holders int
+}
+
+func (b *batchWriter) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.holders == 0 {
+		return b.w.Write(p)
+	}
+	b.buf = append(b.buf, p...)
+	if len(b.buf) >= maxLogBatch {
+		b.flush()
+	}
+	return len(p), nil
+}
+
+// hold collects writes until release
+func (b *batchWriter) hold() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.holders++
+}
+
+// release writes what was collected once no one holds the writer
+func (b *batchWriter) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.holders--
+	if b.holders == 0 {
+		b.flush()
+	}
+}
+
+// flush writes what was collected. The caller holds b.mu.
+func (b *batchWriter) flush() {
+	if len(b.buf) > 0 {
+		b.w.Write(b.buf)
+		b.buf = b.buf[:0]
+	}
+}
+
 // Component-specific loggers
 func getLogger(component string) *slog.Logger {
 	return slog.With("component", component)
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// defaultLogSpoolMaxBytes bounds each service's spool file before it rotates
+const defaultLogSpoolMaxBytes = 1 << 20
+
+// LogSpool configures on-disk persistence of recent service output, so it
+// survives the daemon restarting
+type LogSpool struct {
+	Dir      string `yaml:"dir"`
+	MaxBytes int64  `yaml:"max_bytes"`
+}
+
+func (l *LogSpool) maxBytes() int64 {
+	if l.MaxBytes > 0 {
+		return l.MaxBytes
+	}
+	return defaultLogSpoolMaxBytes
+}
+
+// validateLogSpool checks the log_spool settings
+func (c *Config) validateLogSpool() error {
+	if c.LogSpool == nil {
+		return nil
+	}
+	if c.LogSpool.Dir == "" {
+		return fieldErrorf([]string{"log_spool", "dir"}, "is required")
+	}
+	if c.LogSpool.MaxBytes < 0 {
+		return fieldErrorf([]string{"log_spool", "max_bytes"}, "must not be negative")
+	}
+	return nil
+}
+
+// prepareLogSpool creates the spool directory and hands it to the app user,
+// so spools can still be rotated after privileges are dropped
+func prepareLogSpool(spool *LogSpool, appUser, appGroup string) error {
+	if err := os.MkdirAll(spool.Dir, 0750); err != nil {
+		return fmt.Errorf("failed to create log spool dir: %v", err)
+	}
+	uid, gid, err := lookupUIDGID(appUser, appGroup)
+	if err != nil {
+		return fmt.Errorf("failed to look up app user for log spool: %v", err)
+	}
+	if err := os.Chown(spool.Dir, uid, gid); err != nil {
+		return fmt.Errorf("failed to chown log spool dir: %v", err)
+	}
+	return nil
+}
+
+// logSpoolFile appends a service's output lines to <dir>/<service>.log as
+// JSON lines, keeping one rotated file alongside it at <service>.log.1
+type logSpoolFile struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	file     *os.File
+	size     int64
+}
+
+// openLogSpoolFile opens a service's spool for appending and returns the
+// lines already in it, oldest first
+func openLogSpoolFile(spool *LogSpool, service string) (*logSpoolFile, []LogLine, error) {
+	path := filepath.Join(spool.Dir, service+".log")
+	previous := append(readLogSpool(path+".1"), readLogSpool(path)...)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, previous, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, previous, err
+	}
+	return &logSpoolFile{path: path, maxBytes: spool.maxBytes(), file: file, size: info.Size()}, previous, nil
+}
+
+// readLogSpool reads the lines of a spool file, skipping any it can't parse
+// such as a final line cut short by a crash
+func readLogSpool(path string) []LogLine {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var lines []LogLine
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line LogLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err == nil {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// write appends a line, rotating the file once it grows past its limit
+func (s *logSpoolFile) write(line LogLine) error {
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size+int64(len(data)) > s.maxBytes && s.size > 0 {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	return err
+}
+
+func (s *logSpoolFile) rotate() error {
+	s.file.Close()
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	s.size = 0
+	return nil
+}
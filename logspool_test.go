@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLogSpoolPersistsAndRotates(t *testing.T) {
+	spool := &LogSpool{Dir: t.TempDir(), MaxBytes: 400}
+
+	file, previous, err := openLogSpoolFile(spool, "web")
+	if err != nil {
+		t.Fatalf("Failed to open spool: %v", err)
+	}
+	if len(previous) != 0 {
+		t.Fatalf("Expected an empty spool, got %d lines", len(previous))
+	}
+
+	buffer := NewLogBuffer(100)
+	buffer.spool = file
+	for i := 0; i < 20; i++ {
+		buffer.add("stdout", "INFO", fmt.Sprintf("line %d", i))
+	}
+	file.file.Close()
+
+	_, previous, err = openLogSpoolFile(spool, "web")
+	if err != nil {
+		t.Fatalf("Failed to reopen spool: %v", err)
+	}
+	if len(previous) == 0 || len(previous) >= 20 {
+		t.Fatalf("Expected rotation to keep some but not all lines, got %d", len(previous))
+	}
+	if last := previous[len(previous)-1].Text; last != "line 19" {
+		t.Errorf("Expected the newest line last, got %q", last)
+	}
+}
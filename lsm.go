@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// validateLSM checks apparmor_profile and selinux_label
+func (c *Config) validateLSM() error {
+	for name, svc := range c.Services {
+		if svc.AppArmorProfile != "" && svc.SELinuxLabel != "" {
+			return serviceErrorf(name, "selinux_label", "can't be combined with apparmor_profile")
+		}
+		if svc.SELinuxLabel != "" && strings.Count(svc.SELinuxLabel, ":") < 2 {
+			return serviceErrorf(name, "selinux_label", "must look like user:role:type[:level]")
+		}
+		if strings.ContainsAny(svc.AppArmorProfile, "\n\x00") {
+			return serviceErrorf(name, "apparmor_profile", "must be a single line")
+		}
+		if strings.ContainsAny(svc.SELinuxLabel, " \n\x00") {
+			return serviceErrorf(name, "selinux_label", "must not contain whitespace")
+		}
+	}
+	return nil
+}
+
+// startLabeled starts a service's command confined by its AppArmor profile or
+// SELinux label. The label is set for the next exec of one OS thread, which
+// the child inherits when forked from it; the thread is never unlocked, so
+// it exits afterwards rather than passing the label on to other commands.
+//
+// Commands started through pei sandbox-exec are labeled by it instead, so
+// the label applies to the service rather than the helper.
+func startLabeled(cmd *exec.Cmd, svc Service) error {
+	if (svc.AppArmorProfile == "" && svc.SELinuxLabel == "") || startsSandboxed(cmd) {
+		return startChild(cmd)
+	}
+	started := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		if err := setExecLabel(svc); err != nil {
+			started <- err
+			return
+		}
+		started <- startChild(cmd)
+	}()
+	return <-started
+}
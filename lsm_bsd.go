@@ -0,0 +1,11 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import "errors"
+
+// setExecLabel is not supported here, so services with an apparmor_profile
+// or selinux_label fail to start
+func setExecLabel(svc Service) error {
+	return errors.New("apparmor_profile and selinux_label are not supported on this platform")
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// setExecLabel sets the AppArmor profile or SELinux label the calling thread
+// switches to at its next exec, as aa_change_onexec and setexeccon do. The
+// caller must have locked the thread.
+func setExecLabel(svc Service) error {
+	path, value := "/proc/thread-self/attr/exec", svc.SELinuxLabel
+	if svc.AppArmorProfile != "" {
+		value = "exec " + svc.AppArmorProfile
+		// Kernels with several LSMs stacked have a per-LSM attribute
+		if _, err := os.Stat("/proc/thread-self/attr/apparmor/exec"); err == nil {
+			path = "/proc/thread-self/attr/apparmor/exec"
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("setting security label: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(value); err != nil {
+		return fmt.Errorf("setting security label %q: %v", value, err)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestValidateLSM(t *testing.T) {
+	cases := []struct {
+		svc Service
+		err string
+	}{
+		{Service{AppArmorProfile: "web", SELinuxLabel: "system_u:system_r:web_t:s0"}, "can't be combined"},
+		{Service{SELinuxLabel: "web_t"}, "must look like user:role:type"},
+		{Service{SELinuxLabel: "system_u:system_r:web t"}, "must not contain whitespace"},
+		{Service{AppArmorProfile: "web\nexec other"}, "must be a single line"},
+	}
+	for _, c := range cases {
+		config := &Config{Services: map[string]Service{"web": c.svc}}
+		if err := config.validateLSM(); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%+v: expected error containing %q, got %v", c.svc, c.err, err)
+		}
+	}
+}
+
+func TestStartLabeledWithoutLabel(t *testing.T) {
+	cmd := exec.Command("true")
+	if err := startLabeled(cmd, Service{Name: "web"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Error(err)
+	}
+}
@@ -13,21 +13,77 @@ func showHelp() {
 	fmt.Println("\nUsage:")
 	fmt.Println("  pei [command] [options]")
 	fmt.Println("\nCommands:")
-	fmt.Println("  list                      List all services and their status")
+	fmt.Println("  list [-o cols|wide]       List all services and their status (--sort by a column, e.g. cpu)")
 	fmt.Println("  status [service]          Show detailed status for service (or all if no service specified)")
-	fmt.Println("  restart <service>         Restart a specific service")
-	fmt.Println("  signal <service:signal>   Send signal to service")
+	fmt.Println("  restart <service...>      Restart services (--reason \"text\" to record why)")
+	fmt.Println("  start <service...>        Start services that were stopped, failed or completed")
+	fmt.Println("  stop <service...>         Stop services and leave them stopped until started (--force kills their process groups at once)")
+	fmt.Println("  kill <service...>         Kill services' process groups at once; their restart policy still applies")
+	fmt.Println("  run <oneshot>             Run a oneshot now, printing its output, and exit with its exit code")
+	fmt.Println("  reload [--dry-run]        Re-read the config and apply added, removed and changed services")
+	fmt.Println("  diff                      Show how the config file differs from what the daemon is running")
+	fmt.Println("  snapshot                  Print the running configuration and service state as YAML")
+	fmt.Println("  metrics                   Print the daemon's own metrics in Prometheus format")
+	fmt.Println("  debug dump                Print the daemon's internals and goroutine stacks")
+	fmt.Println("  signal <service:signal>   Send signal to services (--group for their whole process groups)")
+	fmt.Println("  logs <service>            Show recent output of a service (-n lines, default 100)")
+	fmt.Println("  tail [service...]         Merge recent output of services (-f to follow, --stream, --level)")
+	fmt.Println("  attach <service>          Attach the terminal to a service (input requires tty: true)")
+	fmt.Println("  boot-analyze              Show a waterfall of service startup during boot")
+	fmt.Println("  events [service]          Show recent events such as rollouts (-f to follow, --limit n)")
+	fmt.Println("  plan                      Show what would be started, in what order, without starting it")
+	fmt.Println("  graph [--format ascii]    Print the service dependency graph in DOT (or as text)")
+	fmt.Println("  doctor                    Check that the environment can run the configured services")
+	fmt.Println("  config render             Print the fully resolved configuration with defaults applied")
+	fmt.Println("  test <file>               Check the configuration against the assertions in a test file")
+	fmt.Println("  schema                    Print a JSON Schema for pei.yaml")
+	fmt.Println("  version                   Show the CLI's and daemon's version, commit, build date and schema version")
 	fmt.Println("  help                      Show this help")
 	fmt.Println("\nGlobal Options:")
-	fmt.Println("  -c <config>               Path to configuration file (default: pei.yaml)")
+	fmt.Println("  -c <config>               Path, or http(s):// or s3:// URL, of the configuration file (default: pei.yaml)")
+	fmt.Println("  -config-poll <duration>   How often to check a remote configuration for changes (default: 1m, 0 disables)")
+	fmt.Println("  -dry-run                  Show the startup plan instead of starting services")
+	fmt.Println("  -strict                   Reject unknown keys in the configuration file")
+	fmt.Println("  -template                 Render the configuration file as a Go template first (implied by .tmpl)")
+	fmt.Println("  -timeout <duration>       How long commands wait for the daemon to answer (default: 30s, 0 waits forever)")
+	fmt.Println("  -host <ssh://user@host>   Manage the daemon on another machine over SSH (default: $PEI_HOST)")
 	fmt.Println("  -help                     Show this help")
-	fmt.Println("\nSignals: HUP, TERM, KILL, USR1, USR2")
+	fmt.Println("\nrestart, start, stop, kill and signal take --all instead of service names, and --label name=value")
+	fmt.Println("to only act on services with that label (--group name for group=name, except on signal).")
+	fmt.Println("Services are started and restarted in start order, and stopped in reverse.")
+	fmt.Println("\nSignals: any signal name or number, e.g. HUP, SIGWINCH, QUIT, 15, RTMIN+2")
 	fmt.Println("\nExamples:")
 	fmt.Println("  pei list")
+	fmt.Println("  pei list -o name,state,cpu,mem --sort mem")
 	fmt.Println("  pei status echo")
 	fmt.Println("  pei restart echo")
+	fmt.Println("  pei restart web --reason \"deploying v1.2.3\"")
+	fmt.Println("  pei stop --force worker")
+	fmt.Println("  pei start worker")
+	fmt.Println("  pei run backup")
+	fmt.Println("  pei restart --all --group backend")
+	fmt.Println("  pei signal --all HUP")
 	fmt.Println("  pei signal echo:HUP")
+	fmt.Println("  pei signal web:TERM --group")
+	fmt.Println("  pei tail -f web worker --level warn")
+	fmt.Println("  pei boot-analyze")
+	fmt.Println("  pei graph | dot -Tsvg > services.svg")
+	fmt.Println("  pei --dry-run -c /etc/pei.yaml")
 	fmt.Println("  pei -c /etc/pei.yaml list")
+	fmt.Println("  pei --timeout 5s status web")
+}
+
+// appUserGroup returns the unprivileged user and group pei drops to
+func appUserGroup() (string, string) {
+	appUser := os.Getenv("PEI_APP_USER")
+	appGroup := os.Getenv("PEI_APP_GROUP")
+	if appUser == "" {
+		appUser = "appuser" // default
+	}
+	if appGroup == "" {
+		appGroup = "appuser" // default
+	}
+	return appUser, appGroup
 }
 
 func main() {
@@ -35,8 +91,14 @@ func main() {
 	initLogger()
 
 	// Parse global flags first
-	configPath := flag.String("c", "pei.yaml", "path to configuration file")
+	configPath := flag.String("c", "pei.yaml", "path or http(s):// or s3:// URL of the configuration file")
+	flag.DurationVar(&configPollInterval, "config-poll", defaultConfigPollInterval, "how often to check a remote configuration for changes (0 disables)")
 	helpFlag := flag.Bool("help", false, "show help information")
+	dryRunFlag := flag.Bool("dry-run", false, "show the startup plan without starting services")
+	flag.BoolVar(&strictConfig, "strict", false, "reject unknown keys in the configuration file")
+	flag.BoolVar(&templateConfig, "template", false, "render the configuration file as a Go template first")
+	flag.DurationVar(&ipcTimeout, "timeout", defaultIPCTimeout, "how long commands wait for the daemon to answer (0 waits forever)")
+	flag.StringVar(&remoteHost, "host", os.Getenv("PEI_HOST"), "manage the daemon on another machine, as ssh://user@host[:port]")
 	flag.Parse()
 
 	// Get remaining arguments after flags
@@ -47,13 +109,24 @@ func main() {
 		return
 	}
 
+	if *dryRunFlag {
+		args = []string{"plan"}
+	}
+
 	// Handle CLI operations - returns true if any CLI command was executed
 	if hasCommand := handleCLICommands(configPath, args); hasCommand {
 		return
 	}
 
-	// Check if we're running as PID 1 for daemon mode
-	if os.Getpid() != 1 {
+	// A remote daemon that can't be reached must not start one here
+	if remoteHost != "" {
+		fmt.Fprintf(os.Stderr, "Error: no command given, or the daemon on %s could not be reached\n", remoteHost)
+		os.Exit(1)
+	}
+
+	// Check if we're running as PID 1 for daemon mode. Without credential
+	// switching pei runs as an ordinary process, for local development.
+	if credentialSwitching && os.Getpid() != 1 {
 		fmt.Println("No pei daemon running. Available commands:")
 		fmt.Println("  pei list                    List all services and their status")
 		fmt.Println("  pei status [service]        Show detailed status for service")
@@ -64,7 +137,7 @@ func main() {
 	}
 
 	// Check if we have root privileges (effective UID)
-	if os.Geteuid() != 0 {
+	if credentialSwitching && os.Geteuid() != 0 {
 		slog.Error("pei must be run with root privileges")
 		os.Exit(1)
 	}
@@ -78,18 +151,19 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Set up app user/group
-	appUser := os.Getenv("PEI_APP_USER")
-	appGroup := os.Getenv("PEI_APP_GROUP")
-	if appUser == "" {
-		appUser = "appuser" // default
-	}
-	if appGroup == "" {
-		appGroup = "appuser" // default
+	// Tag every log record with container and pod metadata from here on
+	applyMetadata(config.Metadata, config.Kubernetes)
+
+	if !credentialSwitching {
+		slog.Warn("Running without credential switching, services run as the current user and their user and group are ignored",
+			"uid", os.Getuid())
 	}
 
+	// Set up app user/group
+	appUser, appGroup := appUserGroup()
+
 	// Create and start the daemon
-	daemon := NewDaemon(config, appUser, appGroup)
+	daemon := NewDaemon(config, *configPath, appUser, appGroup)
 	ctx := context.Background()
 	if err := daemon.Start(ctx); err != nil {
 		slog.Error("Daemon failed to start", "error", err)
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maintenanceRecheck is the longest pei goes without looking at maintenance
+// windows, so windows a reload adds or changes take effect
+const maintenanceRecheck = time.Minute
+
+// MaintenanceAction is what happens to automatic restarts during a
+// maintenance window
+type MaintenanceAction string
+
+const (
+	MaintenanceQueue    MaintenanceAction = "queue"    // hold them until the window closes
+	MaintenanceSuppress MaintenanceAction = "suppress" // drop them, leaving services as they are
+)
+
+// MaintenanceWindow is planned downtime, such as a database upgrade, during
+// which pei doesn't restart the services that depend on it by itself. A
+// window opens at Start for Duration: every day, or on Days, when Start is a
+// time of day, or once when it's an RFC 3339 timestamp.
+type MaintenanceWindow struct {
+	Name     string            `yaml:"name"`
+	Start    string            `yaml:"start"`
+	Duration time.Duration     `yaml:"duration"`
+	Days     []string          `yaml:"days"`     // weekdays a daily window opens on; every day if empty
+	Timezone string            `yaml:"timezone"` // a daily window's zone; local time if empty
+	Services []string          `yaml:"services"` // all services, if empty
+	Labels   map[string]string `yaml:"labels"`   // only services carrying these labels
+	Action   MaintenanceAction `yaml:"action"`   // queue, if empty
+}
+
+// maintenanceSchedule is when a maintenance window opens: once at at, or
+// daily at hour:minute in loc on days
+type maintenanceSchedule struct {
+	at           time.Time
+	hour, minute int
+	loc          *time.Location
+	days         []time.Weekday
+}
+
+// weekdays are the names days: accepts, such as mon and monday
+var weekdays = func() map[string]time.Weekday {
+	names := make(map[string]time.Weekday)
+	for day := range time.Weekday(7) {
+		name := strings.ToLower(day.String())
+		names[name], names[name[:3]] = day, day
+	}
+	return names
+}()
+
+// schedule parses when the window opens
+func (w MaintenanceWindow) schedule() (maintenanceSchedule, error) {
+	if at, err := time.Parse(time.RFC3339, w.Start); err == nil {
+		if len(w.Days) > 0 || w.Timezone != "" {
+			return maintenanceSchedule{}, fmt.Errorf("days and timezone only apply to daily windows, not one starting at a timestamp")
+		}
+		return maintenanceSchedule{at: at}, nil
+	}
+
+	hours, minutes, found := strings.Cut(w.Start, ":")
+	hour, hourErr := strconv.Atoi(hours)
+	minute, minuteErr := strconv.Atoi(minutes)
+	if !found || hourErr != nil || minuteErr != nil || hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return maintenanceSchedule{}, fmt.Errorf("start %q must be a time of day such as 02:30, or an RFC 3339 timestamp", w.Start)
+	}
+	sched := maintenanceSchedule{hour: hour, minute: minute, loc: time.Local}
+	if w.Timezone != "" {
+		loc, err := time.LoadLocation(w.Timezone)
+		if err != nil {
+			return maintenanceSchedule{}, fmt.Errorf("unknown timezone %q", w.Timezone)
+		}
+		sched.loc = loc
+	}
+	for _, name := range w.Days {
+		day, exists := weekdays[strings.ToLower(name)]
+		if !exists {
+			return maintenanceSchedule{}, fmt.Errorf("unknown day %q", name)
+		}
+		sched.days = append(sched.days, day)
+	}
+	return sched, nil
+}
+
+// opensOn reports whether a daily window opens on a day
+func (s maintenanceSchedule) opensOn(day time.Weekday) bool {
+	return len(s.days) == 0 || slices.Contains(s.days, day)
+}
+
+// active reports whether the window is open at t, and if so when it closes
+func (w MaintenanceWindow) active(t time.Time) (bool, time.Time) {
+	sched, err := w.schedule()
+	if err != nil {
+		return false, time.Time{}
+	}
+	if !sched.at.IsZero() {
+		end := sched.at.Add(w.Duration)
+		return !t.Before(sched.at) && t.Before(end), end
+	}
+
+	// A window can still be open from yesterday, or from days ago if it
+	// lasts longer than a day
+	local := t.In(sched.loc)
+	var end time.Time
+	for back := int(w.Duration/(24*time.Hour)) + 1; back >= 0; back-- {
+		open := time.Date(local.Year(), local.Month(), local.Day()-back, sched.hour, sched.minute, 0, 0, sched.loc)
+		if sched.opensOn(open.Weekday()) && !t.Before(open) && t.Before(open.Add(w.Duration)) {
+			end = open.Add(w.Duration)
+		}
+	}
+	return !end.IsZero(), end
+}
+
+// next is when the window next opens after t, or zero if it never does again
+func (w MaintenanceWindow) next(t time.Time) time.Time {
+	sched, err := w.schedule()
+	if err != nil {
+		return time.Time{}
+	}
+	if !sched.at.IsZero() {
+		if sched.at.After(t) {
+			return sched.at
+		}
+		return time.Time{}
+	}
+	local := t.In(sched.loc)
+	for ahead := range 8 {
+		open := time.Date(local.Year(), local.Month(), local.Day()+ahead, sched.hour, sched.minute, 0, 0, sched.loc)
+		if open.After(t) && sched.opensOn(open.Weekday()) {
+			return open
+		}
+	}
+	return time.Time{}
+}
+
+// covers reports whether the window applies to a service
+func (w MaintenanceWindow) covers(svc Service) bool {
+	return (len(w.Services) == 0 || slices.Contains(w.Services, svc.Name)) && hasLabels(svc, w.Labels)
+}
+
+// validateMaintenance checks the maintenance windows
+func (c *Config) validateMaintenance() error {
+	seen := make(map[string]bool)
+	for i, w := range c.Maintenance {
+		path := []string{"maintenance", listIndex(i)}
+		if w.Name == "" {
+			return fieldErrorf(append(path, "name"), "is required")
+		}
+		if seen[w.Name] {
+			return fieldErrorf(append(path, "name"), "duplicate maintenance window %q", w.Name)
+		}
+		seen[w.Name] = true
+		if w.Start == "" {
+			return fieldErrorf(append(path, "start"), "is required")
+		}
+		if _, err := w.schedule(); err != nil {
+			return fieldErrorf(append(path, "start"), "%v", err)
+		}
+		if w.Duration <= 0 {
+			return fieldErrorf(append(path, "duration"), "must be positive")
+		}
+		for j, name := range w.Services {
+			if _, exists := c.Services[name]; !exists {
+				return fieldErrorf(append(path, "services", listIndex(j)), "unknown service %q", name)
+			}
+		}
+		switch w.Action {
+		case "", MaintenanceQueue, MaintenanceSuppress:
+		default:
+			return fieldErrorf(append(path, "action"), "must be queue or suppress")
+		}
+	}
+	return nil
+}
+
+// maintenanceWindow returns the open maintenance window covering a service
+// at t and when it closes, or nil if there is none. Of several, it returns
+// the one that closes last.
+func (c *Config) maintenanceWindow(svc Service, t time.Time) (*MaintenanceWindow, time.Time) {
+	var window *MaintenanceWindow
+	var closes time.Time
+	for i, w := range c.Maintenance {
+		if !w.covers(svc) {
+			continue
+		}
+		if active, end := w.active(t); active && end.After(closes) {
+			window, closes = &c.Maintenance[i], end
+		}
+	}
+	return window, closes
+}
+
+// holdForMaintenance keeps pei from restarting a service by itself while a
+// maintenance window covers it: the restart is held until the window closes,
+// or dropped if the window suppresses restarts. It reports whether it took
+// the restart. A restart someone asks for goes ahead, taking any restart
+// held for the service with it.
+func (d *Daemon) holdForMaintenance(req *restartRequest) bool {
+	name := req.svc.Name
+	d.mu.Lock()
+	if held, exists := d.heldRestarts[name]; exists {
+		delete(d.heldRestarts, name)
+		*req = held.merge(*req)
+	}
+	d.mu.Unlock()
+
+	window, closes := d.getConfig().maintenanceWindow(req.svc, time.Now())
+	if window == nil || !req.reason.automatic() {
+		return false
+	}
+	fields := map[string]string{"window": window.Name, "reason": string(req.reason)}
+	running := false
+	if proc, exists := d.getServiceProcess(name); exists {
+		running = proc.running()
+	}
+
+	if window.Action == MaintenanceSuppress {
+		logServiceInfo(name, "Maintenance window suppressed restart", "window", window.Name, "reason", string(req.reason))
+		d.events.record(EventRestartSuppressed, name, "Restart suppressed by maintenance window", fields)
+		if !running {
+			d.setState(req.svc, StateStopped)
+		}
+		return true
+	}
+
+	d.mu.Lock()
+	d.heldRestarts[name] = *req
+	d.mu.Unlock()
+
+	if !running {
+		d.setNextRestart(req.svc, time.Until(closes))
+	}
+	fields["until"] = closes.Format(time.RFC3339)
+	logServiceInfo(name, "Maintenance window holding restart", "window", window.Name, "reason", string(req.reason),
+		"until", closes.Format(time.RFC3339))
+	d.events.record(EventRestartHeld, name, "Restart held until maintenance window closes", fields)
+	return true
+}
+
+// releaseHeldRestarts queues the restarts held for services no maintenance
+// window covers any more
+func (d *Daemon) releaseHeldRestarts(config *Config, now time.Time) {
+	var released []restartRequest
+	d.mu.Lock()
+	for name, req := range d.heldRestarts {
+		svc, exists := config.Services[name]
+		if exists {
+			if window, _ := config.maintenanceWindow(svc, now); window != nil {
+				continue
+			}
+			released = append(released, req)
+		}
+		delete(d.heldRestarts, name)
+	}
+	d.mu.Unlock()
+
+	for _, req := range released {
+		logServiceInfo(req.svc.Name, "Maintenance over, releasing held restart", "reason", string(req.reason))
+		d.requestRestart(req)
+	}
+}
+
+// runMaintenance records maintenance windows opening and closing, and
+// releases the restarts they held as they close, until shutdown begins
+func (d *Daemon) runMaintenance() {
+	open := make(map[string]bool)
+	for {
+		now := time.Now()
+		config := d.getConfig()
+		wait := maintenanceRecheck
+		current := make(map[string]bool)
+		for _, w := range config.Maintenance {
+			if active, closes := w.active(now); active {
+				current[w.Name] = true
+				if !open[w.Name] {
+					getLogger("maintenance").Info("Maintenance window opened", "window", w.Name, "until", closes.Format(time.RFC3339))
+					d.events.record(EventMaintenanceStarted, "", "Maintenance window opened",
+						map[string]string{"window": w.Name, "until": closes.Format(time.RFC3339)})
+				}
+				wait = min(wait, closes.Sub(now))
+			} else if opens := w.next(now); !opens.IsZero() {
+				wait = min(wait, opens.Sub(now))
+			}
+		}
+		for name := range open {
+			if !current[name] {
+				getLogger("maintenance").Info("Maintenance window closed", "window", name)
+				d.events.record(EventMaintenanceEnded, "", "Maintenance window closed", map[string]string{"window": name})
+			}
+		}
+		open = current
+		d.releaseHeldRestarts(config, now)
+
+		if !d.sleep(wait) {
+			return
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindowActive(t *testing.T) {
+	utc := func(s string) time.Time {
+		at, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return at
+	}
+
+	// 2024-01-06 is a Saturday
+	weekend := MaintenanceWindow{Start: "23:00", Duration: 3 * time.Hour, Days: []string{"sat", "Sunday"}, Timezone: "UTC"}
+	tests := []struct {
+		at     string
+		active bool
+		closes string
+	}{
+		{"2024-01-06T22:59:00Z", false, ""},
+		{"2024-01-06T23:00:00Z", true, "2024-01-07T02:00:00Z"},
+		{"2024-01-07T01:59:00Z", true, "2024-01-07T02:00:00Z"},
+		{"2024-01-07T02:00:00Z", false, ""},
+		{"2024-01-08T00:30:00Z", true, "2024-01-08T02:00:00Z"}, // opened Sunday night
+		{"2024-01-08T23:30:00Z", false, ""},                    // Monday doesn't open
+	}
+	for _, tt := range tests {
+		active, closes := weekend.active(utc(tt.at))
+		if active != tt.active || (active && !closes.Equal(utc(tt.closes))) {
+			t.Errorf("at %s: expected %v until %s, got %v until %s", tt.at, tt.active, tt.closes, active, closes)
+		}
+	}
+	if next := weekend.next(utc("2024-01-08T00:30:00Z")); !next.Equal(utc("2024-01-13T23:00:00Z")) {
+		t.Errorf("expected the window to open next on Saturday, got %s", next)
+	}
+
+	// A daily window can last longer than a day
+	long := MaintenanceWindow{Start: "12:00", Duration: 30 * time.Hour, Days: []string{"mon"}, Timezone: "UTC"}
+	if active, closes := long.active(utc("2024-01-09T17:00:00Z")); !active || !closes.Equal(utc("2024-01-09T18:00:00Z")) {
+		t.Errorf("expected Monday's window open until Tuesday evening, got %v until %s", active, closes)
+	}
+
+	once := MaintenanceWindow{Start: "2024-03-01T10:00:00+01:00", Duration: time.Hour}
+	if active, _ := once.active(utc("2024-03-01T09:30:00Z")); !active {
+		t.Error("expected a one-off window open during it")
+	}
+	if next := once.next(utc("2024-03-01T09:30:00Z")); !next.IsZero() {
+		t.Errorf("expected a one-off window not to open again, got %s", next)
+	}
+}
+
+func TestValidateMaintenance(t *testing.T) {
+	tests := []struct {
+		window MaintenanceWindow
+		err    string
+	}{
+		{MaintenanceWindow{Name: "db", Start: "02:00", Duration: time.Hour, Services: []string{"web"}}, ""},
+		{MaintenanceWindow{Name: "db", Start: "2024-03-01T10:00:00Z", Duration: time.Hour, Action: MaintenanceSuppress}, ""},
+		{MaintenanceWindow{Start: "02:00", Duration: time.Hour}, "name: is required"},
+		{MaintenanceWindow{Name: "db", Start: "2am", Duration: time.Hour}, "must be a time of day"},
+		{MaintenanceWindow{Name: "db", Start: "24:00", Duration: time.Hour}, "must be a time of day"},
+		{MaintenanceWindow{Name: "db", Start: "02:00", Duration: time.Hour, Days: []string{"someday"}}, `unknown day "someday"`},
+		{MaintenanceWindow{Name: "db", Start: "02:00", Duration: time.Hour, Timezone: "Mars/Olympus"}, "unknown timezone"},
+		{MaintenanceWindow{Name: "db", Start: "2024-03-01T10:00:00Z", Duration: time.Hour, Days: []string{"mon"}}, "only apply to daily windows"},
+		{MaintenanceWindow{Name: "db", Start: "02:00"}, "duration: must be positive"},
+		{MaintenanceWindow{Name: "db", Start: "02:00", Duration: time.Hour, Services: []string{"api"}}, `unknown service "api"`},
+		{MaintenanceWindow{Name: "db", Start: "02:00", Duration: time.Hour, Action: "pause"}, "must be queue or suppress"},
+	}
+	for _, tt := range tests {
+		config := &Config{Services: map[string]Service{"web": {Name: "web"}}, Maintenance: []MaintenanceWindow{tt.window}}
+		err := config.validateMaintenance()
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%+v: unexpected error: %v", tt.window, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%+v: expected error containing %q, got %v", tt.window, tt.err, err)
+		}
+	}
+}
+
+func TestHoldForMaintenance(t *testing.T) {
+	web := Service{Name: "web", Labels: map[string]string{"tier": "app"}}
+	db := Service{Name: "db"}
+	config := &Config{
+		Services: map[string]Service{"web": web, "db": db},
+		Maintenance: []MaintenanceWindow{{
+			Name:     "upgrade",
+			Start:    time.Now().Add(-time.Minute).Format(time.RFC3339),
+			Duration: time.Hour,
+			Labels:   map[string]string{"tier": "app"},
+		}},
+	}
+	d := NewDaemon(config, "", "", "")
+	defer d.cancel()
+	d.setState(web, StateBackoff)
+
+	// Automatic restarts of covered services are held, and merge while held
+	crash := restartRequest{svc: web, reason: RestartReasonCrash}
+	if !d.holdForMaintenance(&crash) {
+		t.Fatal("expected the crash restart held")
+	}
+	health := restartRequest{svc: web, reason: RestartReasonHealthCheck}
+	if !d.holdForMaintenance(&health) || len(d.heldRestarts) != 1 {
+		t.Fatalf("expected one restart held, got %+v", d.heldRestarts)
+	}
+	if status, _ := d.getServiceStatus("web"); status.NextRestart.IsZero() {
+		t.Error("expected the service's next restart at the window's close")
+	}
+	if events := d.events.list("web", 0); len(events) != 2 || events[0].Type != EventRestartHeld || events[0].Fields["window"] != "upgrade" {
+		t.Errorf("expected restart_held events, got %+v", events)
+	}
+
+	// Services the window doesn't cover restart as usual
+	other := restartRequest{svc: db, reason: RestartReasonCrash}
+	if d.holdForMaintenance(&other) {
+		t.Error("expected a service outside the window to restart")
+	}
+
+	// An operator's restart goes ahead, taking the held one with it
+	operator := restartRequest{svc: web, reason: RestartReasonOperator}
+	if d.holdForMaintenance(&operator) {
+		t.Fatal("expected an operator's restart to go ahead")
+	}
+	if len(d.heldRestarts) != 0 || len(operator.coalesced) != 2 {
+		t.Errorf("expected the held restart merged into the operator's, got %+v", operator)
+	}
+
+	// Held restarts are released once no window covers them
+	d.holdForMaintenance(&restartRequest{svc: web, reason: RestartReasonFailure})
+	d.releaseHeldRestarts(config, time.Now())
+	if len(d.heldRestarts) != 1 {
+		t.Fatal("expected the restart still held while the window is open")
+	}
+	d.releaseHeldRestarts(config, time.Now().Add(2*time.Hour))
+	if len(d.heldRestarts) != 0 || d.restarts.depth() != 1 {
+		t.Errorf("expected the held restart queued once the window closed, got %d queued", d.restarts.depth())
+	}
+
+	// A suppressing window drops restarts
+	config.Maintenance[0].Action = MaintenanceSuppress
+	if !d.holdForMaintenance(&restartRequest{svc: web, reason: RestartReasonCrash}) || len(d.heldRestarts) != 0 {
+		t.Error("expected the restart dropped")
+	}
+	if d.serviceState("web") != StateStopped {
+		t.Errorf("expected a suppressed service left stopped, got %s", d.serviceState("web"))
+	}
+}
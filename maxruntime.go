@@ -0,0 +1,105 @@
+package main
+
+import (
+	"time"
+)
+
+// What happens to a service that reaches its max_runtime
+const (
+	MaxRuntimeStop    = "stop"    // stop it and leave it stopped; the default
+	MaxRuntimeRestart = "restart" // replace it with a fresh instance
+)
+
+// stopRequest asks the service manager to stop a service's instance and
+// leave the service stopped, or to kill it
+type stopRequest struct {
+	svc      Service
+	instance *serviceProcess
+	mode     stopMode
+
+	// operator is set for pei stop, which stops whichever instance is
+	// current and leaves the service stopped until pei start
+	operator bool
+
+	// done is closed once the request is carried out, if set
+	done chan struct{}
+}
+
+// validateMaxRuntime checks max_runtime and max_runtime_action
+func (c *Config) validateMaxRuntime() error {
+	for name, svc := range c.Services {
+		if svc.MaxRuntime < 0 {
+			return serviceErrorf(name, "max_runtime", "must not be negative")
+		}
+		switch svc.MaxRuntimeAction {
+		case "", MaxRuntimeStop, MaxRuntimeRestart:
+		default:
+			return serviceErrorf(name, "max_runtime_action", "must be stop or restart")
+		}
+		if svc.MaxRuntimeAction != "" && svc.MaxRuntime == 0 {
+			return serviceErrorf(name, "max_runtime_action", "needs max_runtime")
+		}
+		if svc.MaxRuntime > 0 && (svc.OnDemand || svc.Spawn != "") {
+			return serviceErrorf(name, "max_runtime", "is not supported for on_demand or spawn services")
+		}
+	}
+	return nil
+}
+
+// enforceMaxRuntime stops or restarts an instance once it has run for its
+// service's max_runtime, unless it exits or shutdown begins first
+func (d *Daemon) enforceMaxRuntime(svc Service, proc *serviceProcess) {
+	timer := time.NewTimer(svc.MaxRuntime)
+	defer timer.Stop()
+	select {
+	case <-proc.exited:
+		return
+	case <-d.ctx.Done():
+		return
+	case <-timer.C:
+	}
+
+	action := svc.MaxRuntimeAction
+	if action == "" {
+		action = MaxRuntimeStop
+	}
+	logServiceInfo(svc.Name, "Service reached its max_runtime",
+		"pid", proc.cmd.Process.Pid,
+		"max_runtime", svc.MaxRuntime.String(),
+		"action", action)
+
+	if action == MaxRuntimeRestart {
+		d.requestRestart(restartRequest{svc: svc, reason: RestartReasonMaxRuntime, detail: svc.MaxRuntime.String(), instance: proc})
+		return
+	}
+	select {
+	case d.stopChan <- stopRequest{svc: svc, instance: proc}:
+	case <-d.ctx.Done():
+	}
+}
+
+// stopInstance stops a service's instance on purpose and leaves the service
+// stopped, unless the instance has already exited or been replaced. Must be
+// called with elevated privileges.
+func (d *Daemon) stopInstance(req stopRequest) {
+	unlock := d.lockService(req.svc.Name)
+	defer unlock()
+
+	proc, exists := d.getServiceProcess(req.svc.Name)
+	if req.operator {
+		defer d.disableService(req.svc)
+		req.instance = proc
+	}
+	if !exists || proc != req.instance || !proc.running() {
+		return
+	}
+	switch req.mode {
+	case stopForce:
+		d.forceStopProcess(req.svc.Name, proc)
+	case stopKill:
+		d.killProcess(req.svc.Name, proc)
+	default:
+		d.stopProcess(req.svc.Name, proc, serviceStopTimeout)
+		d.events.record(EventServiceStopped, req.svc.Name, "Service stopped", map[string]string{"mode": stopGraceful.String()})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnforceMaxRuntime(t *testing.T) {
+	svc := Service{Name: "worker", MaxRuntime: 10 * time.Millisecond}
+	d := NewDaemon(&Config{Services: map[string]Service{"worker": svc}}, "", "", "")
+	defer d.cancel()
+	proc := &serviceProcess{cmd: &exec.Cmd{Process: &os.Process{Pid: 42}}, exited: make(chan struct{})}
+
+	// By default the instance is stopped, through the service manager
+	go d.enforceMaxRuntime(svc, proc)
+	select {
+	case req := <-d.stopChan:
+		if req.instance != proc {
+			t.Errorf("expected a stop of the instance, got %+v", req)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a stop request")
+	}
+
+	svc.MaxRuntimeAction = MaxRuntimeRestart
+	d.enforceMaxRuntime(svc, proc)
+	req, ok := d.restarts.pop()
+	if !ok || req.reason != RestartReasonMaxRuntime || req.instance != proc {
+		t.Errorf("expected a max-runtime restart, got %+v", req)
+	}
+
+	// Instances that exit first are left alone
+	close(proc.exited)
+	d.enforceMaxRuntime(svc, proc)
+	if d.restarts.depth() != 0 {
+		t.Error("expected no restart for an exited instance")
+	}
+}
+
+func TestValidateMaxRuntime(t *testing.T) {
+	cases := []struct {
+		svc Service
+		err string
+	}{
+		{Service{MaxRuntime: -time.Hour}, "must not be negative"},
+		{Service{MaxRuntime: time.Hour, MaxRuntimeAction: "kill"}, "must be stop or restart"},
+		{Service{MaxRuntimeAction: MaxRuntimeRestart}, "needs max_runtime"},
+		{Service{MaxRuntime: time.Hour, Spawn: SpawnPerConnection}, "not supported"},
+	}
+	for _, c := range cases {
+		config := &Config{Services: map[string]Service{"worker": c.svc}}
+		if err := config.validateMaxRuntime(); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%+v: expected error containing %q, got %v", c.svc, c.err, err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"log/slog"
+	"maps"
+	"os"
+	"regexp"
+	"sort"
+)
+
+// containerIDPattern matches the 64 hex digit IDs Docker, containerd and
+// CRI-O put in cgroup paths and mount sources
+var containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// Metadata configures container-level fields added to every log record pei
+// emits, so logs from many containers can be told apart
+type Metadata struct {
+	Hostname    bool              `yaml:"hostname"`
+	ContainerID bool              `yaml:"container_id"`
+	ImageEnv    string            `yaml:"image_env"`
+	Env         map[string]string `yaml:"env"`
+	Static      map[string]string `yaml:"static"`
+}
+
+// fields resolves the configured metadata to field names and values. Fields
+// that can't be determined are left out.
+func (m *Metadata) fields() map[string]string {
+	fields := make(map[string]string)
+	for name, value := range m.Static {
+		fields[name] = value
+	}
+	for name, env := range m.Env {
+		if value := os.Getenv(env); value != "" {
+			fields[name] = value
+		}
+	}
+	if m.ImageEnv != "" {
+		if image := os.Getenv(m.ImageEnv); image != "" {
+			fields["image"] = image
+		}
+	}
+	if m.Hostname {
+		if hostname, err := os.Hostname(); err == nil {
+			fields["hostname"] = hostname
+		}
+	}
+	if m.ContainerID {
+		if id := detectContainerID(); id != "" {
+			fields["container_id"] = id
+		}
+	}
+	return fields
+}
+
+// detectContainerID finds the ID of the container pei runs in, preferring
+// PEI_CONTAINER_ID, then the cgroup path, then the mount table
+func detectContainerID() string {
+	if id := os.Getenv("PEI_CONTAINER_ID"); id != "" {
+		return id
+	}
+	for _, path := range []string{"/proc/self/cgroup", "/proc/self/mountinfo"} {
+		if data, err := os.ReadFile(path); err == nil {
+			if id := containerIDPattern.FindString(string(data)); id != "" {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// applyMetadata adds the configured metadata, and the pod's when running in
+// Kubernetes, to every record logged from now on, under a "metadata" group
+func applyMetadata(metadata *Metadata, kubernetes *Kubernetes) {
+	fields := make(map[string]string)
+	if kubernetes != nil {
+		fields = kubernetes.fields(kubernetes.pod())
+	}
+	if metadata != nil {
+		maps.Copy(fields, metadata.fields())
+	}
+	if len(fields) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	attrs := make([]any, 0, len(names))
+	for _, name := range names {
+		attrs = append(attrs, slog.String(name, fields[name]))
+	}
+	slog.SetDefault(slog.Default().With(slog.Group("metadata", attrs...)))
+}
+
+// validateMetadata checks the metadata field names
+func (c *Config) validateMetadata() error {
+	if c.Metadata == nil {
+		return nil
+	}
+	for key, fields := range map[string]map[string]string{"static": c.Metadata.Static, "env": c.Metadata.Env} {
+		for name := range fields {
+			if !labelNamePattern.MatchString(name) {
+				return fieldErrorf([]string{"metadata", key, name}, "field %q must be letters, digits and underscores, not starting with a digit", name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestMetadataFields(t *testing.T) {
+	t.Setenv("TEST_IMAGE_TAG", "app:1.2.3")
+	t.Setenv("TEST_REGION", "eu-west-1")
+	t.Setenv("PEI_CONTAINER_ID", "abc123")
+
+	metadata := &Metadata{
+		ContainerID: true,
+		ImageEnv:    "TEST_IMAGE_TAG",
+		Env:         map[string]string{"region": "TEST_REGION", "zone": "TEST_UNSET_ZONE"},
+		Static:      map[string]string{"cluster": "prod"},
+	}
+	fields := metadata.fields()
+
+	want := map[string]string{
+		"container_id": "abc123",
+		"image":        "app:1.2.3",
+		"region":       "eu-west-1",
+		"cluster":      "prod",
+	}
+	if len(fields) != len(want) {
+		t.Errorf("Expected fields %v, got %v", want, fields)
+	}
+	for name, value := range want {
+		if fields[name] != value {
+			t.Errorf("Expected %s=%q, got %q", name, value, fields[name])
+		}
+	}
+}
+
+func TestContainerIDPattern(t *testing.T) {
+	id := "4f1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c"
+	line := "0::/system.slice/docker-" + id + ".scope\n"
+	if got := containerIDPattern.FindString(line); got != id {
+		t.Errorf("Expected container ID %s, got %q", id, got)
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MetricsConfig serves Prometheus metrics over HTTP. Metrics hold no
+// secrets, so the endpoint has no authentication; bind it to an address
+// only your scraper can reach.
+type MetricsConfig struct {
+	Address string `yaml:"address"` // tcp://host:port
+}
+
+// validateMetrics checks the metrics settings
+func (c *Config) validateMetrics() error {
+	if c.Metrics == nil {
+		return nil
+	}
+	network, _, err := parseListenAddress(c.Metrics.Address)
+	if err != nil {
+		return fieldErrorf([]string{"metrics", "address"}, "%v", err)
+	}
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return fieldErrorf([]string{"metrics", "address"}, "must be a tcp:// address")
+	}
+	return nil
+}
+
+// reapStats counts the reaper's work, updated by the reaper and read by
+// metrics without a lock
+type reapStats struct {
+	passes      atomic.Uint64
+	reaped      atomic.Uint64
+	lastLatency atomic.Int64 // nanoseconds from SIGCHLD to the pass finishing
+	maxLatency  atomic.Int64
+}
+
+func (s *reapStats) record(reaped int, latency time.Duration) {
+	s.passes.Add(1)
+	s.reaped.Add(uint64(reaped))
+	s.lastLatency.Store(int64(latency))
+	for {
+		current := s.maxLatency.Load()
+		if int64(latency) <= current || s.maxLatency.CompareAndSwap(current, int64(latency)) {
+			return
+		}
+	}
+}
+
+// DaemonMetrics is pei's view of its own health
+type DaemonMetrics struct {
+	Uptime     time.Duration `json:"uptime"`
+	Goroutines int           `json:"goroutines"`
+	HeapBytes  uint64        `json:"heap_bytes"`
+	SysBytes   uint64        `json:"sys_bytes"`
+	GCCycles   uint32        `json:"gc_cycles"`
+	OpenFDs    int           `json:"open_fds"` // -1 where the platform doesn't say
+
+	RestartQueueDepth int    `json:"restart_queue_depth"`
+	RestartsMerged    uint64 `json:"restarts_merged"`
+	RestartsProcessed uint64 `json:"restarts_processed"`
+
+	ReapPasses      uint64        `json:"reap_passes"`
+	Reaped          uint64        `json:"reaped"`
+	LastReapLatency time.Duration `json:"last_reap_latency"`
+	MaxReapLatency  time.Duration `json:"max_reap_latency"`
+
+	Listeners []ListenerMetrics `json:"listeners"`
+	Services  []ServiceMetrics  `json:"services"`
+
+	// Pod holds the pod's details when running in Kubernetes
+	Pod map[string]string `json:"pod,omitempty"`
+}
+
+// ServiceMetrics is one service's resource use, summed over its instances:
+// its current one, or those running for connections
+type ServiceMetrics struct {
+	Name     string            `json:"name"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Up       bool              `json:"up"`
+	Restarts int               `json:"restarts"`
+	Health   *HealthStatus     `json:"health,omitempty"`
+	processUsage
+}
+
+// processUsage is what a process is using. Fields are -1 where the platform
+// or pei's privileges don't say.
+type processUsage struct {
+	CPUSeconds  float64 `json:"cpu_seconds"`
+	MemoryBytes int64   `json:"memory_bytes"`
+	OpenFDs     int     `json:"open_fds"`
+}
+
+// add sums usage, staying unknown if either side is
+func (u *processUsage) add(other processUsage) {
+	if u.CPUSeconds >= 0 {
+		u.CPUSeconds = addKnown(u.CPUSeconds, other.CPUSeconds)
+	}
+	if u.MemoryBytes >= 0 {
+		u.MemoryBytes = addKnown(u.MemoryBytes, other.MemoryBytes)
+	}
+	if u.OpenFDs >= 0 {
+		u.OpenFDs = addKnown(u.OpenFDs, other.OpenFDs)
+	}
+}
+
+func addKnown[T int | int64 | float64](a, b T) T {
+	if b < 0 {
+		return -1
+	}
+	return a + b
+}
+
+// ListenerMetrics counts one control listener's traffic
+type ListenerMetrics struct {
+	Name               string `json:"name"`
+	Connections        int    `json:"connections"`
+	MaxConnections     int    `json:"max_connections"`
+	Requests           uint64 `json:"requests"`
+	RateLimited        uint64 `json:"rate_limited"`
+	RefusedConnections uint64 `json:"refused_connections"`
+}
+
+// selfMetrics collects the daemon's own metrics
+func (d *Daemon) selfMetrics() *DaemonMetrics {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	metrics := &DaemonMetrics{
+		Uptime:            time.Since(d.startedAt).Round(time.Second),
+		Goroutines:        runtime.NumGoroutine(),
+		HeapBytes:         mem.HeapAlloc,
+		SysBytes:          mem.Sys,
+		GCCycles:          mem.NumGC,
+		OpenFDs:           openFDCount(),
+		RestartQueueDepth: d.restarts.depth(),
+		RestartsMerged:    d.restarts.merged.Load(),
+		RestartsProcessed: d.restarts.processed.Load(),
+		ReapPasses:        d.reapStats.passes.Load(),
+		Reaped:            d.reapStats.reaped.Load(),
+		LastReapLatency:   time.Duration(d.reapStats.lastLatency.Load()),
+		MaxReapLatency:    time.Duration(d.reapStats.maxLatency.Load()),
+	}
+	metrics.Listeners = append(metrics.Listeners, d.ipcLimiter.metrics("local"))
+	for _, listener := range d.apiListeners {
+		metrics.Listeners = append(metrics.Listeners, listener.limiter.metrics(listener.address))
+	}
+	metrics.Services = d.serviceMetrics()
+	if k := d.getConfig().Kubernetes; k != nil {
+		metrics.Pod = k.fields(k.pod())
+	}
+	return metrics
+}
+
+// serviceMetrics collects every service's resource use, sorted by name
+func (d *Daemon) serviceMetrics() []ServiceMetrics {
+	config := d.getConfig()
+	var services []ServiceMetrics
+	for name, status := range d.getAllServiceStatus() {
+		svc, exists := config.Services[name]
+		if !exists {
+			continue
+		}
+		services = append(services, ServiceMetrics{
+			Name:         name,
+			Labels:       svc.Labels,
+			Up:           status.State.running(),
+			Restarts:     status.Restarts,
+			Health:       status.Health,
+			processUsage: d.serviceUsage(name),
+		})
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services
+}
+
+// serviceUsage sums the resource use of a service's running instances: its
+// current one, or those running for connections
+func (d *Daemon) serviceUsage(name string) processUsage {
+	var usage processUsage
+	instances := d.connectionInstances(name)
+	if proc, exists := d.getServiceProcess(name); exists {
+		instances = append(instances, proc)
+	}
+	for _, proc := range instances {
+		if proc.running() {
+			usage.add(processResources(proc.cmd.Process.Pid))
+		}
+	}
+	return usage
+}
+
+// writePrometheus writes metrics in the Prometheus text format
+func (m *DaemonMetrics) writePrometheus(w io.Writer) {
+	gauge := func(name, help string, value any) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, value)
+	}
+	counter := func(name, help string, value any) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %v\n", name, help, name, name, value)
+	}
+	gauge("pei_uptime_seconds", "Seconds since the daemon started.", m.Uptime.Seconds())
+	gauge("pei_goroutines", "Goroutines in the daemon.", m.Goroutines)
+	gauge("pei_heap_bytes", "Bytes of allocated heap objects.", m.HeapBytes)
+	gauge("pei_sys_bytes", "Bytes of memory obtained from the OS.", m.SysBytes)
+	counter("pei_gc_cycles_total", "Completed garbage collection cycles.", m.GCCycles)
+	if m.OpenFDs >= 0 {
+		gauge("pei_open_fds", "Open file descriptors.", m.OpenFDs)
+	}
+	gauge("pei_restart_queue_depth", "Restarts waiting for the service manager.", m.RestartQueueDepth)
+	counter("pei_restarts_merged_total", "Restarts merged into one already waiting for the same service.", m.RestartsMerged)
+	counter("pei_restarts_processed_total", "Restarts taken from the queue by the service manager.", m.RestartsProcessed)
+	counter("pei_reap_passes_total", "Times the reaper collected exited children.", m.ReapPasses)
+	counter("pei_reaped_processes_total", "Exited children collected by the reaper.", m.Reaped)
+	gauge("pei_reap_latency_seconds", "Seconds from SIGCHLD to the last reap finishing.", m.LastReapLatency.Seconds())
+	gauge("pei_reap_latency_max_seconds", "Longest seconds from SIGCHLD to a reap finishing.", m.MaxReapLatency.Seconds())
+	if len(m.Pod) > 0 {
+		var labels []string
+		for _, name := range slices.Sorted(maps.Keys(m.Pod)) {
+			labels = append(labels, fmt.Sprintf("%s=%q", name, m.Pod[name]))
+		}
+		fmt.Fprintf(w, "# HELP pei_pod_info The pod pei runs in, as labels; join on it to attribute pei's metrics.\n# TYPE pei_pod_info gauge\npei_pod_info{%s} 1\n",
+			strings.Join(labels, ","))
+	}
+
+	perListener := func(name, kind, help string, value func(ListenerMetrics) any) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, listener := range m.Listeners {
+			fmt.Fprintf(w, "%s{listener=%q} %v\n", name, listener.Name, value(listener))
+		}
+	}
+	perListener("pei_ipc_connections", "gauge", "Open control connections.", func(l ListenerMetrics) any { return l.Connections })
+	perListener("pei_ipc_requests_total", "counter", "Control requests received.", func(l ListenerMetrics) any { return l.Requests })
+	perListener("pei_ipc_rate_limited_total", "counter", "Control requests refused by the rate limit.", func(l ListenerMetrics) any { return l.RateLimited })
+	perListener("pei_ipc_refused_connections_total", "counter", "Control connections refused at max_connections.", func(l ListenerMetrics) any { return l.RefusedConnections })
+
+	perService := func(name, kind, help string, value func(ServiceMetrics) (any, bool)) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, service := range m.Services {
+			if v, known := value(service); known {
+				fmt.Fprintf(w, "%s{%s} %v\n", name, service.promLabels(), v)
+			}
+		}
+	}
+	perService("pei_service_up", "gauge", "Whether the service has a running instance.", func(s ServiceMetrics) (any, bool) {
+		if s.Up {
+			return 1, true
+		}
+		return 0, true
+	})
+	perService("pei_service_restarts_total", "counter", "Times the service has been restarted.", func(s ServiceMetrics) (any, bool) { return s.Restarts, true })
+	perService("pei_service_cpu_seconds_total", "counter", "CPU seconds used by the service's running instances.", func(s ServiceMetrics) (any, bool) { return s.CPUSeconds, s.CPUSeconds >= 0 })
+	perService("pei_service_memory_bytes", "gauge", "Resident memory of the service's running instances.", func(s ServiceMetrics) (any, bool) { return s.MemoryBytes, s.MemoryBytes >= 0 })
+	perService("pei_service_open_fds", "gauge", "File descriptors open in the service's running instances.", func(s ServiceMetrics) (any, bool) { return s.OpenFDs, s.OpenFDs >= 0 })
+
+	// Health, for services whose check has run
+	perHealth := func(name, kind, help string, value func(*HealthStatus) any) {
+		perService(name, kind, help, func(s ServiceMetrics) (any, bool) {
+			if s.Health == nil {
+				return nil, false
+			}
+			return value(s.Health), true
+		})
+	}
+	perService("pei_service_healthy", "gauge", "Whether the service's health check has passed (1) or failed retries times in a row (0).", func(s ServiceMetrics) (any, bool) {
+		if s.Health == nil || s.Health.Status == "" {
+			return nil, false
+		}
+		if s.Health.Status == HealthHealthy {
+			return 1, true
+		}
+		return 0, true
+	})
+	perHealth("pei_service_health_consecutive_failures", "gauge", "Health probes failed in a row.", func(h *HealthStatus) any { return h.ConsecutiveFailures })
+	perHealth("pei_service_health_probe_latency_seconds", "gauge", "Seconds the last health probe took.", func(h *HealthStatus) any { return h.LastLatency.Seconds() })
+	perHealth("pei_service_health_checks_total", "counter", "Health probes run.", func(h *HealthStatus) any { return h.Checks })
+	perHealth("pei_service_health_failures_total", "counter", "Health probes failed.", func(h *HealthStatus) any { return h.Failures })
+}
+
+// promLabels formats a service's name and labels as Prometheus labels
+func (s ServiceMetrics) promLabels() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "service=%q", s.Name)
+	for _, name := range slices.Sorted(maps.Keys(s.Labels)) {
+		fmt.Fprintf(&b, ",%s=%q", name, s.Labels[name])
+	}
+	return b.String()
+}
+
+// openMetricsListener binds the metrics endpoint while pei is still root
+func openMetricsListener(config *MetricsConfig) (net.Listener, error) {
+	if config == nil {
+		return nil, nil
+	}
+	network, address, _ := parseListenAddress(config.Address)
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("metrics listener %s: %v", config.Address, err)
+	}
+	return listener, nil
+}
+
+// serveMetrics answers Prometheus scrapes on /metrics until shutdown
+func (d *Daemon) serveMetrics(listener net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		d.selfMetrics().writePrometheus(w)
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: ipcReadTimeout}
+	go func() {
+		<-d.ctx.Done()
+		server.Close()
+	}()
+
+	slog.Info("Metrics listening", "address", listener.Addr().String())
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("Metrics server failed", "error", err)
+	}
+}
+
+// debugDump is the daemon's state for pei debug dump: its metrics and every
+// goroutine's stack
+func (d *Daemon) debugDump() (*DaemonMetrics, string) {
+	var stacks bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&stacks, 2)
+	return d.selfMetrics(), stacks.String()
+}
+
+// debugDumpIPC prints the daemon's metrics and goroutine stacks
+func debugDumpIPC() error {
+	resp, err := sendIPCRequest(IPCRequest{Command: "debug-dump"})
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("daemon error: %s", resp.Message)
+	}
+	m := resp.Metrics
+	if m == nil {
+		return fmt.Errorf("daemon sent no metrics")
+	}
+
+	fmt.Printf("Uptime:          %s\n", m.Uptime)
+	fmt.Printf("Goroutines:      %d\n", m.Goroutines)
+	fmt.Printf("Heap:            %d bytes (%d from the OS, %d GC cycles)\n", m.HeapBytes, m.SysBytes, m.GCCycles)
+	if m.OpenFDs >= 0 {
+		fmt.Printf("Open FDs:        %d\n", m.OpenFDs)
+	}
+	fmt.Printf("Restart queue:   %d waiting, %d processed, %d merged\n", m.RestartQueueDepth, m.RestartsProcessed, m.RestartsMerged)
+	fmt.Printf("Reaper:          %d processes in %d passes, last took %s, longest %s\n",
+		m.Reaped, m.ReapPasses, m.LastReapLatency, m.MaxReapLatency)
+	sort.Slice(m.Listeners, func(i, j int) bool { return m.Listeners[i].Name < m.Listeners[j].Name })
+	for _, l := range m.Listeners {
+		fmt.Printf("Listener %s: %d of %d connections, %d requests, %d rate limited, %d connections refused\n",
+			l.Name, l.Connections, l.MaxConnections, l.Requests, l.RateLimited, l.RefusedConnections)
+	}
+	for _, s := range m.Services {
+		state := "down"
+		if s.Up {
+			state = "up"
+		}
+		fmt.Printf("Service %s: %s, %d restarts, %.2fs CPU, %d bytes resident, %d open FDs\n",
+			s.Name, state, s.Restarts, s.CPUSeconds, s.MemoryBytes, s.OpenFDs)
+	}
+	fmt.Printf("\n%s", resp.Message)
+	return nil
+}
+
+// showMetricsIPC prints the daemon's metrics in the Prometheus text format
+func showMetricsIPC() error {
+	resp, err := sendIPCRequest(IPCRequest{Command: "metrics"})
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("daemon error: %s", resp.Message)
+	}
+	if resp.Metrics == nil {
+		return fmt.Errorf("daemon sent no metrics")
+	}
+	resp.Metrics.writePrometheus(os.Stdout)
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+// openFDCount isn't available here
+func openFDCount() int {
+	return -1
+}
+
+// processResources isn't available here
+func processResources(pid int) processUsage {
+	return processUsage{CPUSeconds: -1, MemoryBytes: -1, OpenFDs: -1}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// openFDCount returns how many file descriptors pei has open
+func openFDCount() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
+
+// clockTicks is USER_HZ, the unit of CPU times in /proc, which is 100 on
+// every architecture Linux supports
+const clockTicks = 100
+
+// processResources reads a process's CPU time, resident memory and open
+// file descriptors from /proc. Descriptors of processes running as another
+// user can't be counted once pei has dropped privileges.
+func processResources(pid int) processUsage {
+	usage := processUsage{CPUSeconds: -1, MemoryBytes: -1, OpenFDs: -1}
+
+	if stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid)); err == nil {
+		// Fields after the command name, which may itself hold spaces and
+		// parentheses; utime and stime are fields 14 and 15
+		if end := bytes.LastIndexByte(stat, ')'); end >= 0 {
+			fields := strings.Fields(string(stat[end+1:]))
+			if len(fields) > 12 {
+				utime, errU := strconv.ParseUint(fields[11], 10, 64)
+				stime, errS := strconv.ParseUint(fields[12], 10, 64)
+				if errU == nil && errS == nil {
+					usage.CPUSeconds = float64(utime+stime) / clockTicks
+				}
+			}
+		}
+	}
+	if statm, err := os.ReadFile(fmt.Sprintf("/proc/%d/statm", pid)); err == nil {
+		if fields := strings.Fields(string(statm)); len(fields) > 1 {
+			if pages, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				usage.MemoryBytes = pages * int64(os.Getpagesize())
+			}
+		}
+	}
+	if entries, err := os.ReadDir(fmt.Sprintf("/proc/%d/fd", pid)); err == nil {
+		usage.OpenFDs = len(entries)
+	}
+	return usage
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	web := Service{Name: "web", Labels: map[string]string{"team": "platform"}}
+	d := NewDaemon(&Config{Services: map[string]Service{"web": web}}, "", "", "")
+	defer d.cancel()
+
+	cmd := exec.Command("sleep", "5")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	d.setServiceProcess("web", &serviceProcess{cmd: cmd, exited: make(chan struct{})})
+	d.setState(web, StateRunning)
+
+	d.reapStats.record(2, 3*time.Millisecond)
+	d.ipcLimiter.allow("uid 0")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go d.serveMetrics(listener)
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, line := range []string{
+		"pei_goroutines ",
+		"pei_restart_queue_depth 0",
+		"pei_reaped_processes_total 2",
+		"pei_reap_latency_seconds 0.003",
+		`pei_ipc_requests_total{listener="local"} 1`,
+		`pei_service_up{service="web",team="platform"} 1`,
+		`pei_service_restarts_total{service="web",team="platform"} 0`,
+	} {
+		if !strings.Contains(string(body), "\n"+line) {
+			t.Errorf("expected metrics to contain %q:\n%s", line, body)
+		}
+	}
+	if runtime.GOOS == "linux" && !strings.Contains(string(body), `pei_service_memory_bytes{service="web",team="platform"} `) {
+		t.Errorf("expected the service's memory use:\n%s", body)
+	}
+}
+
+func TestDebugDump(t *testing.T) {
+	d := NewDaemon(&Config{}, "", "", "")
+	defer d.cancel()
+
+	response := handleCommand(context.Background(), d, IPCRequest{Command: "debug-dump"})
+	if !response.Success || response.Metrics == nil || response.Metrics.Goroutines == 0 {
+		t.Fatalf("expected metrics, got %+v", response)
+	}
+	if !strings.Contains(response.Message, "goroutine ") {
+		t.Errorf("expected goroutine stacks, got %q", response.Message)
+	}
+}
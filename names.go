@@ -0,0 +1,55 @@
+package main
+
+import "regexp"
+
+// serviceNamePattern keeps service names usable as command-line arguments,
+// file names and metric label values
+var serviceNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// maxServiceNameLength keeps service names readable in pei list
+const maxServiceNameLength = 64
+
+// reservedServiceNames can't be service names because commands and reports
+// use them for something else
+var reservedServiceNames = map[string]string{
+	"all": "it selects every service in commands",
+	"pei": "it names the daemon in reports",
+}
+
+// validateServiceNames checks every service's name
+func (c *Config) validateServiceNames() error {
+	for name := range c.Services {
+		if len(name) > maxServiceNameLength {
+			return serviceErrorf(name, "", "name must be at most %d characters", maxServiceNameLength)
+		}
+		if !serviceNamePattern.MatchString(name) {
+			return serviceErrorf(name, "", "name must be letters, digits, '.', '_' and '-', starting with a letter or digit")
+		}
+		if reason, reserved := reservedServiceNames[name]; reserved {
+			return serviceErrorf(name, "", "name %q is reserved: %s", name, reason)
+		}
+	}
+	return nil
+}
+
+// validateServiceReferences checks that every service named in depends_on,
+// after and before is configured
+func (c *Config) validateServiceReferences() error {
+	for name, svc := range c.Services {
+		for key, references := range map[string][]string{
+			"depends_on": svc.DependsOn,
+			"after":      svc.After,
+			"before":     svc.Before,
+		} {
+			for i, reference := range references {
+				if _, exists := c.Services[reference]; !exists {
+					return fieldErrorf([]string{"services", name, key, listIndex(i)}, "unknown service %q", reference)
+				}
+				if reference == name {
+					return fieldErrorf([]string{"services", name, key, listIndex(i)}, "service can't refer to itself")
+				}
+			}
+		}
+	}
+	return nil
+}
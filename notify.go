@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// Notifier types
+const (
+	NotifierWebhook   = "webhook"   // POSTs each event as JSON
+	NotifierSlack     = "slack"     // posts a message to a Slack incoming webhook
+	NotifierPagerDuty = "pagerduty" // triggers PagerDuty Events v2 alerts
+	NotifierEmail     = "email"     // mails digests of events over SMTP
+)
+
+// Notifier sends events matching its filter to an outside service, so people
+// hear about crash loops and failures without watching pei events. Secrets
+// such as url and routing_key can come from the environment or a file with
+// a .tmpl config.
+type Notifier struct {
+	Name     string   `yaml:"name"`
+	Type     string   `yaml:"type"`
+	Events   []string `yaml:"events"`   // event types to send
+	Services []string `yaml:"services"` // only events for these, if set
+	URL      string   `yaml:"url"`
+
+	// Message is a text/template over the event for Slack messages,
+	// PagerDuty summaries and the lines of an email digest
+	Message string `yaml:"message"`
+
+	// Slack
+	Channel string `yaml:"channel"` // overrides the webhook's channel
+
+	// PagerDuty
+	RoutingKey string            `yaml:"routing_key"`
+	Severity   map[string]string `yaml:"severity"` // event type to severity
+
+	// Email
+	SMTP       *SMTPServer   `yaml:"smtp"`
+	From       string        `yaml:"from"`
+	To         []string      `yaml:"to"`
+	Digest     time.Duration `yaml:"digest"`       // how long events gather before a mail
+	MaxPerHour int           `yaml:"max_per_hour"` // mails at most
+}
+
+// defaultNotifyMessage is the message sent when a notifier has none
+const defaultNotifyMessage = `{{if .Service}}[{{.Service}}] {{end}}{{.Message}}{{range $key, $value := .Fields}} {{$key}}={{$value}}{{end}}`
+
+// pagerDutyEventsURL is the PagerDuty Events API v2 endpoint
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// pagerDutySeverities are the severities PagerDuty accepts
+var pagerDutySeverities = []string{"critical", "error", "warning", "info"}
+
+// defaultSeverity is the PagerDuty severity of each event type unless a
+// notifier's severity says otherwise; other types are info
+var defaultSeverity = map[string]string{
+	EventCrashLoop:            "critical",
+	EventServiceFailed:        "critical",
+	EventUnhealthy:            "error",
+	EventRolloutFailed:        "error",
+	EventPostStartCheckFailed: "error",
+	EventCrashBundle:          "error",
+	EventServiceCrashed:       "error",
+	EventRestart:              "warning",
+}
+
+// validateNotifiers checks the notifiers list
+func (c *Config) validateNotifiers() error {
+	names := make(map[string]bool)
+	for i, n := range c.Notifiers {
+		path := []string{"notifiers", listIndex(i)}
+		if n.Name == "" {
+			return fieldErrorf(append(path, "name"), "is required")
+		}
+		if names[n.Name] {
+			return fieldErrorf(append(path, "name"), "notifier %s is declared twice", n.Name)
+		}
+		names[n.Name] = true
+
+		switch n.Type {
+		case NotifierWebhook, NotifierSlack:
+			if n.URL == "" {
+				return fieldErrorf(append(path, "url"), "is required for %s notifiers", n.Type)
+			}
+		case NotifierPagerDuty:
+			if n.RoutingKey == "" {
+				return fieldErrorf(append(path, "routing_key"), "is required for pagerduty notifiers")
+			}
+		case NotifierEmail:
+			if n.URL != "" {
+				return fieldErrorf(append(path, "url"), "does not apply to email notifiers")
+			}
+			if err := n.validateEmail(path); err != nil {
+				return err
+			}
+		default:
+			return fieldErrorf(append(path, "type"), "must be webhook, slack, pagerduty or email")
+		}
+		if n.SMTP != nil && n.Type != NotifierEmail {
+			return fieldErrorf(append(path, "smtp"), "only applies to email notifiers")
+		}
+		if n.URL != "" && !isHTTPURL(n.URL) {
+			return fieldErrorf(append(path, "url"), "must be an http:// or https:// URL")
+		}
+		if n.Channel != "" && n.Type != NotifierSlack {
+			return fieldErrorf(append(path, "channel"), "only applies to slack notifiers")
+		}
+		if len(n.Severity) > 0 && n.Type != NotifierPagerDuty {
+			return fieldErrorf(append(path, "severity"), "only applies to pagerduty notifiers")
+		}
+
+		if len(n.Events) == 0 {
+			return fieldErrorf(append(path, "events"), "must list at least one event type")
+		}
+		for j, eventType := range n.Events {
+			if !slices.Contains(eventTypes, eventType) {
+				return fieldErrorf(append(path, "events", listIndex(j)), "unknown event type %q", eventType)
+			}
+		}
+		for j, name := range n.Services {
+			if _, exists := c.Services[name]; !exists {
+				return fieldErrorf(append(path, "services", listIndex(j)), "unknown service %q", name)
+			}
+		}
+		for eventType, severity := range n.Severity {
+			if !slices.Contains(eventTypes, eventType) {
+				return fieldErrorf(append(path, "severity", eventType), "unknown event type")
+			}
+			if !slices.Contains(pagerDutySeverities, severity) {
+				return fieldErrorf(append(path, "severity", eventType), "must be critical, error, warning or info")
+			}
+		}
+		if _, err := n.template(); err != nil {
+			return fieldErrorf(append(path, "message"), "%v", err)
+		}
+	}
+	return nil
+}
+
+// isHTTPURL reports whether raw is an absolute http or https URL
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// template parses the notifier's message
+func (n Notifier) template() (*template.Template, error) {
+	message := n.Message
+	if message == "" {
+		message = defaultNotifyMessage
+	}
+	return template.New(n.Name).Option("missingkey=zero").Parse(message)
+}
+
+// wants reports whether the notifier sends an event. A PagerDuty notifier
+// also sends healthy for services it alerted about being unhealthy, to
+// resolve the alert.
+func (n Notifier) wants(event Event) bool {
+	if len(n.Services) > 0 && !slices.Contains(n.Services, event.Service) {
+		return false
+	}
+	if n.Type == NotifierPagerDuty && event.Type == EventHealthy {
+		return slices.Contains(n.Events, EventUnhealthy)
+	}
+	return slices.Contains(n.Events, event.Type)
+}
+
+// notifyTimeout bounds each attempt to deliver a notification
+const notifyTimeout = 10 * time.Second
+
+// notifyQueueSize is how many events may wait to be sent. Beyond it events
+// are dropped rather than holding up the journal behind a slow endpoint.
+const notifyQueueSize = 256
+
+// startNotifiers sends recorded events to the notifiers configured when
+// each is sent, one at a time, for as long as pei runs, so failures during
+// shutdown are reported too. Email notifiers gather events into digests
+// sent separately.
+func (d *Daemon) startNotifiers() {
+	queue := make(chan Event, notifyQueueSize)
+	var dropped atomic.Uint64
+	d.events.subscribe(func(event Event) {
+		select {
+		case queue <- event:
+		default:
+			dropped.Add(1)
+		}
+	})
+
+	client := &http.Client{Timeout: notifyTimeout}
+	digests := make(map[string]*emailDigest)
+	go func() {
+		for event := range queue {
+			if n := dropped.Swap(0); n > 0 {
+				getLogger("notify").Warn("Notification queue full, dropped events", "dropped", n)
+			}
+			for _, n := range d.getConfig().Notifiers {
+				if !n.wants(event) {
+					continue
+				}
+				if n.Type == NotifierEmail {
+					if digests[n.Name] == nil {
+						digests[n.Name] = newEmailDigest()
+					}
+					digests[n.Name].add(n, event)
+					continue
+				}
+				if err := n.send(client, event); err != nil {
+					getLogger("notify").Warn("Failed to send notification",
+						"notifier", n.Name,
+						"event", event.Type,
+						"service", event.Service,
+						"error", err)
+				}
+			}
+		}
+	}()
+}
+
+// send delivers one event, formatted for the notifier's type
+func (n Notifier) send(client *http.Client, event Event) error {
+	var body any
+	target := n.URL
+	switch n.Type {
+	case NotifierWebhook:
+		body = event
+	case NotifierSlack:
+		text, err := n.message(event)
+		if err != nil {
+			return err
+		}
+		body = slackMessage{Text: text, Channel: n.Channel}
+	case NotifierPagerDuty:
+		alert, err := n.pagerDutyEvent(event)
+		if err != nil {
+			return err
+		}
+		body = alert
+		if target == "" {
+			target = pagerDutyEventsURL
+		}
+	}
+	return postJSON(client, target, body)
+}
+
+// message renders the notifier's message for an event
+func (n Notifier) message(event Event) (string, error) {
+	tmpl, err := n.template()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, event); err != nil {
+		return "", fmt.Errorf("failed to render message: %v", err)
+	}
+	return b.String(), nil
+}
+
+// slackMessage is the body of a Slack incoming webhook request
+type slackMessage struct {
+	Text    string `json:"text"`
+	Channel string `json:"channel,omitempty"`
+}
+
+// pagerDutyEvent is the body of a PagerDuty Events API v2 request
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"` // trigger or resolve
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Timestamp     time.Time         `json:"timestamp"`
+	Component     string            `json:"component,omitempty"`
+	Class         string            `json:"class"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+// pagerDutyEvent builds the alert for an event. Alerts are deduplicated per
+// host, service and event type, so a crash loop pages once until resolved,
+// and healthy resolves the service's unhealthy alert.
+func (n Notifier) pagerDutyEvent(event Event) (pagerDutyEvent, error) {
+	source, _ := os.Hostname()
+	if source == "" {
+		source = "pei"
+	}
+	dedupKey := func(eventType string) string {
+		return strings.Join([]string{"pei", source, event.Service, eventType}, "/")
+	}
+	if event.Type == EventHealthy {
+		return pagerDutyEvent{RoutingKey: n.RoutingKey, EventAction: "resolve", DedupKey: dedupKey(EventUnhealthy)}, nil
+	}
+
+	summary, err := n.message(event)
+	if err != nil {
+		return pagerDutyEvent{}, err
+	}
+	severity := n.Severity[event.Type]
+	if severity == "" {
+		severity = defaultSeverity[event.Type]
+	}
+	if severity == "" {
+		severity = "info"
+	}
+	details := make(map[string]string, len(event.Fields)+len(event.Labels))
+	for key, value := range event.Labels {
+		details[key] = value
+	}
+	for key, value := range event.Fields {
+		details[key] = value
+	}
+	if len(event.Output) > 0 {
+		details["output"] = strings.Join(event.Output, "\n")
+	}
+	return pagerDutyEvent{
+		RoutingKey:  n.RoutingKey,
+		EventAction: "trigger",
+		DedupKey:    dedupKey(event.Type),
+		Payload: &pagerDutyPayload{
+			Summary:       summary,
+			Source:        source,
+			Severity:      severity,
+			Timestamp:     event.Time,
+			Component:     event.Service,
+			Class:         event.Type,
+			CustomDetails: details,
+		},
+	}, nil
+}
+
+// postJSON POSTs body as JSON, treating any status but 2xx as a failure
+func postJSON(client *http.Client, target string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		reply, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(reply)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
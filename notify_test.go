@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifierFormats(t *testing.T) {
+	var got []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("bad body: %v", err)
+		}
+		got = append(got, body)
+	}))
+	defer server.Close()
+
+	event := Event{
+		Time:    time.Now(),
+		Type:    EventCrashLoop,
+		Service: "web",
+		Message: "Service is crash-looping",
+		Fields:  map[string]string{"restarts": "5"},
+	}
+	client := server.Client()
+
+	slack := Notifier{Name: "chat", Type: NotifierSlack, URL: server.URL, Channel: "#ops", Events: []string{EventCrashLoop}}
+	if err := slack.send(client, event); err != nil {
+		t.Fatal(err)
+	}
+	if got[0]["text"] != "[web] Service is crash-looping restarts=5" || got[0]["channel"] != "#ops" {
+		t.Errorf("unexpected slack message %v", got[0])
+	}
+
+	pager := Notifier{
+		Name: "oncall", Type: NotifierPagerDuty, URL: server.URL, RoutingKey: "key",
+		Events: []string{EventCrashLoop, EventUnhealthy}, Message: "{{.Service}} is down",
+	}
+	if err := pager.send(client, event); err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := got[1]["payload"].(map[string]any)
+	if got[1]["routing_key"] != "key" || got[1]["event_action"] != "trigger" ||
+		payload["severity"] != "critical" || payload["summary"] != "web is down" {
+		t.Errorf("unexpected pagerduty event %v", got[1])
+	}
+
+	// healthy resolves the unhealthy alert
+	healthy := Event{Type: EventHealthy, Service: "web"}
+	if !pager.wants(healthy) {
+		t.Fatal("expected pagerduty notifier to want healthy to resolve unhealthy")
+	}
+	if err := pager.send(client, healthy); err != nil {
+		t.Fatal(err)
+	}
+	if got[2]["event_action"] != "resolve" || !strings.HasSuffix(got[2]["dedup_key"].(string), "/web/unhealthy") {
+		t.Errorf("unexpected pagerduty resolve %v", got[2])
+	}
+}
+
+func TestNotifierFilter(t *testing.T) {
+	n := Notifier{Type: NotifierSlack, Events: []string{EventServiceFailed}, Services: []string{"db"}}
+	if !n.wants(Event{Type: EventServiceFailed, Service: "db"}) {
+		t.Error("expected failed for db to be sent")
+	}
+	if n.wants(Event{Type: EventServiceFailed, Service: "web"}) {
+		t.Error("expected other services to be filtered out")
+	}
+	if n.wants(Event{Type: EventRestart, Service: "db"}) {
+		t.Error("expected other event types to be filtered out")
+	}
+}
+
+func TestValidateNotifiers(t *testing.T) {
+	cases := []struct {
+		notifier Notifier
+		err      string
+	}{
+		{Notifier{Name: "a", Type: NotifierSlack, Events: []string{EventRestart}}, "url: is required"},
+		{Notifier{Name: "a", Type: NotifierPagerDuty, Events: []string{EventRestart}}, "routing_key: is required"},
+		{Notifier{Name: "a", Type: "sms", Events: []string{EventRestart}}, "must be webhook, slack, pagerduty or email"},
+		{Notifier{Name: "a", Type: NotifierWebhook, URL: "ftp://x", Events: []string{EventRestart}}, "http:// or https://"},
+		{Notifier{Name: "a", Type: NotifierWebhook, URL: "http://x"}, "at least one event type"},
+		{Notifier{Name: "a", Type: NotifierWebhook, URL: "http://x", Events: []string{"crash-loop"}}, `unknown event type "crash-loop"`},
+		{Notifier{Name: "a", Type: NotifierWebhook, URL: "http://x", Events: []string{EventRestart}, Services: []string{"db"}}, `unknown service "db"`},
+		{Notifier{Name: "a", Type: NotifierPagerDuty, RoutingKey: "k", Events: []string{EventRestart}, Severity: map[string]string{EventRestart: "high"}}, "must be critical"},
+		{Notifier{Name: "a", Type: NotifierSlack, URL: "http://x", Events: []string{EventRestart}, Message: "{{.Service"}, "message"},
+	}
+	for _, c := range cases {
+		config := &Config{Services: map[string]Service{"web": {Name: "web"}}, Notifiers: []Notifier{c.notifier}}
+		err := config.validateNotifiers()
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%+v: expected error containing %q, got %v", c.notifier, c.err, err)
+		}
+	}
+}
+
+func TestCrashLoopEvent(t *testing.T) {
+	d := NewDaemon(&Config{}, "", "", "")
+	defer d.cancel()
+	svc := Service{Name: "web"}
+	d.setState(svc, StateRunning)
+
+	for range crashLoopRestarts + 2 {
+		d.recordRestart(restartRequest{svc: svc, reason: RestartReasonFailure})
+	}
+	loops := 0
+	for _, event := range d.events.list("web", 0) {
+		if event.Type == EventCrashLoop {
+			loops++
+		}
+	}
+	if loops != 1 {
+		t.Errorf("expected the crash loop to be reported once, got %d", loops)
+	}
+
+	// Failing until a restart isn't giving up
+	d.setState(svc, StateFailed)
+	if events := d.events.list("web", 1); events[0].Type == EventServiceFailed {
+		t.Errorf("expected no failed event before pei gives up")
+	}
+	d.failService(svc, "exceeded max_restarts (3)")
+	events := d.events.list("web", 1)
+	if len(events) != 1 || events[0].Type != EventServiceFailed || events[0].Fields["reason"] != "exceeded max_restarts (3)" {
+		t.Errorf("expected a failed event, got %+v", events)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// startTiers groups services into tiers based on their depends_on, after
+// and before ordering directives, and priorities. Every service in a tier
+// only has to wait for services in earlier tiers, so a tier can be started
+// (or stopped, in reverse) as a unit. Services within a tier are sorted by
+// name so startup order is deterministic.
+//
+// Ordering references to services that don't exist are ignored, since after
+// and before only describe order and never require the other service.
+func startTiers(services map[string]Service) ([][]string, error) {
+	// next[a] lists the services that must be started after a
+	next := make(map[string][]string)
+	indegree := make(map[string]int)
+	for name := range services {
+		indegree[name] = 0
+	}
+	predecessors := orderingPredecessors(services)
+	for name, firsts := range predecessors {
+		indegree[name] = len(firsts)
+		for _, first := range firsts {
+			next[first] = append(next[first], name)
+		}
+	}
+
+	var ready []string
+	for name, degree := range indegree {
+		if degree == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	var tiers [][]string
+	placed := 0
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		tiers = append(tiers, ready)
+		placed += len(ready)
+
+		var following []string
+		for _, name := range ready {
+			for _, then := range next[name] {
+				indegree[then]--
+				if indegree[then] == 0 {
+					following = append(following, then)
+				}
+			}
+		}
+		ready = following
+	}
+
+	if placed != len(services) {
+		return nil, orderingCycleError(services, predecessors, indegree)
+	}
+
+	return tiers, nil
+}
+
+// startOrder returns service names in the order they should be started
+func startOrder(services map[string]Service) ([]string, error) {
+	tiers, err := startTiers(services)
+	if err != nil {
+		return nil, err
+	}
+
+	var order []string
+	for _, tier := range tiers {
+		order = append(order, tier...)
+	}
+	return order, nil
+}
+
+// orderingCycleError describes a cycle among the services startTiers
+// couldn't place, naming why each waits for the next, such as "a depends on
+// b, b starts after a". Services only waiting behind the cycle aren't named.
+func orderingCycleError(services map[string]Service, predecessors map[string][]string, indegree map[string]int) error {
+	// Every unplaced service waits for another unplaced one, so following
+	// them from any leads into a cycle
+	var unplaced []string
+	for name, degree := range indegree {
+		if degree > 0 {
+			unplaced = append(unplaced, name)
+		}
+	}
+	sort.Strings(unplaced)
+	var path []string
+	seen := make(map[string]int)
+	for name := unplaced[0]; ; {
+		if start, exists := seen[name]; exists {
+			// Start from the first by name, so the error is always the same
+			path = path[start:]
+			first := slices.Index(path, slices.Min(path))
+			path = slices.Concat(path[first:], path[:first])
+			break
+		}
+		seen[name] = len(path)
+		path = append(path, name)
+		for _, first := range predecessors[name] {
+			if indegree[first] > 0 {
+				name = first
+				break
+			}
+		}
+	}
+
+	reasons := make([]string, len(path))
+	for i, then := range path {
+		reasons[i] = orderingReason(services, path[(i+1)%len(path)], then)
+	}
+	cyclic := slices.Sorted(slices.Values(path))
+	return fmt.Errorf("ordering cycle detected between services: %s: %s", strings.Join(cyclic, ", "), strings.Join(reasons, ", "))
+}
+
+// orderingReason says why then starts after first
+func orderingReason(services map[string]Service, first, then string) string {
+	svc := services[then]
+	switch {
+	case slices.Contains(svc.DependsOn, first):
+		return fmt.Sprintf("%s depends on %s", then, first)
+	case slices.Contains(svc.After, first), slices.Contains(services[first].Before, then):
+		return fmt.Sprintf("%s starts after %s", then, first)
+	case svc.JoinNamespaces != nil && svc.JoinNamespaces.Service == first:
+		return fmt.Sprintf("%s joins the namespaces of %s", then, first)
+	}
+	return fmt.Sprintf("%s has a higher priority than %s", then, first)
+}
+
+// orderingPredecessors returns, for each service, the services it is ordered
+// after through its depends_on or after list, another service's before list,
+// or a lower priority
+func orderingPredecessors(services map[string]Service) map[string][]string {
+	predecessors := make(map[string][]string)
+	add := func(first, then string) {
+		if _, exists := services[first]; !exists {
+			return
+		}
+		if _, exists := services[then]; !exists {
+			return
+		}
+		for _, existing := range predecessors[then] {
+			if existing == first {
+				return
+			}
+		}
+		predecessors[then] = append(predecessors[then], first)
+	}
+
+	for name, svc := range services {
+		// A dependency starts first, so it's there when needed
+		for _, other := range svc.DependsOn {
+			add(other, name)
+		}
+		for _, other := range svc.After {
+			add(other, name)
+		}
+		for _, other := range svc.Before {
+			add(name, other)
+		}
+		// Joining a service's namespaces needs it running
+		if svc.JoinNamespaces != nil && svc.JoinNamespaces.Service != "" {
+			add(svc.JoinNamespaces.Service, name)
+		}
+	}
+
+	// Each priority starts after the next lower one, and so, transitively,
+	// after all lower ones
+	byPriority := make(map[int][]string)
+	for name, svc := range services {
+		byPriority[svc.Priority] = append(byPriority[svc.Priority], name)
+	}
+	priorities := slices.Sorted(maps.Keys(byPriority))
+	for i := 1; i < len(priorities); i++ {
+		for _, first := range byPriority[priorities[i-1]] {
+			for _, then := range byPriority[priorities[i]] {
+				add(first, then)
+			}
+		}
+	}
+
+	for name := range predecessors {
+		sort.Strings(predecessors[name])
+	}
+	return predecessors
+}
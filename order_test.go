@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStartTiers(t *testing.T) {
+	services := map[string]Service{
+		"db":     {Name: "db"},
+		"cache":  {Name: "cache", Before: []string{"web"}},
+		"web":    {Name: "web", After: []string{"db"}},
+		"worker": {Name: "worker", After: []string{"web", "missing"}},
+	}
+
+	tiers, err := startTiers(services)
+	if err != nil {
+		t.Fatalf("startTiers failed: %v", err)
+	}
+
+	expected := [][]string{{"cache", "db"}, {"web"}, {"worker"}}
+	if !reflect.DeepEqual(tiers, expected) {
+		t.Errorf("Expected tiers %v, got %v", expected, tiers)
+	}
+}
+
+func TestStartTiersCycle(t *testing.T) {
+	services := map[string]Service{
+		"a": {Name: "a", After: []string{"b"}},
+		"b": {Name: "b", After: []string{"a"}},
+		"c": {Name: "c"},
+	}
+
+	_, err := startTiers(services)
+	if err == nil {
+		t.Fatal("Expected error for ordering cycle")
+	}
+	if !strings.Contains(err.Error(), "a, b") {
+		t.Errorf("Expected cycle error to name services, got: %v", err)
+	}
+}
+
+func TestStartTiersDependsOn(t *testing.T) {
+	services := map[string]Service{
+		"web":    {Name: "web", DependsOn: []string{"db", "cache"}},
+		"db":     {Name: "db"},
+		"cache":  {Name: "cache", DependsOn: []string{"db"}},
+		"worker": {Name: "worker"},
+	}
+
+	tiers, err := startTiers(services)
+	if err != nil {
+		t.Fatalf("startTiers failed: %v", err)
+	}
+	expected := [][]string{{"db", "worker"}, {"cache"}, {"web"}}
+	if !reflect.DeepEqual(tiers, expected) {
+		t.Errorf("Expected tiers %v, got %v", expected, tiers)
+	}
+
+	// The error names the cycle and why each service waits, but not the
+	// services only waiting behind it
+	services["db"] = Service{Name: "db", After: []string{"web"}}
+	services["api"] = Service{Name: "api", DependsOn: []string{"web"}}
+	_, err = startTiers(services)
+	if err == nil {
+		t.Fatal("Expected error for dependency cycle")
+	}
+	want := "ordering cycle detected between services: cache, db, web: cache depends on db, db starts after web, web depends on cache"
+	if err.Error() != want {
+		t.Errorf("Expected %q, got %q", want, err.Error())
+	}
+}
+
+func TestStartTiersPriority(t *testing.T) {
+	services := map[string]Service{
+		"db":      {Name: "db", Priority: -10},
+		"migrate": {Name: "migrate", Priority: -10, After: []string{"db"}},
+		"web":     {Name: "web"},
+		"worker":  {Name: "worker"},
+		"metrics": {Name: "metrics", Priority: 5},
+	}
+
+	tiers, err := startTiers(services)
+	if err != nil {
+		t.Fatalf("startTiers failed: %v", err)
+	}
+	expected := [][]string{{"db"}, {"migrate"}, {"web", "worker"}, {"metrics"}}
+	if !reflect.DeepEqual(tiers, expected) {
+		t.Errorf("Expected tiers %v, got %v", expected, tiers)
+	}
+
+	// Ordering that contradicts priority is a cycle
+	services["db"] = Service{Name: "db", Priority: -10, After: []string{"web"}}
+	if _, err := startTiers(services); err == nil {
+		t.Error("Expected after contradicting priority to be a cycle")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// defaultOutputMode is the mode stdout and stderr files are given, readable
+// by the service's group but nobody else
+const defaultOutputMode = 0o640
+
+// validateOutputFiles checks services' stdout, stderr and output_mode
+func (c *Config) validateOutputFiles() error {
+	for name, svc := range c.Services {
+		for key, path := range map[string]string{"stdout": svc.Stdout, "stderr": svc.Stderr} {
+			if path != "" && !filepath.IsAbs(path) {
+				return serviceErrorf(name, key, "must be an absolute path")
+			}
+		}
+		if svc.OutputMode == "" {
+			continue
+		}
+		if svc.Stdout == "" && svc.Stderr == "" {
+			return serviceErrorf(name, "output_mode", "needs stdout or stderr")
+		}
+		if _, err := parseOutputMode(svc.OutputMode); err != nil {
+			return serviceErrorf(name, "output_mode", "%v", err)
+		}
+	}
+	return nil
+}
+
+// parseOutputMode parses an octal file mode such as 0640
+func parseOutputMode(mode string) (os.FileMode, error) {
+	bits, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || bits > 0o777 {
+		return 0, fmt.Errorf("%q is not an octal file mode such as 0640", mode)
+	}
+	return os.FileMode(bits), nil
+}
+
+// outputMode is the mode a service's output files are given
+func outputMode(svc Service) os.FileMode {
+	if mode, err := parseOutputMode(svc.OutputMode); err == nil {
+		return mode
+	}
+	return defaultOutputMode
+}
+
+// outputFiles holds the files a service instance's output is copied to
+type outputFiles struct {
+	stdout io.Writer
+	stderr io.Writer
+	opened []*os.File
+}
+
+// stdoutWriter is where stdout is copied, nil if nowhere
+func (o *outputFiles) stdoutWriter() io.Writer {
+	if o == nil {
+		return nil
+	}
+	return o.stdout
+}
+
+// stderrWriter is where stderr is copied, nil if nowhere
+func (o *outputFiles) stderrWriter() io.Writer {
+	if o == nil {
+		return nil
+	}
+	return o.stderr
+}
+
+func (o *outputFiles) Close() {
+	for _, f := range o.opened {
+		f.Close()
+	}
+}
+
+// openOutputFiles opens a service's stdout and stderr files for appending,
+// the same file once if both streams go to it. /dev/stdout and /dev/stderr
+// mean pei's own output, which needs no file. Must be called with elevated
+// privileges.
+func openOutputFiles(svc Service, uid, gid int) (*outputFiles, error) {
+	files := &outputFiles{}
+	open := func(path string) (io.Writer, error) {
+		// pei's own output already carries the service's, as log lines
+		if strings.HasPrefix(path, "/dev/std") {
+			return nil, nil
+		}
+		file, err := openOutputFile(path, outputMode(svc), uid, gid)
+		if err != nil {
+			files.Close()
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		files.opened = append(files.opened, file)
+		return file, nil
+	}
+
+	if svc.Stdout != "" {
+		file, err := open(svc.Stdout)
+		if err != nil {
+			return nil, err
+		}
+		files.stdout = file
+	}
+	switch {
+	case svc.Stderr == "":
+	case svc.Stderr == svc.Stdout:
+		files.stderr = files.stdout
+	default:
+		file, err := open(svc.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		files.stderr = file
+	}
+	return files, nil
+}
+
+// openOutputFile opens an output file for appending. Missing directories are
+// created for the service's user, so it can still rotate or reopen its logs
+// under paths such as /var/log. The file is opened and created as that user,
+// never through a symlink, so a service can't point its log at a file only
+// root may write and have pei hand it over. Device files such as
+// /dev/console are opened as they are.
+func openOutputFile(path string, mode os.FileMode, uid, gid int) (*os.File, error) {
+	if strings.HasPrefix(path, "/dev/") {
+		return os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	}
+	if err := makeOutputDirs(filepath.Dir(path), uid, gid); err != nil {
+		return nil, err
+	}
+	var file *os.File
+	err := asServiceUser(uid, gid, func() error {
+		var err error
+		file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND|syscall.O_NOFOLLOW, mode)
+		if err != nil {
+			return err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("not a regular file")
+		}
+		// The umask may have narrowed the mode, and an existing file keeps its
+		// own. Only the file's owner may change it.
+		return file.Chmod(mode)
+	})
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+// makeOutputDirs creates dir and any missing parents for the service's user.
+// Each is made as the user where they may write to its parent, and otherwise
+// made by pei and handed to them: a parent they can't write to is one they
+// can't swap for a symlink either. Directories that already exist are left
+// alone.
+func makeOutputDirs(dir string, uid, gid int) error {
+	if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := makeOutputDirs(filepath.Dir(dir), uid, gid); err != nil {
+		return err
+	}
+	err := asServiceUser(uid, gid, func() error { return os.Mkdir(dir, 0o755) })
+	if !errors.Is(err, os.ErrPermission) {
+		if errors.Is(err, os.ErrExist) {
+			return nil
+		}
+		return err
+	}
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil
+		}
+		return err
+	}
+	return os.Lchown(dir, uid, gid)
+}
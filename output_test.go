@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestValidateOutputFiles(t *testing.T) {
+	tests := []struct {
+		svc Service
+		err string
+	}{
+		{Service{Stdout: "/var/log/app/out.log", Stderr: "/var/log/app/out.log", OutputMode: "0600"}, ""},
+		{Service{Stdout: "/dev/stdout"}, ""},
+		{Service{Stdout: "logs/out.log"}, "must be an absolute path"},
+		{Service{OutputMode: "0640"}, "needs stdout or stderr"},
+		{Service{Stderr: "/var/log/err.log", OutputMode: "rw-r-----"}, "not an octal file mode"},
+		{Service{Stderr: "/var/log/err.log", OutputMode: "01777"}, "not an octal file mode"},
+	}
+	for _, tt := range tests {
+		config := &Config{Services: map[string]Service{"web": tt.svc}}
+		err := config.validateOutputFiles()
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%+v: unexpected error: %v", tt.svc, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%+v: expected error containing %q, got %v", tt.svc, tt.err, err)
+		}
+	}
+}
+
+func TestOpenOutputFiles(t *testing.T) {
+	dir := t.TempDir()
+	svc := Service{
+		Stdout:     filepath.Join(dir, "app", "logs", "out.log"),
+		Stderr:     filepath.Join(dir, "app", "logs", "out.log"),
+		OutputMode: "0604",
+	}
+	files, err := openOutputFiles(svc, os.Getuid(), os.Getgid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files.opened) != 1 || files.stdout != files.stderr {
+		t.Errorf("expected one file shared by both streams, got %d", len(files.opened))
+	}
+
+	info, err := os.Stat(svc.Stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o604 {
+		t.Errorf("expected mode 0604, got %v", info.Mode().Perm())
+	}
+	if info, err := os.Stat(filepath.Dir(svc.Stdout)); err != nil || !info.IsDir() {
+		t.Errorf("expected the log directory to be created, got %v", err)
+	}
+
+	// Output is copied to the file as it was written
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture := NewServiceOutputCapture(svc, r, nil, 42)
+	capture.files = files
+	capture.Start()
+	w.WriteString("one\ntwo")
+	w.Close()
+	capture.waitDrained(5 * time.Second)
+
+	data, err := os.ReadFile(svc.Stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "one\ntwo" {
+		t.Errorf("expected output in file, got %q", data)
+	}
+}
+
+func TestOpenOutputFilesPeiOutput(t *testing.T) {
+	files, err := openOutputFiles(Service{Stdout: "/dev/stdout", Stderr: "/dev/stderr"}, os.Getuid(), os.Getgid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files.stdout != nil || files.stderr != nil {
+		t.Error("expected no files for pei's own output")
+	}
+}
+
+func TestOpenOutputFileRefusesSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "shadow")
+	os.WriteFile(target, []byte("root:secret\n"), 0o600)
+	link := filepath.Join(dir, "out.log")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := openOutputFiles(Service{Stdout: link}, os.Getuid(), os.Getgid()); err == nil {
+		t.Fatal("expected a log path swapped for a symlink to be refused")
+	}
+	if info, err := os.Stat(target); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the symlink's target to be left alone, got %v, %v", info.Mode(), err)
+	}
+
+	if _, err := openOutputFiles(Service{Stdout: dir}, os.Getuid(), os.Getgid()); err == nil {
+		t.Error("expected a directory to be refused")
+	}
+}
+
+func TestOpenOutputFileAsServiceUser(t *testing.T) {
+	if !credentialSwitching || os.Geteuid() != 0 {
+		t.Skip("needs root to act as another user")
+	}
+	dir := t.TempDir()
+	// The service's user has to reach it
+	os.Chmod(filepath.Dir(dir), 0o755)
+	os.Chmod(dir, 0o755)
+	const nobody = 65534
+	path := filepath.Join(dir, "app", "out.log")
+	files, err := openOutputFiles(Service{Stdout: path}, nobody, nobody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files.Close()
+
+	for _, created := range []string{path, filepath.Dir(path)} {
+		info, err := os.Stat(created)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat := info.Sys().(*syscall.Stat_t); stat.Uid != nobody || stat.Gid != nobody {
+			t.Errorf("expected %s to belong to the service's user, got %d:%d", created, stat.Uid, stat.Gid)
+		}
+	}
+
+	// A file the user can't write, even in their own directory, stays shut
+	other := filepath.Join(dir, "app", "root.log")
+	os.WriteFile(other, nil, 0o600)
+	if _, err := openOutputFiles(Service{Stdout: other}, nobody, nobody); err == nil {
+		t.Error("expected a file only root may write to be refused")
+	}
+}
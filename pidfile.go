@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// validatePIDFiles checks pid_file
+func (c *Config) validatePIDFiles() error {
+	owners := make(map[string]string)
+	for _, name := range slices.Sorted(maps.Keys(c.Services)) {
+		svc := c.Services[name]
+		if svc.PIDFile == "" {
+			continue
+		}
+		if !filepath.IsAbs(svc.PIDFile) {
+			return serviceErrorf(name, "pid_file", "must be an absolute path")
+		}
+		if svc.Spawn == SpawnPerConnection {
+			return serviceErrorf(name, "pid_file", "is not supported for spawn services, which have no single PID")
+		}
+		path := filepath.Clean(svc.PIDFile)
+		if owner, exists := owners[path]; exists {
+			return serviceErrorf(name, "pid_file", "is also used by service %s", owner)
+		}
+		owners[path] = name
+	}
+	return nil
+}
+
+// writePIDFile records a service's current PID in its pid_file, replacing
+// the previous instance's. The file is replaced in one step, so tools never
+// read a partial PID. Its directory is created owned by pei's user if it
+// doesn't exist, so the file can be removed once privileges are dropped.
+// Must be called with elevated privileges.
+func (d *Daemon) writePIDFile(svc Service, pid int) error {
+	if svc.PIDFile == "" {
+		return nil
+	}
+	dir := filepath.Dir(svc.PIDFile)
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		uid, gid, err := lookupUIDGID(d.appUser, d.appGroup)
+		if err != nil {
+			return err
+		}
+		if err := os.Chown(dir, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(svc.PIDFile)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := fmt.Fprintf(tmp, "%d\n", pid); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), svc.PIDFile)
+}
+
+// removePIDFile removes a service's pid_file once the instance it names has
+// exited, leaving it alone if a newer instance has already taken it over
+func removePIDFile(svc Service, pid int) {
+	if svc.PIDFile == "" {
+		return
+	}
+	data, err := os.ReadFile(svc.PIDFile)
+	if err != nil {
+		return
+	}
+	if current, err := strconv.Atoi(strings.TrimSpace(string(data))); err != nil || current != pid {
+		return
+	}
+	if err := os.Remove(svc.PIDFile); err != nil {
+		logServiceError(svc.Name, "Failed to remove pid file", "path", svc.PIDFile, "error", err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPIDFile(t *testing.T) {
+	d := NewDaemon(&Config{}, "", "", "")
+	defer d.cancel()
+	svc := Service{Name: "web", PIDFile: filepath.Join(t.TempDir(), "web.pid")}
+
+	if err := d.writePIDFile(svc, 42); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(svc.PIDFile); string(data) != "42\n" {
+		t.Errorf("expected the PID in the file, got %q", data)
+	}
+
+	// A restart's new instance takes the file over before the old one's
+	// exit is noticed, which leaves it alone
+	if err := d.writePIDFile(svc, 43); err != nil {
+		t.Fatal(err)
+	}
+	removePIDFile(svc, 42)
+	if data, _ := os.ReadFile(svc.PIDFile); string(data) != "43\n" {
+		t.Errorf("expected the new PID to stay, got %q", data)
+	}
+
+	removePIDFile(svc, 43)
+	if _, err := os.Stat(svc.PIDFile); !os.IsNotExist(err) {
+		t.Error("expected the pid file to be removed once its instance exited")
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(svc.PIDFile)); len(entries) != 0 {
+		t.Errorf("expected no temporary files left behind, got %v", entries)
+	}
+}
+
+func TestValidatePIDFiles(t *testing.T) {
+	cases := []struct {
+		services map[string]Service
+		err      string
+	}{
+		{map[string]Service{"web": {PIDFile: "web.pid"}}, "absolute"},
+		{map[string]Service{"web": {PIDFile: "/run/web.pid", Spawn: SpawnPerConnection}}, "spawn"},
+		{map[string]Service{"a": {PIDFile: "/run/x.pid"}, "b": {PIDFile: "/run/../run/x.pid"}}, "also used by service a"},
+	}
+	for _, c := range cases {
+		config := &Config{Services: c.services}
+		if err := config.validatePIDFiles(); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%+v: expected error containing %q, got %v", c.services, c.err, err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// resolveCommand finds the executable a service would run, resolving bare
+// names through PATH and relative paths against the working directory
+func resolveCommand(svc Service) (string, error) {
+	if len(svc.Command) == 0 || svc.Command[0] == "" {
+		return "", fmt.Errorf("no command configured")
+	}
+
+	path := svc.Command[0]
+	if !strings.Contains(path, "/") {
+		resolved, err := exec.LookPath(path)
+		if err != nil {
+			return "", fmt.Errorf("command %q not found in PATH", path)
+		}
+		return resolved, nil
+	}
+
+	if !filepath.IsAbs(path) && svc.WorkingDir != "" {
+		path = filepath.Join(svc.WorkingDir, path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("command %q not found: %v", path, err)
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return "", fmt.Errorf("command %q is not executable", path)
+	}
+	return path, nil
+}
+
+// showPlan prints what the daemon would start, in what order and as which
+// user, without launching anything. It returns the number of problems found.
+func showPlan(config *Config, configPath string) int {
+	var problems []string
+	problem := func(service, format string, args ...any) {
+		problems = append(problems, fmt.Sprintf("%s: %s", service, fmt.Sprintf(format, args...)))
+	}
+
+	fmt.Printf("Startup plan for %s (%d services)\n", configPath, len(config.Services))
+
+	tiers, err := startTiers(config.Services)
+	if err != nil {
+		fmt.Printf("\nProblems:\n  %v\n", err)
+		return 1
+	}
+
+	if len(config.Init) > 0 {
+		fmt.Printf("\nInit (run in order, before any service):\n")
+		for i, initStep := range config.Init {
+			svc := initStep.service()
+			fmt.Printf("  %d. %s\n", i+1, svc.Name)
+			resolved, err := resolveCommand(svc)
+			if err != nil {
+				problem(svc.Name, "%v", err)
+				resolved = "unresolved"
+			}
+			fmt.Printf("     command:     %s (%s)\n", strings.Join(svc.Command, " "), resolved)
+			if _, _, err := lookupUIDGID(svc.User, svc.Group); err != nil {
+				problem(svc.Name, "user/group %s:%s: %v", svc.User, svc.Group, err)
+			}
+			fmt.Printf("     user:        %s:%s\n", svc.User, svc.Group)
+		}
+	}
+
+	predecessors := orderingPredecessors(config.Services)
+	step := 0
+	for i, tier := range tiers {
+		fmt.Printf("\nTier %d:\n", i+1)
+		for _, name := range tier {
+			svc := config.Services[name]
+			step++
+
+			header := fmt.Sprintf("  %d. %s", step, name)
+			if len(predecessors[name]) > 0 {
+				header += fmt.Sprintf(" (after %s)", strings.Join(predecessors[name], ", "))
+			}
+			fmt.Println(header)
+
+			resolved, err := resolveCommand(svc)
+			if err != nil {
+				problem(name, "%v", err)
+				resolved = "unresolved"
+			}
+			fmt.Printf("     command:     %s (%s)\n", strings.Join(svc.Command, " "), resolved)
+
+			uid, gid, err := lookupUIDGID(svc.User, svc.Group)
+			if err != nil {
+				problem(name, "user/group %s:%s: %v", svc.User, svc.Group, err)
+				fmt.Printf("     user:        %s:%s (unresolved)\n", svc.User, svc.Group)
+			} else {
+				fmt.Printf("     user:        %s:%s (uid %d, gid %d)\n", svc.User, svc.Group, uid, gid)
+			}
+
+			if svc.WorkingDir != "" {
+				if info, err := os.Stat(svc.WorkingDir); err != nil {
+					problem(name, "working_dir %s: %v", svc.WorkingDir, err)
+				} else if !info.IsDir() {
+					problem(name, "working_dir %s is not a directory", svc.WorkingDir)
+				}
+				fmt.Printf("     working_dir: %s\n", svc.WorkingDir)
+			}
+
+			if len(svc.Environment) > 0 {
+				var keys []string
+				for k := range svc.Environment {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				fmt.Printf("     environment: %s\n", strings.Join(keys, ", "))
+			}
+			if svc.Timezone != "" || svc.Locale != "" {
+				fmt.Printf("     locale:      %s\n", strings.Join(localeEnvironment(svc), " "))
+			}
+
+			if svc.Oneshot {
+				if svc.Interval > 0 {
+					fmt.Printf("     schedule:    oneshot every %s\n", svc.Interval)
+				} else {
+					fmt.Printf("     schedule:    oneshot\n")
+				}
+			} else {
+				restart := svc.Restart
+				if restart == "" {
+					restart = RestartNever
+				}
+				fmt.Printf("     restart:     %s\n", restart)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		fmt.Printf("\nProblems:\n")
+		for _, p := range problems {
+			fmt.Printf("  %s\n", p)
+		}
+	} else {
+		fmt.Printf("\nNo problems found\n")
+	}
+	return len(problems)
+}
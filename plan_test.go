@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns what fn prints to standard output
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-output
+}
+
+func TestResolveCommand(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "run.sh"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		svc  Service
+		want string
+		err  string
+	}{
+		{Service{Command: []string{"./run.sh"}, WorkingDir: dir}, filepath.Join(dir, "run.sh"), ""},
+		{Service{Command: []string{filepath.Join(dir, "run.sh")}}, filepath.Join(dir, "run.sh"), ""},
+		{Service{Command: []string{"./notes.txt"}, WorkingDir: dir}, "", "is not executable"},
+		{Service{Command: []string{dir}}, "", "is not executable"},
+		{Service{Command: []string{"./missing"}, WorkingDir: dir}, "", "not found"},
+		{Service{Command: []string{"pei-no-such-command"}}, "", "not found in PATH"},
+		{Service{}, "", "no command configured"},
+	}
+	for _, tt := range tests {
+		got, err := resolveCommand(tt.svc)
+		if tt.err == "" {
+			if err != nil || got != tt.want {
+				t.Errorf("%v: expected %s, got %s, %v", tt.svc.Command, tt.want, got, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%v: expected error containing %q, got %v", tt.svc.Command, tt.err, err)
+		}
+	}
+
+	// Bare names are looked up in PATH
+	if got, err := resolveCommand(Service{Command: []string{"sh"}}); err != nil || !filepath.IsAbs(got) {
+		t.Errorf("expected sh resolved through PATH, got %s, %v", got, err)
+	}
+}
+
+func TestShowPlan(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := user.LookupGroupId(current.Gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{Services: map[string]Service{
+		"db":  {Name: "db", Command: []string{"sleep", "30"}, User: current.Username, Group: group.Name},
+		"web": {Name: "web", Command: []string{"pei-no-such-command"}, User: current.Username, Group: group.Name, After: []string{"db"}},
+		"job": {Name: "job", Command: []string{"sh"}, User: "pei-no-such-user", Group: group.Name, WorkingDir: "/pei-no-such-dir"},
+	}}
+
+	var problems int
+	output := captureStdout(t, func() { problems = showPlan(config, "pei.yaml") })
+
+	// web's missing command, and job's user and working directory
+	if problems != 3 {
+		t.Errorf("expected 3 problems, got %d:\n%s", problems, output)
+	}
+	if !strings.Contains(output, "web (after db)") || strings.Index(output, "Tier 2") > strings.Index(output, "web (after db)") {
+		t.Errorf("expected web planned after db, got:\n%s", output)
+	}
+	for _, want := range []string{
+		"web: command \"pei-no-such-command\" not found in PATH",
+		"job: user/group pei-no-such-user:",
+		"job: working_dir /pei-no-such-dir:",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected problem %q, got:\n%s", want, output)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// Post-start check defaults
+const (
+	defaultPostStartDelay   = time.Second
+	defaultPostStartTimeout = 10 * time.Second
+)
+
+// PostStartCheck is a smoke test run once shortly after a service starts.
+// If it fails the start counts as failed: the instance is stopped and the
+// service's restart policy decides what happens next.
+type PostStartCheck struct {
+	Command []string      `yaml:"command"`
+	Delay   time.Duration `yaml:"delay"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (c *PostStartCheck) delay() time.Duration {
+	if c.Delay > 0 {
+		return c.Delay
+	}
+	return defaultPostStartDelay
+}
+
+func (c *PostStartCheck) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return defaultPostStartTimeout
+}
+
+// validatePostStartChecks checks every service's post_start_check
+func (c *Config) validatePostStartChecks() error {
+	for name, svc := range c.Services {
+		check := svc.PostStartCheck
+		if check == nil {
+			continue
+		}
+		if len(check.Command) == 0 {
+			return serviceErrorf(name, "post_start_check", "needs a command")
+		}
+		if check.Delay < 0 || check.Timeout < 0 {
+			return serviceErrorf(name, "post_start_check", "delay and timeout must not be negative")
+		}
+	}
+	return nil
+}
+
+// postStartRequest asks the service manager to run an instance's
+// post-start check, which needs elevated privileges
+type postStartRequest struct {
+	svc  Service
+	proc *serviceProcess
+}
+
+// schedulePostStartCheck queues an instance's post-start check once its delay
+// has passed and the instance is ready
+func (d *Daemon) schedulePostStartCheck(svc Service, proc *serviceProcess) {
+	if svc.PostStartCheck == nil {
+		return
+	}
+
+	go func() {
+		select {
+		case <-time.After(svc.PostStartCheck.delay()):
+		case <-proc.exited:
+			return
+		}
+		select {
+		case <-proc.ready.ch:
+		case <-proc.exited:
+			return
+		}
+
+		select {
+		case d.postStartChan <- postStartRequest{svc: svc, proc: proc}:
+		case <-proc.exited:
+		case <-d.ctx.Done():
+		}
+	}()
+}
+
+// runPostStartCheck runs an instance's post-start check as the service's
+// user, stopping the instance if it fails. Must be called with elevated
+// privileges.
+func (d *Daemon) runPostStartCheck(svc Service, proc *serviceProcess) {
+	if !proc.running() {
+		return
+	}
+	check := svc.PostStartCheck
+
+	ctx, cancel := context.WithTimeout(context.Background(), check.timeout())
+	defer cancel()
+	cmd, err := buildHelperCmd(ctx, svc, check.Command)
+	if err == nil {
+		var output []byte
+		if output, err = combinedOutput(cmd); err != nil {
+			err = fmt.Errorf("%v: %s", err, output)
+		}
+	}
+	if err == nil {
+		logServiceInfo(svc.Name, "Post-start check passed", "pid", proc.cmd.Process.Pid)
+		return
+	}
+
+	detail := err.Error()
+	logServiceError(svc.Name, "Post-start check failed, stopping service", "pid", proc.cmd.Process.Pid, "error", err)
+	d.events.record(EventPostStartCheckFailed, svc.Name, "Post-start check failed", map[string]string{"error": detail})
+
+	// monitorService reads this once the instance has exited
+	proc.failedCheck.Store(&detail)
+	if err := proc.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		logServiceError(svc.Name, "Failed to send SIGTERM to service", "error", err)
+	}
+	go func() {
+		select {
+		case <-proc.exited:
+		case <-time.After(serviceStopTimeout):
+			logServiceError(svc.Name, "Service did not stop in time, killing it", "timeout", serviceStopTimeout.String())
+			proc.cmd.Process.Kill()
+		}
+	}()
+}
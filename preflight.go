@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// serviceProblem is one reason a service can't be started
+type serviceProblem struct {
+	service string
+	err     error
+}
+
+func (p serviceProblem) Error() string {
+	return fmt.Sprintf("service %s: %v", p.service, p.err)
+}
+
+// preflightServices checks every service against the system before anything
+// is started: its user and group resolve, its command exists (unless it has
+// start retries) and matches any checksum or signature, the services it depends on are configured, and no
+// two services write the same output file.
+// All problems are returned, in service name order, rather than the first.
+func preflightServices(config *Config) []serviceProblem {
+	var names []string
+	for name := range config.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []serviceProblem
+	report := func(name string, format string, args ...any) {
+		problems = append(problems, serviceProblem{service: name, err: fmt.Errorf(format, args...)})
+	}
+
+	// Init steps run as their own users too
+	for _, step := range config.Init {
+		if _, _, err := lookupUIDGID(step.User, step.Group); err != nil {
+			report(step.Name, "user %s:%s: %v", step.User, step.Group, err)
+		}
+		if _, err := resolveCommand(step.service()); err != nil {
+			report(step.Name, "%v", err)
+		}
+	}
+
+	// Output paths and the first service writing each
+	writers := make(map[string]string)
+
+	for _, name := range names {
+		svc := config.Services[name]
+
+		if _, _, err := lookupUIDGID(svc.User, svc.Group); err != nil {
+			report(name, "user %s:%s: %v", svc.User, svc.Group, err)
+		}
+		// A service retried at startup may be waiting for its command to
+		// appear, on a volume that isn't mounted yet
+		if _, err := resolveCommand(svc); err != nil {
+			if svc.StartRetries == 0 {
+				report(name, "%v", err)
+			}
+		} else if err := verifyCommand(svc); err != nil {
+			report(name, "%v", err)
+		}
+		for _, dep := range svc.DependsOn {
+			if _, exists := config.Services[dep]; !exists {
+				report(name, "depends_on unknown service %q", dep)
+			}
+		}
+
+		// A service may send both streams to one file, but services can't
+		// share one
+		paths := []string{svc.Stdout}
+		if svc.Stderr != svc.Stdout {
+			paths = append(paths, svc.Stderr)
+		}
+		for _, path := range paths {
+			if path == "" || strings.HasPrefix(path, "/dev/") {
+				continue
+			}
+			if writer, exists := writers[path]; exists {
+				report(name, "output path %s is also used by service %s", path, writer)
+				continue
+			}
+			writers[path] = name
+		}
+	}
+	return problems
+}
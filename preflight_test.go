@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPreflightServices(t *testing.T) {
+	config := &Config{Services: map[string]Service{
+		"good": {
+			Name: "good", Command: []string{"true"}, User: "root", Group: "root",
+			Stdout: "/var/log/good.log", Stderr: "/var/log/good.log",
+		},
+		"nouser": {
+			Name: "nouser", Command: []string{"true"}, User: "no-such-user", Group: "root",
+		},
+		"nocommand": {
+			Name: "nocommand", Command: []string{"no-such-command"}, User: "root", Group: "root",
+			DependsOn: []string{"missing"},
+		},
+		"clash": {
+			Name: "clash", Command: []string{"true"}, User: "root", Group: "root",
+			Stdout: "/var/log/good.log",
+		},
+	}}
+
+	problems := preflightServices(config)
+	var messages []string
+	for _, problem := range problems {
+		messages = append(messages, problem.Error())
+	}
+
+	expected := []string{
+		"service good: output path /var/log/good.log is also used by service clash",
+		`service nocommand: command "no-such-command" not found in PATH`,
+		`service nocommand: depends_on unknown service "missing"`,
+		"service nouser: user no-such-user:root",
+	}
+	if len(messages) != len(expected) {
+		t.Fatalf("expected %d problems, got %d: %v", len(expected), len(messages), messages)
+	}
+	for i, prefix := range expected {
+		if !strings.HasPrefix(messages[i], prefix) {
+			t.Errorf("problem %d: expected %q, got %q", i, prefix, messages[i])
+		}
+	}
+}
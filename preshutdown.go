@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// defaultPreShutdownTimeout bounds the pre-shutdown hook if it sets no timeout
+const defaultPreShutdownTimeout = 30 * time.Second
+
+// PreShutdown is a command run when pei is asked to stop, before any service
+// is stopped, such as deregistering the container from service discovery
+// while its services keep serving
+type PreShutdown struct {
+	Command []string      `yaml:"command"`
+	User    string        `yaml:"user"`
+	Group   string        `yaml:"group"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (p *PreShutdown) timeout() time.Duration {
+	if p.Timeout > 0 {
+		return p.Timeout
+	}
+	return defaultPreShutdownTimeout
+}
+
+// validatePreShutdown checks the pre_shutdown hook
+func (c *Config) validatePreShutdown() error {
+	if c.PreShutdown == nil {
+		return nil
+	}
+	if len(c.PreShutdown.Command) == 0 {
+		return fieldErrorf([]string{"pre_shutdown"}, "needs a command")
+	}
+	if c.PreShutdown.Timeout < 0 {
+		return fieldErrorf([]string{"pre_shutdown", "timeout"}, "must not be negative")
+	}
+	if (c.PreShutdown.User == "") != (c.PreShutdown.Group == "") {
+		return fieldErrorf([]string{"pre_shutdown"}, "set both user and group, or neither")
+	}
+	return nil
+}
+
+// runPreShutdown runs the pre-shutdown hook, as pei's app user unless it
+// names another. Shutdown goes ahead whether or not it succeeds. Must be
+// called with elevated privileges.
+func (d *Daemon) runPreShutdown(shutdownLogger *slog.Logger) {
+	hook := d.getConfig().PreShutdown
+	if hook == nil {
+		return
+	}
+
+	svc := Service{Name: "pre_shutdown", Command: hook.Command, User: hook.User, Group: hook.Group, Oneshot: true}
+	if svc.User == "" {
+		svc.User, svc.Group = d.appUser, d.appGroup
+	}
+
+	shutdownLogger.Info("Running pre-shutdown hook", "timeout", hook.timeout().String())
+	started := time.Now()
+	if err := d.runOnce(svc, hook.timeout()); err != nil {
+		shutdownLogger.Error("Pre-shutdown hook failed, stopping services anyway", "error", err)
+		return
+	}
+	shutdownLogger.Info("Pre-shutdown hook completed", "duration", time.Since(started).String())
+}
@@ -0,0 +1,37 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// credentialSwitching is off here: pei runs as an ordinary process for local
+// development and every service runs as the user who started it
+const credentialSwitching = false
+
+// asServiceUser just runs fn: every service runs as pei's own user here
+func asServiceUser(uid, gid int, fn func() error) error {
+	return fn()
+}
+
+// serviceCredential leaves processes with pei's own credentials
+func serviceCredential(uid, gid int) *syscall.Credential {
+	return nil
+}
+
+// dropPrivileges has nothing to drop without credential switching
+func dropPrivileges(appUser, appGroup string) error {
+	return nil
+}
+
+// elevatePrivileges has nothing to elevate to without credential switching
+func elevatePrivileges() error {
+	return nil
+}
+
+// peerUID isn't available here, so local peers share one rate limit
+func peerUID(conn *net.UnixConn) (int, bool) {
+	return 0, false
+}
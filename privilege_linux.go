@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// credentialSwitching is whether pei runs services as their configured users,
+// which needs pei to start as root, as PID 1 of a container
+const credentialSwitching = true
+
+// asServiceUser runs fn with file access checked as a service's user and
+// group rather than pei's, on this thread only, so what fn creates is theirs
+// and a symlink can't lead it anywhere they couldn't write themselves
+func asServiceUser(uid, gid int, fn func() error) error {
+	runtime.LockOSThread()
+	euid, egid := os.Geteuid(), os.Getegid()
+	if err := syscall.Setfsgid(gid); err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	if err := syscall.Setfsuid(uid); err != nil {
+		syscall.Setfsgid(egid)
+		runtime.UnlockOSThread()
+		return err
+	}
+	err := fn()
+	// A thread left with the wrong credentials mustn't be reused: leaving it
+	// locked ends it with this goroutine
+	if syscall.Setfsuid(euid) == nil && syscall.Setfsgid(egid) == nil {
+		runtime.UnlockOSThread()
+	}
+	return err
+}
+
+// serviceCredential returns the credential a process started as a user runs
+// with
+func serviceCredential(uid, gid int) *syscall.Credential {
+	return &syscall.Credential{
+		Uid: uint32(uid),
+		Gid: uint32(gid),
+	}
+}
+
+func dropPrivileges(appUser, appGroup string) error {
+	uid, gid, err := lookupUIDGID(appUser, appGroup)
+	if err != nil {
+		return err
+	}
+	// Store current root credentials (effective UID/GID)
+	rootUid := os.Geteuid()
+	rootGid := os.Getegid()
+
+	// Switch to target user, keeping root as real UID
+	if err := syscall.Setreuid(rootUid, uid); err != nil {
+		return err
+	}
+	if err := syscall.Setregid(rootGid, gid); err != nil {
+		// Try to restore root privileges if setting group fails
+		// Restore both UID and GID to prevent partial privilege state
+		// Attempting to restore UID to rootUid after Setregid failure.
+		fmt.Printf("Setregid failed: %v. Attempting to restore UID to %d.\n", err, rootUid)
+		if restoreUidErr := syscall.Setreuid(uid, rootUid); restoreUidErr != nil {
+			return fmt.Errorf("failed to set group and restore UID: %v, %v", err, restoreUidErr)
+		}
+		// Note: GID should already be at rootGid since Setregid failed
+		return err
+	}
+	return nil
+}
+
+func elevatePrivileges() error {
+	// Get the real UID/GID (which should be root)
+	rootUid := os.Getuid()
+	rootGid := os.Getgid()
+
+	// Store current effective UID/GID for potential restoration
+	prevEffectiveUid := os.Geteuid()
+	prevEffectiveGid := os.Getegid()
+
+	// Switch effective UID/GID back to root
+	if err := syscall.Setreuid(prevEffectiveUid, rootUid); err != nil {
+		return err
+	}
+	if err := syscall.Setregid(prevEffectiveGid, rootGid); err != nil {
+		// Try to restore previous state if setting group fails
+		// Restore both UID and GID to prevent partial privilege state
+		if restoreUidErr := syscall.Setreuid(rootUid, prevEffectiveUid); restoreUidErr != nil {
+			return fmt.Errorf("failed to set group and restore UID: %v, %v", err, restoreUidErr)
+		}
+		// Note: GID should already be at prevEffectiveGid since Setregid failed
+		return err
+	}
+	return nil
+}
+
+// peerUID returns the user on the other end of a unix socket
+func peerUID(conn *net.UnixConn) (int, bool) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil || credErr != nil {
+		return 0, false
+	}
+	return int(cred.Uid), true
+}
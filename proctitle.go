@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// scrubArgsDelay is how long after starting a service that doesn't signal
+// readiness its arguments are hidden, giving it time to read them first
+const scrubArgsDelay = time.Second
+
+// validateProcessTitles checks process_title and scrub_args
+func (c *Config) validateProcessTitles() error {
+	for name, svc := range c.Services {
+		if svc.ProcessTitle != "" && strings.TrimSpace(svc.ProcessTitle) == "" {
+			return serviceErrorf(name, "process_title", "must not be blank")
+		}
+		for i, flag := range svc.ScrubArgs {
+			if !strings.HasPrefix(flag, "-") {
+				return fieldErrorf([]string{"services", name, "scrub_args", listIndex(i)}, "%q must be a flag, such as --password", flag)
+			}
+		}
+		if len(svc.ScrubArgs) > 0 && svc.UserNamespace != nil {
+			return serviceErrorf(name, "scrub_args", "can't be used with user_namespace")
+		}
+	}
+	return nil
+}
+
+// commandLine is the argv a service's process runs with: its command, with
+// its process_title as argv[0] if it has one
+func commandLine(svc Service) []string {
+	argv := slices.Clone(svc.Command)
+	if svc.ProcessTitle != "" {
+		argv[0] = svc.ProcessTitle
+	}
+	return argv
+}
+
+// scrubbedArgs masks the values of the given flags in argv, whether passed
+// as --flag value or --flag=value. Masks keep each argument's length, so the
+// command line can be rewritten in place.
+func scrubbedArgs(argv []string, flags []string) []string {
+	mask := func(s string) string { return strings.Repeat("*", len(s)) }
+	scrubbed := slices.Clone(argv)
+	for i := 1; i < len(scrubbed); i++ {
+		arg := scrubbed[i]
+		if arg == "--" {
+			break
+		}
+		for _, flag := range flags {
+			if arg == flag && i+1 < len(scrubbed) {
+				scrubbed[i+1] = mask(scrubbed[i+1])
+				i++
+				break
+			}
+			if value, found := strings.CutPrefix(arg, flag+"="); found {
+				scrubbed[i] = flag + "=" + mask(value)
+				break
+			}
+		}
+	}
+	return scrubbed
+}
+
+// scrubRequest asks the service manager to hide an instance's secret
+// arguments, which needs its user's access to the process
+type scrubRequest struct {
+	svc      Service
+	proc     *serviceProcess
+	uid, gid int
+}
+
+// scheduleScrubArgs queues hiding an instance's scrub_args once it has had
+// time to read them: when it's ready, for services that signal readiness,
+// or shortly after it starts otherwise
+func (d *Daemon) scheduleScrubArgs(svc Service, proc *serviceProcess, uid, gid int) {
+	if len(svc.ScrubArgs) == 0 {
+		return
+	}
+
+	go func() {
+		wait := time.Duration(0)
+		if !svc.signalsReadiness() {
+			wait = scrubArgsDelay
+		}
+		select {
+		case <-time.After(wait):
+		case <-proc.exited:
+			return
+		}
+		select {
+		case <-proc.ready.ch:
+		case <-proc.exited:
+			return
+		}
+
+		select {
+		case d.scrubChan <- scrubRequest{svc: svc, proc: proc, uid: uid, gid: gid}:
+		case <-proc.exited:
+		case <-d.ctx.Done():
+		}
+	}()
+}
+
+// scrubArgs rewrites an instance's command line so ps and /proc show its
+// scrub_args' values masked. Must be called with elevated privileges.
+func (d *Daemon) scrubArgs(req scrubRequest) {
+	if !req.proc.running() {
+		return
+	}
+	pid := req.proc.cmd.Process.Pid
+	argv := commandLine(req.svc)
+	if err := rewriteCmdline(pid, req.uid, req.gid, argv, scrubbedArgs(argv, req.svc.ScrubArgs)); err != nil {
+		logServiceError(req.svc.Name, "Failed to scrub arguments from command line", "pid", pid, "error", err)
+		return
+	}
+	logServiceInfo(req.svc.Name, "Scrubbed arguments from command line", "pid", pid)
+}
+
+// errCmdlineChanged is returned when a process has already changed its own
+// command line, so pei leaves it alone
+var errCmdlineChanged = fmt.Errorf("command line was changed since the process started")
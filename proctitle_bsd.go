@@ -0,0 +1,11 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import "errors"
+
+// rewriteCmdline is not supported here: there's no /proc/PID/mem to write a
+// process's arguments through
+func rewriteCmdline(pid, uid, gid int, argv, replacement []string) error {
+	return errors.New("not supported on this platform")
+}
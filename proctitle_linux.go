@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// rewriteCmdline overwrites a process's command line in its memory, where
+// /proc/PID/cmdline and ps read it from, once it has checked the memory
+// still holds argv. The replacement must be the same length. The process's
+// memory is opened with its own uid and gid, so pei needs no CAP_SYS_PTRACE.
+func rewriteCmdline(pid, uid, gid int, argv, replacement []string) error {
+	want := strings.Join(argv, "\x00") + "\x00"
+	write := strings.Join(replacement, "\x00") + "\x00"
+	if len(write) != len(want) {
+		return fmt.Errorf("replacement command line is %d bytes, not %d", len(write), len(want))
+	}
+
+	start, end, err := cmdlineBounds(pid)
+	if err != nil {
+		return err
+	}
+	if end-start != int64(len(want)) {
+		return errCmdlineChanged
+	}
+	mem, err := openAsUser(fmt.Sprintf("/proc/%d/mem", pid), uid, gid)
+	if err != nil {
+		return err
+	}
+	defer mem.Close()
+
+	current := make([]byte, len(want))
+	if _, err := mem.ReadAt(current, start); err != nil {
+		return err
+	}
+	if string(current) != want {
+		return errCmdlineChanged
+	}
+	_, err = mem.WriteAt([]byte(write), start)
+	return err
+}
+
+// cmdlineBounds reads where a process's arguments are in its memory, from
+// the arg_start and arg_end fields of /proc/PID/stat
+func cmdlineBounds(pid int) (int64, int64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+	// Fields are counted from the state, after the command name, which can
+	// hold spaces and parentheses
+	paren := strings.LastIndexByte(string(data), ')')
+	fields := strings.Fields(string(data[paren+1:]))
+	if paren < 0 || len(fields) < 47 {
+		return 0, 0, fmt.Errorf("unexpected /proc/%d/stat format", pid)
+	}
+	start, err := strconv.ParseInt(fields[45], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := strconv.ParseInt(fields[46], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// openAsUser opens a file read-write with the filesystem uid and gid of a
+// user, which only this thread takes on while it does
+func openAsUser(path string, uid, gid int) (*os.File, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	oldGID, _, _ := syscall.RawSyscall(syscall.SYS_SETFSGID, uintptr(gid), 0, 0)
+	oldUID, _, _ := syscall.RawSyscall(syscall.SYS_SETFSUID, uintptr(uid), 0, 0)
+	defer func() {
+		syscall.RawSyscall(syscall.SYS_SETFSUID, oldUID, 0, 0)
+		syscall.RawSyscall(syscall.SYS_SETFSGID, oldGID, 0, 0)
+	}()
+	return os.OpenFile(path, os.O_RDWR, 0)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRewriteCmdline(t *testing.T) {
+	argv := []string{"sleeper", "-c", "sleep 10; :", "sh", "--password", "hunter2"}
+	cmd := exec.Command("/bin/sh", argv[1:]...)
+	cmd.Args[0] = argv[0]
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	pid := cmd.Process.Pid
+
+	// Wait for the exec, until which the child still has the test's command line
+	path := fmt.Sprintf("/proc/%d/cmdline", pid)
+	original := strings.Join(argv, "\x00") + "\x00"
+	deadline := time.Now().Add(5 * time.Second)
+	for data, _ := os.ReadFile(path); string(data) != original; data, _ = os.ReadFile(path) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected cmdline %q, got %q", original, data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	scrubbed := scrubbedArgs(argv, []string{"--password"})
+	if err := rewriteCmdline(pid, os.Getuid(), os.Getgid(), argv, scrubbed); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "sleeper\x00-c\x00sleep 10; :\x00sh\x00--password\x00*******\x00"; string(data) != want {
+		t.Errorf("expected cmdline %q, got %q", want, data)
+	}
+
+	// A command line that isn't what pei started is left alone
+	if err := rewriteCmdline(pid, os.Getuid(), os.Getgid(), argv, scrubbed); !errors.Is(err, errCmdlineChanged) {
+		t.Errorf("expected a changed command line to be refused, got %v", err)
+	}
+}
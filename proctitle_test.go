@@ -0,0 +1,51 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestScrubbedArgs(t *testing.T) {
+	argv := []string{"pei:web", "--password", "hunter2", "--token=abc", "--port", "8080", "--", "--password", "literal"}
+	expected := []string{"pei:web", "--password", "*******", "--token=***", "--port", "8080", "--", "--password", "literal"}
+	if got := scrubbedArgs(argv, []string{"--password", "--token"}); !slices.Equal(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	// A flag with nothing after it has nothing to hide
+	if got := scrubbedArgs([]string{"web", "--password"}, []string{"--password"}); !slices.Equal(got, []string{"web", "--password"}) {
+		t.Errorf("expected the arguments unchanged, got %q", got)
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	svc := Service{Command: []string{"/app/web", "--port", "8080"}, ProcessTitle: "pei:web"}
+	if got := commandLine(svc); !slices.Equal(got, []string{"pei:web", "--port", "8080"}) {
+		t.Errorf("expected the title as argv[0], got %q", got)
+	}
+	cmd := buildServiceCmd(svc, 1000, 1000)
+	if cmd.Args[0] != "pei:web" || cmd.Path != "/app/web" {
+		t.Errorf("expected /app/web run as pei:web, got %s as %q", cmd.Path, cmd.Args)
+	}
+	if svc.Command[0] != "/app/web" {
+		t.Error("expected the service's command left alone")
+	}
+}
+
+func TestValidateProcessTitles(t *testing.T) {
+	cases := []struct {
+		svc Service
+		err string
+	}{
+		{Service{ProcessTitle: "  "}, "process_title: must not be blank"},
+		{Service{ScrubArgs: []string{"password"}}, `"password" must be a flag`},
+		{Service{ScrubArgs: []string{"--password"}, UserNamespace: &UserNamespace{}}, "can't be used with user_namespace"},
+	}
+	for _, c := range cases {
+		config := &Config{Services: map[string]Service{"web": c.svc}}
+		if err := config.validateProcessTitles(); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%+v: expected error containing %q, got %v", c.svc, c.err, err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+func ioctl(fd, request, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// ptyReader reads from a PTY master, treating the EIO returned once the
+// slave side has been closed as a normal end of output
+type ptyReader struct {
+	*os.File
+}
+
+func (p ptyReader) Read(b []byte) (int, error) {
+	n, err := p.File.Read(b)
+	if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.EIO {
+		return n, io.EOF
+	}
+	return n, err
+}
+
+// serviceIO holds pei's ends of a service's standard streams
+type serviceIO struct {
+	stdout io.ReadCloser
+	stderr io.ReadCloser
+
+	// input writes to the service's terminal, nil unless it runs with a PTY
+	input io.Writer
+
+	// afterStart releases the child's ends once the command has been
+	// started, or has failed to start
+	afterStart func(started bool)
+}
+
+// setupServiceOutput connects a service's output to pipes, or to a PTY when
+// tty is set, that pei reads from
+func setupServiceOutput(cmd *exec.Cmd, svc Service, uid, gid int) (*serviceIO, error) {
+	if !svc.TTY {
+		// Plain pipes rather than cmd.StdoutPipe, which Wait closes before
+		// the last of the output may have been read
+		stdout, stdoutW, err := os.Pipe()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create stdout pipe: %v", err)
+		}
+		stderr, stderrW, err := os.Pipe()
+		if err != nil {
+			stdout.Close()
+			stdoutW.Close()
+			return nil, fmt.Errorf("failed to create stderr pipe: %v", err)
+		}
+		cmd.Stdout = stdoutW
+		cmd.Stderr = stderrW
+		return &serviceIO{
+			stdout: stdout,
+			stderr: stderr,
+			afterStart: func(started bool) {
+				stdoutW.Close()
+				stderrW.Close()
+				if !started {
+					stdout.Close()
+					stderr.Close()
+				}
+			},
+		}, nil
+	}
+
+	master, slave, err := openPTY()
+	if err != nil {
+		return nil, err
+	}
+
+	// Let the service reopen its terminal by name after dropping to its user
+	if err := slave.Chown(uid, gid); err != nil {
+		logServiceError(svc.Name, "Failed to chown pty to service user", "error", err)
+	}
+
+	// The slave becomes the controlling terminal (fd 0) of the new session
+	// set up in serviceSysProcAttr
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	return &serviceIO{
+		stdout: ptyReader{master},
+		input:  master,
+		afterStart: func(started bool) {
+			slave.Close()
+			if !started {
+				master.Close()
+			}
+		},
+	}, nil
+}
+
+// getWinsize reads the window size of a terminal, failing if fd isn't one
+func getWinsize(fd uintptr) (rows, cols uint16, err error) {
+	var ws struct{ Row, Col, Xpixel, Ypixel uint16 }
+	if err := ioctl(fd, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws))); err != nil {
+		return 0, 0, err
+	}
+	return ws.Row, ws.Col, nil
+}
+
+// setWinsize sets the window size of a terminal
+func setWinsize(fd uintptr, rows, cols uint16) error {
+	ws := struct{ Row, Col, Xpixel, Ypixel uint16 }{Row: rows, Col: cols}
+	return ioctl(fd, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+}
+
+// makeRaw puts a terminal into raw mode and returns its previous state
+func makeRaw(fd uintptr) (*syscall.Termios, error) {
+	var old syscall.Termios
+	if err := ioctl(fd, ioctlGetTermios, uintptr(unsafe.Pointer(&old))); err != nil {
+		return nil, err
+	}
+
+	raw := old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+
+	if err := ioctl(fd, ioctlSetTermios, uintptr(unsafe.Pointer(&raw))); err != nil {
+		return nil, err
+	}
+	return &old, nil
+}
+
+// restoreTerminal puts a terminal back into a state saved by makeRaw
+func restoreTerminal(fd uintptr, state *syscall.Termios) error {
+	return ioctl(fd, ioctlSetTermios, uintptr(unsafe.Pointer(state)))
+}
@@ -0,0 +1,21 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Terminal attribute ioctls
+const (
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+)
+
+// openPTY is not supported here, so services with tty: true fail to start.
+// pei attach still works for output and puts the local terminal in raw mode.
+func openPTY() (master, slave *os.File, err error) {
+	return nil, nil, errors.New("tty is only supported on Linux")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestSetupServiceOutputTTY(t *testing.T) {
+	svc := Service{Name: "web", TTY: true}
+	cmd := exec.Command("sh", "-c", `test -t 0 && test -t 1 && echo tty; read line; echo "got $line"`)
+	cmd.SysProcAttr = serviceSysProcAttr(svc, os.Getuid(), os.Getgid())
+	cmd.SysProcAttr.Credential = nil // stay the current user
+	streams, err := setupServiceOutput(cmd, svc, os.Getuid(), os.Getgid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Start()
+	streams.afterStart(err == nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streams.stderr != nil || streams.input == nil {
+		t.Fatal("expected one terminal for output, that takes input")
+	}
+
+	// Input goes to the service's terminal, and is echoed back with its output
+	if _, err := io.WriteString(streams.input, "hello\n"); err != nil {
+		t.Fatal(err)
+	}
+	cmd.Wait()
+	output, err := io.ReadAll(streams.stdout)
+	if err != nil {
+		t.Fatalf("expected the closed terminal read as the end of output, got %v", err)
+	}
+	if got := strings.ReplaceAll(string(output), "\r\n", "\n"); !strings.Contains(got, "tty\n") || !strings.Contains(got, "got hello\n") {
+		t.Errorf("expected the service to run on a terminal and read its input, got %q", output)
+	}
+	streams.stdout.Close()
+}
+
+func TestSetupServiceOutputPipes(t *testing.T) {
+	svc := Service{Name: "web"}
+	cmd := exec.Command("sh", "-c", "test -t 1 || echo out; echo err >&2")
+	streams, err := setupServiceOutput(cmd, svc, os.Getuid(), os.Getgid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Start()
+	streams.afterStart(err == nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streams.input != nil {
+		t.Error("expected no input without a terminal")
+	}
+
+	stdout, _ := io.ReadAll(streams.stdout)
+	stderr, _ := io.ReadAll(streams.stderr)
+	cmd.Wait()
+	if string(stdout) != "out\n" || string(stderr) != "err\n" {
+		t.Errorf("expected output split across pipes, got %q and %q", stdout, stderr)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// defaultReadyTimeout is how long boot waits for a service that signals
+// readiness before starting the services ordered after it anyway
+const defaultReadyTimeout = time.Minute
+
+// readyFilePollInterval is how often pei checks for a ready file whose
+// directory doesn't exist yet, so it can't be watched
+const readyFilePollInterval = 250 * time.Millisecond
+
+// signalsReadiness reports whether a service tells pei when it is ready,
+// rather than counting as ready as soon as it has started
+func (s Service) signalsReadiness() bool {
+	return s.ReadyFile != "" || s.ReadyLogPattern != ""
+}
+
+func (s Service) readyTimeout() time.Duration {
+	if s.ReadyTimeout > 0 {
+		return s.ReadyTimeout
+	}
+	return defaultReadyTimeout
+}
+
+// validateReadiness checks every service's readiness settings
+func (c *Config) validateReadiness() error {
+	for name, svc := range c.Services {
+		if svc.ReadyFile != "" && svc.ReadyLogPattern != "" {
+			return serviceErrorf(name, "ready_log_pattern", "set only one of ready_file or ready_log_pattern")
+		}
+		if svc.ReadyFile != "" && !filepath.IsAbs(svc.ReadyFile) {
+			return serviceErrorf(name, "ready_file", "must be an absolute path")
+		}
+		if svc.ReadyLogPattern != "" {
+			if _, err := regexp.Compile(svc.ReadyLogPattern); err != nil {
+				return serviceErrorf(name, "ready_log_pattern", "%v", err)
+			}
+		}
+		if svc.ReadyTimeout < 0 {
+			return serviceErrorf(name, "ready_timeout", "must not be negative")
+		}
+	}
+	return nil
+}
+
+// readyState tracks whether an instance has become ready
+type readyState struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+func newReadyState() *readyState {
+	return &readyState{ch: make(chan struct{})}
+}
+
+// mark records that the instance is ready, reporting whether it wasn't already
+func (r *readyState) mark() bool {
+	marked := false
+	r.once.Do(func() {
+		close(r.ch)
+		marked = true
+	})
+	return marked
+}
+
+func (r *readyState) isReady() bool {
+	select {
+	case <-r.ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// prepareReadiness clears a ready file left by a previous instance, so only
+// the new instance can mark itself ready. Must be called before it starts.
+func prepareReadiness(svc Service) {
+	if svc.ReadyFile == "" {
+		return
+	}
+	if err := os.Remove(svc.ReadyFile); err != nil && !os.IsNotExist(err) {
+		logServiceError(svc.Name, "Failed to remove stale ready file", "path", svc.ReadyFile, "error", err)
+	}
+}
+
+// watchReadiness marks an instance ready once it signals readiness, or
+// straight away if the service doesn't signal it
+func (d *Daemon) watchReadiness(svc Service, proc *serviceProcess) {
+	if !svc.signalsReadiness() {
+		d.setReady(svc, proc, "")
+		return
+	}
+
+	if svc.ReadyFile != "" {
+		go func() {
+			if err := waitForFile(svc.ReadyFile, proc.exited); err != nil {
+				if proc.running() {
+					logServiceError(svc.Name, "Failed to watch ready file", "path", svc.ReadyFile, "error", err)
+				}
+				return
+			}
+			d.setReady(svc, proc, "ready_file")
+		}()
+	}
+}
+
+// readyLogMatcher returns a function, called with each line of an
+// instance's output, that marks the instance ready once a line matches the
+// service's ready_log_pattern. It returns nil if no pattern is configured.
+func (d *Daemon) readyLogMatcher(svc Service, proc *serviceProcess) func(line string) {
+	if svc.ReadyLogPattern == "" {
+		return nil
+	}
+	pattern := regexp.MustCompile(svc.ReadyLogPattern)
+	return func(line string) {
+		if !proc.ready.isReady() && pattern.MatchString(line) {
+			d.setReady(svc, proc, "ready_log_pattern")
+		}
+	}
+}
+
+// setReady marks an instance ready and, if it is the service's supervised
+// instance, the service too. source names the readiness signal, or is empty
+// for services that are ready once started.
+func (d *Daemon) setReady(svc Service, proc *serviceProcess, source string) {
+	if !proc.ready.mark() {
+		return
+	}
+	if source != "" {
+		logServiceInfo(svc.Name, "Service is ready", "pid", proc.cmd.Process.Pid, "signal", source)
+		d.events.record(EventServiceReady, svc.Name, "Service is ready", map[string]string{"signal": source})
+	}
+	if !proc.detached.Load() {
+		d.setStateIf(svc, StateStarting, StateRunning)
+	}
+}
+
+// waitReady waits for an instance to become ready, giving up once the
+// service's ready timeout has passed or the instance exits
+func waitReady(svc Service, proc *serviceProcess) error {
+	select {
+	case <-proc.ready.ch:
+		return nil
+	case <-proc.exited:
+		return fmt.Errorf("exited before becoming ready")
+	case <-time.After(svc.readyTimeout()):
+		return fmt.Errorf("not ready after %s", svc.readyTimeout())
+	}
+}
+
+// waitTierReady waits for the services of a boot tier that started to
+// become ready, so services ordered after them only start once they can be
+// used. A service that isn't ready in time is reported and boot carries on.
+func (d *Daemon) waitTierReady(tier []string, failed []string) {
+	var wg sync.WaitGroup
+	for _, name := range tier {
+		proc, exists := d.getServiceProcess(name)
+		if !exists || slices.Contains(failed, name) {
+			continue
+		}
+		svc := d.config.Services[name]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := waitReady(svc, proc)
+			if err != nil {
+				logServiceError(name, "Service did not become ready", "error", err)
+			}
+			d.boot.ready(name, err)
+		}()
+	}
+	wg.Wait()
+}
+
+// pollForFile waits until path exists by checking for it periodically
+func pollForFile(path string, done <-chan struct{}) error {
+	ticker := time.NewTicker(readyFilePollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+		select {
+		case <-done:
+			return fmt.Errorf("instance exited")
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+// waitForFile waits until path exists. There's no inotify here, so it polls.
+func waitForFile(path string, done <-chan struct{}) error {
+	return pollForFile(path, done)
+}
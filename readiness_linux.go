@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// waitForFile waits until path is created or touched, using inotify on its
+// directory. It returns an error if done is closed first.
+func waitForFile(path string, done <-chan struct{}) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("inotify: %v", err)
+	}
+	// Non-blocking, so the runtime poller can interrupt reads on Close
+	watcher := os.NewFile(uintptr(fd), "inotify")
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-done:
+		case <-stop:
+		}
+		watcher.Close()
+	}()
+
+	dir, name := filepath.Split(path)
+	mask := uint32(syscall.IN_CREATE | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO)
+	if _, err := syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		// The service may create the directory itself
+		return pollForFile(path, done)
+	}
+
+	// The file may have appeared before the watch was added
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := watcher.Read(buf)
+		if err != nil {
+			return fmt.Errorf("instance exited")
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			nameLen := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+			start := offset + syscall.SizeofInotifyEvent
+			if strings.TrimRight(string(buf[start:start+nameLen]), "\x00") == name {
+				return nil
+			}
+			offset = start + nameLen
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWaitForFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ready")
+	done := make(chan struct{})
+
+	result := make(chan error, 1)
+	go func() { result <- waitForFile(path, done) }()
+
+	select {
+	case err := <-result:
+		t.Fatalf("returned before the file was created: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("expected the file to be seen, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("file creation was not noticed")
+	}
+}
+
+func TestWaitForFileStops(t *testing.T) {
+	done := make(chan struct{})
+	result := make(chan error, 1)
+	go func() { result <- waitForFile(filepath.Join(t.TempDir(), "ready"), done) }()
+
+	close(done)
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("expected an error when stopped before the file appeared")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("waitForFile did not stop")
+	}
+}
+
+func TestWaitForFileMissingDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "run")
+	done := make(chan struct{})
+	result := make(chan error, 1)
+	go func() { result <- waitForFile(filepath.Join(dir, "ready"), done) }()
+
+	time.Sleep(50 * time.Millisecond)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ready"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("expected the file to be seen, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("file creation was not noticed")
+	}
+}
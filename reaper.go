@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// Reaper configures how pei reports the orphaned processes it reaps as
+// PID 1: processes it didn't start, left behind when their parent exited
+type Reaper struct {
+	// Report resolves each orphan's command, process group and session, and
+	// the service they belong to, before reaping it, and records an
+	// unexpected_orphan event with them
+	Report bool `yaml:"report"`
+}
+
+// zombieProcess is what /proc still shows of an exited process waiting to be
+// reaped. Its cmdline is usually gone by then, but its comm isn't.
+type zombieProcess struct {
+	PID     int
+	Comm    string
+	Cmdline string
+	PGID    int
+	SID     int
+}
+
+// childTracker records the processes pei started itself, which their
+// exec.Cmd waits for, so the reaper leaves them alone and only reaps orphans
+type childTracker struct {
+	// forking is held for reading while a child is started and recorded, and
+	// for writing while the reaper picks what to reap, so a child that exits
+	// straight away is never mistaken for an orphan
+	forking sync.RWMutex
+
+	mu   sync.Mutex
+	pids map[int]bool
+}
+
+// ownChildren are the processes pei started and will wait for
+var ownChildren = &childTracker{pids: make(map[int]bool)}
+
+// startChild starts a command pei waits for itself with waitChild
+func startChild(cmd *exec.Cmd) error {
+	ownChildren.forking.RLock()
+	defer ownChildren.forking.RUnlock()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	ownChildren.mu.Lock()
+	ownChildren.pids[cmd.Process.Pid] = true
+	ownChildren.mu.Unlock()
+	return nil
+}
+
+// waitChild waits for a command started with startChild
+func waitChild(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	ownChildren.mu.Lock()
+	delete(ownChildren.pids, cmd.Process.Pid)
+	ownChildren.mu.Unlock()
+	return err
+}
+
+// combinedOutput runs a command like cmd.CombinedOutput, as one of pei's
+// own children
+func combinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := startChild(cmd); err != nil {
+		return nil, err
+	}
+	err := waitChild(cmd)
+	return output.Bytes(), err
+}
+
+// owns reports whether pei started a process and waits for it itself
+func (t *childTracker) owns(pid int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.pids[pid]
+}
+
+// reapChildren reaps exited orphans, leaving pei's own children to the
+// exec.Cmd waiting for them, and returns how many it reaped. Where exited
+// children can't be listed without reaping them it reaps nothing, since
+// waiting for any child would take pei's own from their exec.Cmd.
+func (d *Daemon) reapChildren(logger *slog.Logger) int {
+	ownChildren.forking.Lock()
+	defer ownChildren.forking.Unlock()
+
+	zombies, err := zombieChildren()
+	if err != nil {
+		logger.Debug("Not reaping children", "error", err)
+		return 0
+	}
+
+	report := false
+	if reaper := d.getConfig().Reaper; reaper != nil {
+		report = reaper.Report
+	}
+	reaped := 0
+	for _, pid := range zombies {
+		if ownChildren.owns(pid) {
+			continue
+		}
+		// Looked at before reaping, while /proc still has it
+		var orphan zombieProcess
+		if report {
+			orphan = describeZombie(pid)
+		}
+
+		var ws syscall.WaitStatus
+		if wpid, err := syscall.Wait4(pid, &ws, syscall.WNOHANG, nil); wpid != pid {
+			if err != nil && err != syscall.ECHILD {
+				logger.Error("Error in child reaper", "pid", pid, "error", err)
+			}
+			continue
+		}
+		reaped++
+		logger.Info("Reaped child process",
+			"pid", pid,
+			"exit_status", ws.ExitStatus(),
+			"signaled", ws.Signaled())
+		if report {
+			d.reportOrphan(orphan, ws)
+		}
+	}
+	return reaped
+}
+
+// reportOrphan records an unexpected_orphan event for a reaped orphan,
+// naming the service whose process group or session it was in
+func (d *Daemon) reportOrphan(orphan zombieProcess, ws syscall.WaitStatus) {
+	fields := map[string]string{
+		"pid":  strconv.Itoa(orphan.PID),
+		"comm": orphan.Comm,
+		"pgid": strconv.Itoa(orphan.PGID),
+		"sid":  strconv.Itoa(orphan.SID),
+	}
+	if orphan.Cmdline != "" {
+		fields["cmdline"] = orphan.Cmdline
+	}
+	if ws.Signaled() {
+		fields["signal"] = ws.Signal().String()
+	} else {
+		fields["exit_status"] = strconv.Itoa(ws.ExitStatus())
+	}
+
+	d.events.record(EventUnexpectedOrphan, d.orphanService(orphan), "Reaped an orphaned process pei didn't start", fields)
+}
+
+// orphanService is the service whose instance leads the orphan's process
+// group or session, or "" if none does
+func (d *Daemon) orphanService(orphan zombieProcess) string {
+	for name, proc := range d.getAllServiceProcesses() {
+		pid := proc.cmd.Process.Pid
+		if pid == orphan.PGID || pid == orphan.SID {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,17 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import "errors"
+
+// zombieChildren is not supported here, as there's no /proc to find exited
+// children in without reaping them, so the reaper leaves them all to their
+// exec.Cmd. pei isn't PID 1 here, so orphans go to init rather than to it.
+func zombieChildren() ([]int, error) {
+	return nil, errors.New("not supported on this platform")
+}
+
+// describeZombie is not supported here
+func describeZombie(pid int) zombieProcess {
+	return zombieProcess{PID: pid}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// zombieChildren lists pei's children that have exited and are waiting to be
+// reaped, from /proc, so each can be looked at before it is
+func zombieChildren() ([]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	self := os.Getpid()
+	var zombies []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		_, fields, err := readProcStat(pid)
+		// Fields from the state: state, ppid, pgrp, session
+		if err != nil || fields[0] != "Z" || fields[1] != strconv.Itoa(self) {
+			continue
+		}
+		zombies = append(zombies, pid)
+	}
+	return zombies, nil
+}
+
+// describeZombie reads what /proc still shows of an exited process
+func describeZombie(pid int) zombieProcess {
+	zombie := zombieProcess{PID: pid}
+	comm, fields, err := readProcStat(pid)
+	if err != nil {
+		return zombie
+	}
+	zombie.Comm = comm
+	zombie.PGID, _ = strconv.Atoi(fields[2])
+	zombie.SID, _ = strconv.Atoi(fields[3])
+	if cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid)); err == nil {
+		zombie.Cmdline = strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+	}
+	return zombie
+}
+
+// readProcStat reads a process's command name and the fields of
+// /proc/PID/stat that follow it, starting with its state. The name can hold
+// spaces and parentheses, so it's cut at the last parenthesis.
+func readProcStat(pid int) (string, []string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return "", nil, err
+	}
+	open := strings.IndexByte(string(data), '(')
+	paren := strings.LastIndexByte(string(data), ')')
+	if open < 0 || paren < open {
+		return "", nil, fmt.Errorf("unexpected /proc/%d/stat format", pid)
+	}
+	fields := strings.Fields(string(data[paren+1:]))
+	if len(fields) < 4 {
+		return "", nil, fmt.Errorf("unexpected /proc/%d/stat format", pid)
+	}
+	return string(data[open+1 : paren]), fields, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os/exec"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// waitForZombies waits until every pid has exited and is waiting to be reaped
+func waitForZombies(t *testing.T, pids ...int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		zombies, err := zombieChildren()
+		if err != nil {
+			t.Fatal(err)
+		}
+		waiting := true
+		for _, pid := range pids {
+			waiting = waiting && slices.Contains(zombies, pid)
+		}
+		if waiting {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("processes %v never exited", pids)
+}
+
+func TestReapChildrenLeavesOwnChildren(t *testing.T) {
+	d := NewDaemon(&Config{Services: map[string]Service{}, Reaper: &Reaper{Report: true}}, "", "", "")
+	defer d.cancel()
+
+	own := exec.Command("sh", "-c", "exit 3")
+	if err := startChild(own); err != nil {
+		t.Fatal(err)
+	}
+	// Started without startChild, as an orphan left to pei would be
+	orphan := exec.Command("sleep", "0")
+	if err := orphan.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitForZombies(t, own.Process.Pid, orphan.Process.Pid)
+
+	// Other tests may leave orphans of their own, which are reaped too
+	if reaped := d.reapChildren(slog.New(slog.NewTextHandler(io.Discard, nil))); reaped < 1 {
+		t.Errorf("expected the orphan to be reaped, reaped %d", reaped)
+	}
+	if err := waitChild(own); err == nil || own.ProcessState.ExitCode() != 3 {
+		t.Errorf("expected pei's own child to keep its exit status, got %v", err)
+	}
+	if ownChildren.owns(own.Process.Pid) {
+		t.Error("expected the waited for child to be forgotten")
+	}
+
+	events := d.events.list("", 0)
+	i := slices.IndexFunc(events, func(e Event) bool {
+		return e.Type == EventUnexpectedOrphan && e.Fields["pid"] == strconv.Itoa(orphan.Process.Pid)
+	})
+	if i < 0 {
+		t.Fatal("expected an unexpected_orphan event for the orphan")
+	}
+	if fields := events[i].Fields; fields["comm"] != "sleep" || fields["exit_status"] != "0" {
+		t.Errorf("expected the orphan's comm and exit status, got %v", fields)
+	}
+}
+
+func TestReadProcStat(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	cmd.Args[0] = "a (b) c"
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	comm, fields, err := readProcStat(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comm != "sleep" || fields[0] == "" {
+		t.Errorf("unexpected comm %q and fields %v", comm, fields)
+	}
+	described := describeZombie(cmd.Process.Pid)
+	if described.Cmdline != "a (b) c 10" || described.PGID == 0 || described.SID == 0 {
+		t.Errorf("unexpected description %+v", described)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestReaperKeepsCleanExit(t *testing.T) {
+	d := NewDaemon(&Config{Services: map[string]Service{}}, "", "", "")
+	defer d.cancel()
+
+	cmd := exec.Command("true")
+	if err := startChild(cmd); err != nil {
+		t.Fatal(err)
+	}
+	// Give the child time to exit, then reap before its exec.Cmd waits, as
+	// a SIGCHLD arriving first would
+	time.Sleep(100 * time.Millisecond)
+	d.reapChildren(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	if err := waitChild(cmd); err != nil || cmd.ProcessState.ExitCode() != 0 {
+		t.Errorf("expected a clean exit with code 0, got %v", err)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// ReloadSummary describes what a config reload changed, or would change
+type ReloadSummary struct {
+	DryRun    bool     `json:"dry_run"`
+	Added     []string `json:"added,omitempty"`
+	Removed   []string `json:"removed,omitempty"`
+	Restarted []string `json:"restarted,omitempty"`
+	Updated   []string `json:"updated,omitempty"` // changed without a restart
+	Unchanged []string `json:"unchanged,omitempty"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// What pei does when it receives SIGHUP
+const (
+	SIGHUPReload  = "reload"  // reload the config file, like pei reload
+	SIGHUPForward = "forward" // forward it to services, like USR1 and USR2
+)
+
+// sighupAction is what pei does on SIGHUP: what sighup says, or by default
+// reload unless signals are configured for HUP, which then keep being
+// forwarded as before
+func (c *Config) sighupAction() string {
+	if c.SIGHUP != "" {
+		return c.SIGHUP
+	}
+	if c.forwardsHUP() {
+		return SIGHUPForward
+	}
+	return SIGHUPReload
+}
+
+// forwardsHUP reports whether the top-level signals or any service's signals
+// say what to do with a forwarded HUP
+func (c *Config) forwardsHUP() bool {
+	if _, exists := c.signalRule(syscall.SIGHUP); exists {
+		return true
+	}
+	for _, svc := range c.Services {
+		if svc.signalAction(syscall.SIGHUP) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadOnSignal reloads the config file after pei receives SIGHUP
+func (d *Daemon) reloadOnSignal() {
+	logger := getLogger("config")
+	logger.Info("Received SIGHUP, reloading", "source", d.configPath)
+	summary, err := d.requestReload(d.ctx, false)
+	if err != nil {
+		logger.Error("Failed to reload config", "source", d.configPath, "error", err)
+		return
+	}
+	logger.Info("Reloaded config",
+		"added", summary.Added,
+		"removed", summary.Removed,
+		"restarted", summary.Restarted,
+		"updated", summary.Updated,
+		"errors", summary.Errors)
+}
+
+// reloadRequest asks the service manager to reload the config file
+type reloadRequest struct {
+	dryRun bool
+	reply  chan reloadResult
+}
+
+type reloadResult struct {
+	summary *ReloadSummary
+	err     error
+}
+
+// supervisorySettings are service settings pei reads while supervising a
+// service rather than when starting its process, so a reload that only
+// changes these updates the service without restarting it. Changes to them
+// apply from the next time pei acts on them: the next exit, restart, stop or
+// forwarded signal.
+var supervisorySettings = map[string]bool{
+	"restart":           true,
+	"max_restarts":      true,
+	"restart_delay":     true,
+	"restart_jitter":    true,
+	"restart_strategy":  true,
+	"restart_overlap":   true,
+	"start_retries":     true,
+	"start_retry_delay": true,
+	"healthcheck":       true,
+	"ready_timeout":     true,
+	"post_start_check":  true,
+	"drain_delay":       true,
+	"drain_signal":      true,
+	"drain_command":     true,
+	"crash_bundle":      true,
+	"depends_on":        true,
+	"after":             true,
+	"before":            true,
+	"priority":          true,
+	"reload_command":    true,
+	"signals":           true,
+	"signal_group":      true,
+	"labels":            true,
+	"stop_phase":        true,
+	"interval":          true,
+	"resources":         true,
+	"watch":             true,
+	"watch_action":      true,
+	"watch_debounce":    true,
+}
+
+// needsRestart reports whether any of the settings that differ between two
+// definitions of a service only take effect when its process starts
+func needsRestart(old, updated Service) bool {
+	restart := false
+	forEachChangedSetting(old, updated, func(key string, _, _ any) {
+		if !supervisorySettings[key] {
+			restart = true
+		}
+	})
+	return restart
+}
+
+// diffConfigs works out which services a new config adds, removes and
+// changes, and which of the changed ones need restarting
+func diffConfigs(old, updated *Config) *ReloadSummary {
+	summary := &ReloadSummary{}
+	for name, svc := range updated.Services {
+		previous, exists := old.Services[name]
+		switch {
+		case !exists:
+			summary.Added = append(summary.Added, name)
+		case reflect.DeepEqual(previous, svc):
+			summary.Unchanged = append(summary.Unchanged, name)
+		case needsRestart(previous, svc):
+			summary.Restarted = append(summary.Restarted, name)
+		default:
+			summary.Updated = append(summary.Updated, name)
+		}
+	}
+	for name := range old.Services {
+		if _, exists := updated.Services[name]; !exists {
+			summary.Removed = append(summary.Removed, name)
+		}
+	}
+	for _, names := range [][]string{summary.Added, summary.Removed, summary.Restarted, summary.Updated, summary.Unchanged} {
+		sort.Strings(names)
+	}
+	return summary
+}
+
+// requestReload hands a reload to the service manager, which owns privilege
+// changes, and waits for the outcome
+func (d *Daemon) requestReload(ctx context.Context, dryRun bool) (*ReloadSummary, error) {
+	req := reloadRequest{dryRun: dryRun, reply: make(chan reloadResult, 1)}
+	select {
+	case d.reloadChan <- req:
+	case <-ctx.Done():
+		if d.shuttingDown() {
+			return nil, fmt.Errorf("daemon is shutting down")
+		}
+		return nil, fmt.Errorf("timed out waiting for the service manager")
+	}
+	select {
+	case result := <-req.reply:
+		return result.summary, result.err
+	case <-ctx.Done():
+		// The reply channel is buffered, so the reload finishes regardless
+		return nil, fmt.Errorf("timed out waiting for the reload to finish, it is still in progress")
+	}
+}
+
+// reloadConfig re-reads the config file and, unless this is a dry run, stops
+// removed services, restarts changed ones whose process is affected, and
+// starts new ones. Top-level
+// settings other than signals only take effect on a full restart. Must be
+// called with elevated privileges.
+func (d *Daemon) reloadConfig(dryRun bool) (*ReloadSummary, error) {
+	updated, err := loadConfig(d.configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %v", d.configPath, err)
+	}
+	tiers, err := startTiers(updated.Services)
+	if err != nil {
+		return nil, err
+	}
+	if problems := preflightServices(updated); len(problems) > 0 {
+		errs := make([]error, len(problems))
+		for i, problem := range problems {
+			errs[i] = problem
+		}
+		return nil, errors.Join(errs...)
+	}
+
+	old := d.getConfig()
+	summary := diffConfigs(old, updated)
+	summary.DryRun = dryRun
+	if dryRun {
+		return summary, nil
+	}
+
+	if err := d.shared.open(updated); err != nil {
+		return nil, err
+	}
+
+	for _, name := range summary.Removed {
+		unlock := d.lockService(name)
+		d.stopSpawner(name)
+		if proc, exists := d.getServiceProcess(name); exists && proc.running() {
+			d.stopProcess(name, proc, serviceStopTimeout)
+		}
+		unlock()
+	}
+
+	d.mu.Lock()
+	d.config = updated
+	d.tiers = tiers
+	for _, name := range summary.Removed {
+		delete(d.serviceProcs, name)
+		delete(d.serviceStatus, name)
+	}
+	for _, name := range summary.Updated {
+		if status, exists := d.serviceStatus[name]; exists {
+			status.Labels = updated.Services[name].Labels
+		}
+	}
+	d.mu.Unlock()
+	d.events.setLabels(updated)
+
+	for _, name := range summary.Restarted {
+		// A service an operator stopped picks up its new definition when
+		// it's started again
+		if d.serviceState(name) == StateDisabled {
+			continue
+		}
+		req := restartRequest{svc: updated.Services[name], reason: RestartReasonConfigReload}
+		// Carry out a restart already waiting for the service with this
+		// one, rather than restarting it again straight after
+		if waiting, exists := d.restarts.take(name); exists {
+			req = waiting.merge(req)
+		}
+		unlock := d.lockService(name)
+		d.recordRestart(req)
+		d.restartService(req.svc)
+		unlock()
+	}
+	for _, tier := range tiers {
+		for _, name := range tier {
+			if slices.Contains(summary.Added, name) {
+				if err := d.startService(updated.Services[name]); err != nil {
+					summary.Errors = append(summary.Errors, fmt.Sprintf("%s: %v", name, err))
+				}
+			}
+		}
+	}
+
+	d.syncWatchers(updated)
+
+	d.events.record(EventConfigReload, "", "Reloaded configuration", map[string]string{
+		"added":     strings.Join(summary.Added, ","),
+		"removed":   strings.Join(summary.Removed, ","),
+		"restarted": strings.Join(summary.Restarted, ","),
+		"updated":   strings.Join(summary.Updated, ","),
+	})
+	return summary, nil
+}
+
+func reloadConfigIPC(dryRun bool) error {
+	resp, err := sendIPCRequest(IPCRequest{Command: "reload", DryRun: dryRun})
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("daemon error: %s", resp.Message)
+	}
+
+	summary := resp.Reload
+	if summary.DryRun {
+		fmt.Println("Dry run, nothing was changed")
+	}
+	for _, group := range []struct {
+		label string
+		names []string
+	}{
+		{"Added", summary.Added},
+		{"Removed", summary.Removed},
+		{"Restarted", summary.Restarted},
+		{"Updated", summary.Updated},
+		{"Unchanged", summary.Unchanged},
+	} {
+		if len(group.names) > 0 {
+			fmt.Printf("%-10s %s\n", group.label+":", strings.Join(group.names, ", "))
+		}
+	}
+	for _, problem := range summary.Errors {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", problem)
+	}
+	if len(summary.Errors) > 0 {
+		return fmt.Errorf("%d services failed to start", len(summary.Errors))
+	}
+	return nil
+}
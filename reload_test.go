@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	old := &Config{Services: map[string]Service{
+		"web":    {Name: "web", Command: []string{"web"}},
+		"worker": {Name: "worker", Command: []string{"worker"}},
+		"cron":   {Name: "cron", Command: []string{"cron"}},
+		"db":     {Name: "db", Command: []string{"db"}, Restart: RestartAlways},
+		"queue":  {Name: "queue", Command: []string{"queue"}},
+	}}
+	updated := &Config{Services: map[string]Service{
+		"web":    {Name: "web", Command: []string{"web"}},
+		"worker": {Name: "worker", Command: []string{"worker", "--fast"}},
+		"api":    {Name: "api", Command: []string{"api"}},
+		"db":     {Name: "db", Command: []string{"db"}, Restart: RestartOnFailure, MaxRestarts: 5},
+		"queue":  {Name: "queue", Command: []string{"queue"}, Labels: map[string]string{"team": "a"}, Stdout: "/var/log/queue.log"},
+	}}
+
+	summary := diffConfigs(old, updated)
+	expected := &ReloadSummary{
+		Added:     []string{"api"},
+		Removed:   []string{"cron"},
+		Restarted: []string{"queue", "worker"},
+		Updated:   []string{"db"},
+		Unchanged: []string{"web"},
+	}
+	if !reflect.DeepEqual(summary, expected) {
+		t.Errorf("expected %+v, got %+v", expected, summary)
+	}
+}
+
+func TestSupervisorySettingsExist(t *testing.T) {
+	keys := make(map[string]bool)
+	serviceType := reflect.TypeFor[Service]()
+	for i := 0; i < serviceType.NumField(); i++ {
+		key, _, _ := strings.Cut(serviceType.Field(i).Tag.Get("yaml"), ",")
+		keys[key] = true
+	}
+	for key := range supervisorySettings {
+		if !keys[key] {
+			t.Errorf("supervisory setting %s is not a service key", key)
+		}
+	}
+}
+
+func TestRequestReloadTimeout(t *testing.T) {
+	// No service manager is running to pick the reload up
+	d := NewDaemon(&Config{}, "", "", "")
+	defer d.cancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := d.requestReload(ctx, false); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+}
+
+func TestSIGHUPAction(t *testing.T) {
+	hupRule := map[string]SignalRule{"HUP": {Services: []string{"web"}}}
+	hupMapping := map[string]Service{"web": {Signals: map[string]string{"SIGHUP": "USR1"}}}
+	tests := []struct {
+		config *Config
+		want   string
+		err    string
+	}{
+		{&Config{}, SIGHUPReload, ""},
+		{&Config{SIGHUP: SIGHUPForward}, SIGHUPForward, ""},
+		{&Config{Signals: hupRule, Services: map[string]Service{"web": {}}}, SIGHUPForward, ""},
+		{&Config{Services: hupMapping}, SIGHUPForward, ""},
+		{&Config{Signals: map[string]SignalRule{"USR1": {}}}, SIGHUPReload, ""},
+		{&Config{SIGHUP: SIGHUPReload, Services: hupMapping}, SIGHUPReload, "would never apply"},
+		{&Config{SIGHUP: "restart"}, "restart", "must be reload or forward"},
+	}
+	for _, tt := range tests {
+		if got := tt.config.sighupAction(); got != tt.want {
+			t.Errorf("%+v: expected %s, got %s", tt.config, tt.want, got)
+		}
+		err := tt.config.validateSignals()
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%+v: unexpected error: %v", tt.config, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%+v: expected error containing %q, got %v", tt.config, tt.err, err)
+		}
+	}
+}
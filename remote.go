@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// remoteHost is the daemon the CLI talks to instead of the local one, as
+// ssh://[user@]host[:port][/path/to/pei] or, for a daemon's API listeners,
+// tcp://host:port or unix:///path. It is set by --host or PEI_HOST.
+var remoteHost string
+
+// dialStdioCommand is the hidden command that bridges its stdin and stdout
+// to the daemon's socket, for the CLI to run on a remote host over SSH
+const dialStdioCommand = "dial-stdio"
+
+// sshArgs builds the ssh command line that runs pei dial-stdio on the host
+// named by an ssh:// URL. A path in the URL names the remote pei binary,
+// for hosts where it isn't on the PATH.
+func sshArgs(target string, connectTimeout int) ([]string, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "ssh" || u.Hostname() == "" {
+		return nil, fmt.Errorf("--host %q must look like ssh://user@host[:port] or tcp://host:port", target)
+	}
+
+	args := []string{"-T"}
+	if u.User != nil {
+		args = append(args, "-l", u.User.Username())
+	}
+	if u.Port() != "" {
+		args = append(args, "-p", u.Port())
+	}
+	if connectTimeout > 0 {
+		args = append(args, "-o", "ConnectTimeout="+strconv.Itoa(connectTimeout))
+	}
+	pei := "pei"
+	if u.Path != "" && u.Path != "/" {
+		pei = u.Path
+	}
+	return append(args, "--", u.Hostname(), pei, dialStdioCommand), nil
+}
+
+// sshConn is a connection to a remote daemon through an ssh process
+type sshConn struct {
+	net.Conn
+	cmd *exec.Cmd
+}
+
+func (c *sshConn) Close() error {
+	err := c.Conn.Close()
+	c.cmd.Process.Kill()
+	return err
+}
+
+// dialRemote connects to the daemon on remoteHost
+func dialRemote() (net.Conn, error) {
+	u, err := url.Parse(remoteHost)
+	if err == nil && u.Scheme == "tcp" {
+		return dialAPI(u.Host)
+	}
+	if err == nil && u.Scheme == "unix" {
+		conn, err := net.DialTimeout("unix", u.Path, ipcTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to pei daemon at %s: %v", u.Path, err)
+		}
+		return conn, nil
+	}
+	return dialSSH()
+}
+
+// dialSSH connects to the daemon on remoteHost by running pei dial-stdio
+// there over SSH. The ssh process's pipes are bridged through an in-memory
+// connection so requests get the same deadlines as local ones.
+func dialSSH() (net.Conn, error) {
+	connectTimeout := 0
+	if ipcTimeout > 0 {
+		connectTimeout = max(1, int(ipcTimeout.Seconds()))
+	}
+	args, err := sshArgs(remoteHost, connectTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command("ssh", args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run ssh: %v", err)
+	}
+
+	local, bridge := net.Pipe()
+	go func() {
+		io.Copy(stdin, bridge)
+		stdin.Close()
+	}()
+	go func() {
+		io.Copy(bridge, stdout)
+		bridge.Close()
+		cmd.Wait()
+	}()
+	return &sshConn{Conn: local, cmd: cmd}, nil
+}
+
+// dialStdio copies stdin to the daemon's socket and its replies to stdout,
+// so a CLI on another machine can reach the daemon through SSH
+func dialStdio() error {
+	conn, err := net.Dial("unix", SocketPath)
+	if err != nil {
+		return fmt.Errorf("failed to connect to pei daemon: %v", err)
+	}
+	defer conn.Close()
+
+	go func() {
+		io.Copy(conn, os.Stdin)
+		// Let the daemon finish answering after the client is done sending
+		conn.(*net.UnixConn).CloseWrite()
+	}()
+	_, err = io.Copy(os.Stdout, conn)
+	return err
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// configPollInterval is how often the daemon checks a remote config for
+// changes. It is set by --config-poll; 0 disables polling.
+var configPollInterval = defaultConfigPollInterval
+
+const defaultConfigPollInterval = time.Minute
+
+// remoteConfigTimeout bounds each fetch of a remote config
+const remoteConfigTimeout = 30 * time.Second
+
+// maxRemoteConfigSize guards against fetching something that isn't a config
+const maxRemoteConfigSize = 4 << 20
+
+// remoteConfig is what was last fetched from a remote config source, for
+// conditional requests
+type remoteConfig struct {
+	etag         string
+	lastModified string
+	data         []byte
+}
+
+var (
+	remoteConfigsMu sync.Mutex
+	remoteConfigs   = make(map[string]*remoteConfig)
+)
+
+// isRemoteConfig reports whether a config path is an http://, https:// or
+// s3:// URL rather than a file. Plain http is only fetched from loopback
+// addresses, since the config's commands run as root.
+func isRemoteConfig(path string) bool {
+	for _, scheme := range []string{"http://", "https://", "s3://"} {
+		if strings.HasPrefix(path, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// readConfigSource reads a config file, or fetches a remote config
+func readConfigSource(path string) ([]byte, error) {
+	if !isRemoteConfig(path) {
+		return os.ReadFile(path)
+	}
+	return fetchRemoteConfig(path)
+}
+
+// fetchRemoteConfig fetches a remote config, sending the ETag and
+// Last-Modified of the previous fetch so an unchanged config isn't sent
+// again
+func fetchRemoteConfig(source string) ([]byte, error) {
+	remoteConfigsMu.Lock()
+	defer remoteConfigsMu.Unlock()
+	previous := remoteConfigs[source]
+
+	req, err := remoteConfigRequest(source)
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		if previous.etag != "" {
+			req.Header.Set("If-None-Match", previous.etag)
+		}
+		if previous.lastModified != "" {
+			req.Header.Set("If-Modified-Since", previous.lastModified)
+		}
+	}
+	if strings.HasPrefix(source, "s3://") {
+		if err := signS3Request(req, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	client := &http.Client{Timeout: remoteConfigTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && previous != nil {
+		return previous.data, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", source, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteConfigSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %v", source, err)
+	}
+	if len(data) > maxRemoteConfigSize {
+		return nil, fmt.Errorf("fetching %s: larger than %d bytes", source, maxRemoteConfigSize)
+	}
+
+	remoteConfigs[source] = &remoteConfig{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		data:         data,
+	}
+	return data, nil
+}
+
+// cachedRemoteConfig is what was last fetched from a remote config source,
+// nil if nothing has been
+func cachedRemoteConfig(source string) []byte {
+	remoteConfigsMu.Lock()
+	defer remoteConfigsMu.Unlock()
+	if previous := remoteConfigs[source]; previous != nil {
+		return previous.data
+	}
+	return nil
+}
+
+// remoteConfigRequest builds the GET request for a remote config. s3:// URLs
+// become path-style requests to the bucket's regional endpoint, or to
+// AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL for S3-compatible stores.
+func remoteConfigRequest(source string) (*http.Request, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config URL %q: %v", source, err)
+	}
+	if u.Scheme != "s3" {
+		if err := checkConfigTransport(u); err != nil {
+			return nil, err
+		}
+		return http.NewRequest(http.MethodGet, source, nil)
+	}
+
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, fmt.Errorf("invalid config URL %q, expected s3://bucket/key", source)
+	}
+	endpoint := cmp.Or(os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL"))
+	if endpoint == "" {
+		endpoint = "https://s3." + awsRegion() + ".amazonaws.com"
+	}
+	target, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint %q: %v", endpoint, err)
+	}
+	// Signatures cover the path as S3 encodes it, which is stricter than Go
+	target.RawPath = target.EscapedPath() + "/" + awsEscape(u.Host+"/"+key)
+	target.Path += "/" + u.Host + "/" + key
+	if err := checkConfigTransport(target); err != nil {
+		return nil, err
+	}
+	return http.NewRequest(http.MethodGet, target.String(), nil)
+}
+
+// checkConfigTransport refuses to fetch a config over plain http from
+// anywhere but a loopback address: its commands run as root, so anyone on
+// the network path could otherwise run their own
+func checkConfigTransport(u *url.URL) error {
+	switch {
+	case u.Scheme == "https":
+		return nil
+	case u.Scheme == "http" && isLoopback(net.JoinHostPort(u.Hostname(), "0")):
+		return nil
+	default:
+		return fmt.Errorf("refusing to fetch the config from %s over %s: use https", u.Host, u.Scheme)
+	}
+}
+
+// awsEscape percent-encodes everything in an S3 path but unreserved
+// characters and slashes
+func awsEscape(path string) string {
+	var b strings.Builder
+	for _, c := range []byte(path) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// awsRegion is the region from AWS_REGION or AWS_DEFAULT_REGION
+func awsRegion() string {
+	return cmp.Or(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+}
+
+// signS3Request signs a request with AWS Signature Version 4 using the
+// credentials in AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN. Without credentials the request is left anonymous,
+// for public buckets.
+func signS3Request(req *http.Request, now time.Time) error {
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" && secretKey == "" {
+		return nil
+	}
+	if accessKey == "" || secretKey == "" {
+		return fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set together")
+	}
+
+	const emptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayload)
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	values := []string{req.URL.Host, emptyPayload, amzDate}
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+		headers = append(headers, "x-amz-security-token")
+		values = append(values, token)
+	}
+
+	var canonicalHeaders strings.Builder
+	for i, name := range headers {
+		canonicalHeaders.WriteString(name + ":" + values[i] + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayload,
+	}, "\n")
+
+	scope := date + "/" + awsRegion() + "/s3/aws4_request"
+	digest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, awsRegion(), "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// pollRemoteConfig checks a remote config for changes every
+// configPollInterval until shutdown begins, and reloads when it changes
+func (d *Daemon) pollRemoteConfig() {
+	if !isRemoteConfig(d.configPath) || configPollInterval <= 0 {
+		return
+	}
+	logger := getLogger("config")
+	// The config applied last, which a failed reload leaves as it was so the
+	// next poll tries again
+	applied := cachedRemoteConfig(d.configPath)
+	for d.sleep(configPollInterval) {
+		data, err := fetchRemoteConfig(d.configPath)
+		if err != nil {
+			logger.Warn("Failed to check remote config for changes", "source", d.configPath, "error", err)
+			continue
+		}
+		if bytes.Equal(data, applied) {
+			continue
+		}
+
+		logger.Info("Remote config changed, reloading", "source", d.configPath)
+		ctx, cancel := context.WithTimeout(d.ctx, ipcRequestTimeout)
+		summary, err := d.requestReload(ctx, false)
+		cancel()
+		if err != nil {
+			logger.Error("Failed to reload remote config", "source", d.configPath, "error", err)
+			continue
+		}
+		applied = data
+		logger.Info("Reloaded remote config",
+			"added", summary.Added,
+			"removed", summary.Removed,
+			"restarted", summary.Restarted,
+			"updated", summary.Updated)
+	}
+}
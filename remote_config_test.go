@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchRemoteConfig(t *testing.T) {
+	body := "services:\n  web:\n    command: [\"/bin/web\"]\n"
+	etag := `"v1"`
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	source := server.URL + "/pei.yaml"
+
+	config, err := loadConfig(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := config.Services["web"]; !exists {
+		t.Errorf("expected the web service, got %v", config.Services)
+	}
+
+	if data, err := fetchRemoteConfig(source); err != nil || string(data) != body {
+		t.Errorf("expected the unchanged config from the cache, got %q, %v", data, err)
+	}
+
+	body = strings.ReplaceAll(body, "web", "api")
+	etag = `"v2"`
+	data, err := fetchRemoteConfig(source)
+	if err != nil || !strings.Contains(string(data), "api") {
+		t.Errorf("expected the changed config, got err=%v data=%q", err, data)
+	}
+	if requests != 3 {
+		t.Errorf("expected 3 requests, got %d", requests)
+	}
+}
+
+func TestRemoteConfigRequestS3(t *testing.T) {
+	t.Setenv("AWS_ENDPOINT_URL", "https://minio:9000")
+	req, err := remoteConfigRequest("s3://configs/fleet/pei+web.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := req.URL.String(); got != "https://minio:9000/configs/fleet/pei%2Bweb.yaml" {
+		t.Errorf("unexpected S3 URL %s", got)
+	}
+
+	if _, err := remoteConfigRequest("s3://configs"); err == nil {
+		t.Error("expected an error for an S3 URL without a key")
+	}
+}
+
+func TestRemoteConfigRequiresHTTPS(t *testing.T) {
+	for source, allowed := range map[string]bool{
+		"https://config.example.com/pei.yaml": true,
+		"http://127.0.0.1:8080/pei.yaml":      true,
+		"http://localhost/pei.yaml":           true,
+		"http://config.example.com/pei.yaml":  false,
+		"http://10.0.0.5/pei.yaml":            false,
+	} {
+		if _, err := remoteConfigRequest(source); (err == nil) != allowed {
+			t.Errorf("%s: expected allowed=%v, got %v", source, allowed, err)
+		}
+	}
+
+	t.Setenv("AWS_ENDPOINT_URL", "http://minio:9000")
+	if _, err := remoteConfigRequest("s3://configs/pei.yaml"); err == nil || !strings.Contains(err.Error(), "use https") {
+		t.Errorf("expected a plain http S3 endpoint to be refused, got %v", err)
+	}
+}
+
+func TestSignS3Request(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "eu-west-1")
+	req, _ := http.NewRequest(http.MethodGet, "https://s3.eu-west-1.amazonaws.com/configs/pei.yaml", nil)
+	if err := signS3Request(req, time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	auth := req.Header.Get("Authorization")
+	for _, want := range []string{
+		"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20250102/eu-west-1/s3/aws4_request",
+		"SignedHeaders=host;x-amz-content-sha256;x-amz-date",
+		"Signature=",
+	} {
+		if !strings.Contains(auth, want) {
+			t.Errorf("expected Authorization to contain %q, got %q", want, auth)
+		}
+	}
+	if req.Header.Get("X-Amz-Date") != "20250102T030405Z" {
+		t.Errorf("unexpected X-Amz-Date %q", req.Header.Get("X-Amz-Date"))
+	}
+}
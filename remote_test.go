@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSSHArgs(t *testing.T) {
+	tests := []struct {
+		target string
+		args   []string
+	}{
+		{"ssh://node", []string{"-T", "--", "node", "pei", "dial-stdio"}},
+		{"ssh://ops@node:2222", []string{"-T", "-l", "ops", "-p", "2222", "--", "node", "pei", "dial-stdio"}},
+		{"ssh://node/usr/local/bin/pei", []string{"-T", "--", "node", "/usr/local/bin/pei", "dial-stdio"}},
+	}
+	for _, tt := range tests {
+		args, err := sshArgs(tt.target, 0)
+		if err != nil || !slices.Equal(args, tt.args) {
+			t.Errorf("%s: expected %q, got %q, %v", tt.target, tt.args, args, err)
+		}
+	}
+
+	for _, target := range []string{"node", "tcp://node:22", "ssh://"} {
+		if _, err := sshArgs(target, 0); err == nil {
+			t.Errorf("%s: expected an error", target)
+		}
+	}
+}
+
+func TestDialRemote(t *testing.T) {
+	// An ssh that echoes requests back stands in for a remote daemon
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte("#!/bin/sh\nexec cat\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	defer func(host string) { remoteHost = host }(remoteHost)
+	remoteHost = "ssh://node"
+
+	conn, err := dialDaemon()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping\n" {
+		t.Fatalf("expected the request echoed through ssh, got %q, %v", buf, err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// effective returns a copy of the config with the defaults pei applies at
+// runtime filled in, so it shows what will actually be used
+func (c *Config) effective() *Config {
+	effective := *c
+	effective.Services = make(map[string]Service, len(c.Services))
+	for name, svc := range c.Services {
+		if svc.Restart == "" {
+			svc.Restart = RestartNever
+		}
+		if svc.RestartStrategy == "" {
+			svc.RestartStrategy = RestartStrategyStopFirst
+		}
+		if svc.RestartStrategy == RestartStrategyStartFirst && svc.RestartOverlap <= 0 {
+			svc.RestartOverlap = defaultRestartOverlap
+		}
+		if svc.HealthCheck != nil {
+			check := *svc.HealthCheck
+			check.Interval = check.interval()
+			check.Timeout = check.timeout()
+			check.Retries = check.retries()
+			svc.HealthCheck = &check
+		}
+		if svc.signalsReadiness() {
+			svc.ReadyTimeout = svc.readyTimeout()
+		}
+		if svc.PostStartCheck != nil {
+			check := PostStartCheck{Command: svc.PostStartCheck.Command, Delay: svc.PostStartCheck.delay(), Timeout: svc.PostStartCheck.timeout()}
+			svc.PostStartCheck = &check
+		}
+		if svc.CrashBundle != nil {
+			bundle := CrashBundle{Dir: svc.CrashBundle.dir(), LogLines: svc.CrashBundle.logLines(), Keep: svc.CrashBundle.keep()}
+			svc.CrashBundle = &bundle
+		}
+		effective.Services[name] = svc
+	}
+	if c.LogSpool != nil {
+		spool := *c.LogSpool
+		spool.MaxBytes = spool.maxBytes()
+		effective.LogSpool = &spool
+	}
+	return &effective
+}
+
+// renderConfig prints the fully resolved configuration: after templating,
+// extends and defaults, leaving out settings that are unset
+func renderConfig(config *Config) error {
+	doc, err := renderedConfig(config)
+	if err != nil {
+		return err
+	}
+	return writeYAML(os.Stdout, doc)
+}
+
+// renderedConfig is the resolved configuration as a YAML document, with
+// unset settings left out
+func renderedConfig(config *Config) (map[string]any, error) {
+	data, err := yaml.Marshal(config.effective())
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if services, ok := doc["services"].(map[string]any); ok {
+		for _, svc := range services {
+			if fields, ok := svc.(map[string]any); ok {
+				// Names come from the services map keys
+				delete(fields, "name")
+			}
+		}
+	}
+
+	pruned, _ := pruneEmpty(doc).(map[string]any)
+	return pruned, nil
+}
+
+// writeYAML writes a document with the indentation pei's examples use
+func writeYAML(w io.Writer, doc any) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// pruneEmpty drops zero values from decoded YAML so only settings that have
+// a value are shown
+func pruneEmpty(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if pruned := pruneEmpty(item); pruned == nil {
+				delete(v, key)
+			} else {
+				v[key] = pruned
+			}
+		}
+		if len(v) == 0 {
+			return nil
+		}
+		return v
+	case []any:
+		if len(v) == 0 {
+			return nil
+		}
+		// Prune mappings in lists, such as init steps, but keep every
+		// scalar so command arguments are shown as they are
+		for i, item := range v {
+			if fields, ok := item.(map[string]any); ok {
+				v[i] = pruneEmpty(fields)
+			}
+		}
+		return v
+	case string:
+		// Unset durations marshal as 0s
+		if v == "" || v == "0s" {
+			return nil
+		}
+	case bool:
+		if !v {
+			return nil
+		}
+	case int:
+		if v == 0 {
+			return nil
+		}
+	}
+	return value
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRenderConfig(t *testing.T) {
+	config, err := loadConfig(writeConfig(t, `
+services:
+  web:
+    command: ["web", "--name", ""]
+    user: nobody
+    restart_strategy: start-first
+    healthcheck:
+      command: ["curl", "-f", "localhost"]
+  worker:
+    command: ["worker"]
+    restart: always
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := captureStdout(t, func() {
+		if err := renderConfig(config); err != nil {
+			t.Error(err)
+		}
+	})
+	var doc struct {
+		Services map[string]map[string]any `yaml:"services"`
+	}
+	if err := yaml.Unmarshal([]byte(output), &doc); err != nil {
+		t.Fatalf("expected YAML, got %v:\n%s", err, output)
+	}
+	web, worker := doc.Services["web"], doc.Services["worker"]
+
+	// Defaults pei applies at runtime are filled in
+	if web["restart"] != string(RestartNever) || web["restart_overlap"] != defaultRestartOverlap.String() {
+		t.Errorf("expected restart and overlap defaults, got:\n%s", output)
+	}
+	check, _ := web["healthcheck"].(map[string]any)
+	if check["interval"] != defaultHealthInterval.String() || check["retries"] == nil {
+		t.Errorf("expected healthcheck defaults, got:\n%s", output)
+	}
+	if worker["restart"] != string(RestartAlways) || worker["restart_strategy"] != string(RestartStrategyStopFirst) {
+		t.Errorf("expected worker's own restart policy and the default strategy, got:\n%s", output)
+	}
+
+	// Unset settings and names are left out, but command arguments are kept
+	// as they are
+	if _, ok := worker["working_dir"]; ok {
+		t.Errorf("expected unset settings left out, got:\n%s", output)
+	}
+	if _, ok := web["name"]; ok {
+		t.Errorf("expected service names left to the map keys, got:\n%s", output)
+	}
+	if command, _ := web["command"].([]any); len(command) != 3 || command[2] != "" {
+		t.Errorf("expected the empty argument kept, got %v", web["command"])
+	}
+}
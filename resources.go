@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often a oneshot waiting for resources looks at the headroom again,
+// and how long a reservation holds for a run that hasn't started yet
+const (
+	resourceRecheck  = 5 * time.Second
+	reservationGrace = 30 * time.Second
+)
+
+// ResourceRequest is the CPU and memory a oneshot needs to run. Each
+// scheduled run waits until the container has that much headroom, less what
+// other oneshots' runs have reserved, so batch jobs don't starve or OOM the
+// services they share the container with.
+type ResourceRequest struct {
+	CPU    float64 `yaml:"cpu"`    // cores, such as 0.5
+	Memory string  `yaml:"memory"` // bytes, or with a unit such as 512Mi or 2G
+}
+
+// memoryBytes is the memory request in bytes
+func (r ResourceRequest) memoryBytes() int64 {
+	bytes, _ := parseByteSize(r.Memory)
+	return bytes
+}
+
+// byteUnits are the size suffixes parseByteSize accepts
+var byteUnits = map[string]int64{
+	"": 1, "K": 1e3, "M": 1e6, "G": 1e9, "T": 1e12,
+	"Ki": 1 << 10, "Mi": 1 << 20, "Gi": 1 << 30, "Ti": 1 << 40,
+}
+
+// parseByteSize parses a size such as 512Mi or 2G. An empty size is zero.
+func parseByteSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	number := strings.TrimRight(s, "KMGTi")
+	unit, known := byteUnits[s[len(number):]]
+	value, err := strconv.ParseFloat(number, 64)
+	if !known || err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q, use bytes or a unit such as 512Mi or 2G", s)
+	}
+	return int64(value * float64(unit)), nil
+}
+
+// validateResources checks oneshots' resource requests
+func (c *Config) validateResources() error {
+	for name, svc := range c.Services {
+		if svc.Resources == nil {
+			continue
+		}
+		if !svc.Oneshot {
+			return serviceErrorf(name, "resources", "only applies to oneshot services")
+		}
+		if svc.Resources.CPU < 0 {
+			return fieldErrorf([]string{"services", name, "resources", "cpu"}, "must not be negative")
+		}
+		if _, err := parseByteSize(svc.Resources.Memory); err != nil {
+			return fieldErrorf([]string{"services", name, "resources", "memory"}, "%v", err)
+		}
+	}
+	return nil
+}
+
+// resourceHeadroom is how much CPU, in cores, and memory, in bytes, the
+// container has to spare
+type resourceHeadroom struct {
+	CPU    float64
+	Memory int64
+}
+
+// readHeadroom measures the container's headroom; tests replace it
+var readHeadroom = systemHeadroom
+
+// reservation is what a oneshot's run was admitted with
+type reservation struct {
+	request ResourceRequest
+	at      time.Time
+}
+
+// resourceScheduler admits oneshots' runs one at a time, so runs that
+// become due together can't both take the same headroom
+type resourceScheduler struct {
+	mu           sync.Mutex
+	reservations map[string]reservation
+}
+
+func newResourceScheduler() *resourceScheduler {
+	return &resourceScheduler{reservations: make(map[string]reservation)}
+}
+
+// reserved totals the reservations of runs other than name's that are
+// running, or about to
+func (s *resourceScheduler) reserved(d *Daemon, name string) resourceHeadroom {
+	var total resourceHeadroom
+	for other, r := range s.reservations {
+		if other == name {
+			continue
+		}
+		if d.serviceState(other).running() || time.Since(r.at) < reservationGrace {
+			total.CPU += r.request.CPU
+			total.Memory += r.request.memoryBytes()
+		}
+	}
+	return total
+}
+
+// admit reserves a run's resources if the container has room for them. It
+// admits any run when the headroom can't be measured.
+func (s *resourceScheduler) admit(d *Daemon, name string, request ResourceRequest) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	headroom, err := readHeadroom()
+	if err == nil {
+		reserved := s.reserved(d, name)
+		if request.CPU > headroom.CPU-reserved.CPU || request.memoryBytes() > headroom.Memory-reserved.Memory {
+			return false, nil
+		}
+	}
+	s.reservations[name] = reservation{request: request, at: time.Now()}
+	return true, err
+}
+
+// release drops a run's reservation once it has exited
+func (s *resourceScheduler) release(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.reservations, name)
+}
+
+// awaitResources waits until a oneshot's next run fits in the container's
+// headroom and reserves it. It returns false if shutdown begins first.
+func (d *Daemon) awaitResources(svc Service) bool {
+	if svc.Resources == nil {
+		return true
+	}
+	waiting := false
+	for {
+		admitted, err := d.resources.admit(d, svc.Name, *svc.Resources)
+		if err != nil {
+			logServiceError(svc.Name, "Can't measure resource headroom, running without waiting", "error", err)
+		}
+		if admitted {
+			if waiting {
+				logServiceInfo(svc.Name, "Resources available, running")
+			}
+			return true
+		}
+		if !waiting {
+			logServiceInfo(svc.Name, "Waiting for resources to run",
+				"cpu", svc.Resources.CPU,
+				"memory", svc.Resources.Memory)
+			d.setState(svc, StatePending)
+			waiting = true
+		}
+		if !d.sleep(resourceRecheck) {
+			return false
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import "errors"
+
+// systemHeadroom can't be measured here, so oneshots run without waiting
+func systemHeadroom() (resourceHeadroom, error) {
+	return resourceHeadroom{}, errors.New("measuring resource headroom is not supported on this platform")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cpuSampleInterval is how long CPU usage is measured over
+const cpuSampleInterval = 500 * time.Millisecond
+
+// systemHeadroom measures the container's headroom: the memory its cgroup
+// may still use, or the memory available, whichever is less, and the CPU
+// its cgroup's quota, or the machine, has left over a short sample
+func systemHeadroom() (resourceHeadroom, error) {
+	available, err := memAvailable()
+	if err != nil {
+		return resourceHeadroom{}, err
+	}
+	headroom := resourceHeadroom{Memory: available, CPU: float64(runtime.NumCPU())}
+
+	// The container's limits may be set on any cgroup above pei's own,
+	// which under a device cgroup is a sibling of its services'
+	cgroup, err := ownCgroup("")
+	if err != nil {
+		return headroom, hostCPUHeadroom(&headroom)
+	}
+	usageDir := ""
+	for dir := cgroup; ; dir = path.Dir(dir) {
+		full := filepath.Join(cgroupRoot, dir)
+		if limit, ok := readCgroupInt(full, "memory.max"); ok {
+			if current, ok := readCgroupInt(full, "memory.current"); ok {
+				headroom.Memory = min(headroom.Memory, limit-current)
+			}
+		}
+		if cores, ok := cgroupCPULimit(full); ok && cores < headroom.CPU {
+			headroom.CPU = cores
+			usageDir = full
+		}
+		if dir == "/" || dir == "." {
+			break
+		}
+	}
+	if usageDir == "" {
+		return headroom, hostCPUHeadroom(&headroom)
+	}
+
+	first, ok := readCgroupStat(usageDir, "cpu.stat", "usage_usec")
+	if !ok {
+		return headroom, nil
+	}
+	time.Sleep(cpuSampleInterval)
+	second, _ := readCgroupStat(usageDir, "cpu.stat", "usage_usec")
+	used := float64(second-first) / float64(cpuSampleInterval.Microseconds())
+	headroom.CPU = max(headroom.CPU-used, 0)
+	return headroom, nil
+}
+
+// memAvailable reads MemAvailable from /proc/meminfo, in bytes
+func memAvailable() (int64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value, found := strings.CutPrefix(scanner.Text(), "MemAvailable:"); found {
+			kb, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 10, 64)
+			return kb * 1024, err
+		}
+	}
+	return 0, fmt.Errorf("no MemAvailable in /proc/meminfo")
+}
+
+// readCgroupInt reads a cgroup file holding a number, reporting false for
+// "max", which means no limit, or if the file isn't there
+func readCgroupInt(dir, name string) (int64, bool) {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return 0, false
+	}
+	value, err := strconv.ParseInt(string(bytes.TrimSpace(data)), 10, 64)
+	return value, err == nil
+}
+
+// readCgroupStat reads a key from a flat keyed cgroup file such as cpu.stat
+func readCgroupStat(dir, name, key string) (int64, bool) {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, found := strings.CutPrefix(line, key+" "); found {
+			n, err := strconv.ParseInt(value, 10, 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+// cgroupCPULimit reads a cgroup's CPU quota from cpu.max, in cores
+func cgroupCPULimit(dir string) (float64, bool) {
+	data, err := os.ReadFile(filepath.Join(dir, "cpu.max"))
+	if err != nil {
+		return 0, false
+	}
+	quota, period, _ := strings.Cut(strings.TrimSpace(string(data)), " ")
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p == 0 {
+		return 0, false
+	}
+	return q / p, true
+}
+
+// hostCPUHeadroom takes the machine's idle CPU, over a short sample of
+// /proc/stat, as the CPU headroom
+func hostCPUHeadroom(headroom *resourceHeadroom) error {
+	read := func() (idle, total float64, err error) {
+		data, err := os.ReadFile("/proc/stat")
+		if err != nil {
+			return 0, 0, err
+		}
+		line, _, _ := strings.Cut(string(data), "\n")
+		fields := strings.Fields(line)
+		if len(fields) < 5 || fields[0] != "cpu" {
+			return 0, 0, fmt.Errorf("unexpected /proc/stat format")
+		}
+		for i, field := range fields[1:] {
+			value, _ := strconv.ParseFloat(field, 64)
+			total += value
+			if i == 3 || i == 4 { // idle and iowait
+				idle += value
+			}
+		}
+		return idle, total, nil
+	}
+	idle1, total1, err := read()
+	if err != nil {
+		return err
+	}
+	time.Sleep(cpuSampleInterval)
+	idle2, total2, err := read()
+	if err != nil {
+		return err
+	}
+	if total2 > total1 {
+		headroom.CPU *= (idle2 - idle1) / (total2 - total1)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	for input, want := range map[string]int64{
+		"":      0,
+		"1024":  1024,
+		"512Mi": 512 << 20,
+		"1.5Gi": 3 << 29,
+		"2G":    2e9,
+	} {
+		if got, err := parseByteSize(input); err != nil || got != want {
+			t.Errorf("%q: expected %d, got %d (%v)", input, want, got, err)
+		}
+	}
+	for _, input := range []string{"Mi", "12Q", "-1Gi", "1iG"} {
+		if _, err := parseByteSize(input); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+}
+
+func TestValidateResources(t *testing.T) {
+	cases := []struct {
+		svc Service
+		err string
+	}{
+		{Service{Resources: &ResourceRequest{CPU: 1}}, "only applies to oneshot"},
+		{Service{Oneshot: true, Resources: &ResourceRequest{CPU: -1}}, "cpu: must not be negative"},
+		{Service{Oneshot: true, Resources: &ResourceRequest{Memory: "lots"}}, "memory: invalid size"},
+	}
+	for _, c := range cases {
+		config := &Config{Services: map[string]Service{"batch": c.svc}}
+		if err := config.validateResources(); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%+v: expected error containing %q, got %v", c.svc, c.err, err)
+		}
+	}
+}
+
+func TestResourceSchedulerAdmit(t *testing.T) {
+	defer func(saved func() (resourceHeadroom, error)) { readHeadroom = saved }(readHeadroom)
+	readHeadroom = func() (resourceHeadroom, error) {
+		return resourceHeadroom{CPU: 2, Memory: 1 << 30}, nil
+	}
+	d := NewDaemon(&Config{}, "", "", "")
+	defer d.cancel()
+	s := d.resources
+
+	if ok, _ := s.admit(d, "report", ResourceRequest{CPU: 1, Memory: "512Mi"}); !ok {
+		t.Fatal("expected a run that fits to be admitted")
+	}
+	// Runs due together share the headroom rather than each taking it all
+	if ok, _ := s.admit(d, "export", ResourceRequest{CPU: 1.5}); ok {
+		t.Error("expected a run that only fits without the other's reservation to wait")
+	}
+	if ok, _ := s.admit(d, "cleanup", ResourceRequest{Memory: "256Mi"}); !ok {
+		t.Error("expected a run that fits beside the other to be admitted")
+	}
+	if ok, _ := s.admit(d, "report", ResourceRequest{CPU: 1, Memory: "512Mi"}); !ok {
+		t.Error("expected a run's own reservation not to count against it")
+	}
+
+	s.release("report")
+	s.release("cleanup")
+	if ok, _ := s.admit(d, "export", ResourceRequest{CPU: 1.5}); !ok {
+		t.Error("expected the run admitted once the others released their reservations")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// restartQueue holds restarts waiting for the service manager. It never
+// refuses one: a restart for a service that already has one waiting is
+// merged into it and keeps its place, so each service has at most one
+// pending restart, and services are restarted in the order they were first
+// asked to be. See restartRequest.merge for which reason is reported.
+type restartQueue struct {
+	mu      sync.Mutex
+	order   []string
+	pending map[string]restartRequest
+
+	// ready has a value while restarts are waiting
+	ready chan struct{}
+
+	// Counters for metrics
+	merged    atomic.Uint64
+	processed atomic.Uint64
+}
+
+func newRestartQueue() *restartQueue {
+	return &restartQueue{
+		pending: make(map[string]restartRequest),
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+// push queues a restart, reporting false if it was merged into one already
+// waiting for the same service
+func (q *restartQueue) push(req restartRequest) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if waiting, exists := q.pending[req.svc.Name]; exists {
+		q.pending[req.svc.Name] = waiting.merge(req)
+		q.merged.Add(1)
+		return false
+	}
+	q.pending[req.svc.Name] = req
+	q.order = append(q.order, req.svc.Name)
+	q.signal()
+	return true
+}
+
+// pop takes the oldest waiting restart
+func (q *restartQueue) pop() (restartRequest, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.order) == 0 {
+		return restartRequest{}, false
+	}
+	name := q.order[0]
+	q.order = q.order[1:]
+	req := q.pending[name]
+	delete(q.pending, name)
+	q.processed.Add(1)
+	if len(q.order) > 0 {
+		q.signal()
+	}
+	return req, true
+}
+
+// take removes the restart waiting for a service, if any, for a caller
+// about to restart it anyway
+func (q *restartQueue) take(name string) (restartRequest, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	req, exists := q.pending[name]
+	if !exists {
+		return restartRequest{}, false
+	}
+	delete(q.pending, name)
+	q.order = slices.DeleteFunc(q.order, func(queued string) bool { return queued == name })
+	q.merged.Add(1)
+	return req, true
+}
+
+// depth is how many restarts are waiting
+func (q *restartQueue) depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.order)
+}
+
+// signal wakes the service manager. Must be called with q.mu held.
+func (q *restartQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestRestartQueue(t *testing.T) {
+	q := newRestartQueue()
+	for _, name := range []string{"web", "db", "web", "cache"} {
+		q.push(restartRequest{svc: Service{Name: name}, reason: RestartReasonOperator})
+	}
+	if q.depth() != 3 || q.merged.Load() != 1 {
+		t.Fatalf("expected 3 waiting and 1 merged, got %d and %d", q.depth(), q.merged.Load())
+	}
+
+	// Services come out in the order they were first asked for, and the
+	// queue stays ready until it's empty
+	for _, expected := range []string{"web", "db", "cache"} {
+		select {
+		case <-q.ready:
+		default:
+			t.Fatalf("expected the queue to be ready before %s", expected)
+		}
+		req, ok := q.pop()
+		if !ok || req.svc.Name != expected {
+			t.Fatalf("expected %s, got %+v, %v", expected, req, ok)
+		}
+	}
+	select {
+	case <-q.ready:
+		t.Fatal("expected an empty queue not to be ready")
+	default:
+	}
+
+	// Once taken, a service can be queued again
+	if !q.push(restartRequest{svc: Service{Name: "web"}}) {
+		t.Fatal("expected a new restart for web to be queued")
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RestartReason records why a service was restarted
+type RestartReason string
+
+const (
+	// Restarts by the restart policy after the service exited on its own
+	RestartReasonExited   RestartReason = "exited"   // exited cleanly under restart: always
+	RestartReasonFailure  RestartReason = "failure"  // exited with a non-zero status
+	RestartReasonCrash    RestartReason = "crash"    // killed by a signal
+	RestartReasonSchedule RestartReason = "schedule" // next run of an interval oneshot
+
+	// Restarts pei or an operator asked for while the service was running
+	RestartReasonOperator       RestartReason = "operator"         // pei restart, with its --reason as detail
+	RestartReasonKilled         RestartReason = "killed"           // pei kill, then the restart policy
+	RestartReasonRun            RestartReason = "run"              // pei run, out of a oneshot's schedule
+	RestartReasonConfigReload   RestartReason = "config-reload"    // its configuration changed
+	RestartReasonHealthCheck    RestartReason = "health-check"     // it failed its health check
+	RestartReasonPostStartCheck RestartReason = "post-start-check" // it failed its post_start_check
+	RestartReasonMaxRuntime     RestartReason = "max-runtime"      // it ran for its max_runtime
+	RestartReasonFileChange     RestartReason = "file-change"      // files it watches changed
+)
+
+// restartPrecedence ranks reasons for when several ask for the same restart:
+// the one reported is the highest, since someone asking for a restart says
+// more about why it happened than the symptoms that also called for one
+var restartPrecedence = map[RestartReason]int{
+	RestartReasonOperator:       4,
+	RestartReasonKilled:         4,
+	RestartReasonRun:            4,
+	RestartReasonConfigReload:   3,
+	RestartReasonFileChange:     3,
+	RestartReasonHealthCheck:    2,
+	RestartReasonPostStartCheck: 2,
+	RestartReasonCrash:          1,
+	RestartReasonFailure:        1,
+	RestartReasonExited:         1,
+	RestartReasonSchedule:       0,
+	RestartReasonMaxRuntime:     0,
+}
+
+// maxRestartHistory is how many recent restarts are kept in a service's status
+const maxRestartHistory = 20
+
+// maxRestartNote bounds the reason an operator can give pei restart
+const maxRestartNote = 512
+
+// RestartRecord describes one restart of a service
+type RestartRecord struct {
+	Time   time.Time     `json:"time"`
+	Reason RestartReason `json:"reason"`
+	Detail string        `json:"detail,omitempty"`
+
+	// Other reasons the restart was asked for, carried out by this one
+	Coalesced []RestartReason `json:"coalesced,omitempty"`
+}
+
+// restartRequest asks the service manager to restart a service
+type restartRequest struct {
+	svc    Service
+	reason RestartReason
+	detail string
+
+	// Reasons of other requests merged into this one
+	coalesced []RestartReason
+
+	// The instance whose exit asked for the restart, if one did; nil means
+	// restart whichever instance is current
+	instance *serviceProcess
+}
+
+// merge combines a later request for the same restart into r. The reason
+// with the higher precedence wins, the earlier one on a tie, and the other
+// is kept in coalesced.
+func (r restartRequest) merge(later restartRequest) restartRequest {
+	winner, loser := r, later
+	if restartPrecedence[later.reason] > restartPrecedence[r.reason] {
+		winner, loser = later, r
+	}
+	winner.svc = later.svc
+	winner.instance = later.instance
+	if r.instance == nil {
+		winner.instance = nil
+	}
+	winner.coalesced = append(append(append([]RestartReason(nil), r.coalesced...), later.coalesced...), loser.reason)
+	// Operators asking for the same restart each keep the reason they gave
+	if winner.reason == RestartReasonOperator && loser.reason == RestartReasonOperator && loser.detail != "" && loser.detail != winner.detail {
+		winner.detail = strings.TrimPrefix(winner.detail+"; "+loser.detail, "; ")
+	}
+	return winner
+}
+
+// automatic reports whether pei restarts for this reason by itself, rather
+// than because someone asked it to or changed what the service runs
+func (r RestartReason) automatic() bool {
+	switch r {
+	case RestartReasonOperator, RestartReasonKilled, RestartReasonRun, RestartReasonConfigReload, RestartReasonFileChange:
+		return false
+	default:
+		return true
+	}
+}
+
+// exitRestartReason classifies how a service exited, for restarts that
+// follow from it
+func exitRestartReason(err error) (RestartReason, string) {
+	if err == nil {
+		return RestartReasonExited, ""
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return RestartReasonCrash, ws.Signal().String()
+		}
+	}
+	return RestartReasonFailure, err.Error()
+}
+
+// requestRestart queues a restart with the service manager. It returns false
+// if the daemon is shutting down, or if the service already has a restart
+// waiting, which this one is merged into.
+func (d *Daemon) requestRestart(req restartRequest) bool {
+	if d.shuttingDown() {
+		return false
+	}
+	return d.restarts.push(req)
+}
+
+// recordRestart counts a restart that is about to happen and adds it to the
+// service's history and the event journal
+func (d *Daemon) recordRestart(req restartRequest) {
+	record := RestartRecord{Time: time.Now(), Reason: req.reason, Detail: req.detail, Coalesced: req.coalesced}
+	crashLoop := false
+
+	d.mu.Lock()
+	if status, exists := d.serviceStatus[req.svc.Name]; exists {
+		status.Restarts++
+		if req.failure() {
+			status.FailureRestarts++
+		}
+		recordBurst(status, req, record.Time)
+		status.LastRestartReason = req.reason
+		status.RestartHistory = append(status.RestartHistory, record)
+		if len(status.RestartHistory) > maxRestartHistory {
+			status.RestartHistory = status.RestartHistory[len(status.RestartHistory)-maxRestartHistory:]
+		}
+		if status.RestartReasons == nil {
+			status.RestartReasons = make(map[RestartReason]int)
+		}
+		status.RestartReasons[req.reason]++
+		crashLoop = isCrashLoop(status.RestartHistory, record.Time)
+	}
+	d.mu.Unlock()
+
+	fields := map[string]string{"reason": string(req.reason)}
+	if req.detail != "" {
+		fields["detail"] = req.detail
+	}
+	if len(req.coalesced) > 0 {
+		reasons := make([]string, len(req.coalesced))
+		for i, reason := range req.coalesced {
+			reasons[i] = string(reason)
+		}
+		fields["coalesced"] = strings.Join(reasons, ",")
+	}
+	d.events.record(EventRestart, req.svc.Name, "Restarting service", fields)
+	if crashLoop {
+		d.events.record(EventCrashLoop, req.svc.Name, "Service is crash-looping", map[string]string{
+			"restarts": strconv.Itoa(crashLoopRestarts),
+			"window":   crashLoopWindow.String(),
+		})
+	}
+}
+
+// A service is crash-looping once it has been restarted after exiting by
+// itself crashLoopRestarts times within crashLoopWindow
+const (
+	crashLoopRestarts = 5
+	crashLoopWindow   = 5 * time.Minute
+)
+
+// isCrashLoop reports whether the latest restart in history is the one that
+// makes a crash loop, so the loop is reported once rather than on every
+// restart that continues it
+func isCrashLoop(history []RestartRecord, now time.Time) bool {
+	if len(history) == 0 || !history[len(history)-1].Reason.exit() {
+		return false
+	}
+	exits := 0
+	for _, record := range history {
+		if record.Reason.exit() && now.Sub(record.Time) <= crashLoopWindow {
+			exits++
+		}
+	}
+	return exits == crashLoopRestarts
+}
+
+// exit reports whether the reason is the service exiting by itself
+func (r RestartReason) exit() bool {
+	return r == RestartReasonExited || r == RestartReasonFailure || r == RestartReasonCrash
+}
+
+// requested reports whether an operator asked for the restart, rather than
+// pei deciding on one
+func (r RestartReason) requested() bool {
+	return r == RestartReasonOperator || r == RestartReasonRun
+}
+
+// failure reports whether a restart followed the service exiting or
+// misbehaving on its own, which max_restarts limits, rather than someone
+// asking for it or pei recycling it on schedule
+func (r RestartReason) failure() bool {
+	switch r {
+	case RestartReasonExited, RestartReasonFailure, RestartReasonCrash,
+		RestartReasonHealthCheck, RestartReasonPostStartCheck:
+		return true
+	default:
+		return false
+	}
+}
+
+// failure reports whether any of the reasons merged into a restart was a
+// failure: a crash still happened when an operator restart took its place
+func (r restartRequest) failure() bool {
+	return r.reason.failure() || slices.ContainsFunc(r.coalesced, RestartReason.failure)
+}
+
+// failureRestartCount returns how many of a service's restarts followed a
+// failure, which is what max_restarts limits
+func (d *Daemon) failureRestartCount(name string) int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if status, exists := d.serviceStatus[name]; exists {
+		return status.FailureRestarts
+	}
+	return 0
+}
+
+// restartCount returns how many times a service has been restarted
+func (d *Daemon) restartCount(name string) int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if status, exists := d.serviceStatus[name]; exists {
+		return status.Restarts
+	}
+	return 0
+}
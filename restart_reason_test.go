@@ -0,0 +1,159 @@
+package main
+
+import (
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExitRestartReason(t *testing.T) {
+	tests := []struct {
+		command []string
+		reason  RestartReason
+	}{
+		{[]string{"true"}, RestartReasonExited},
+		{[]string{"false"}, RestartReasonFailure},
+		{[]string{"sh", "-c", "kill -9 $$"}, RestartReasonCrash},
+	}
+
+	for _, tt := range tests {
+		err := exec.Command(tt.command[0], tt.command[1:]...).Run()
+		if reason, _ := exitRestartReason(err); reason != tt.reason {
+			t.Errorf("%v: expected reason %s, got %s", tt.command, tt.reason, reason)
+		}
+	}
+}
+
+func TestRecordRestart(t *testing.T) {
+	d := NewDaemon(&Config{}, "", "", "")
+	svc := Service{Name: "web"}
+	d.setState(svc, StateBackoff)
+
+	d.recordRestart(restartRequest{svc: svc, reason: RestartReasonFailure})
+	d.recordRestart(restartRequest{svc: svc, reason: RestartReasonOperator})
+
+	status, _ := d.getServiceStatus("web")
+	if d.restartCount("web") != 2 || status.LastRestartReason != RestartReasonOperator {
+		t.Fatalf("expected 2 restarts, the last by an operator, got %+v", status)
+	}
+	if status.RestartReasons[RestartReasonFailure] != 1 || len(status.RestartHistory) != 2 {
+		t.Errorf("expected restart history by reason, got %+v", status)
+	}
+
+	// Only the failure counts toward max_restarts
+	d.recordRestart(restartRequest{svc: svc, reason: RestartReasonMaxRuntime})
+	d.recordRestart(restartRequest{svc: svc, reason: RestartReasonOperator, coalesced: []RestartReason{RestartReasonCrash}})
+	if restarts, failures := d.restartCount("web"), d.failureRestartCount("web"); restarts != 4 || failures != 2 {
+		t.Errorf("expected 4 restarts, 2 of them failures, got %d and %d", restarts, failures)
+	}
+}
+
+func TestOperatorRestartReasons(t *testing.T) {
+	svc := Service{Name: "web"}
+	req := restartRequest{svc: svc, reason: RestartReasonCrash, detail: "killed"}.
+		merge(restartRequest{svc: svc, reason: RestartReasonOperator, detail: "deploying v1.2.3"}).
+		merge(restartRequest{svc: svc, reason: RestartReasonOperator}).
+		merge(restartRequest{svc: svc, reason: RestartReasonOperator, detail: "rotating certs"})
+	if req.detail != "deploying v1.2.3; rotating certs" {
+		t.Fatalf("expected both operators' reasons, got %q", req.detail)
+	}
+
+	d := NewDaemon(&Config{}, "", "", "")
+	d.setState(svc, StateRunning)
+	d.recordRestart(req)
+	status, _ := d.getServiceStatus("web")
+	if len(status.RestartHistory) != 1 || status.RestartHistory[0].Detail != req.detail {
+		t.Errorf("expected the reason in the restart history, got %+v", status.RestartHistory)
+	}
+	events := d.events.list("web", 0)
+	if len(events) == 0 || events[len(events)-1].Fields["detail"] != req.detail {
+		t.Errorf("expected the reason in the restart event, got %+v", events)
+	}
+}
+
+func TestCoalesceRestarts(t *testing.T) {
+	q := newRestartQueue()
+	q.push(restartRequest{svc: Service{Name: "web"}, reason: RestartReasonCrash, detail: "killed"})
+	q.push(restartRequest{svc: Service{Name: "web"}, reason: RestartReasonOperator})
+	q.push(restartRequest{svc: Service{Name: "web"}, reason: RestartReasonFailure})
+
+	// The operator's request wins over the symptoms merged into it
+	req, ok := q.pop()
+	if !ok || req.reason != RestartReasonOperator || q.depth() != 0 {
+		t.Fatalf("expected one operator restart, got %+v, %v", req, ok)
+	}
+	if !slices.Equal(req.coalesced, []RestartReason{RestartReasonCrash, RestartReasonFailure}) {
+		t.Errorf("expected crash and failure coalesced, got %v", req.coalesced)
+	}
+
+	// A reload takes over a restart already waiting
+	q.push(restartRequest{svc: Service{Name: "web"}, reason: RestartReasonCrash})
+	waiting, ok := q.take("web")
+	if !ok || q.depth() != 0 {
+		t.Fatalf("expected to take the waiting restart, got %+v, %v", waiting, ok)
+	}
+	req = waiting.merge(restartRequest{svc: Service{Name: "web"}, reason: RestartReasonConfigReload})
+	if req.reason != RestartReasonConfigReload || !slices.Equal(req.coalesced, []RestartReason{RestartReasonCrash}) {
+		t.Errorf("expected a config reload with the crash coalesced, got %+v", req)
+	}
+
+	d := NewDaemon(&Config{}, "", "", "")
+	d.setState(req.svc, StateRunning)
+	d.recordRestart(req)
+	status, _ := d.getServiceStatus("web")
+	if d.restartCount("web") != 1 || !slices.Equal(status.RestartHistory[0].Coalesced, req.coalesced) {
+		t.Errorf("expected one restart recording the coalesced reasons, got %+v", status)
+	}
+}
+
+func TestSkipStaleRestart(t *testing.T) {
+	svc := Service{Name: "web"}
+	d := NewDaemon(&Config{Services: map[string]Service{"web": svc}}, "", "", "")
+	defer d.cancel()
+	d.setState(svc, StateRunning)
+
+	// An operator restart replaced the instance whose exit asked for this
+	// one while it waited out the restart delay
+	exited := &serviceProcess{exited: make(chan struct{})}
+	close(exited.exited)
+	current := &serviceProcess{exited: make(chan struct{})}
+	d.setServiceProcess("web", current)
+
+	d.processRestart(restartRequest{svc: svc, reason: RestartReasonCrash, instance: exited})
+	if d.restartCount("web") != 0 {
+		t.Fatalf("expected the stale restart to be skipped, got %d restarts", d.restartCount("web"))
+	}
+
+	// Merged with a request for whichever instance is current, it isn't stale
+	req := restartRequest{svc: svc, reason: RestartReasonCrash, instance: exited}.merge(restartRequest{svc: svc, reason: RestartReasonOperator})
+	if req.instance != nil {
+		t.Errorf("expected a merged operator restart to apply to the current instance, got %+v", req)
+	}
+}
+
+func TestRestartDelayJitter(t *testing.T) {
+	svc := Service{RestartDelay: time.Second}
+	if delay := restartDelay(svc, 1); delay != time.Second {
+		t.Fatalf("expected restart_delay without jitter, got %v", delay)
+	}
+
+	svc.RestartJitter = 500 * time.Millisecond
+	seen := make(map[time.Duration]bool)
+	for range 50 {
+		delay := restartDelay(svc, 1)
+		if delay < time.Second || delay > 1500*time.Millisecond {
+			t.Fatalf("expected a delay between 1s and 1.5s, got %v", delay)
+		}
+		seen[delay] = true
+	}
+	if len(seen) < 2 {
+		t.Error("expected jitter to vary the delay")
+	}
+
+	negative := "services:\n  web:\n    command: [\"true\"]\n    restart_jitter: -1s\n"
+	if _, err := loadConfig(writeConfig(t, negative)); err == nil || !strings.Contains(err.Error(), "restart_jitter") {
+		t.Errorf("expected negative restart_jitter to be rejected, got: %v", err)
+	}
+}
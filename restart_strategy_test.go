@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net"
+	"os/user"
+	"testing"
+	"time"
+)
+
+// newStrategyDaemon starts a service as the current user, with a stand-in
+// for the service manager finishing rollouts
+func newStrategyDaemon(t *testing.T, svc Service) (*Daemon, *serviceProcess) {
+	t.Helper()
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := user.LookupGroupId(current.Gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.Name = "web"
+	svc.User, svc.Group = current.Username, group.Name
+	d := NewDaemon(&Config{Services: map[string]Service{"web": svc}}, "", "", "")
+	t.Cleanup(func() {
+		d.cancel()
+		for _, proc := range []*serviceProcess{d.serviceProcs["web"], d.rollouts["web"]} {
+			if proc != nil && proc.running() {
+				proc.detached.Store(true)
+				proc.cmd.Process.Kill()
+				<-proc.exited
+			}
+		}
+	})
+
+	go func() {
+		for {
+			select {
+			case req := <-d.rolloutChan:
+				d.completeRollout(req)
+			case req := <-d.healthChan:
+				req.done <- d.probeHealth(req.svc, req.proc)
+			case <-d.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	old, err := d.launchService(svc, "Starting service", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, old
+}
+
+// waitFor waits until done reports true
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if done() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %s", what)
+}
+
+// recorded reports whether an event of the type has been recorded for the
+// service. Health checks record events of their own, so the one looked for
+// needn't be the last
+func recorded(d *Daemon, eventType string) bool {
+	for _, event := range d.events.list("web", 0) {
+		if event.Type == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRestartStartFirst(t *testing.T) {
+	svc := Service{Command: []string{"sleep", "30"}, RestartStrategy: RestartStrategyStartFirst, RestartOverlap: 200 * time.Millisecond}
+	d, old := newStrategyDaemon(t, svc)
+	svc = d.getConfig().Services["web"]
+
+	// The overlap is waited out off the service manager
+	started := time.Now()
+	d.restartService(svc)
+	if elapsed := time.Since(started); elapsed >= svc.RestartOverlap {
+		t.Errorf("expected the restart not to wait for the overlap, took %s", elapsed)
+	}
+	if current, _ := d.getServiceProcess("web"); current != old {
+		t.Error("expected the old instance to stay current during the overlap")
+	}
+
+	waitFor(t, "the replacement to take over after the overlap", func() bool {
+		current, _ := d.getServiceProcess("web")
+		return current != old && current.running()
+	})
+	select {
+	case <-old.exited:
+	case <-time.After(5 * time.Second):
+		t.Error("expected the old instance to be stopped")
+	}
+}
+
+func TestRestartStartFirstReplacementExits(t *testing.T) {
+	svc := Service{Command: []string{"sleep", "30"}, RestartStrategy: RestartStrategyStartFirst, RestartOverlap: 5 * time.Second}
+	d, old := newStrategyDaemon(t, svc)
+
+	// A replacement that exits during the overlap doesn't take over
+	svc = d.getConfig().Services["web"]
+	svc.Command = []string{"sh", "-c", "exit 1"}
+	d.restartService(svc)
+	waitFor(t, "the replacement to exit", func() bool {
+		d.mu.RLock()
+		defer d.mu.RUnlock()
+		return d.rollouts["web"] == nil
+	})
+
+	if current, _ := d.getServiceProcess("web"); current != old || !old.running() {
+		t.Error("expected the old instance to keep running as the current one")
+	}
+}
+
+func TestRestartBlueGreen(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	check := &HealthCheck{TCP: listener.Addr().String(), Retries: 2, Interval: 50 * time.Millisecond, Timeout: time.Second}
+	svc := Service{Command: []string{"sleep", "30"}, RestartStrategy: RestartStrategyBlueGreen, HealthCheck: check}
+	d, old := newStrategyDaemon(t, svc)
+	svc = d.getConfig().Services["web"]
+
+	d.restartService(svc)
+	waitFor(t, "a rollout_succeeded event", func() bool { return recorded(d, EventRolloutSucceeded) })
+	current, _ := d.getServiceProcess("web")
+	if current == old || d.serviceState("web") != StateHealthy {
+		t.Fatalf("expected a healthy replacement to take over, got state %s", d.serviceState("web"))
+	}
+
+	// A replacement failing its check is stopped, and the service keeps its
+	// state and current instance
+	old = current
+	d.setState(svc, StateHealthy)
+	listener.Close()
+	d.restartService(svc)
+	waitFor(t, "a rollout_failed event", func() bool { return recorded(d, EventRolloutFailed) })
+	if current, _ := d.getServiceProcess("web"); current != old || !old.running() {
+		t.Error("expected the old instance to stay current")
+	}
+	if state := d.serviceState("web"); state != StateHealthy {
+		t.Errorf("expected the service's state left alone, got %s", state)
+	}
+}
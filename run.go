@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// runRequest asks the service manager to run a oneshot now, out of schedule
+type runRequest struct {
+	svc   Service
+	reply chan spawnResult
+}
+
+// RunUpdate is an item of a pei run stream: a line of the run's output, how
+// many lines were dropped in its place, or, last, how it exited
+type RunUpdate struct {
+	Line    *LogLine `json:"line,omitempty"`
+	Dropped int      `json:"dropped,omitempty"`
+	Exit    *RunExit `json:"exit,omitempty"`
+}
+
+// runLines queues a run's output for its stream. It's fed by the service's
+// log buffer, which it mustn't block, so lines a slow client falls too far
+// behind for are dropped, and a count of them is queued in their place.
+type runLines struct {
+	updates chan RunUpdate
+	dropped int
+}
+
+func (r *runLines) add(line LogLine) {
+	if r.dropped > 0 {
+		select {
+		case r.updates <- RunUpdate{Dropped: r.dropped}:
+			r.dropped = 0
+		default:
+			r.dropped++
+			return
+		}
+	}
+	select {
+	case r.updates <- RunUpdate{Line: &line}:
+	default:
+		r.dropped++
+	}
+}
+
+// RunExit is how a run of a oneshot ended
+type RunExit struct {
+	Code   int    `json:"code"`
+	Signal string `json:"signal,omitempty"`
+}
+
+// runExit describes how an instance exited. An instance killed by a signal
+// exits with 128 plus the signal's number, as it would in a shell.
+func runExit(proc *serviceProcess) RunExit {
+	state := proc.cmd.ProcessState
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return RunExit{Code: 128 + int(ws.Signal()), Signal: ws.Signal().String()}
+	}
+	return RunExit{Code: state.ExitCode()}
+}
+
+// launchRun starts a run of a oneshot for pei run, refusing if it's already
+// running. Its next scheduled run, if it has an interval, counts from when
+// this one ends. Must be called with elevated privileges.
+func (d *Daemon) launchRun(svc Service) (*serviceProcess, error) {
+	unlock := d.lockService(svc.Name)
+	defer unlock()
+
+	if proc, exists := d.getServiceProcess(svc.Name); exists && proc.running() {
+		return nil, fmt.Errorf("service '%s' is already running", svc.Name)
+	}
+	d.recordRestart(restartRequest{svc: svc, reason: RestartReasonRun})
+	return d.launchService(svc, "Running service on request", false)
+}
+
+// requestRun asks the service manager to run a oneshot now and returns the
+// instance it started
+func (d *Daemon) requestRun(ctx context.Context, name string) (*serviceProcess, error) {
+	if d.shuttingDown() {
+		return nil, fmt.Errorf("daemon is shutting down")
+	}
+	svc, exists := d.getConfig().Services[name]
+	if !exists {
+		return nil, fmt.Errorf("Service '%s' not found", name)
+	}
+	if !svc.Oneshot {
+		return nil, fmt.Errorf("service '%s' is not a oneshot; use pei restart", name)
+	}
+
+	req := runRequest{svc: svc, reply: make(chan spawnResult, 1)}
+	select {
+	case d.runChan <- req:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case result := <-req.reply:
+		return result.proc, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// streamRun runs a oneshot now and streams its output, then how it exited.
+// A client that goes away stops the stream, not the run.
+func (m *muxConn) streamRun(ctx context.Context, req IPCRequest) bool {
+	// Only a configured service has a log buffer to subscribe to; any other
+	// name would create one, or with log_spool a file under its name
+	if _, exists := m.d.getConfig().Services[req.Service]; !exists {
+		m.respond(req.ID, IPCResponse{Success: false, Message: fmt.Sprintf("Service '%s' not found", req.Service)})
+		return false
+	}
+
+	// Subscribe first so no line of the run is missed
+	queue := &runLines{updates: make(chan RunUpdate, tailBufferLines)}
+	unsubscribe := m.d.serviceLogs(req.Service).subscribe(queue.add)
+	defer unsubscribe()
+
+	proc, err := m.d.requestRun(ctx, req.Service)
+	if err != nil {
+		m.respond(req.ID, IPCResponse{Success: false, Message: err.Error()})
+		return false
+	}
+	message := fmt.Sprintf("Running service '%s' (pid %d)", req.Service, proc.cmd.Process.Pid)
+	if err := m.open(req.ID, IPCResponse{Success: true, Message: message}); err != nil {
+		return false
+	}
+
+	send := func(update RunUpdate) bool {
+		return m.sendData(req.ID, update) == nil
+	}
+	for {
+		select {
+		case update := <-queue.updates:
+			if !send(update) {
+				return true
+			}
+		case <-proc.capture.Done():
+			// All output has been read once capture stops, and once
+			// unsubscribed the queue's drop count is no longer updated
+			unsubscribe()
+			for {
+				select {
+				case update := <-queue.updates:
+					if !send(update) {
+						return true
+					}
+				default:
+					if queue.dropped > 0 && !send(RunUpdate{Dropped: queue.dropped}) {
+						return true
+					}
+					<-proc.exited
+					exit := runExit(proc)
+					m.sendData(req.ID, RunUpdate{Exit: &exit})
+					return true
+				}
+			}
+		case <-ctx.Done():
+			return true
+		}
+	}
+}
+
+// runIPC runs a oneshot now, printing its output as it goes, and returns
+// the exit code it ended with
+func runIPC(service string) (int, error) {
+	client, err := dialIPCClient()
+	if err != nil {
+		return 1, err
+	}
+	defer client.Close()
+
+	response, stream, err := client.openStream(IPCRequest{Command: "run", Service: service})
+	if err != nil {
+		return 1, err
+	}
+	if !response.Success {
+		return 1, fmt.Errorf("daemon error: %s", response.Message)
+	}
+	fmt.Fprintln(os.Stderr, response.Message)
+
+	for {
+		var update RunUpdate
+		if err := stream.next(&update); err != nil {
+			if errors.Is(err, io.EOF) {
+				return 1, fmt.Errorf("daemon ended the run's stream without its exit status")
+			}
+			return 1, err
+		}
+		switch {
+		case update.Line != nil:
+			out := os.Stdout
+			if update.Line.Stream == "stderr" {
+				out = os.Stderr
+			}
+			fmt.Fprintln(out, update.Line.Text)
+		case update.Dropped > 0:
+			fmt.Fprintf(os.Stderr, "[%d lines of output dropped: pei run fell behind]\n", update.Dropped)
+		case update.Exit != nil:
+			if update.Exit.Signal != "" {
+				fmt.Fprintf(os.Stderr, "Service '%s' was killed by %s\n", service, update.Exit.Signal)
+			} else if update.Exit.Code != 0 {
+				fmt.Fprintf(os.Stderr, "Service '%s' exited with status %d\n", service, update.Exit.Code)
+			}
+			return update.Exit.Code, nil
+		}
+	}
+}
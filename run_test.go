@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestRunExit(t *testing.T) {
+	tests := []struct {
+		script string
+		want   RunExit
+	}{
+		{"exit 0", RunExit{Code: 0}},
+		{"exit 3", RunExit{Code: 3}},
+		{"kill -TERM $$", RunExit{Code: 143, Signal: "terminated"}},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command("sh", "-c", tt.script)
+		cmd.Run()
+		if got := runExit(&serviceProcess{cmd: cmd}); got != tt.want {
+			t.Errorf("%s: expected %+v, got %+v", tt.script, tt.want, got)
+		}
+	}
+}
+
+func TestRequestRunRefuses(t *testing.T) {
+	config := &Config{Services: map[string]Service{
+		"web":    {Name: "web", Command: []string{"sleep", "1"}},
+		"backup": {Name: "backup", Command: []string{"true"}, Oneshot: true},
+	}}
+	d := NewDaemon(config, "", "", "")
+
+	if _, err := d.requestRun(context.Background(), "web"); err == nil || !strings.Contains(err.Error(), "not a oneshot") {
+		t.Errorf("expected a long-running service refused, got %v", err)
+	}
+	if _, err := d.requestRun(context.Background(), "nope"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected an unknown service refused, got %v", err)
+	}
+
+	// A oneshot waits for the service manager
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.requestRun(ctx, "backup"); err != context.Canceled {
+		t.Errorf("expected the request given up with its context, got %v", err)
+	}
+}
+
+func TestStreamRunUnknownService(t *testing.T) {
+	config := &Config{Services: map[string]Service{"web": {Name: "web"}}}
+	d := NewDaemon(config, "", "", "")
+	defer d.cancel()
+
+	server, conn := net.Pipe()
+	go handleIPCRequest(server, d)
+	client := newIPCClient(conn)
+	defer client.Close()
+
+	response, stream, err := client.openStream(IPCRequest{Command: "run", Service: "../../etc/cron.d/x"})
+	if err != nil || response.Success || stream != nil || !strings.Contains(response.Message, "not found") {
+		t.Fatalf("expected an unknown service refused, got %+v, %v", response, err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, exists := d.logs["../../etc/cron.d/x"]; exists {
+		t.Error("expected no log buffer for an unknown service")
+	}
+}
+
+func TestRunLinesCountsDropped(t *testing.T) {
+	queue := &runLines{updates: make(chan RunUpdate, 2)}
+	for _, text := range []string{"one", "two", "three", "four"} {
+		queue.add(LogLine{Text: text})
+	}
+	<-queue.updates
+	queue.add(LogLine{Text: "five"})
+	queue.add(LogLine{Text: "six"})
+
+	// The count takes the dropped lines' place, and the lines that found no
+	// room after it are counted next
+	if update := <-queue.updates; update.Line == nil || update.Line.Text != "two" {
+		t.Fatalf("expected the second line, got %+v", update)
+	}
+	if update := <-queue.updates; update.Dropped != 2 {
+		t.Fatalf("expected two dropped lines, got %+v", update)
+	}
+	if queue.dropped != 2 {
+		t.Errorf("expected the last two lines to be counted as dropped, got %d", queue.dropped)
+	}
+	queue.add(LogLine{Text: "seven"})
+	if update := <-queue.updates; update.Dropped != 2 {
+		t.Errorf("expected the next count, got %+v", update)
+	}
+	if update := <-queue.updates; update.Line == nil || update.Line.Text != "seven" {
+		t.Errorf("expected the line after the count, got %+v", update)
+	}
+}
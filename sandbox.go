@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+)
+
+// sandboxExecCommand is the hidden command a service with read_only_paths,
+// protect_paths or join_ns, or confined to some devices, starts as. It runs
+// as root in the service's own mount namespace, sets up the mounts, joins
+// the service's device cgroup and namespaces, drops to the service's user
+// and execs the service's command, which keeps its PID.
+const sandboxExecCommand = "sandbox-exec"
+
+// validateSandbox checks read_only_paths and protect_paths
+func (c *Config) validateSandbox() error {
+	for name, svc := range c.Services {
+		for i, path := range svc.ReadOnlyPaths {
+			if !filepath.IsAbs(path) {
+				return fieldErrorf([]string{"services", name, "read_only_paths", listIndex(i)}, "must be an absolute path")
+			}
+		}
+		for i, path := range svc.ProtectPaths {
+			if !filepath.IsAbs(path) {
+				return fieldErrorf([]string{"services", name, "protect_paths", listIndex(i)}, "must be an absolute path")
+			}
+			if filepath.Clean(path) == "/" {
+				return fieldErrorf([]string{"services", name, "protect_paths", listIndex(i)}, "can't hide /")
+			}
+		}
+	}
+	return nil
+}
+
+// sandboxJoin is what a service joins as it starts
+type sandboxJoin struct {
+	cgroup     string   // device cgroup directory
+	namespaces []string // namespace files, as /proc/PID/ns/net
+}
+
+// sandboxJoins finds the device cgroup and namespaces a service joins. Must
+// be called with elevated privileges.
+func (d *Daemon) sandboxJoins(svc Service) (sandboxJoin, error) {
+	cgroup, err := d.deviceCgroup(svc)
+	if err != nil {
+		return sandboxJoin{}, err
+	}
+	namespaces, err := d.joinNamespacePaths(svc)
+	if err != nil {
+		return sandboxJoin{}, err
+	}
+	return sandboxJoin{cgroup: cgroup, namespaces: namespaces}, nil
+}
+
+// sandboxed reports whether a service runs in its own mount namespace
+func sandboxed(svc Service) bool {
+	return len(svc.ReadOnlyPaths) > 0 || len(svc.ProtectPaths) > 0
+}
+
+// startsSandboxed reports whether a command starts through pei sandbox-exec
+func startsSandboxed(cmd *exec.Cmd) bool {
+	return len(cmd.Args) > 1 && cmd.Args[1] == sandboxExecCommand
+}
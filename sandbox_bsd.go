@@ -0,0 +1,24 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+var errSandboxUnsupported = errors.New("read_only_paths, protect_paths and join_ns are not supported on this platform")
+
+// sandboxCommand is not supported here, so services with read_only_paths or
+// protect_paths, or join_ns, fail to start. There are no device cgroups to
+// join.
+func sandboxCommand(cmd *exec.Cmd, svc Service, uid, gid int, join sandboxJoin) error {
+	if sandboxed(svc) || len(join.namespaces) > 0 {
+		return errSandboxUnsupported
+	}
+	return nil
+}
+
+func runSandboxExec(args []string) error {
+	return errSandboxUnsupported
+}
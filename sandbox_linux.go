@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+)
+
+// sandboxCommand makes a command start through pei sandbox-exec, as root,
+// when its service has read_only_paths or protect_paths, which it gets a new
+// mount namespace for, or a device cgroup or namespaces to join. The helper
+// drops to uid and gid before running the service.
+func sandboxCommand(cmd *exec.Cmd, svc Service, uid, gid int, join sandboxJoin) error {
+	if !sandboxed(svc) && join.cgroup == "" && len(join.namespaces) == 0 {
+		return nil
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("finding pei to set up the sandbox: %v", err)
+	}
+
+	args := []string{"pei", sandboxExecCommand, "-uid", strconv.Itoa(uid), "-gid", strconv.Itoa(gid)}
+	for _, path := range svc.ReadOnlyPaths {
+		args = append(args, "-read-only", path)
+	}
+	for _, path := range svc.ProtectPaths {
+		args = append(args, "-protect", path)
+	}
+	if join.cgroup != "" {
+		args = append(args, "-cgroup", join.cgroup)
+	}
+	for _, path := range join.namespaces {
+		args = append(args, "-join-ns", path)
+	}
+	if svc.AppArmorProfile != "" {
+		args = append(args, "-apparmor-profile", svc.AppArmorProfile)
+	}
+	if svc.SELinuxLabel != "" {
+		args = append(args, "-selinux-label", svc.SELinuxLabel)
+	}
+	// The helper finds the command itself, and runs it with its title
+	if cmd.Args[0] != svc.Command[0] {
+		args = append(args, "-argv0", cmd.Args[0])
+	}
+	cmd.Args = append(append(args, "--", svc.Command[0]), cmd.Args[1:]...)
+	cmd.Path = self
+	cmd.Err = nil
+
+	// The helper needs root for the mounts; Go makes the new namespace's
+	// mounts private so nothing propagates back to pei's
+	cmd.SysProcAttr.Credential = nil
+	if sandboxed(svc) {
+		cmd.SysProcAttr.Unshareflags |= syscall.CLONE_NEWNS
+	}
+	return nil
+}
+
+// pathList collects a repeated flag
+type pathList []string
+
+func (p *pathList) String() string     { return fmt.Sprint(*p) }
+func (p *pathList) Set(v string) error { *p = append(*p, v); return nil }
+
+// runSandboxExec is pei sandbox-exec: it makes paths read-only or hides
+// them, then becomes the service's command as its user
+func runSandboxExec(args []string) error {
+	flags := flag.NewFlagSet(sandboxExecCommand, flag.ContinueOnError)
+	uid := flags.Int("uid", -1, "user to run the command as")
+	gid := flags.Int("gid", -1, "group to run the command as")
+	var readOnly, protect pathList
+	flags.Var(&readOnly, "read-only", "path to make read-only")
+	flags.Var(&protect, "protect", "path to hide")
+	cgroup := flags.String("cgroup", "", "cgroup directory to join")
+	var namespaces pathList
+	flags.Var(&namespaces, "join-ns", "namespace file to join")
+	var label Service
+	flags.StringVar(&label.AppArmorProfile, "apparmor-profile", "", "AppArmor profile to exec the command under")
+	flags.StringVar(&label.SELinuxLabel, "selinux-label", "", "SELinux label to exec the command under")
+	argv0 := flags.String("argv0", "", "argv[0] to run the command with, instead of its name")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	command := flags.Args()
+	if len(command) == 0 || *uid < 0 || *gid < 0 {
+		return fmt.Errorf("usage: pei %s -uid N -gid N [-read-only PATH] [-protect PATH] [-cgroup DIR] [-join-ns PATH] -- COMMAND", sandboxExecCommand)
+	}
+
+	for _, path := range readOnly {
+		if err := syscall.Mount(path, path, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("read_only_paths %s: %v", path, err)
+		}
+		if err := syscall.Mount("", path, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+			return fmt.Errorf("read_only_paths %s: %v", path, err)
+		}
+	}
+	for _, path := range protect {
+		if err := hidePath(path); err != nil {
+			return fmt.Errorf("protect_paths %s: %v", path, err)
+		}
+	}
+
+	if *cgroup != "" {
+		if err := os.WriteFile(filepath.Join(*cgroup, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+			return fmt.Errorf("joining device cgroup: %v", err)
+		}
+	}
+
+	// Namespaces are joined by this thread only, so the command must be
+	// exec'd from it too
+	runtime.LockOSThread()
+	for _, path := range namespaces {
+		if err := joinNamespace(path); err != nil {
+			return fmt.Errorf("join_ns %s: %v", path, err)
+		}
+	}
+
+	if err := syscall.Setgroups(nil); err != nil {
+		return err
+	}
+	if err := syscall.Setgid(*gid); err != nil {
+		return err
+	}
+	if err := syscall.Setuid(*uid); err != nil {
+		return err
+	}
+
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		return err
+	}
+	if label.AppArmorProfile != "" || label.SELinuxLabel != "" {
+		if err := setExecLabel(label); err != nil {
+			return err
+		}
+	}
+	if *argv0 != "" {
+		command[0] = *argv0
+	}
+	return syscall.Exec(path, command, os.Environ())
+}
+
+// sysSetns is the setns system call, which the syscall package doesn't
+// number everywhere
+var sysSetns = map[string]uintptr{
+	"386": 346, "amd64": 308, "arm": 375, "arm64": 268, "loong64": 268,
+	"ppc64": 350, "ppc64le": 350, "riscv64": 268, "s390x": 339,
+}[runtime.GOARCH]
+
+// joinNamespace moves the calling thread into the namespace a file such as
+// /proc/PID/ns/net refers to
+func joinNamespace(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if sysSetns == 0 {
+		return fmt.Errorf("not supported on %s", runtime.GOARCH)
+	}
+	if _, _, errno := syscall.Syscall(sysSetns, f.Fd(), 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// hidePath covers a directory with an empty, read-only tmpfs, or a file
+// with /dev/null, so its contents can't be read or changed
+func hidePath(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return syscall.Mount("tmpfs", path, "tmpfs", syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "mode=000")
+	}
+	if err := syscall.Mount("/dev/null", path, "", syscall.MS_BIND, ""); err != nil {
+		return err
+	}
+	return syscall.Mount("", path, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, "")
+}
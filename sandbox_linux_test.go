@@ -0,0 +1,46 @@
+package main
+
+import (
+	"slices"
+	"syscall"
+	"testing"
+)
+
+func TestSandboxCommand(t *testing.T) {
+	svc := Service{
+		Name:          "web",
+		Command:       []string{"/app/web", "--port", "8080"},
+		ReadOnlyPaths: []string{"/app"},
+		ProtectPaths:  []string{"/data/other"},
+	}
+	cmd := buildServiceCmd(svc, 1000, 1000)
+	if err := sandboxCommand(cmd, svc, 1000, 1000, sandboxJoin{}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"pei", sandboxExecCommand, "-uid", "1000", "-gid", "1000",
+		"-read-only", "/app", "-protect", "/data/other", "--", "/app/web", "--port", "8080"}
+	if !slices.Equal(cmd.Args, expected) {
+		t.Errorf("expected %v, got %v", expected, cmd.Args)
+	}
+	if cmd.SysProcAttr.Credential != nil || cmd.SysProcAttr.Unshareflags&syscall.CLONE_NEWNS == 0 {
+		t.Errorf("expected the helper to start as root in a new mount namespace, got %+v", cmd.SysProcAttr)
+	}
+
+	// The helper finds the command by name and runs it with its title
+	svc.ProcessTitle = "pei:web"
+	cmd = buildServiceCmd(svc, 1000, 1000)
+	if err := sandboxCommand(cmd, svc, 1000, 1000, sandboxJoin{}); err != nil {
+		t.Fatal(err)
+	}
+	if tail := cmd.Args[len(cmd.Args)-6:]; !slices.Equal(tail, []string{"-argv0", "pei:web", "--", "/app/web", "--port", "8080"}) {
+		t.Errorf("expected the title passed to the helper, got %v", cmd.Args)
+	}
+
+	// Services without paths start directly
+	plain := Service{Name: "db", Command: []string{"/app/db"}}
+	cmd = buildServiceCmd(plain, 1000, 1000)
+	sandboxCommand(cmd, plain, 1000, 1000, sandboxJoin{})
+	if cmd.Args[0] != "/app/db" {
+		t.Errorf("expected the command unchanged, got %v", cmd.Args)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateSandbox(t *testing.T) {
+	cases := []struct {
+		svc Service
+		err string
+	}{
+		{Service{ReadOnlyPaths: []string{"app"}}, "read_only_paths[0]: must be an absolute path"},
+		{Service{ProtectPaths: []string{"/data", "data"}}, "protect_paths[1]: must be an absolute path"},
+		{Service{ProtectPaths: []string{"/"}}, "can't hide /"},
+	}
+	for _, c := range cases {
+		config := &Config{Services: map[string]Service{"web": c.svc}}
+		if err := config.validateSandbox(); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%+v: expected error containing %q, got %v", c.svc, c.err, err)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxInstances bounds instances:, so a typo can't start thousands of processes
+const maxInstances = 256
+
+// instanceEnv is the variable holding a scaled service instance's index
+const instanceEnv = "PEI_INSTANCE"
+
+// instancePattern matches ${PEI_INSTANCE} and ${PEI_INSTANCE+8080} in a
+// scaled service's settings
+var instancePattern = regexp.MustCompile(`\$\{PEI_INSTANCE(?:\s*\+\s*([0-9]+))?\}`)
+
+// resolveInstances expands services with instances: N, in the parsed
+// document, into N services named after them with -0, -1, ... appended.
+// Each instance gets its index in PEI_INSTANCE, and ${PEI_INSTANCE} or
+// ${PEI_INSTANCE+offset} anywhere in its settings, such as command,
+// environment or working_dir, is replaced by its index, plus offset if
+// given. Services ordered after or before a scaled service are ordered
+// after or before all its instances.
+func resolveInstances(doc *yaml.Node) error {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil
+	}
+	_, services := mappingEntry(resolveAlias(doc.Content[0]), "services")
+	services = resolveAlias(services)
+	if services == nil || services.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	scaled := make(map[string][]string)
+	content := make([]*yaml.Node, 0, len(services.Content))
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		key, svc := services.Content[i], resolveAlias(services.Content[i+1])
+		countKey, countNode := mappingEntry(svc, "instances")
+		if countKey == nil {
+			content = append(content, key, svc)
+			continue
+		}
+		path := []string{"services", key.Value, "instances"}
+		count, err := strconv.Atoi(resolveAlias(countNode).Value)
+		if err != nil || count < 1 || count > maxInstances {
+			return fieldErrorf(path, "must be a number from 1 to %d", maxInstances)
+		}
+
+		for index := range count {
+			name := fmt.Sprintf("%s-%d", key.Value, index)
+			if nameKey, _ := mappingEntry(services, name); nameKey != nil {
+				return fieldErrorf(path, "instance %s has the same name as another service", name)
+			}
+			instance := instanceNode(svc, index)
+			nameNode := *key
+			nameNode.Value = name
+			content = append(content, &nameNode, instance)
+			scaled[key.Value] = append(scaled[key.Value], name)
+		}
+	}
+	services.Content = content
+
+	// Point ordering at the instances of scaled services
+	for i := 1; i < len(services.Content); i += 2 {
+		for _, field := range []string{"after", "before"} {
+			_, list := mappingEntry(services.Content[i], field)
+			list = resolveAlias(list)
+			if list == nil || list.Kind != yaml.SequenceNode {
+				continue
+			}
+			var items []*yaml.Node
+			for _, item := range list.Content {
+				instances, isScaled := scaled[resolveAlias(item).Value]
+				if !isScaled {
+					items = append(items, item)
+					continue
+				}
+				for _, name := range instances {
+					ref := *resolveAlias(item)
+					ref.Value = name
+					items = append(items, &ref)
+				}
+			}
+			copied := *list
+			copied.Content = items
+			setMappingEntry(services.Content[i], &yaml.Node{Kind: yaml.ScalarNode, Value: field}, &copied)
+		}
+	}
+	return nil
+}
+
+// instanceNode copies a scaled service's settings for one instance: without
+// instances:, with PEI_INSTANCE set and ${PEI_INSTANCE} replaced
+func instanceNode(svc *yaml.Node, index int) *yaml.Node {
+	instance := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: svc.Line, Column: svc.Column}
+	hasEnvironment := false
+	for _, pair := range mappingPairs(svc) {
+		switch pair[0].Value {
+		case "instances":
+			continue
+		case "environment":
+			if resolveAlias(pair[1]).Kind == yaml.MappingNode {
+				hasEnvironment = true
+				env := substituteInstance(pair[1], index)
+				if key, _ := mappingEntry(env, instanceEnv); key == nil {
+					env.Content = append(env.Content,
+						&yaml.Node{Kind: yaml.ScalarNode, Value: instanceEnv},
+						&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: strconv.Itoa(index)})
+				}
+				instance.Content = append(instance.Content, pair[0], env)
+				continue
+			}
+		}
+		instance.Content = append(instance.Content, pair[0], substituteInstance(pair[1], index))
+	}
+	if !hasEnvironment {
+		instance.Content = append(instance.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: "environment"},
+			&yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{
+				{Kind: yaml.ScalarNode, Value: instanceEnv},
+				{Kind: yaml.ScalarNode, Tag: "!!str", Value: strconv.Itoa(index)},
+			}})
+	}
+	return instance
+}
+
+// substituteInstance deep copies a node, replacing ${PEI_INSTANCE} in its
+// scalar values with index. Aliases are copied as what they point at, so
+// instances sharing an anchor each get their own values.
+func substituteInstance(node *yaml.Node, index int) *yaml.Node {
+	node = resolveAlias(node)
+	copied := *node
+	if node.Kind == yaml.ScalarNode {
+		copied.Value = instancePattern.ReplaceAllStringFunc(node.Value, func(match string) string {
+			offset := 0
+			if digits := instancePattern.FindStringSubmatch(match)[1]; digits != "" {
+				offset, _ = strconv.Atoi(digits)
+			}
+			return strconv.Itoa(index + offset)
+		})
+		return &copied
+	}
+	copied.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			copied.Content[i] = child
+		} else {
+			copied.Content[i] = substituteInstance(child, index)
+		}
+	}
+	return &copied
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigInstances(t *testing.T) {
+	contents := `strict: true
+x-env: &env
+  DATA: /data/${PEI_INSTANCE}
+services:
+  worker:
+    command: ["worker", "--port", "${PEI_INSTANCE+8080}"]
+    instances: 3
+    environment: *env
+  proxy:
+    command: ["proxy"]
+    after: [db, worker]
+  db:
+    command: ["db"]
+`
+	config, err := loadConfig(writeConfig(t, contents))
+	if err != nil {
+		t.Fatalf("Expected config with instances to load, got: %v", err)
+	}
+	if _, exists := config.Services["worker"]; exists || len(config.Services) != 5 {
+		t.Fatalf("Expected worker replaced by its instances, got %v", slices.Sorted(maps.Keys(config.Services)))
+	}
+	for i, port := range []string{"8080", "8081", "8082"} {
+		name := fmt.Sprintf("worker-%d", i)
+		svc := config.Services[name]
+		if svc.Name != name || svc.Command[2] != port {
+			t.Errorf("Expected %s to listen on %s, got %v", name, port, svc.Command)
+		}
+		if svc.Environment["PEI_INSTANCE"] != port[3:] || svc.Environment["DATA"] != "/data/"+port[3:] {
+			t.Errorf("Expected %s's environment computed from its index, got %v", name, svc.Environment)
+		}
+	}
+	if after := config.Services["proxy"].After; !slices.Equal(after, []string{"db", "worker-0", "worker-1", "worker-2"}) {
+		t.Errorf("Expected proxy ordered after every worker instance, got %v", after)
+	}
+
+	for contents, want := range map[string]string{
+		"services:\n  worker:\n    command: [w]\n    instances: 0\n":                                "must be a number from 1 to 256",
+		"services:\n  worker:\n    command: [w]\n    instances: two\n":                              "must be a number",
+		"services:\n  worker:\n    command: [w]\n    instances: 2\n  worker-1:\n    command: [w]\n": "same name as another service",
+	} {
+		_, err := loadConfig(writeConfig(t, contents))
+		if err == nil || !strings.Contains(err.Error(), want) || !strings.Contains(err.Error(), "instances") {
+			t.Errorf("Expected error containing %q, got %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// schemaEnums lists the allowed values for string types with a fixed set
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(RestartPolicy("")):   {string(RestartAlways), string(RestartOnFailure), string(RestartNever)},
+	reflect.TypeOf(RestartStrategy("")): {string(RestartStrategyStopFirst), string(RestartStrategyStartFirst), string(RestartStrategyBlueGreen)},
+}
+
+// durationPattern matches the Go duration strings accepted in the config
+const durationPattern = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+
+// schemaGenerator builds a JSON Schema from the config structs, collecting
+// named struct types under $defs so they can be referenced
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+// configSchema returns a JSON Schema describing pei.yaml
+func configSchema() map[string]any {
+	g := &schemaGenerator{defs: make(map[string]any)}
+	root := g.structSchema(reflect.TypeOf(Config{}))
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["$id"] = "https://github.com/bnferguson/pei/pei.schema.json"
+	root["title"] = "pei configuration"
+	root["$defs"] = g.defs
+	// Top-level x- keys, such as x-defaults, are ignored by pei
+	root["patternProperties"] = map[string]any{"^x-": map[string]any{}}
+	return root
+}
+
+func (g *schemaGenerator) schemaFor(t reflect.Type) map[string]any {
+	if values, exists := schemaEnums[t]; exists {
+		return map[string]any{"type": "string", "enum": values}
+	}
+
+	if t == reflect.TypeOf(time.Duration(0)) {
+		return map[string]any{"type": "string", "pattern": durationPattern}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schemaFor(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if _, exists := g.defs[name]; !exists {
+			g.defs[name] = true // Placeholder to stop recursion
+			g.defs[name] = g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + name}
+	default:
+		return map[string]any{}
+	}
+}
+
+// structSchema describes a struct using its yaml tags as property names
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag := field.Tag.Get("yaml")
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+
+		// Inlined structs contribute their properties directly
+		if strings.Contains(opts, "inline") && field.Type.Kind() == reflect.Struct {
+			for key, value := range g.structSchema(field.Type)["properties"].(map[string]any) {
+				properties[key] = value
+			}
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		properties[name] = g.schemaFor(field.Type)
+	}
+
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// printSchema writes the config JSON Schema to stdout
+func printSchema() error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(configSchema())
+}
@@ -1,22 +1,53 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"io"
 	"log/slog"
 	"strings"
+	"sync"
+	"time"
 )
 
+// attachWriteTimeout bounds how long a write to a slow client can take
+// before it is disconnected
+const attachWriteTimeout = time.Second
+
+// attachQueueLength is how many reads of output an attached client can fall
+// behind by before it is disconnected
+const attachQueueLength = 64
+
 // ServiceOutputCapture manages capturing and logging service output
 type ServiceOutputCapture struct {
 	service    Service
 	stdoutPipe io.ReadCloser
 	stderrPipe io.ReadCloser
 	stopChan   chan struct{}
+	stopOnce   sync.Once
 	logger     *slog.Logger
 	pid        int
+
+	// input writes to the service's terminal for attached clients, if it has one
+	input io.Writer
+
+	// history keeps recent output lines for the service, if set
+	history *LogBuffer
+
+	// onLine is called with each output line, if set
+	onLine func(line string)
+
+	// files receive a copy of the raw output, if set
+	files *outputFiles
+
+	// readers tracks the goroutines still reading output
+	readers sync.WaitGroup
+
+	// Attached clients receiving raw service output
+	attachMu   sync.Mutex
+	attached   map[int]*attachClient
+	nextAttach int
 }
 
 // NewServiceOutputCapture creates a new output capture for a service
@@ -26,7 +57,8 @@ func NewServiceOutputCapture(service Service, stdoutPipe, stderrPipe io.ReadClos
 		stdoutPipe: stdoutPipe,
 		stderrPipe: stderrPipe,
 		stopChan:   make(chan struct{}),
-		logger:     slog.With("component", "service-output", "service", service.Name),
+		attached:   make(map[int]*attachClient),
+		logger:     slog.With("component", "service-output", "service", service.Name).With(labelAttrs(service.Labels)...),
 		pid:        pid,
 	}
 }
@@ -34,110 +66,298 @@ func NewServiceOutputCapture(service Service, stdoutPipe, stderrPipe io.ReadClos
 // Start begins capturing service output in separate goroutines
 func (s *ServiceOutputCapture) Start() {
 	if s.stdoutPipe != nil {
-		go s.captureOutput(s.stdoutPipe, "stdout")
+		stream := "stdout"
+		if s.service.TTY {
+			stream = "tty"
+		}
+		s.readers.Add(1)
+		go s.captureOutput(s.stdoutPipe, stream, s.files.stdoutWriter())
 	}
 	if s.stderrPipe != nil {
-		go s.captureOutput(s.stderrPipe, "stderr")
+		s.readers.Add(1)
+		go s.captureOutput(s.stderrPipe, "stderr", s.files.stderrWriter())
+	}
+	if s.files != nil {
+		go func() {
+			s.readers.Wait()
+			s.files.Close()
+		}()
+	}
+}
+
+// waitDrained waits for all output to be read after the service exited. It
+// gives up after the timeout, as processes the service left behind may still
+// hold its output open.
+func (s *ServiceOutputCapture) waitDrained(timeout time.Duration) {
+	drained := make(chan struct{})
+	go func() {
+		s.readers.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(timeout):
 	}
 }
 
 // Stop signals the capture goroutines to stop
 func (s *ServiceOutputCapture) Stop() {
-	close(s.stopChan)
+	s.stopOnce.Do(func() { close(s.stopChan) })
 }
 
-// captureOutput reads from a pipe and logs each line with service context
-func (s *ServiceOutputCapture) captureOutput(pipe io.ReadCloser, stream string) {
+// outputBufferSize is how much output is read at once, and the longest line
+// logged whole; longer lines are logged in pieces
+const outputBufferSize = 64 << 10
+
+// outputBuffers are reused between instances, so restarts and per-connection
+// services don't allocate a fresh buffer per stream
+var outputBuffers = sync.Pool{New: func() any {
+	buf := make([]byte, outputBufferSize)
+	return &buf
+}}
+
+// captureOutput reads from a pipe and logs each line with service context.
+// Each read takes whatever output is waiting, and the lines in it are
+// written to pei's output together. Raw output is also copied to file, if
+// the stream has one.
+func (s *ServiceOutputCapture) captureOutput(pipe io.ReadCloser, stream string, file io.Writer) {
+	defer s.readers.Done()
 	defer pipe.Close()
 
-	scanner := bufio.NewScanner(pipe)
-	for scanner.Scan() {
-		select {
-		case <-s.stopChan:
-			return
-		default:
-			line := scanner.Text()
-			if line != "" {
-				s.logServiceOutput(line, stream)
+	bufp := outputBuffers.Get().(*[]byte)
+	defer outputBuffers.Put(bufp)
+	buf := *bufp
+
+	out := s.newLineLogger(stream)
+	pending := 0 // bytes of an unfinished line at the start of buf
+	for {
+		n, err := pipe.Read(buf[pending:])
+		if n > 0 {
+			// Copy raw output to attached clients before splitting it into
+			// log lines, so interactive prompts without a trailing newline
+			// still reach them
+			attachWriter{s}.Write(buf[pending : pending+n])
+			if file != nil {
+				if _, err := file.Write(buf[pending : pending+n]); err != nil {
+					s.logger.Error("Failed to write output file, no longer copying output to it",
+						"stream", stream,
+						"error", err)
+					file = nil
+				}
 			}
+
+			select {
+			case <-s.stopChan:
+				return
+			default:
+			}
+
+			end := pending + n
+			start := 0
+			logOutput.hold()
+			for {
+				i := bytes.IndexByte(buf[start:end], '\n')
+				if i < 0 {
+					break
+				}
+				out.handle(buf[start : start+i])
+				start += i + 1
+			}
+			if start == 0 && end == len(buf) {
+				out.handle(buf[:end])
+				start = end
+			}
+			logOutput.release()
+			pending = copy(buf, buf[start:end])
+		}
+		if err != nil {
+			// A last line without a newline is still a line
+			if pending > 0 {
+				out.handle(buf[:pending])
+			}
+			if err != io.EOF {
+				s.logger.Error("Error reading service output",
+					"stream", stream,
+					"error", err)
+			}
+			return
 		}
 	}
+}
+
+// lineLogger handles the lines of one of a service's output streams
+type lineLogger struct {
+	s      *ServiceOutputCapture
+	stream string
+
+	// logger has the stream's attributes formatted once, not per line
+	logger *slog.Logger
+
+	// fields is reused to parse each line of structured logs
+	fields map[string]any
+}
 
-	if err := scanner.Err(); err != nil {
-		s.logger.Error("Error reading service output",
-			"stream", stream,
-			"error", err)
+func (s *ServiceOutputCapture) newLineLogger(stream string) *lineLogger {
+	return &lineLogger{
+		s:      s,
+		stream: stream,
+		logger: s.logger.With("stream", stream, "pid", s.pid, "user", s.service.User),
+		fields: make(map[string]any),
 	}
 }
 
-// logServiceOutput intelligently handles service output, detecting and preserving structured logs
-func (s *ServiceOutputCapture) logServiceOutput(line, stream string) {
-	line = strings.TrimSpace(line)
-	if line == "" {
+// handle records a line of output in the service's history, passes it to
+// readiness matching and logs it
+func (l *lineLogger) handle(raw []byte) {
+	raw = bytes.TrimSuffix(raw, []byte("\r"))
+	if len(raw) == 0 {
 		return
 	}
+	line := string(raw)
 
-	// Check if service is configured for JSON logs
-	if s.service.JSONLogs {
-		s.logStructuredServiceOutput(line, stream)
-	} else {
-		// Log as plain text with service context
-		s.logger.Info("Service output",
-			"stream", stream,
-			"pid", s.pid,
-			"user", s.service.User,
-			"output", line)
-	}
-}
-
-// logStructuredServiceOutput handles service output that is already structured JSON
-func (s *ServiceOutputCapture) logStructuredServiceOutput(jsonLine, stream string) {
-	// Parse the service's JSON log
-	var serviceLog map[string]interface{}
-	if err := json.Unmarshal([]byte(jsonLine), &serviceLog); err != nil {
-		// Log the fallback for debugging purposes
-		s.logger.Debug("Non-JSON output from JSON-configured service",
-			"stream", stream,
-			"pid", s.pid,
-			"user", s.service.User,
-			"parse_error", err.Error())
-
-		// Fall back to plain text logging
-		s.logger.Info("Service output",
-			"stream", stream,
-			"pid", s.pid,
-			"user", s.service.User,
-			"output", jsonLine)
-		return
+	// Structured logs are parsed once, for both their level and fields
+	level := slog.LevelInfo
+	var parseErr error
+	if l.s.service.JSONLogs {
+		clear(l.fields)
+		if parseErr = json.Unmarshal([]byte(strings.TrimSpace(line)), &l.fields); parseErr == nil {
+			level = extractLogLevel(l.fields)
+		}
 	}
 
-	// Extract level from service log, defaulting to INFO if not found or invalid
-	level := extractLogLevel(serviceLog)
+	if l.s.history != nil {
+		l.s.history.add(l.stream, level.String(), line)
+	}
+	if l.s.onLine != nil {
+		l.s.onLine(line)
+	}
 
-	// Extract message from service log
-	message := extractLogMessage(serviceLog)
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" {
+		return
+	}
+	ctx := context.Background()
+	switch {
+	case !l.s.service.JSONLogs:
+		l.logPlain(ctx, trimmed)
+	case parseErr != nil:
+		l.logger.LogAttrs(ctx, slog.LevelDebug, "Non-JSON output from JSON-configured service",
+			slog.String("parse_error", parseErr.Error()))
+		l.logPlain(ctx, trimmed)
+	default:
+		l.logStructured(ctx, level)
+	}
+}
 
-	// Create enhanced log entry that preserves service structure but adds pei context
-	attrs := []slog.Attr{
-		slog.String("stream", stream),
-		slog.Int("pid", s.pid),
-		slog.String("user", s.service.User),
-		slog.String("service_log_format", "json"),
+// logPlain logs a line of plain output
+func (l *lineLogger) logPlain(ctx context.Context, line string) {
+	if l.logger.Enabled(ctx, slog.LevelInfo) {
+		l.logger.LogAttrs(ctx, slog.LevelInfo, "Service output", slog.String("output", line))
 	}
+}
 
-	// Add all fields from the service log as nested attributes
-	for key, value := range serviceLog {
+// logStructured logs a line of structured output that has been parsed into
+// fields, at the level the service used, preserving its fields
+func (l *lineLogger) logStructured(ctx context.Context, level slog.Level) {
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+	attrs := make([]slog.Attr, 1, len(l.fields)+1)
+	attrs[0] = slog.String("service_log_format", "json")
+	for key, value := range l.fields {
 		// Skip fields we've already handled
 		if key == "level" || key == "severity" || key == "msg" || key == "message" {
 			continue
 		}
-
-		// Convert value to string for logging
 		attrs = append(attrs, slog.Any("service_"+key, value))
 	}
+	l.logger.LogAttrs(ctx, level, extractLogMessage(l.fields), attrs...)
+}
+
+// Attach registers a writer that receives the service's raw output until the
+// returned detach function is called. A writer that fails, or falls too far
+// behind, is disconnected: closed, if it can be.
+func (s *ServiceOutputCapture) Attach(w io.Writer) (detach func()) {
+	client := &attachClient{w: w, queue: make(chan []byte, attachQueueLength)}
+	s.attachMu.Lock()
+	id := s.nextAttach
+	s.nextAttach++
+	s.attached[id] = client
+	s.attachMu.Unlock()
+
+	go client.send(func() { s.detach(id, true) })
+	return func() { s.detach(id, false) }
+}
+
+// detach stops sending output to an attached client, disconnecting it if
+// it failed
+func (s *ServiceOutputCapture) detach(id int, disconnect bool) {
+	s.attachMu.Lock()
+	defer s.attachMu.Unlock()
+	if client, exists := s.attached[id]; exists {
+		s.dropClient(id, client, disconnect)
+	}
+}
+
+// dropClient stops sending output to an attached client. Must be called with
+// attachMu held.
+func (s *ServiceOutputCapture) dropClient(id int, client *attachClient, disconnect bool) {
+	delete(s.attached, id)
+	close(client.queue)
+	if closer, ok := client.w.(io.Closer); ok && disconnect {
+		closer.Close()
+	}
+}
+
+// attachClient is an attached client and the output queued for it, which it
+// is sent on its own goroutine so a slow client holds up no one else
+type attachClient struct {
+	w     io.Writer
+	queue chan []byte
+}
+
+// send writes queued output to the client until it's detached, calling
+// failed if a write fails or doesn't finish within attachWriteTimeout
+func (c *attachClient) send(failed func()) {
+	for p := range c.queue {
+		if conn, ok := c.w.(interface{ SetWriteDeadline(time.Time) error }); ok {
+			conn.SetWriteDeadline(time.Now().Add(attachWriteTimeout))
+		}
+		if _, err := c.w.Write(p); err != nil {
+			failed()
+			return
+		}
+	}
+}
 
-	// Log at the same level as the service used
-	s.logger.LogAttrs(context.Background(), level, message, attrs...)
+// Done returns a channel that is closed when output capture stops
+func (s *ServiceOutputCapture) Done() <-chan struct{} {
+	return s.stopChan
+}
+
+// attachWriter fans raw output out to attached clients' queues. It never
+// blocks or fails, so a slow or broken client can't hold up capture; one
+// whose queue is full is disconnected.
+type attachWriter struct {
+	s *ServiceOutputCapture
+}
+
+func (a attachWriter) Write(p []byte) (int, error) {
+	a.s.attachMu.Lock()
+	defer a.s.attachMu.Unlock()
+	if len(a.s.attached) == 0 {
+		return len(p), nil
+	}
+	// The read buffer is reused, and clients share the copy
+	chunk := bytes.Clone(p)
+	for id, client := range a.s.attached {
+		select {
+		case client.queue <- chunk:
+		default:
+			a.s.dropClient(id, client, true)
+		}
+	}
+	return len(p), nil
 }
 
 // extractLogLevel extracts and converts log level from service JSON
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// captureLines feeds output to a capture through a pipe and returns the
+// lines it recorded
+func captureLines(t *testing.T, svc Service, output string) []LogLine {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture := NewServiceOutputCapture(svc, r, nil, 42)
+	capture.history = NewLogBuffer(100)
+	capture.Start()
+	w.WriteString(output)
+	w.Close()
+	capture.waitDrained(5 * time.Second)
+	return capture.history.last(0)
+}
+
+func TestCaptureOutputSplitsLines(t *testing.T) {
+	long := strings.Repeat("x", outputBufferSize+10)
+	entries := captureLines(t, Service{Name: "web"}, "one\r\n\ntwo\n"+long+"\nlast")
+
+	var lines []string
+	for _, entry := range entries {
+		lines = append(lines, entry.Text)
+	}
+	want := []string{"one", "two", long[:outputBufferSize], long[outputBufferSize:], "last"}
+	if len(lines) != len(want) {
+		t.Fatalf("expected %d lines, got %d", len(want), len(lines))
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d: expected %.20q, got %.20q", i, want[i], lines[i])
+		}
+	}
+}
+
+func TestCaptureOutputStructuredLevels(t *testing.T) {
+	entries := captureLines(t, Service{Name: "web", JSONLogs: true},
+		`{"level":"error","msg":"boom"}`+"\nnot json\n"+`{"level":"warn","msg":"hmm"}`+"\n")
+	want := []string{"ERROR", "INFO", "WARN"}
+	if len(entries) != len(want) {
+		t.Fatalf("expected %d lines, got %d", len(want), len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Level != want[i] {
+			t.Errorf("line %d: expected level %s, got %s", i, want[i], entry.Level)
+		}
+	}
+}
+
+func TestBatchWriter(t *testing.T) {
+	var out bytes.Buffer
+	b := &batchWriter{w: &out}
+
+	b.Write([]byte("direct\n"))
+	if out.String() != "direct\n" {
+		t.Fatalf("expected unheld writes to go straight through, got %q", out.String())
+	}
+
+	b.hold()
+	b.hold()
+	b.Write([]byte("a\n"))
+	b.Write([]byte("b\n"))
+	b.release()
+	if out.String() != "direct\n" {
+		t.Fatalf("expected writes to be held, got %q", out.String())
+	}
+	b.release()
+	if out.String() != "direct\na\nb\n" {
+		t.Fatalf("expected held writes on the last release, got %q", out.String())
+	}
+
+	b.hold()
+	b.Write(make([]byte, maxLogBatch))
+	if out.Len() != len("direct\na\nb\n")+maxLogBatch {
+		t.Error("expected a full batch to be written while held")
+	}
+	b.release()
+}
+
+// benchmarkCapture measures logging lines of service output through a pipe
+func benchmarkCapture(b *testing.B, svc Service, line string) {
+	previous := slog.Default()
+	defer slog.SetDefault(previous)
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		b.Fatal(err)
+	}
+	capture := NewServiceOutputCapture(svc, r, nil, 42)
+	capture.history = NewLogBuffer(1000)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(line) + 1))
+	b.ResetTimer()
+	capture.Start()
+	out := bufio.NewWriter(w)
+	for range b.N {
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	out.Flush()
+	w.Close()
+	capture.waitDrained(time.Minute)
+}
+
+func BenchmarkCaptureOutput(b *testing.B) {
+	b.Run("plain", func(b *testing.B) {
+		benchmarkCapture(b, Service{Name: "web", User: "web"},
+			"GET /index.html 200 1.2ms user-agent=curl/8.0")
+	})
+	b.Run("json", func(b *testing.B) {
+		benchmarkCapture(b, Service{Name: "web", User: "web", JSONLogs: true},
+			`{"level":"info","msg":"request","path":"/index.html","status":200,"duration_ms":1.2}`)
+	})
+}
+
+// attachBuffer collects what an attached client is sent
+type attachBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *attachBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *attachBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitOutput waits until an attached client has been sent want
+func waitOutput(t *testing.T, b *attachBuffer, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for b.String() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %q sent to the client, got %q", want, b.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAttach(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	capture := NewServiceOutputCapture(Service{Name: "web"}, r, nil, 42)
+	capture.Start()
+
+	// Raw output reaches the client as it comes, prompts without a newline
+	// included
+	client := &attachBuffer{}
+	detach := capture.Attach(client)
+	w.WriteString("password: ")
+	waitOutput(t, client, "password: ")
+
+	detach()
+	w.WriteString("after\n")
+	time.Sleep(50 * time.Millisecond)
+	if got := client.String(); got != "password: " {
+		t.Errorf("expected nothing sent after detaching, got %q", got)
+	}
+}
+
+// stalledClient never finishes a write until it's closed
+type stalledClient struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *stalledClient) Write(p []byte) (int, error) {
+	<-c.closed
+	return 0, io.ErrClosedPipe
+}
+
+func (c *stalledClient) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func TestAttachDropsSlowClient(t *testing.T) {
+	capture := NewServiceOutputCapture(Service{Name: "web"}, nil, nil, 42)
+	stalled := &stalledClient{closed: make(chan struct{})}
+	defer stalled.Close()
+	capture.Attach(stalled)
+	client := &attachBuffer{}
+	capture.Attach(client)
+
+	// Capture carries on while the stalled client falls behind, and the
+	// other client, keeping up, gets everything
+	var want strings.Builder
+	for batch := range 4 {
+		started := time.Now()
+		for i := range attachQueueLength / 2 {
+			line := strings.Repeat("x", batch+i%7) + "\n"
+			want.WriteString(line)
+			attachWriter{capture}.Write([]byte(line))
+		}
+		if elapsed := time.Since(started); elapsed > attachWriteTimeout/2 {
+			t.Errorf("expected a stalled client not to hold up capture, took %s", elapsed)
+		}
+		waitOutput(t, client, want.String())
+	}
+
+	select {
+	case <-stalled.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the stalled client to be disconnected")
+	}
+	capture.attachMu.Lock()
+	defer capture.attachMu.Unlock()
+	if len(capture.attached) != 1 {
+		t.Errorf("expected only the client keeping up attached, got %d", len(capture.attached))
+	}
+}
+
+func TestAttachServiceForwardsInput(t *testing.T) {
+	// Stands in for the pty: pei writes to one end, the service reads the other
+	master, terminal := net.Pipe()
+	defer terminal.Close()
+	capture := NewServiceOutputCapture(Service{Name: "web", TTY: true}, nil, nil, 42)
+	capture.input = master
+	d := NewDaemon(&Config{Services: map[string]Service{"web": {Name: "web"}}}, "", "", "")
+	defer d.cancel()
+	d.serviceProcs["web"] = &serviceProcess{capture: capture}
+
+	server, conn := net.Pipe()
+	defer conn.Close()
+	go handleIPCRequest(server, d)
+	if err := json.NewEncoder(conn).Encode(IPCRequest{Command: "attach", Service: "web"}); err != nil {
+		t.Fatal(err)
+	}
+	decoder := json.NewDecoder(conn)
+	var response IPCResponse
+	if err := decoder.Decode(&response); err != nil || !response.Success {
+		t.Fatalf("expected to attach, got %+v, %v", response, err)
+	}
+
+	// What the client types reaches the service's terminal
+	go conn.Write([]byte("ls\n"))
+	typed := make([]byte, 3)
+	if _, err := io.ReadFull(terminal, typed); err != nil || string(typed) != "ls\n" {
+		t.Fatalf("expected the input forwarded, got %q, %v", typed, err)
+	}
+
+	// and what the service prints reaches the client
+	attachWriter{capture}.Write([]byte("file\n"))
+	reader := bufio.NewReader(io.MultiReader(decoder.Buffered(), conn))
+	output, err := reader.ReadString('\n')
+	if output == "\n" {
+		// The end of the response
+		output, err = reader.ReadString('\n')
+	}
+	if err != nil || output != "file\n" {
+		t.Errorf("expected the output sent, got %q, %v", output, err)
+	}
+}
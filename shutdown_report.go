@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log/slog"
+	"sort"
+	"syscall"
+)
+
+// ServiceExit is a service's entry in the final report logged at shutdown
+type ServiceExit struct {
+	Service  string `json:"service"`
+	State    string `json:"state"`
+	ExitCode int    `json:"exit_code"`
+	Signal   string `json:"signal,omitempty"`
+	Restarts int    `json:"restarts"`
+}
+
+// States a service can end shutdown in
+const (
+	ExitStateExited     = "exited"      // exited with ExitCode
+	ExitStateKilled     = "killed"      // killed by Signal
+	ExitStateRunning    = "running"     // still running, even after being killed
+	ExitStateNotStarted = "not-started" // never started, or failed to
+	ExitStateUnknown    = "unknown"     // exited, but its status was reaped elsewhere
+)
+
+// finalReport describes how every service's last instance ended, in name order
+func (d *Daemon) finalReport() []ServiceExit {
+	var names []string
+	for name := range d.getConfig().Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	report := make([]ServiceExit, 0, len(names))
+	for _, name := range names {
+		exit := ServiceExit{Service: name, State: ExitStateNotStarted, ExitCode: -1}
+		if status, exists := d.getServiceStatus(name); exists {
+			exit.Restarts = status.Restarts
+		}
+
+		proc, exists := d.getServiceProcess(name)
+		switch {
+		case !exists:
+		case proc.running():
+			exit.State = ExitStateRunning
+		case proc.cmd.ProcessState == nil:
+			exit.State = ExitStateUnknown
+		default:
+			exit.State = ExitStateExited
+			exit.ExitCode = proc.cmd.ProcessState.ExitCode()
+			if ws, ok := proc.cmd.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+				exit.State = ExitStateKilled
+				exit.Signal = ws.Signal().String()
+			}
+		}
+		report = append(report, exit)
+	}
+	return report
+}
+
+// logFinalReport logs how each service ended, as pei's last words before it
+// exits
+func (d *Daemon) logFinalReport(shutdownLogger *slog.Logger) {
+	for _, exit := range d.finalReport() {
+		attrs := []any{
+			"service", exit.Service,
+			"state", exit.State,
+			"exit_code", exit.ExitCode,
+			"restarts", exit.Restarts,
+		}
+		if exit.Signal != "" {
+			attrs = append(attrs, "signal", exit.Signal)
+		}
+		shutdownLogger.Info("Final service status", attrs...)
+	}
+}
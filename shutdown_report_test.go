@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestFinalReport(t *testing.T) {
+	exited := func(command ...string) *serviceProcess {
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Run()
+		proc := &serviceProcess{cmd: cmd, exited: make(chan struct{})}
+		close(proc.exited)
+		return proc
+	}
+
+	d := NewDaemon(&Config{Services: map[string]Service{
+		"web":    {Name: "web"},
+		"worker": {Name: "worker"},
+		"cron":   {Name: "cron"},
+	}}, "", "", "")
+	d.setServiceProcess("web", exited("sh", "-c", "exit 3"))
+	d.setServiceStatus("web", &ServiceStatus{Name: "web", Restarts: 2})
+	d.setServiceProcess("worker", exited("sh", "-c", "kill -9 $$"))
+
+	expected := []ServiceExit{
+		{Service: "cron", State: ExitStateNotStarted, ExitCode: -1},
+		{Service: "web", State: ExitStateExited, ExitCode: 3, Restarts: 2},
+		{Service: "worker", State: ExitStateKilled, ExitCode: -1, Signal: "killed"},
+	}
+	if report := d.finalReport(); !reflect.DeepEqual(report, expected) {
+		t.Errorf("expected %+v, got %+v", expected, report)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Signal actions a service can map a forwarded signal to, besides another signal
+const (
+	SignalActionReload = "reload"
+	SignalActionIgnore = "ignore"
+)
+
+// reloadCommandTimeout bounds how long a reload command may run
+const reloadCommandTimeout = 30 * time.Second
+
+// forwardedSignals are the signals pei forwards to services when it receives them
+var forwardedSignals = []syscall.Signal{syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2}
+
+// SignalRule controls which services receive a signal forwarded by pei
+type SignalRule struct {
+	Services     []string `yaml:"services"`
+	ProcessGroup bool     `yaml:"process_group"`
+}
+
+// signalNames maps standard signal names, without the SIG prefix, to signals
+var signalNames = map[string]syscall.Signal{
+	"HUP":    syscall.SIGHUP,
+	"INT":    syscall.SIGINT,
+	"QUIT":   syscall.SIGQUIT,
+	"ILL":    syscall.SIGILL,
+	"TRAP":   syscall.SIGTRAP,
+	"ABRT":   syscall.SIGABRT,
+	"IOT":    syscall.SIGIOT,
+	"BUS":    syscall.SIGBUS,
+	"FPE":    syscall.SIGFPE,
+	"KILL":   syscall.SIGKILL,
+	"USR1":   syscall.SIGUSR1,
+	"SEGV":   syscall.SIGSEGV,
+	"USR2":   syscall.SIGUSR2,
+	"PIPE":   syscall.SIGPIPE,
+	"ALRM":   syscall.SIGALRM,
+	"TERM":   syscall.SIGTERM,
+	"CHLD":   syscall.SIGCHLD,
+	"CONT":   syscall.SIGCONT,
+	"STOP":   syscall.SIGSTOP,
+	"TSTP":   syscall.SIGTSTP,
+	"TTIN":   syscall.SIGTTIN,
+	"TTOU":   syscall.SIGTTOU,
+	"URG":    syscall.SIGURG,
+	"XCPU":   syscall.SIGXCPU,
+	"XFSZ":   syscall.SIGXFSZ,
+	"VTALRM": syscall.SIGVTALRM,
+	"PROF":   syscall.SIGPROF,
+	"WINCH":  syscall.SIGWINCH,
+	"IO":     syscall.SIGIO,
+	"SYS":    syscall.SIGSYS,
+}
+
+// parseSignal converts a signal name such as HUP, SIGWINCH or RTMIN+2, or a
+// signal number, to a signal
+func parseSignal(name string) (syscall.Signal, error) {
+	upper := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "SIG")
+
+	if number, err := strconv.Atoi(upper); err == nil {
+		if number < 1 || number > sigMax {
+			return 0, fmt.Errorf("signal number %d out of range 1-%d", number, sigMax)
+		}
+		return syscall.Signal(number), nil
+	}
+
+	if sig, exists := signalNames[upper]; exists {
+		return sig, nil
+	}
+	if sig, exists := platformSignalNames[upper]; exists {
+		return sig, nil
+	}
+
+	// Real-time signals relative to either end of the range
+	if sigRTMax == 0 && strings.HasPrefix(upper, "RT") {
+		return 0, fmt.Errorf("real-time signals are not supported on this platform")
+	}
+	for _, rt := range []struct {
+		prefix string
+		base   int
+		sign   int
+	}{{"RTMIN", sigRTMin, 1}, {"RTMAX", sigRTMax, -1}} {
+		rest, found := strings.CutPrefix(upper, rt.prefix)
+		if !found {
+			continue
+		}
+		offset := 0
+		if rest != "" {
+			op := "+"
+			if rt.sign < 0 {
+				op = "-"
+			}
+			digits, found := strings.CutPrefix(rest, op)
+			if !found {
+				break
+			}
+			var err error
+			if offset, err = strconv.Atoi(digits); err != nil || offset < 0 {
+				break
+			}
+		}
+		number := rt.base + rt.sign*offset
+		if number < sigRTMin || number > sigRTMax {
+			return 0, fmt.Errorf("real-time signal %s out of range", name)
+		}
+		return syscall.Signal(number), nil
+	}
+
+	return 0, fmt.Errorf("unsupported signal: %s", name)
+}
+
+// parseForwardedSignal parses a signal name and checks pei forwards it
+func parseForwardedSignal(name string) (syscall.Signal, error) {
+	sig, err := parseSignal(name)
+	if err != nil {
+		return 0, err
+	}
+	for _, forwarded := range forwardedSignals {
+		if sig == forwarded {
+			return sig, nil
+		}
+	}
+	return 0, fmt.Errorf("signal %s is not forwarded to services (only HUP, USR1, USR2)", name)
+}
+
+// validateSignals checks sighup, signal forwarding rules and per-service
+// signal mappings
+func (c *Config) validateSignals() error {
+	switch c.SIGHUP {
+	case "", SIGHUPForward:
+	case SIGHUPReload:
+		if c.forwardsHUP() {
+			return fieldErrorf([]string{"sighup"}, "reload doesn't forward HUP to services, so signals configured for HUP would never apply")
+		}
+	default:
+		return fieldErrorf([]string{"sighup"}, "must be reload or forward")
+	}
+
+	for name, rule := range c.Signals {
+		if _, err := parseForwardedSignal(name); err != nil {
+			return fieldErrorf([]string{"signals", name}, "%v", err)
+		}
+		for i, service := range rule.Services {
+			if _, exists := c.Services[service]; !exists {
+				return fieldErrorf([]string{"signals", name, "services", listIndex(i)}, "unknown service %q", service)
+			}
+		}
+	}
+
+	for serviceName, svc := range c.Services {
+		for name, action := range svc.Signals {
+			if _, err := parseForwardedSignal(name); err != nil {
+				return fieldErrorf([]string{"services", serviceName, "signals", name}, "%v", err)
+			}
+			switch action {
+			case SignalActionIgnore:
+			case SignalActionReload:
+				if len(svc.ReloadCommand) == 0 {
+					return fieldErrorf([]string{"services", serviceName, "signals", name}, "maps to reload but no reload_command is set")
+				}
+			default:
+				if _, err := parseSignal(action); err != nil {
+					return fieldErrorf([]string{"services", serviceName, "signals", name}, "%v", err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// signalRule returns the forwarding rule for a signal, if one is configured
+func (c *Config) signalRule(sig syscall.Signal) (SignalRule, bool) {
+	for name, rule := range c.Signals {
+		if parsed, err := parseSignal(name); err == nil && parsed == sig {
+			return rule, true
+		}
+	}
+	return SignalRule{}, false
+}
+
+// signalAction returns how a service handles a forwarded signal: the signal
+// to deliver, or the reload/ignore action
+func (s Service) signalAction(sig syscall.Signal) string {
+	for name, action := range s.Signals {
+		if parsed, err := parseSignal(name); err == nil && parsed == sig {
+			return action
+		}
+	}
+	return ""
+}
+
+// signalProcess sends a signal to a service process, or to its whole process
+// group when requested. pei never signals its own process group, so services
+// that share it only receive the signal themselves.
+func signalProcess(pid int, sig syscall.Signal, group bool) error {
+	if group {
+		pgid, err := syscall.Getpgid(pid)
+		if err == nil && pgid != syscall.Getpgrp() {
+			return syscall.Kill(-pgid, sig)
+		}
+	}
+	return syscall.Kill(pid, sig)
+}
+
+// runReloadCommand starts a service's reload command as the service user.
+// It must be called with elevated privileges; the command is waited on in
+// the background so signal handling is not blocked.
+func (d *Daemon) runReloadCommand(svc Service) error {
+	ctx, cancel := context.WithTimeout(d.ctx, reloadCommandTimeout)
+	cmd, err := buildHelperCmd(ctx, svc, svc.ReloadCommand)
+	if err != nil {
+		cancel()
+		return err
+	}
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := startChild(cmd); err != nil {
+		cancel()
+		return err
+	}
+
+	go func() {
+		defer cancel()
+		signalLogger := getLogger("signal")
+		if err := waitChild(cmd); err != nil {
+			signalLogger.Error("Reload command failed", "service", svc.Name, "error", err, "output", output.String())
+		} else {
+			signalLogger.Info("Reload command completed", "service", svc.Name)
+		}
+	}()
+	return nil
+}
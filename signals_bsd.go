@@ -0,0 +1,18 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import "syscall"
+
+// There are no real-time signals to address by name here
+const (
+	sigRTMin = 0
+	sigRTMax = 0
+	sigMax   = 31
+)
+
+// platformSignalNames maps BSD-only signal names to signals
+var platformSignalNames = map[string]syscall.Signal{
+	"EMT":  syscall.SIGEMT,
+	"INFO": syscall.SIGINFO,
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"path/filepath"
+	"syscall"
+)
+
+// Real-time signal range as seen by processes. The C library reserves the
+// first few kernel real-time signals for itself, glibc two and musl three,
+// so RTMIN depends on which one the container's programs use.
+const (
+	sigRTMax = 64
+	sigMax   = sigRTMax
+)
+
+// sigRTMin is SIGRTMIN for the container's C library
+var sigRTMin = detectRTMin("/")
+
+// detectRTMin is SIGRTMIN for the C library under root: 35 where musl's
+// dynamic loader is installed, as on Alpine, and glibc's 34 otherwise
+func detectRTMin(root string) int {
+	if musl, _ := filepath.Glob(filepath.Join(root, "lib", "ld-musl-*.so.1")); len(musl) > 0 {
+		return 35
+	}
+	return 34
+}
+
+// platformSignalNames maps Linux-only signal names to signals
+var platformSignalNames = map[string]syscall.Signal{
+	"STKFLT": syscall.SIGSTKFLT,
+	"POLL":   syscall.SIGPOLL,
+	"PWR":    syscall.SIGPWR,
+}
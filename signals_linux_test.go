@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestDetectRTMin(t *testing.T) {
+	root := t.TempDir()
+	if rtmin := detectRTMin(root); rtmin != 34 {
+		t.Errorf("expected glibc's SIGRTMIN without musl, got %d", rtmin)
+	}
+
+	os.MkdirAll(filepath.Join(root, "lib"), 0o755)
+	os.WriteFile(filepath.Join(root, "lib", "ld-musl-x86_64.so.1"), nil, 0o755)
+	if rtmin := detectRTMin(root); rtmin != 35 {
+		t.Errorf("expected musl's SIGRTMIN, got %d", rtmin)
+	}
+}
+
+func TestParseRealTimeSignal(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected syscall.Signal
+	}{
+		{"RTMIN", syscall.Signal(sigRTMin)},
+		{"SIGRTMIN", syscall.Signal(sigRTMin)},
+		{"rtmin+0", syscall.Signal(sigRTMin)},
+		{"RTMIN+3", syscall.Signal(sigRTMin + 3)},
+		{"SIGRTMIN+3", syscall.Signal(sigRTMin + 3)},
+		{"RTMAX", syscall.Signal(sigRTMax)},
+		{"RTMAX-1", syscall.Signal(sigRTMax - 1)},
+		{"RTMIN+" + strconv.Itoa(sigRTMax-sigRTMin), syscall.Signal(sigRTMax)},
+	}
+	for _, tt := range tests {
+		if sig, err := parseSignal(tt.name); err != nil || sig != tt.expected {
+			t.Errorf("parseSignal(%q) = %d, %v, expected %d", tt.name, sig, err, tt.expected)
+		}
+	}
+
+	for _, invalid := range []string{
+		"RTMIN+", "RTMIN-2", "RTMIN+-1", "RTMIN+x", "RTMIN+" + strconv.Itoa(sigRTMax-sigRTMin+1),
+		"RTMAX+1", "RTMAX-" + strconv.Itoa(sigRTMax-sigRTMin+1), "RTMID",
+	} {
+		if _, err := parseSignal(invalid); err == nil {
+			t.Errorf("expected parseSignal(%q) to fail", invalid)
+		}
+	}
+}
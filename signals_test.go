@@ -0,0 +1,169 @@
+package main
+
+import (
+	"io"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected syscall.Signal
+	}{
+		{"HUP", syscall.SIGHUP},
+		{"SIGWINCH", syscall.SIGWINCH},
+		{"sigquit", syscall.SIGQUIT},
+		{"15", syscall.SIGTERM},
+		{"9", syscall.SIGKILL},
+	}
+
+	for _, tt := range tests {
+		sig, err := parseSignal(tt.name)
+		if err != nil {
+			t.Errorf("parseSignal(%q) failed: %v", tt.name, err)
+			continue
+		}
+		if sig != tt.expected {
+			t.Errorf("parseSignal(%q) = %d, expected %d", tt.name, sig, tt.expected)
+		}
+	}
+
+	for _, invalid := range []string{"", "BOGUS", "SIG", "0", "-1", "65", "1.5", "0x1", "RTMIN+40", "RTMIN-1", "RTMAX+1"} {
+		if _, err := parseSignal(invalid); err == nil {
+			t.Errorf("Expected parseSignal(%q) to fail", invalid)
+		}
+	}
+}
+
+func TestValidateSignals(t *testing.T) {
+	tests := []struct {
+		config string
+		err    string
+	}{
+		{"signals:\n  HUP: {services: [web]}\n", ""},
+		{"signals:\n  KILL: {}\n", "is not forwarded to services"},
+		{"signals:\n  BOGUS: {}\n", "unsupported signal"},
+		{"signals:\n  HUP: {services: [db]}\n", "unknown service"},
+		{"services:\n  web:\n    signals: {USR1: TERM, USR2: ignore}\n", ""},
+		{"services:\n  web:\n    signals: {TERM: ignore}\n", "is not forwarded to services"},
+		{"services:\n  web:\n    signals: {HUP: reload}\n", "no reload_command is set"},
+		{"services:\n  web:\n    signals: {HUP: BOGUS}\n", "unsupported signal"},
+	}
+	for _, tt := range tests {
+		config := "services:\n  web:\n    command: [\"true\"]\n"
+		if strings.HasPrefix(tt.config, "services:") {
+			config = strings.Replace(tt.config, "web:\n", "web:\n    command: [\"true\"]\n", 1)
+		} else {
+			config += tt.config
+		}
+		_, err := loadConfig(writeConfig(t, config))
+		if tt.err == "" && err != nil {
+			t.Errorf("%q: expected config to load, got %v", tt.config, err)
+		} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%q: expected error containing %q, got %v", tt.config, tt.err, err)
+		}
+	}
+}
+
+func TestForwardSignalToServices(t *testing.T) {
+	config := &Config{
+		Signals: map[string]SignalRule{"HUP": {Services: []string{"web", "worker", "cache"}}},
+		Services: map[string]Service{
+			"web":    {Name: "web"},
+			"worker": {Name: "worker", Signals: map[string]string{"HUP": "TERM"}},
+			"cache":  {Name: "cache", Signals: map[string]string{"HUP": SignalActionIgnore}},
+			"db":     {Name: "db"},
+		},
+	}
+	d := NewDaemon(config, "", "", "")
+	defer d.cancel()
+
+	// Each service's exit, by the signal that ended it
+	exits := make(map[string]chan syscall.Signal)
+	for name := range config.Services {
+		cmd := exec.Command("sleep", "30")
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		d.serviceProcs[name] = &serviceProcess{cmd: cmd}
+		exited := make(chan syscall.Signal, 1)
+		exits[name] = exited
+		go func() {
+			cmd.Wait()
+			exited <- cmd.ProcessState.Sys().(syscall.WaitStatus).Signal()
+		}()
+		defer cmd.Process.Kill()
+	}
+
+	d.forwardSignalToServices(syscall.SIGHUP)
+
+	for name, expected := range map[string]syscall.Signal{"web": syscall.SIGHUP, "worker": syscall.SIGTERM} {
+		select {
+		case sig := <-exits[name]:
+			if sig != expected {
+				t.Errorf("%s: expected %s, got %s", name, expected, sig)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: expected %s, got nothing", name, expected)
+		}
+	}
+	// Ignored by cache, and not forwarded to db at all
+	for _, name := range []string{"cache", "db"} {
+		select {
+		case sig := <-exits[name]:
+			t.Errorf("%s: expected no signal, got %s", name, sig)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func TestForwardSignalToProcessGroup(t *testing.T) {
+	for _, group := range []bool{false, true} {
+		config := &Config{
+			Signals:  map[string]SignalRule{"HUP": {Services: []string{"web"}}},
+			Services: map[string]Service{"web": {Name: "web", SignalGroup: group}},
+		}
+		d := NewDaemon(config, "", "", "")
+
+		// The shell and its worker share the output pipe, so it closes only
+		// once both have gone
+		cmd := exec.Command("sh", "-c", "sleep 30 & echo started; exec sleep 30")
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		output, err := cmd.StdoutPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		d.serviceProcs["web"] = &serviceProcess{cmd: cmd}
+		// Wait for the worker before signalling
+		if _, err := output.Read(make([]byte, len("started\n"))); err != nil {
+			t.Fatal(err)
+		}
+		closed := make(chan struct{})
+		go func() {
+			io.Copy(io.Discard, output)
+			close(closed)
+		}()
+
+		d.forwardSignalToServices(syscall.SIGHUP)
+		select {
+		case <-closed:
+			if !group {
+				t.Error("expected the worker to outlive a signal to the service alone")
+			}
+		case <-time.After(500 * time.Millisecond):
+			if group {
+				t.Error("expected signal_group to reach the worker too")
+			}
+		}
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Wait()
+		d.cancel()
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// snapshotStateKey holds runtime state in a snapshot. pei ignores top-level
+// x- keys, so a snapshot can be loaded as a config file as it is.
+const snapshotStateKey = extensionPrefix + "state"
+
+// SnapshotState is the runtime state recorded alongside the configuration
+// in a snapshot
+type SnapshotState struct {
+	TakenAt    time.Time                  `yaml:"taken_at"`
+	ConfigPath string                     `yaml:"config_path,omitempty"`
+	Services   map[string]ServiceSnapshot `yaml:"services,omitempty"`
+}
+
+// ServiceSnapshot is one service's runtime state in a snapshot
+type ServiceSnapshot struct {
+	State             ServiceState  `yaml:"state"`
+	Enabled           bool          `yaml:"enabled"`
+	PID               int           `yaml:"pid,omitempty"`
+	StartTime         time.Time     `yaml:"started_at,omitempty"`
+	Restarts          int           `yaml:"restarts,omitempty"`
+	LastRestartReason RestartReason `yaml:"last_restart_reason,omitempty"`
+}
+
+// newSnapshotState records the state of every configured service
+func newSnapshotState(configPath string, statuses map[string]*ServiceStatus, config *Config) SnapshotState {
+	state := SnapshotState{
+		TakenAt:    time.Now().UTC().Truncate(time.Second),
+		ConfigPath: configPath,
+		Services:   make(map[string]ServiceSnapshot),
+	}
+	for name := range config.Services {
+		service := ServiceSnapshot{State: StatePending, Enabled: true}
+		if status, exists := statuses[name]; exists {
+			service.State = status.State
+			service.Enabled = status.State != StateDisabled
+			service.Restarts = status.Restarts
+			service.LastRestartReason = status.LastRestartReason
+			if status.Running {
+				service.PID = status.PID
+				service.StartTime = status.StartTime.UTC().Truncate(time.Second)
+			}
+		}
+		state.Services[name] = service
+	}
+	return state
+}
+
+// writeSnapshot writes the effective configuration with the runtime state
+// under x-state
+func writeSnapshot(w io.Writer, config *Config, state SnapshotState) error {
+	doc, err := renderedConfig(config)
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		doc = make(map[string]any)
+	}
+	doc[snapshotStateKey] = state
+	return writeYAML(w, doc)
+}
+
+// snapshotIPC prints a snapshot of the running daemon
+func snapshotIPC() error {
+	resp, err := sendIPCRequest(IPCRequest{Command: "snapshot"})
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("daemon error: %s", resp.Message)
+	}
+	if resp.Config == nil {
+		return fmt.Errorf("daemon sent no configuration")
+	}
+
+	state := newSnapshotState(resp.ConfigPath, resp.Services, resp.Config)
+	var disabled []string
+	for name, service := range state.Services {
+		if !service.Enabled {
+			disabled = append(disabled, name)
+		}
+	}
+	sort.Strings(disabled)
+	if len(disabled) > 0 {
+		fmt.Fprintf(os.Stderr, "Note: disabled services are recorded under %s but start normally if the snapshot is loaded: %v\n", snapshotStateKey, disabled)
+	}
+	return writeSnapshot(os.Stdout, resp.Config, state)
+}
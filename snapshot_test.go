@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSnapshotLoadsAsConfig(t *testing.T) {
+	config := &Config{Services: map[string]Service{
+		"web":    {Name: "web", Command: []string{"web"}, Restart: RestartAlways},
+		"worker": {Name: "worker", Command: []string{"worker"}},
+	}}
+	statuses := map[string]*ServiceStatus{
+		"web":    {Name: "web", State: StateRunning, Running: true, PID: 42, StartTime: time.Now(), Restarts: 2},
+		"worker": {Name: "worker", State: StateDisabled},
+	}
+
+	var out bytes.Buffer
+	if err := writeSnapshot(&out, config, newSnapshotState("/etc/pei.yaml", statuses, config)); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		State SnapshotState `yaml:"x-state"`
+	}
+	if err := yaml.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("failed to parse snapshot: %v\n%s", err, out.String())
+	}
+	web, worker := doc.State.Services["web"], doc.State.Services["worker"]
+	if web.State != StateRunning || web.PID != 42 || web.Restarts != 2 || !web.Enabled {
+		t.Errorf("unexpected web state %+v", web)
+	}
+	if worker.Enabled || worker.PID != 0 {
+		t.Errorf("expected worker to be disabled, got %+v", worker)
+	}
+	if strings.Contains(out.String(), "started_at: 0001") {
+		t.Errorf("expected unset start times to be left out:\n%s", out.String())
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.yaml")
+	if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("expected snapshot to load as a config, got: %v", err)
+	}
+	if loaded.Services["web"].Restart != RestartAlways || len(loaded.Services) != 2 {
+		t.Errorf("expected the snapshot's services, got %+v", loaded.Services)
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// SpawnPerConnection has pei accept connections on a service's socket and
+// start an instance for each one, with the connection as its stdin and
+// stdout, as inetd does
+const SpawnPerConnection = "per-connection"
+
+// defaultMaxConnections caps a per-connection service's instances running at
+// once, unless it sets max_connections
+const defaultMaxConnections = 64
+
+// acceptRetryDelay is how long to wait after accept fails, for instance
+// because pei has run out of file descriptors
+const acceptRetryDelay = 100 * time.Millisecond
+
+// validateSpawn checks spawn and max_connections
+func (c *Config) validateSpawn() error {
+	for name, svc := range c.Services {
+		if svc.MaxConnections < 0 {
+			return serviceErrorf(name, "max_connections", "must not be negative")
+		}
+		switch svc.Spawn {
+		case "":
+			if svc.MaxConnections != 0 {
+				return serviceErrorf(name, "max_connections", "only applies to spawn: %s", SpawnPerConnection)
+			}
+			continue
+		case SpawnPerConnection:
+		default:
+			return serviceErrorf(name, "spawn", "unknown mode %q, expected %s", svc.Spawn, SpawnPerConnection)
+		}
+
+		if svc.Oneshot || svc.OnDemand || svc.TTY {
+			return serviceErrorf(name, "spawn", "%s can't be used with oneshot, on_demand or tty", SpawnPerConnection)
+		}
+		if svc.ReadyFile != "" || svc.ReadyLogPattern != "" || svc.PostStartCheck != nil || svc.HealthCheck != nil {
+			return serviceErrorf(name, "spawn", "%s instances don't support readiness, post_start_check or healthcheck", SpawnPerConnection)
+		}
+		sockets := 0
+		for i, f := range svc.Files {
+			if f.Listen == "" {
+				continue
+			}
+			sockets++
+			network, _, err := parseListenAddress(f.Listen)
+			if err == nil && !streamNetwork(network) {
+				return fieldErrorf([]string{"services", name, "files", listIndex(i), "listen"},
+					"%s needs a stream socket (tcp or unix) to accept connections on, not %s", SpawnPerConnection, network)
+			}
+		}
+		if sockets != 1 {
+			return serviceErrorf(name, "spawn", "%s needs exactly one socket to listen on in files", SpawnPerConnection)
+		}
+	}
+	return nil
+}
+
+// maxConnections is how many instances of a per-connection service may run
+// at once
+func (svc Service) maxConnections() int {
+	if svc.MaxConnections > 0 {
+		return svc.MaxConnections
+	}
+	return defaultMaxConnections
+}
+
+// listenSocket returns the address of the socket a per-connection service
+// accepts on
+func (svc Service) listenSocket() string {
+	for _, f := range svc.Files {
+		if f.Listen != "" {
+			return f.Listen
+		}
+	}
+	return ""
+}
+
+// connectionSpawner accepts connections for a per-connection service and
+// tracks the instances started for them
+type connectionSpawner struct {
+	listener net.Listener
+	socket   *os.File
+	released chan struct{} // has a value when an instance has exited
+	done     chan struct{} // closed when the accept loop returns
+
+	mu     sync.Mutex
+	active map[*serviceProcess]struct{}
+}
+
+// spawnRequest asks the service manager to start an instance for a
+// connection
+type spawnRequest struct {
+	svc    Service
+	conn   *os.File
+	remote net.Addr
+	reply  chan spawnResult
+}
+
+type spawnResult struct {
+	proc *serviceProcess
+	err  error
+}
+
+// startSpawner starts accepting connections for a per-connection service, if
+// pei isn't already. Definition changes apply from the next connection.
+func (d *Daemon) startSpawner(svc Service) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, exists := d.spawners[svc.Name]; exists {
+		return nil
+	}
+
+	listen := svc.listenSocket()
+	socket := d.shared.listeners[listen]
+	if socket == nil {
+		return fmt.Errorf("socket %s is not open", listen)
+	}
+	listener, err := net.FileListener(socket)
+	if err != nil {
+		return fmt.Errorf("socket %s: %v", listen, err)
+	}
+	spawner := &connectionSpawner{
+		listener: listener,
+		socket:   socket,
+		released: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		active:   make(map[*serviceProcess]struct{}),
+	}
+	d.spawners[svc.Name] = spawner
+
+	status, exists := d.serviceStatus[svc.Name]
+	if !exists {
+		status = &ServiceStatus{Name: svc.Name, Labels: svc.Labels}
+		d.serviceStatus[svc.Name] = status
+	}
+	status.State = StateRunning
+	status.Running = true
+	status.StartTime = time.Now()
+
+	logServiceInfo(svc.Name, "Accepting connections", "listen", listen, "max_connections", svc.maxConnections())
+	go d.acceptConnections(svc.Name, spawner)
+	return nil
+}
+
+// stopSpawner stops accepting connections for a service, leaving instances
+// already started to finish. It returns the instances still running.
+func (d *Daemon) stopSpawner(name string) []*serviceProcess {
+	d.mu.Lock()
+	spawner, exists := d.spawners[name]
+	delete(d.spawners, name)
+	d.mu.Unlock()
+	if !exists {
+		return nil
+	}
+	spawner.close()
+	return spawner.instances()
+}
+
+// closeSpawner stops accepting connections for a service during shutdown,
+// keeping track of its instances until they have been stopped. It returns
+// the instances still running.
+func (d *Daemon) closeSpawner(name string) []*serviceProcess {
+	d.mu.RLock()
+	spawner, exists := d.spawners[name]
+	d.mu.RUnlock()
+	if !exists {
+		return nil
+	}
+	spawner.close()
+	return spawner.instances()
+}
+
+// close stops the accept loop and waits for it to return
+func (s *connectionSpawner) close() {
+	s.listener.Close()
+	<-s.done
+	// Accepting put the socket in non-blocking mode, which a service given
+	// it later would inherit
+	syscall.SetNonblock(int(s.socket.Fd()), false)
+}
+
+// connectionInstances returns the running per-connection instances of a
+// service
+func (d *Daemon) connectionInstances(name string) []*serviceProcess {
+	d.mu.RLock()
+	spawner, exists := d.spawners[name]
+	d.mu.RUnlock()
+	if !exists {
+		return nil
+	}
+	return spawner.instances()
+}
+
+func (s *connectionSpawner) instances() []*serviceProcess {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	procs := make([]*serviceProcess, 0, len(s.active))
+	for proc := range s.active {
+		procs = append(procs, proc)
+	}
+	return procs
+}
+
+func (s *connectionSpawner) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.active)
+}
+
+// acceptConnections starts an instance for each connection, waiting while
+// max_connections are running, until the spawner is stopped or the service
+// is no longer per-connection
+func (d *Daemon) acceptConnections(name string, spawner *connectionSpawner) {
+	defer close(spawner.done)
+	for {
+		svc, exists := d.getConfig().Services[name]
+		if !exists || svc.Spawn != SpawnPerConnection {
+			return
+		}
+		if spawner.count() >= svc.maxConnections() {
+			// Further connections wait in the socket's backlog
+			select {
+			case <-spawner.released:
+				continue
+			case <-d.ctx.Done():
+				return
+			}
+		}
+
+		conn, err := spawner.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			logServiceError(name, "Failed to accept connection", "error", err)
+			if !d.sleep(acceptRetryDelay) {
+				return
+			}
+			continue
+		}
+		d.spawnForConnection(svc, spawner, conn)
+	}
+}
+
+// spawnForConnection has the service manager start an instance with conn as
+// its stdin and stdout
+func (d *Daemon) spawnForConnection(svc Service, spawner *connectionSpawner, conn net.Conn) {
+	remote := conn.RemoteAddr()
+	file, err := conn.(interface{ File() (*os.File, error) }).File()
+	conn.Close()
+	if err != nil {
+		logServiceError(svc.Name, "Failed to hand over connection", "error", err)
+		return
+	}
+	defer file.Close()
+
+	reply := make(chan spawnResult, 1)
+	select {
+	case d.spawnChan <- spawnRequest{svc: svc, conn: file, remote: remote, reply: reply}:
+	case <-d.ctx.Done():
+		return
+	}
+	result := <-reply
+	if result.err != nil {
+		logServiceError(svc.Name, "Failed to start instance for connection", "remote", remote.String(), "error", result.err)
+		return
+	}
+	proc := result.proc
+
+	spawner.mu.Lock()
+	spawner.active[proc] = struct{}{}
+	spawner.mu.Unlock()
+	d.setConnections(svc.Name, spawner.count())
+
+	go func() {
+		err := waitChild(proc.cmd)
+		close(proc.exited)
+		proc.capture.waitDrained(outputDrainTimeout)
+		proc.capture.Stop()
+
+		spawner.mu.Lock()
+		delete(spawner.active, proc)
+		spawner.mu.Unlock()
+		select {
+		case spawner.released <- struct{}{}:
+		default:
+		}
+		d.setConnections(svc.Name, spawner.count())
+
+		if err != nil {
+			logServiceInfo(svc.Name, "Connection instance exited with error", "pid", proc.cmd.Process.Pid, "error", err)
+		}
+	}()
+}
+
+// setConnections records how many per-connection instances are running
+func (d *Daemon) setConnections(name string, count int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if status, exists := d.serviceStatus[name]; exists {
+		status.Connections = count
+	}
+}
+
+// launchConnection starts an instance of a per-connection service for one
+// connection. Its stderr goes to the service's logs. Must be called with
+// elevated privileges.
+func (d *Daemon) launchConnection(req spawnRequest) (*serviceProcess, error) {
+	svc := req.svc
+	if err := verifyCommand(svc); err != nil {
+		return nil, err
+	}
+	uid, gid, err := lookupUIDGID(svc.User, svc.Group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user/group: %v", err)
+	}
+
+	cmd := buildServiceCmd(svc, uid, gid)
+	if err := userNamespaceCommand(cmd, svc, uid, gid); err != nil {
+		return nil, err
+	}
+	join, err := d.sandboxJoins(svc)
+	if err != nil {
+		return nil, err
+	}
+	if err := sandboxCommand(cmd, svc, uid, gid, join); err != nil {
+		return nil, err
+	}
+	cmd.Stdin, cmd.Stdout = req.conn, req.conn
+	if host, port, err := net.SplitHostPort(req.remote.String()); err == nil {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, "REMOTE_ADDR="+host, "REMOTE_PORT="+port)
+	}
+
+	stderr, stderrW, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stderr pipe: %v", err)
+	}
+	cmd.Stderr = stderrW
+
+	// The listening socket stays with pei; other files are passed as usual
+	withoutSocket := svc
+	withoutSocket.Files = slices.DeleteFunc(slices.Clone(svc.Files), func(f FileDescriptor) bool { return f.Listen != "" })
+	releaseFiles, err := d.setupExtraFiles(cmd, withoutSocket)
+	if err != nil {
+		stderr.Close()
+		stderrW.Close()
+		return nil, fmt.Errorf("failed to set up extra files: %v", err)
+	}
+
+	// Stdout is the connection, so only stderr can go to a file
+	withoutStdout := svc
+	withoutStdout.Stdout = ""
+	outputFiles, err := openOutputFiles(withoutStdout, uid, gid)
+	if err != nil {
+		stderr.Close()
+		stderrW.Close()
+		releaseFiles()
+		return nil, fmt.Errorf("failed to open output files: %v", err)
+	}
+
+	err = startLabeled(cmd, svc)
+	stderrW.Close()
+	releaseFiles()
+	if err != nil {
+		stderr.Close()
+		outputFiles.Close()
+		return nil, err
+	}
+
+	proc := &serviceProcess{cmd: cmd, exited: make(chan struct{}), ready: newReadyState()}
+	proc.capture = NewServiceOutputCapture(svc, nil, stderr, cmd.Process.Pid)
+	proc.capture.history = d.serviceLogs(svc.Name)
+	proc.capture.files = outputFiles
+	proc.capture.Start()
+	return proc, nil
+}
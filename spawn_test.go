@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"os/user"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigValidatesSpawn(t *testing.T) {
+	tests := []struct {
+		service string
+		err     string
+	}{
+		{`spawn: forked`, "unknown mode"},
+		{`max_connections: 4`, "only applies"},
+		{`spawn: per-connection`, "exactly one socket"},
+		{`{spawn: per-connection, files: [{fd: 3, listen: "udp://127.0.0.1:0"}]}`, "needs a stream socket"},
+		{`{spawn: per-connection, oneshot: true, files: [{fd: 3, listen: "tcp://127.0.0.1:0"}]}`, "can't be used with oneshot"},
+		{`{spawn: per-connection, max_connections: 4, files: [{fd: 3, listen: "tcp://127.0.0.1:0"}]}`, ""},
+	}
+	for _, tt := range tests {
+		config := "services:\n  echo:\n    command: [\"cat\"]\n    " + tt.service + "\n"
+		if strings.HasPrefix(tt.service, "{") {
+			config = "services:\n  echo: " + strings.Replace(tt.service, "{", "{command: [\"cat\"], ", 1) + "\n"
+		}
+		_, err := loadConfig(writeConfig(t, config))
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: expected config to load, got %v", tt.service, err)
+		} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.service, tt.err, err)
+		}
+	}
+}
+
+func TestSpawnPerConnection(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := user.LookupGroupId(current.Gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := Service{
+		Name:           "echo",
+		Command:        []string{"sh", "-c", "read line; echo \"$line from $REMOTE_ADDR\"; sleep 0.2"},
+		User:           current.Username,
+		Group:          group.Name,
+		Spawn:          SpawnPerConnection,
+		MaxConnections: 1,
+		Files:          []FileDescriptor{{FD: 3, Listen: "tcp://127.0.0.1:0"}},
+	}
+	config := &Config{Services: map[string]Service{"echo": svc}}
+	d := NewDaemon(config, "", "", "")
+	defer d.cancel()
+	if d.shared, err = openSharedFiles(config); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stands in for the service manager
+	go func() {
+		for {
+			select {
+			case req := <-d.spawnChan:
+				proc, err := d.launchConnection(req)
+				req.reply <- spawnResult{proc: proc, err: err}
+			case <-d.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if err := d.startService(svc); err != nil {
+		t.Fatal(err)
+	}
+	address := d.spawners["echo"].listener.Addr().String()
+
+	// Each connection gets its own instance, one at a time
+	answers := make(chan string, 2)
+	for _, name := range []string{"one", "two"} {
+		go func() {
+			conn, err := net.Dial("tcp", address)
+			if err != nil {
+				answers <- err.Error()
+				return
+			}
+			defer conn.Close()
+			conn.Write([]byte(name + "\n"))
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			answers <- strings.TrimSpace(line)
+		}()
+	}
+	for range 2 {
+		select {
+		case answer := <-answers:
+			if !strings.HasSuffix(answer, "from 127.0.0.1") {
+				t.Errorf("expected an answer from a connection instance, got %q", answer)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected both connections to be answered")
+		}
+	}
+
+	status, _ := d.getServiceStatus("echo")
+	if status.State != StateRunning {
+		t.Errorf("expected the service to be running, got %s", status.State)
+	}
+
+	// Stopping accepting leaves instances to finish
+	for _, proc := range d.stopSpawner("echo") {
+		<-proc.exited
+	}
+	if _, err := net.Dial("tcp", address); err != nil {
+		t.Errorf("expected the socket to stay open for a later spawner, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// startRequest asks the service manager to start a service an operator
+// stopped, or one that failed or completed
+type startRequest struct {
+	svc  Service
+	done chan error
+}
+
+// startable reports whether pei start can start a service in this state:
+// one that isn't running, and won't be started by pei itself
+func (s ServiceState) startable() bool {
+	switch s {
+	case StateDisabled, StateStopped, StateFailed, StateCompleted:
+		return true
+	default:
+		return false
+	}
+}
+
+// requestStart asks the service manager to start a service that isn't
+// running and waits until it has, or until ctx is done
+func (d *Daemon) requestStart(ctx context.Context, name string) error {
+	if d.shuttingDown() {
+		return fmt.Errorf("daemon is shutting down")
+	}
+	svc, exists := d.getConfig().Services[name]
+	if !exists {
+		return fmt.Errorf("service '%s' not found", name)
+	}
+	if state := d.serviceState(name); !state.startable() {
+		return fmt.Errorf("service '%s' is %s", name, state)
+	}
+
+	req := startRequest{svc: svc, done: make(chan error, 1)}
+	select {
+	case d.startChan <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startStopped starts a service that isn't running, lifting any hold pei
+// stop put on it. An on-demand service goes back to waiting for a
+// connection. Must be called with elevated privileges.
+func (d *Daemon) startStopped(svc Service) error {
+	if state := d.serviceState(svc.Name); !state.startable() {
+		return fmt.Errorf("service '%s' is %s", svc.Name, state)
+	}
+	if svc.OnDemand {
+		d.awaitActivation(svc)
+	} else if err := d.startInstance(svc); err != nil {
+		d.failService(svc, fmt.Sprintf("failed to start: %v", err))
+		return err
+	}
+	d.events.record(EventServiceStarted, svc.Name, "Service started by operator", nil)
+	return nil
+}
+
+// disableService leaves a service stopped after pei stop: its restart
+// policy, reloads and anything else that would start it again are held off
+// until pei start
+func (d *Daemon) disableService(svc Service) {
+	if svc.Spawn == SpawnPerConnection {
+		d.stopSpawner(svc.Name)
+	}
+	d.setState(svc, StateDisabled)
+}
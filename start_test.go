@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRequestStartRefusesRunningServices(t *testing.T) {
+	svc := Service{Name: "web"}
+	d := NewDaemon(&Config{Services: map[string]Service{"web": svc}}, "", "", "")
+	defer d.cancel()
+
+	for _, state := range []ServiceState{StateRunning, StateBackoff, StateIdle} {
+		d.setState(svc, state)
+		err := d.requestStart(context.Background(), "web")
+		if err == nil || !strings.Contains(err.Error(), string(state)) {
+			t.Errorf("%s: expected start to be refused, got %v", state, err)
+		}
+	}
+	if err := d.requestStart(context.Background(), "nope"); err == nil {
+		t.Error("expected an unknown service to be rejected")
+	}
+}
+
+func TestStartableStates(t *testing.T) {
+	for state, want := range map[ServiceState]bool{
+		StateDisabled:  true,
+		StateStopped:   true,
+		StateFailed:    true,
+		StateCompleted: true,
+		StatePending:   false,
+		StateStarting:  false,
+		StateHealthy:   false,
+		StateStopping:  false,
+	} {
+		if state.startable() != want {
+			t.Errorf("%s: expected startable %v", state, want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import "time"
+
+// Start retry delays: the first retry waits start_retry_delay, or
+// defaultStartRetryDelay, and each one after twice as long as the last, up
+// to maxStartRetryDelay
+const (
+	defaultStartRetryDelay = time.Second
+	maxStartRetryDelay     = 30 * time.Second
+)
+
+// validateStartRetries checks start_retries and start_retry_delay
+func (c *Config) validateStartRetries() error {
+	for name, svc := range c.Services {
+		if svc.StartRetries < 0 {
+			return serviceErrorf(name, "start_retries", "must not be negative")
+		}
+		if svc.StartRetryDelay < 0 {
+			return serviceErrorf(name, "start_retry_delay", "must not be negative")
+		}
+		if svc.StartRetryDelay > 0 && svc.StartRetries == 0 {
+			return serviceErrorf(name, "start_retry_delay", "needs start_retries")
+		}
+		if svc.StartRetries > 0 && svc.OnDemand {
+			return serviceErrorf(name, "start_retries", "doesn't apply to on_demand services, which start on a connection")
+		}
+	}
+	return nil
+}
+
+// startRetryDelay is how long to wait before trying to start a service again
+// after its attempt'th try failed
+func startRetryDelay(svc Service, attempt int) time.Duration {
+	delay := svc.StartRetryDelay
+	if delay <= 0 {
+		delay = defaultStartRetryDelay
+	}
+	for range attempt - 1 {
+		if delay >= maxStartRetryDelay {
+			break
+		}
+		delay *= 2
+	}
+	return min(delay, maxStartRetryDelay)
+}
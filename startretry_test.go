@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartRetryDelay(t *testing.T) {
+	svc := Service{StartRetries: 10, StartRetryDelay: 5 * time.Second}
+	for attempt, want := range []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second} {
+		if got := startRetryDelay(svc, attempt+1); got != want {
+			t.Errorf("attempt %d: expected %s, got %s", attempt+1, want, got)
+		}
+	}
+	if got := startRetryDelay(Service{StartRetries: 1}, 1); got != defaultStartRetryDelay {
+		t.Errorf("expected the default delay, got %s", got)
+	}
+}
+
+func TestValidateStartRetries(t *testing.T) {
+	cases := []struct {
+		svc Service
+		err string
+	}{
+		{Service{StartRetries: -1}, "start_retries: must not be negative"},
+		{Service{StartRetries: 1, StartRetryDelay: -time.Second}, "start_retry_delay: must not be negative"},
+		{Service{StartRetryDelay: time.Second}, "needs start_retries"},
+		{Service{StartRetries: 3, OnDemand: true}, "doesn't apply to on_demand"},
+	}
+	for _, c := range cases {
+		config := &Config{Services: map[string]Service{"web": c.svc}}
+		if err := config.validateStartRetries(); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%+v: expected error containing %q, got %v", c.svc, c.err, err)
+		}
+	}
+}
+
+func TestStartServiceRetries(t *testing.T) {
+	svc := Service{Name: "web", Command: []string{"/nonexistent/web"}, StartRetries: 2, StartRetryDelay: time.Millisecond}
+	d := NewDaemon(&Config{Services: map[string]Service{"web": svc}}, "", "", "")
+	defer d.cancel()
+	d.setState(svc, StatePending)
+
+	if err := d.startService(svc); err == nil {
+		t.Fatal("expected the start to fail")
+	}
+	if state := d.serviceState("web"); state != StateFailed {
+		t.Errorf("expected the service failed once its retries ran out, got %s", state)
+	}
+
+	// A command that may appear later isn't a preflight problem
+	config := &Config{Services: map[string]Service{"web": svc}}
+	for _, problem := range preflightServices(config) {
+		if strings.Contains(problem.Error(), "/nonexistent/web") {
+			t.Errorf("expected a missing command not to be a problem with start retries, got %v", problem)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import "time"
+
+// ServiceState is where a service is in its lifecycle
+type ServiceState string
+
+const (
+	StatePending   ServiceState = "pending"   // waiting to be started for the first time
+	StateStarting  ServiceState = "starting"  // started, but not ready yet
+	StateRunning   ServiceState = "running"   // started and ready
+	StateHealthy   ServiceState = "healthy"   // running and passed its health check
+	StateStopping  ServiceState = "stopping"  // pei is stopping it
+	StateStopped   ServiceState = "stopped"   // pei stopped it
+	StateBackoff   ServiceState = "backoff"   // exited, waiting out its restart delay
+	StateFailed    ServiceState = "failed"    // exited with an error, or failed to start, and won't be restarted
+	StateCompleted ServiceState = "completed" // exited successfully and won't be restarted
+	StateDisabled  ServiceState = "disabled"  // won't be started until an operator starts it
+	StateIdle      ServiceState = "idle"      // on demand, waiting for a connection to start it
+)
+
+// running reports whether a service in this state has a live process
+func (s ServiceState) running() bool {
+	switch s {
+	case StateStarting, StateRunning, StateHealthy, StateStopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// setState moves a service to a new state, creating its status if pei hasn't
+// tracked it yet
+func (d *Daemon) setState(svc Service, state ServiceState) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	status, exists := d.serviceStatus[svc.Name]
+	if !exists {
+		status = &ServiceStatus{Name: svc.Name, Labels: svc.Labels}
+		d.serviceStatus[svc.Name] = status
+	}
+	status.State = state
+	status.Running = state.running()
+	status.NextRestart = time.Time{}
+}
+
+// failService marks a service failed for good, rather than failed until its
+// restart, and records that pei has given up on it
+func (d *Daemon) failService(svc Service, reason string) {
+	d.setState(svc, StateFailed)
+	d.events.record(EventServiceFailed, svc.Name, "Service failed and will not be restarted",
+		map[string]string{"reason": reason})
+}
+
+// serviceState returns a service's current state, or "" if pei isn't
+// tracking it
+func (d *Daemon) serviceState(name string) ServiceState {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if status, exists := d.serviceStatus[name]; exists {
+		return status.State
+	}
+	return ""
+}
+
+// setNextRestart records when a service that isn't running will next be
+// started, until its state changes
+func (d *Daemon) setNextRestart(svc Service, delay time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if status, exists := d.serviceStatus[svc.Name]; exists {
+		status.NextRestart = time.Now().Add(delay)
+	}
+}
+
+// setStateIf moves a service to a new state only if it is in the expected
+// one, so a late signal such as readiness can't undo a later transition
+func (d *Daemon) setStateIf(svc Service, from, to ServiceState) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if status, exists := d.serviceStatus[svc.Name]; exists && status.State == from {
+		status.State = to
+		status.Running = to.running()
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestSetState(t *testing.T) {
+	d := NewDaemon(&Config{}, "", "", "")
+	svc := Service{Name: "web", Labels: map[string]string{"team": "platform"}}
+
+	d.setState(svc, StateStarting)
+	status, exists := d.getServiceStatus("web")
+	if !exists || status.State != StateStarting || !status.Running || status.Labels["team"] != "platform" {
+		t.Fatalf("expected a new starting status, got %+v", status)
+	}
+
+	// Readiness arriving after the service was stopped doesn't revive it
+	d.setState(svc, StateStopping)
+	d.setStateIf(svc, StateStarting, StateRunning)
+	if status.State != StateStopping {
+		t.Errorf("expected state to stay stopping, got %s", status.State)
+	}
+
+	d.setState(svc, StateBackoff)
+	if status.Running {
+		t.Error("expected a service in backoff not to be running")
+	}
+}
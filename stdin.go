@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// stdinFIFOPrefix marks a stdin that names a FIFO pei creates, such as
+// fifo:/run/pei/worker.in
+const stdinFIFOPrefix = "fifo:"
+
+// parseStdin splits a service's stdin into its path and whether pei creates
+// it as a FIFO
+func parseStdin(stdin string) (path string, fifo bool) {
+	if path, found := strings.CutPrefix(stdin, stdinFIFOPrefix); found {
+		return path, true
+	}
+	return stdin, false
+}
+
+// validateStdin checks stdin
+func (c *Config) validateStdin() error {
+	for name, svc := range c.Services {
+		if svc.Stdin == "" {
+			continue
+		}
+		path, _ := parseStdin(svc.Stdin)
+		if !filepath.IsAbs(path) {
+			return serviceErrorf(name, "stdin", "must be an absolute path, or fifo: followed by one")
+		}
+		if svc.TTY {
+			return serviceErrorf(name, "stdin", "cannot be used with tty, whose terminal is the service's input")
+		}
+		if svc.Spawn == SpawnPerConnection {
+			return serviceErrorf(name, "stdin", "cannot be used with spawn, whose connection is each instance's input")
+		}
+	}
+	return nil
+}
+
+// serviceStdin opens what a service reads as its standard input, or returns
+// nil for /dev/null, the default. A file is opened read-only for each
+// instance, and release closes it once the instance has started. A FIFO is
+// created owned by the service's user if it doesn't exist, and held open
+// for reading and writing across restarts: the service never sees end of
+// input when a writer goes away, and what's written while it restarts
+// waits for the next instance. Must be called with elevated privileges.
+func (d *Daemon) serviceStdin(svc Service, uid, gid int) (stdin *os.File, release func(), err error) {
+	path, fifo := parseStdin(svc.Stdin)
+	if path == "" || path == os.DevNull {
+		return nil, func() {}, nil
+	}
+	if !fifo {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, func() { f.Close() }, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f, exists := d.stdinFIFOs[path]; exists {
+		return f, func() {}, nil
+	}
+
+	if err := syscall.Mkfifo(path, 0o660); err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, nil, fmt.Errorf("creating FIFO: %v", err)
+	} else if err == nil {
+		if err := os.Chown(path, uid, gid); err != nil {
+			return nil, nil, fmt.Errorf("chowning FIFO: %v", err)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Mode().Type() != os.ModeNamedPipe {
+		return nil, nil, fmt.Errorf("%s exists and is not a FIFO", path)
+	}
+	// Opening for reading and writing doesn't wait for a writer
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	d.stdinFIFOs[path] = f
+	return f, func() {}, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServiceStdin(t *testing.T) {
+	d := NewDaemon(&Config{}, "", "", "")
+	defer d.cancel()
+	dir := t.TempDir()
+
+	stdin, release, err := d.serviceStdin(Service{Stdin: os.DevNull}, os.Getuid(), os.Getgid())
+	if err != nil || stdin != nil {
+		t.Errorf("expected /dev/null to be left to exec, got %v (%v)", stdin, err)
+	}
+	release()
+
+	file := filepath.Join(dir, "jobs")
+	os.WriteFile(file, []byte("job\n"), 0o600)
+	stdin, release, err = d.serviceStdin(Service{Stdin: file}, os.Getuid(), os.Getgid())
+	if err != nil || stdin == nil {
+		t.Fatalf("expected the file to be opened, got %v", err)
+	}
+	release()
+	if _, err := stdin.Stat(); err == nil {
+		t.Error("expected the file to be closed once released")
+	}
+
+	// A FIFO is created once and kept open across instances, so input
+	// written between them waits for the next
+	fifo := filepath.Join(dir, "in")
+	svc := Service{Stdin: stdinFIFOPrefix + fifo}
+	first, release, err := d.serviceStdin(svc, os.Getuid(), os.Getgid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if info, err := os.Stat(fifo); err != nil || info.Mode().Type() != os.ModeNamedPipe {
+		t.Fatalf("expected a FIFO, got %v", err)
+	}
+	second, _, err := d.serviceStdin(svc, os.Getuid(), os.Getgid())
+	if err != nil || second != first {
+		t.Errorf("expected the same FIFO to be reused, got %v", err)
+	}
+	writer, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.WriteString("hello\n")
+	writer.Close()
+	buf := make([]byte, 16)
+	if n, err := second.Read(buf); err != nil || string(buf[:n]) != "hello\n" {
+		t.Errorf("expected to read what was written, got %q (%v)", buf[:n], err)
+	}
+
+	// Something else at the path is refused
+	if _, _, err := d.serviceStdin(Service{Stdin: stdinFIFOPrefix + file}, os.Getuid(), os.Getgid()); err == nil {
+		t.Error("expected a regular file to be refused as a FIFO")
+	}
+}
+
+func TestValidateStdin(t *testing.T) {
+	cases := []struct {
+		svc Service
+		err string
+	}{
+		{Service{Stdin: "jobs.txt"}, "absolute"},
+		{Service{Stdin: "fifo:in"}, "absolute"},
+		{Service{Stdin: "/dev/null", TTY: true}, "tty"},
+		{Service{Stdin: "/dev/null", Spawn: SpawnPerConnection}, "spawn"},
+	}
+	for _, c := range cases {
+		config := &Config{Services: map[string]Service{"worker": c.svc}}
+		if err := config.validateStdin(); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%+v: expected error containing %q, got %v", c.svc, c.err, err)
+		}
+	}
+}
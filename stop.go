@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"syscall"
+)
+
+// stopMode is how a stop request ends a service's instance
+type stopMode int
+
+const (
+	stopGraceful stopMode = iota // drain, SIGTERM, then SIGKILL after the stop timeout
+	stopForce                    // SIGKILL its process group at once; pei stop --force
+	stopKill                     // SIGKILL its process group and let the restart policy decide; pei kill
+)
+
+func (m stopMode) String() string {
+	switch m {
+	case stopForce:
+		return "force"
+	case stopKill:
+		return "kill"
+	default:
+		return "graceful"
+	}
+}
+
+// requestStop asks the service manager to stop or kill a service's current
+// instance and waits until it has, or until ctx is done. A stop, unlike a
+// kill, also holds the service stopped until pei start, so one waiting to be
+// restarted can be stopped too.
+func (d *Daemon) requestStop(ctx context.Context, name string, mode stopMode) error {
+	if d.shuttingDown() {
+		return fmt.Errorf("daemon is shutting down")
+	}
+	svc, exists := d.getConfig().Services[name]
+	if !exists {
+		return fmt.Errorf("service '%s' not found", name)
+	}
+	proc, _ := d.getServiceProcess(name)
+	if mode == stopKill {
+		if proc == nil || !proc.running() {
+			return fmt.Errorf("service '%s' not running", name)
+		}
+	} else if d.serviceState(name) == StateDisabled {
+		return fmt.Errorf("service '%s' already stopped", name)
+	}
+
+	req := stopRequest{svc: svc, instance: proc, mode: mode, operator: mode != stopKill, done: make(chan struct{})}
+	select {
+	case d.stopChan <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-req.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// forceStopProcess kills a service's process group without draining it or
+// waiting for it to exit by itself, and leaves the service stopped. Must be
+// called with elevated privileges.
+func (d *Daemon) forceStopProcess(name string, proc *serviceProcess) {
+	svc := d.getConfig().Services[name]
+	d.setState(svc, StateStopping)
+	proc.detached.Store(true)
+
+	pid := proc.cmd.Process.Pid
+	logServiceInfo(name, "Force stopping service", "pid", pid)
+	if err := signalProcess(pid, syscall.SIGKILL, true); err != nil {
+		logServiceError(name, "Failed to kill service", "error", err)
+	}
+	<-proc.exited
+	d.setState(svc, StateStopped)
+	d.events.record(EventServiceStopped, name, "Service force stopped", map[string]string{"mode": stopForce.String()})
+}
+
+// killProcess kills a service's process group at once. Unlike a forced stop
+// the service stays supervised: its restart policy decides what happens
+// next, and a restart records the reason killed rather than crash. Must be
+// called with elevated privileges.
+func (d *Daemon) killProcess(name string, proc *serviceProcess) {
+	proc.killed.Store(true)
+
+	pid := proc.cmd.Process.Pid
+	logServiceInfo(name, "Killing service", "pid", pid)
+	if err := signalProcess(pid, syscall.SIGKILL, true); err != nil {
+		logServiceError(name, "Failed to kill service", "error", err)
+		return
+	}
+	<-proc.exited
+	d.events.record(EventServiceKilled, name, "Service killed", map[string]string{"pid": strconv.Itoa(pid)})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// startGroup starts a process in its own process group, as services are
+func startGroup(t *testing.T) *serviceProcess {
+	t.Helper()
+	cmd := exec.Command("sleep", "30")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	proc := &serviceProcess{cmd: cmd, exited: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		close(proc.exited)
+	}()
+	return proc
+}
+
+func TestForceStopProcess(t *testing.T) {
+	svc := Service{Name: "worker"}
+	d := NewDaemon(&Config{Services: map[string]Service{"worker": svc}}, "", "", "")
+	defer d.cancel()
+	proc := startGroup(t)
+
+	d.forceStopProcess("worker", proc)
+	if proc.running() || !proc.detached.Load() {
+		t.Error("expected the instance to be killed and unsupervised")
+	}
+	if state := d.serviceState("worker"); state != StateStopped {
+		t.Errorf("expected stopped, got %s", state)
+	}
+	events := d.events.list("worker", 0)
+	if len(events) != 1 || events[0].Type != EventServiceStopped || events[0].Fields["mode"] != "force" {
+		t.Errorf("expected a force stop event, got %+v", events)
+	}
+}
+
+func TestKillProcess(t *testing.T) {
+	svc := Service{Name: "worker"}
+	d := NewDaemon(&Config{Services: map[string]Service{"worker": svc}}, "", "", "")
+	defer d.cancel()
+	proc := startGroup(t)
+
+	d.killProcess("worker", proc)
+	if proc.running() {
+		t.Error("expected the instance to be killed")
+	}
+	// Killed instances stay supervised, so the restart policy applies
+	if proc.detached.Load() || !proc.killed.Load() {
+		t.Error("expected the instance to stay supervised and be marked killed")
+	}
+	events := d.events.list("worker", 0)
+	if len(events) != 1 || events[0].Type != EventServiceKilled {
+		t.Errorf("expected a kill event, got %+v", events)
+	}
+	if RestartReasonKilled.exit() {
+		t.Error("expected kills not to count towards crash loops")
+	}
+}
+
+func TestOperatorStopHoldsService(t *testing.T) {
+	svc := Service{Name: "worker", Restart: RestartAlways}
+	d := NewDaemon(&Config{Services: map[string]Service{"worker": svc}}, "", "", "")
+	defer d.cancel()
+	proc := startGroup(t)
+	d.setServiceProcess("worker", proc)
+
+	d.stopInstance(stopRequest{svc: svc, mode: stopForce, operator: true})
+	if proc.running() {
+		t.Error("expected the instance to be stopped")
+	}
+	if state := d.serviceState("worker"); state != StateDisabled {
+		t.Errorf("expected disabled, got %s", state)
+	}
+
+	// The restart policy is held off until pei start
+	d.processRestart(restartRequest{svc: svc, reason: RestartReasonCrash, instance: proc})
+	if count := d.restartCount("worker"); count != 0 {
+		t.Errorf("expected no restart, got %d", count)
+	}
+	if err := d.requestStop(context.Background(), "worker", stopGraceful); err == nil || !strings.Contains(err.Error(), "already stopped") {
+		t.Errorf("expected a second stop to be refused, got %v", err)
+	}
+}
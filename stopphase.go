@@ -0,0 +1,90 @@
+package main
+
+import "time"
+
+// StopPhase is a named group of services stopped together during shutdown.
+// Phases are stopped in the order they are declared, each with its own
+// timeout, so shutdown can go e.g. ingest, then web, then db.
+type StopPhase struct {
+	Name    string        `yaml:"name"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (p StopPhase) timeout() time.Duration {
+	if p.Timeout > 0 {
+		return p.Timeout
+	}
+	return serviceStopTimeout
+}
+
+// validateStopPhases checks the stop phases and the services assigned to them
+func (c *Config) validateStopPhases() error {
+	phases := make(map[string]bool)
+	for i, phase := range c.StopPhases {
+		if phase.Name == "" {
+			return fieldErrorf([]string{"stop_phases", listIndex(i)}, "phase needs a name")
+		}
+		if phases[phase.Name] {
+			return fieldErrorf([]string{"stop_phases", listIndex(i), "name"}, "phase %s declared twice", phase.Name)
+		}
+		if phase.Timeout < 0 {
+			return fieldErrorf([]string{"stop_phases", listIndex(i), "timeout"}, "must not be negative")
+		}
+		phases[phase.Name] = true
+	}
+	for name, svc := range c.Services {
+		if svc.StopPhase != "" && !phases[svc.StopPhase] {
+			return serviceErrorf(name, "stop_phase", "%q is not declared in stop_phases", svc.StopPhase)
+		}
+	}
+	return nil
+}
+
+// shutdownPhase is a stop phase with the services in it, grouped into start
+// tiers so they can still be stopped in reverse start order
+type shutdownPhase struct {
+	name    string
+	timeout time.Duration
+	tiers   [][]string
+}
+
+// services lists every service in the phase
+func (p shutdownPhase) services() []string {
+	var names []string
+	for _, tier := range p.tiers {
+		names = append(names, tier...)
+	}
+	return names
+}
+
+// shutdownPhases splits the start tiers into the configured stop phases, in
+// order, followed by a final phase holding every service not assigned to one
+func (d *Daemon) shutdownPhases() []shutdownPhase {
+	d.mu.RLock()
+	config, tiers := d.config, d.tiers
+	d.mu.RUnlock()
+
+	var phases []shutdownPhase
+	for _, phase := range config.StopPhases {
+		phases = append(phases, shutdownPhase{name: phase.Name, timeout: phase.timeout()})
+	}
+	phases = append(phases, shutdownPhase{name: "default", timeout: serviceStopTimeout})
+
+	index := make(map[string]int)
+	for i, phase := range config.StopPhases {
+		index[phase.Name] = i
+	}
+	for i := range phases {
+		phases[i].tiers = make([][]string, len(tiers))
+	}
+	for t, tier := range tiers {
+		for _, name := range tier {
+			i, assigned := index[config.Services[name].StopPhase]
+			if !assigned {
+				i = len(phases) - 1
+			}
+			phases[i].tiers[t] = append(phases[i].tiers[t], name)
+		}
+	}
+	return phases
+}
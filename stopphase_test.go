@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestShutdownPhases(t *testing.T) {
+	config := &Config{
+		StopPhases: []StopPhase{{Name: "ingest", Timeout: 5 * time.Second}, {Name: "web"}},
+		Services: map[string]Service{
+			"queue":   {Name: "queue", StopPhase: "ingest"},
+			"api":     {Name: "api", StopPhase: "web"},
+			"admin":   {Name: "admin", StopPhase: "web"},
+			"db":      {Name: "db"},
+			"metrics": {Name: "metrics"},
+		},
+	}
+	d := &Daemon{config: config, tiers: [][]string{{"db", "metrics"}, {"admin", "api"}, {"queue"}}}
+
+	phases := d.shutdownPhases()
+	expected := []shutdownPhase{
+		{name: "ingest", timeout: 5 * time.Second, tiers: [][]string{nil, nil, {"queue"}}},
+		{name: "web", timeout: serviceStopTimeout, tiers: [][]string{nil, {"admin", "api"}, nil}},
+		{name: "default", timeout: serviceStopTimeout, tiers: [][]string{{"db", "metrics"}, nil, nil}},
+	}
+	if !reflect.DeepEqual(phases, expected) {
+		t.Errorf("expected %+v, got %+v", expected, phases)
+	}
+}
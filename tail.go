@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tailBufferLines is how many lines a slow tail -f client may fall behind
+// before lines are dropped for it
+const tailBufferLines = 1024
+
+// logFilter selects log lines by stream and minimum level
+type logFilter struct {
+	stream string
+	level  slog.Level
+}
+
+func newLogFilter(stream, level string) (logFilter, error) {
+	filter := logFilter{stream: stream, level: slog.LevelDebug}
+	switch stream {
+	case "", "stdout", "stderr", "tty":
+	default:
+		return filter, fmt.Errorf("unknown stream %q, use stdout, stderr or tty", stream)
+	}
+	if level != "" {
+		filter.level = parseLogLevel(strings.ToUpper(level))
+	}
+	return filter, nil
+}
+
+func (f logFilter) matches(line LogLine) bool {
+	if f.stream != "" && line.Stream != f.stream {
+		return false
+	}
+	return parseLogLevel(line.Level) >= f.level
+}
+
+// tailSession is an accepted tail request: the recent lines to send first
+// and, when following, the live lines arriving after them
+type tailSession struct {
+	history     []LogLine
+	live        chan LogLine
+	follow      bool
+	filter      logFilter
+	seen        map[string]time.Time
+	unsubscribe []func()
+}
+
+// openTail checks a tail request and collects its history, subscribing to
+// live output first when following so no line falls between the two
+func (d *Daemon) openTail(req IPCRequest) (*tailSession, error) {
+	config := d.getConfig()
+	services := req.Services
+	if len(services) == 0 {
+		for name := range config.Services {
+			services = append(services, name)
+		}
+	}
+	for _, name := range services {
+		if _, exists := config.Services[name]; !exists {
+			return nil, fmt.Errorf("Service '%s' not found", name)
+		}
+	}
+	filter, err := newLogFilter(req.Stream, req.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &tailSession{
+		live:   make(chan LogLine, tailBufferLines),
+		follow: req.Follow,
+		filter: filter,
+		seen:   make(map[string]time.Time),
+	}
+	if req.Follow {
+		for _, name := range services {
+			t.unsubscribe = append(t.unsubscribe, d.serviceLogs(name).subscribe(func(line LogLine) {
+				line.Service = name
+				select {
+				case t.live <- line:
+				default:
+				}
+			}))
+		}
+	}
+
+	// Live lines already seen in a service's history are skipped
+	for _, name := range services {
+		for _, line := range d.serviceLogs(name).last(0) {
+			t.seen[name] = line.Time
+			if filter.matches(line) {
+				line.Service = name
+				t.history = append(t.history, line)
+			}
+		}
+	}
+	sort.SliceStable(t.history, func(i, j int) bool { return t.history[i].Time.Before(t.history[j].Time) })
+	if req.Limit > 0 && len(t.history) > req.Limit {
+		t.history = t.history[len(t.history)-req.Limit:]
+	}
+	return t, nil
+}
+
+// close stops following live output
+func (t *tailSession) close() {
+	for _, unsubscribe := range t.unsubscribe {
+		unsubscribe()
+	}
+}
+
+// stream sends the history and then, when following, live lines until ctx
+// is done or send fails
+func (t *tailSession) stream(ctx context.Context, send func(LogLine) error) {
+	for _, line := range t.history {
+		if err := send(line); err != nil {
+			return
+		}
+	}
+	if !t.follow {
+		return
+	}
+	for {
+		select {
+		case line := <-t.live:
+			if !t.filter.matches(line) || !line.Time.After(t.seen[line.Service]) {
+				continue
+			}
+			if err := send(line); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// tailLogs streams recent and, when following, live output of services to an
+// IPC client. After the initial response the connection carries one JSON
+// encoded LogLine per line until the client disconnects.
+func (d *Daemon) tailLogs(conn net.Conn, encoder *json.Encoder, req IPCRequest) {
+	t, err := d.openTail(req)
+	if err != nil {
+		if err := encoder.Encode(IPCResponse{Success: false, Message: err.Error()}); err != nil {
+			slog.Error("Failed to encode IPC response", "error", err)
+		}
+		return
+	}
+	defer t.close()
+
+	if err := encoder.Encode(IPCResponse{Success: true}); err != nil {
+		return
+	}
+
+	// The client sends nothing more; reading only tells us when it leaves
+	ctx, cancel := context.WithCancel(d.ctx)
+	defer cancel()
+	if req.Follow {
+		go func() {
+			defer cancel()
+			io.Copy(io.Discard, conn)
+		}()
+	}
+
+	t.stream(ctx, func(line LogLine) error {
+		conn.SetWriteDeadline(time.Now().Add(attachWriteTimeout))
+		return encoder.Encode(line)
+	})
+}
+
+// tailLogsIPC prints merged output of services, prefixed by service name,
+// following new output when follow is set
+func tailLogsIPC(services []string, lines int, follow bool, stream, level string) error {
+	client, err := dialIPCClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	req := IPCRequest{
+		Command:  "tail",
+		Services: services,
+		Limit:    lines,
+		Follow:   follow,
+		Stream:   stream,
+		Level:    level,
+	}
+	response, tail, err := client.openStream(req)
+	if err != nil {
+		return err
+	}
+	if !response.Success {
+		return fmt.Errorf("daemon error: %s", response.Message)
+	}
+
+	width := 0
+	for _, name := range services {
+		width = max(width, len(name))
+	}
+	for {
+		var line LogLine
+		if err := tail.next(&line); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		// Widen the prefix column as new services show up when tailing all
+		width = max(width, len(line.Service))
+		out := os.Stdout
+		if line.Stream == "stderr" {
+			out = os.Stderr
+		}
+		fmt.Fprintf(out, "%-*s | %s\n", width, line.Service, line.Text)
+	}
+}
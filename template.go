@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// templateConfig renders the config as a Go template before parsing it. It
+// is set by the --template flag, and implied by a .tmpl config file.
+var templateConfig bool
+
+// configTemplateFuncs are the functions available to config templates
+var configTemplateFuncs = template.FuncMap{
+	// env returns an environment variable, or "" if it is unset
+	"env": os.Getenv,
+
+	// file returns a file's contents without its trailing newline, such as a
+	// mounted secret
+	"file": func(path string) (string, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	},
+
+	"hostname": os.Hostname,
+
+	// default returns value, or fallback if value is empty:
+	// {{ env "PORT" | default "8080" }}
+	"default": func(fallback, value string) string {
+		if value == "" {
+			return fallback
+		}
+		return value
+	},
+
+	// required fails rendering if value is empty:
+	// {{ env "DATABASE_URL" | required "DATABASE_URL" }}
+	"required": func(name, value string) (string, error) {
+		if value == "" {
+			return "", fmt.Errorf("%s is required", name)
+		}
+		return value, nil
+	},
+
+	// quote makes a value safe to use as a YAML scalar
+	"quote": func(value string) (string, error) {
+		quoted, err := json.Marshal(value)
+		return string(quoted), err
+	},
+}
+
+// renderConfigTemplate runs the config file through text/template
+func renderConfigTemplate(path string, data []byte) ([]byte, error) {
+	tmpl, err := template.New(path).Option("missingkey=error").Funcs(configTemplateFuncs).Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("config template: %v", err)
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, nil); err != nil {
+		return nil, fmt.Errorf("config template: %v", err)
+	}
+	return rendered.Bytes(), nil
+}
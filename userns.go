@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// UserNamespace runs a service in a user namespace of its own, where its
+// user appears as another, usually root
+type UserNamespace struct {
+	// inside:outside:count ranges; by default the service's user and group
+	// are root inside
+	UIDMap []string `yaml:"uid_map"`
+	GIDMap []string `yaml:"gid_map"`
+}
+
+// idMapping is one range of a user namespace's uid or gid map
+type idMapping struct {
+	inside, outside, size int
+}
+
+// parseIDMap parses inside:outside:count ranges, or maps root inside to id
+// when there are none
+func parseIDMap(entries []string, id int) ([]idMapping, error) {
+	if len(entries) == 0 {
+		return []idMapping{{inside: 0, outside: id, size: 1}}, nil
+	}
+	mappings := make([]idMapping, len(entries))
+	for i, entry := range entries {
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%q must be inside:outside:count", entry)
+		}
+		var numbers [3]int
+		for j, part := range parts {
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%q must be inside:outside:count", entry)
+			}
+			numbers[j] = n
+		}
+		if numbers[2] == 0 {
+			return nil, fmt.Errorf("%q maps no ids", entry)
+		}
+		mappings[i] = idMapping{inside: numbers[0], outside: numbers[1], size: numbers[2]}
+	}
+	return mappings, nil
+}
+
+// insideID is the id that outside appears as in a user namespace
+func insideID(mappings []idMapping, outside int) (int, bool) {
+	for _, m := range mappings {
+		if outside >= m.outside && outside < m.outside+m.size {
+			return m.inside + outside - m.outside, true
+		}
+	}
+	return 0, false
+}
+
+// validateUserNamespaces checks user_namespace
+func (c *Config) validateUserNamespaces() error {
+	devices := false
+	for _, svc := range c.Services {
+		devices = devices || len(svc.Devices) > 0
+	}
+	for name, svc := range c.Services {
+		if svc.UserNamespace == nil {
+			continue
+		}
+		path := []string{"services", name, "user_namespace"}
+		if _, err := parseIDMap(svc.UserNamespace.UIDMap, 0); err != nil {
+			return fieldErrorf(append(path, "uid_map"), "%v", err)
+		}
+		if _, err := parseIDMap(svc.UserNamespace.GIDMap, 0); err != nil {
+			return fieldErrorf(append(path, "gid_map"), "%v", err)
+		}
+		// pei sets those up as root before the service starts, which a
+		// user namespace's root can't do
+		if sandboxed(svc) {
+			return serviceErrorf(name, "user_namespace", "can't be combined with read_only_paths or protect_paths")
+		}
+		if devices {
+			return serviceErrorf(name, "user_namespace", "can't be used while services list devices")
+		}
+	}
+	return nil
+}
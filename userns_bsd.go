@@ -0,0 +1,17 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// userNamespaceCommand is not supported here, so services with
+// user_namespace fail to start
+func userNamespaceCommand(cmd *exec.Cmd, svc Service, uid, gid int) error {
+	if svc.UserNamespace != nil {
+		return errors.New("user_namespace is not supported on this platform")
+	}
+	return nil
+}
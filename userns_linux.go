@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+// userNamespaceCommand makes a command start in a new user namespace when
+// its service has user_namespace, mapping ids as configured and running it
+// as whatever its user and group appear as inside
+func userNamespaceCommand(cmd *exec.Cmd, svc Service, uid, gid int) error {
+	if svc.UserNamespace == nil {
+		return nil
+	}
+	uidMap, err := parseIDMap(svc.UserNamespace.UIDMap, uid)
+	if err != nil {
+		return err
+	}
+	gidMap, err := parseIDMap(svc.UserNamespace.GIDMap, gid)
+	if err != nil {
+		return err
+	}
+	insideUID, ok := insideID(uidMap, uid)
+	if !ok {
+		return fmt.Errorf("user_namespace uid_map doesn't map uid %d", uid)
+	}
+	insideGID, ok := insideID(gidMap, gid)
+	if !ok {
+		return fmt.Errorf("user_namespace gid_map doesn't map gid %d", gid)
+	}
+
+	attr := cmd.SysProcAttr
+	attr.Cloneflags |= syscall.CLONE_NEWUSER
+	for _, m := range uidMap {
+		attr.UidMappings = append(attr.UidMappings, syscall.SysProcIDMap{ContainerID: m.inside, HostID: m.outside, Size: m.size})
+	}
+	for _, m := range gidMap {
+		attr.GidMappings = append(attr.GidMappings, syscall.SysProcIDMap{ContainerID: m.inside, HostID: m.outside, Size: m.size})
+	}
+	// pei writes the maps as root, so the service may use setgroups
+	attr.GidMappingsEnableSetgroups = true
+	// The credential applies inside the namespace
+	attr.Credential = &syscall.Credential{Uid: uint32(insideUID), Gid: uint32(insideGID)}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseIDMap(t *testing.T) {
+	mappings, err := parseIDMap(nil, 1000)
+	if err != nil || len(mappings) != 1 || mappings[0] != (idMapping{inside: 0, outside: 1000, size: 1}) {
+		t.Errorf("expected root inside to map to 1000, got %v %v", mappings, err)
+	}
+	if id, ok := insideID(mappings, 1000); !ok || id != 0 {
+		t.Errorf("expected 1000 to be root inside, got %d %v", id, ok)
+	}
+
+	mappings, err = parseIDMap([]string{"0:100000:65536"}, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := insideID(mappings, 101000); !ok || id != 1000 {
+		t.Errorf("expected 101000 to be 1000 inside, got %d %v", id, ok)
+	}
+	if _, ok := insideID(mappings, 1000); ok {
+		t.Error("expected 1000 not to be mapped")
+	}
+
+	for _, entry := range []string{"0:1000", "0:-1:1", "a:b:c", "0:1000:0"} {
+		if _, err := parseIDMap([]string{entry}, 0); err == nil {
+			t.Errorf("expected an error for %q", entry)
+		}
+	}
+}
+
+func TestValidateUserNamespaces(t *testing.T) {
+	cases := []struct {
+		services map[string]Service
+		err      string
+	}{
+		{map[string]Service{"web": {UserNamespace: &UserNamespace{UIDMap: []string{"0:1000"}}}}, "uid_map: \"0:1000\" must be"},
+		{map[string]Service{"web": {UserNamespace: &UserNamespace{}, ReadOnlyPaths: []string{"/app"}}}, "can't be combined"},
+		{map[string]Service{"web": {UserNamespace: &UserNamespace{}}, "vm": {Devices: []string{"/dev/kvm"}}}, "while services list devices"},
+	}
+	for _, c := range cases {
+		config := &Config{Services: c.services}
+		if err := config.validateUserNamespaces(); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("expected error containing %q, got %v", c.err, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Build details, set at build time with
+//
+//	-ldflags "-X main.version=v1.2.3 -X main.commit=abc123 -X main.buildDate=2024-01-02T03:04:05Z"
+//
+// Anything left unset is filled in from the build info Go records
+var (
+	version   = ""
+	commit    = ""
+	buildDate = ""
+)
+
+// schemaVersion is the version of the configuration schema and IPC protocol
+// this build speaks. It changes when either changes incompatibly, so a CLI
+// and daemon that disagree on it may not understand each other.
+const schemaVersion = 1
+
+// VersionInfo describes a pei build
+type VersionInfo struct {
+	Version       string `json:"version"`
+	Commit        string `json:"commit,omitempty"`
+	BuildDate     string `json:"build_date,omitempty"`
+	GoVersion     string `json:"go_version"`
+	Platform      string `json:"platform"`
+	SchemaVersion int    `json:"schema_version"`
+}
+
+// buildVersion describes this build
+func buildVersion() VersionInfo {
+	info := VersionInfo{
+		Version:       version,
+		Commit:        commit,
+		BuildDate:     buildDate,
+		GoVersion:     runtime.Version(),
+		Platform:      runtime.GOOS + "/" + runtime.GOARCH,
+		SchemaVersion: schemaVersion,
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && build.Main.Version != "(devel)" {
+			info.Version = build.Main.Version
+		}
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = setting.Value
+				}
+			case "vcs.modified":
+				if setting.Value == "true" && commit == "" {
+					info.Commit += "-dirty"
+				}
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	return info
+}
+
+// print writes the build's details under a heading such as "Client"
+func (v VersionInfo) print(w io.Writer, heading string) {
+	fmt.Fprintf(w, "%s:\n", heading)
+	fmt.Fprintf(w, "  Version:        %s\n", v.Version)
+	if v.Commit != "" {
+		fmt.Fprintf(w, "  Commit:         %s\n", v.Commit)
+	}
+	if v.BuildDate != "" {
+		built := v.BuildDate
+		if t, err := time.Parse(time.RFC3339, v.BuildDate); err == nil {
+			built = t.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "  Built:          %s\n", built)
+	}
+	fmt.Fprintf(w, "  Go version:     %s\n", v.GoVersion)
+	fmt.Fprintf(w, "  Platform:       %s\n", v.Platform)
+	fmt.Fprintf(w, "  Schema version: %d\n", v.SchemaVersion)
+}
+
+// versionMismatch describes how a daemon's build differs from the CLI's in
+// ways that matter, or returns "" if it doesn't
+func versionMismatch(client, daemon VersionInfo) string {
+	switch {
+	case client.SchemaVersion != daemon.SchemaVersion:
+		return fmt.Sprintf("the daemon speaks schema version %d and this CLI %d; commands may fail or be misread until both are upgraded",
+			daemon.SchemaVersion, client.SchemaVersion)
+	case client.Version != daemon.Version || client.Commit != daemon.Commit:
+		return "the daemon runs a different build than this CLI; restart the container to pick up an upgraded binary"
+	}
+	return ""
+}
+
+// showVersion prints the CLI's version and, if one is running, the daemon's
+func showVersion(w io.Writer) {
+	client := buildVersion()
+	client.print(w, "Client")
+
+	resp, err := sendIPCRequest(IPCRequest{Command: "version"})
+	switch {
+	case err != nil:
+		fmt.Fprintf(w, "Daemon: not reachable (%v)\n", err)
+	case !resp.Success || resp.Version == nil:
+		fmt.Fprintf(w, "Daemon: version not reported (%s)\n", resp.Message)
+	default:
+		resp.Version.print(w, "Daemon")
+		if mismatch := versionMismatch(client, *resp.Version); mismatch != "" {
+			fmt.Fprintf(w, "\nWarning: %s\n", mismatch)
+		}
+	}
+}
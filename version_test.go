@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestBuildVersion(t *testing.T) {
+	info := buildVersion()
+	if info.Version == "" || info.GoVersion == "" || info.SchemaVersion != schemaVersion {
+		t.Errorf("expected a version, Go version and schema version, got %+v", info)
+	}
+
+	// Details stamped at build time win over Go's build info
+	defer func(v, c string) { version, commit = v, c }(version, commit)
+	version, commit = "v1.2.3", "abc123"
+	if info := buildVersion(); info.Version != "v1.2.3" || info.Commit != "abc123" {
+		t.Errorf("expected the stamped version and commit, got %+v", info)
+	}
+}
+
+func TestVersionMismatch(t *testing.T) {
+	client := VersionInfo{Version: "v1.2.3", Commit: "abc", SchemaVersion: 1}
+	if mismatch := versionMismatch(client, client); mismatch != "" {
+		t.Errorf("expected no mismatch, got %q", mismatch)
+	}
+	daemon := client
+	daemon.Version = "v1.2.2"
+	if mismatch := versionMismatch(client, daemon); !strings.Contains(mismatch, "different build") {
+		t.Errorf("expected a build mismatch, got %q", mismatch)
+	}
+	daemon.SchemaVersion = 2
+	if mismatch := versionMismatch(client, daemon); !strings.Contains(mismatch, "schema version 2") {
+		t.Errorf("expected a schema mismatch, got %q", mismatch)
+	}
+}
+
+func TestVersionCommand(t *testing.T) {
+	d := NewDaemon(&Config{}, "", "", "")
+	defer d.cancel()
+	resp := handleCommand(context.Background(), d, IPCRequest{Command: "version"})
+	if !resp.Success || resp.Version == nil || resp.Version.SchemaVersion != schemaVersion {
+		t.Errorf("expected the daemon's version, got %+v", resp)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// What pei does when a service's watched files change. Any other action
+// names a signal to send the service, such as HUP.
+const (
+	WatchRestart = "restart" // restart the service; the default
+	WatchReload  = "reload"  // run its reload_command
+)
+
+// defaultWatchDebounce is how long watched files must stop changing before
+// pei acts, so saving several files or a checkout acts once
+const defaultWatchDebounce = 500 * time.Millisecond
+
+// watchPollInterval is how often watched files are checked when they can't
+// be watched with inotify
+const watchPollInterval = time.Second
+
+// watchRequest asks the service manager to reload or signal a service whose
+// watched files changed
+type watchRequest struct {
+	svc  Service
+	path string
+}
+
+// serviceWatcher watches one service's files until stop is closed
+type serviceWatcher struct {
+	patterns []string
+	debounce time.Duration
+	stop     chan struct{}
+}
+
+// validateWatch checks watch, watch_action and watch_debounce
+func (c *Config) validateWatch() error {
+	for name, svc := range c.Services {
+		for i, pattern := range svc.Watch {
+			path := []string{"services", name, "watch", listIndex(i)}
+			if !filepath.IsAbs(pattern) {
+				return fieldErrorf(path, "must be an absolute path")
+			}
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fieldErrorf(path, "%v", err)
+			}
+			if strings.ContainsAny(filepath.Dir(pattern), `*?[\`) {
+				return fieldErrorf(path, "wildcards are only supported in the file name")
+			}
+		}
+		if len(svc.Watch) == 0 && (svc.WatchAction != "" || svc.WatchDebounce != 0) {
+			return serviceErrorf(name, "watch", "is required with watch_action and watch_debounce")
+		}
+		switch svc.WatchAction {
+		case "", WatchRestart:
+		case WatchReload:
+			if len(svc.ReloadCommand) == 0 {
+				return serviceErrorf(name, "watch_action", "reload needs a reload_command")
+			}
+		default:
+			if _, err := parseSignal(svc.WatchAction); err != nil {
+				return serviceErrorf(name, "watch_action", "must be restart, reload or a signal name")
+			}
+		}
+		if svc.WatchDebounce < 0 {
+			return serviceErrorf(name, "watch_debounce", "must not be negative")
+		}
+	}
+	return nil
+}
+
+// syncWatchers starts watching the files of services that have a watch list
+// and stops watchers for services that no longer do, or whose list changed
+func (d *Daemon) syncWatchers(config *Config) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for name, watcher := range d.watchers {
+		svc, exists := config.Services[name]
+		if !exists || !slices.Equal(svc.Watch, watcher.patterns) || svc.WatchDebounce != watcher.debounce {
+			close(watcher.stop)
+			delete(d.watchers, name)
+		}
+	}
+	for name, svc := range config.Services {
+		if len(svc.Watch) == 0 || d.watchers[name] != nil {
+			continue
+		}
+		watcher := &serviceWatcher{patterns: svc.Watch, debounce: svc.WatchDebounce, stop: make(chan struct{})}
+		d.watchers[name] = watcher
+		go d.runWatcher(name, watcher)
+	}
+}
+
+// runWatcher acts on changes to a service's watched files once they have
+// settled, until the watcher is stopped or shutdown begins
+func (d *Daemon) runWatcher(name string, watcher *serviceWatcher) {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-watcher.stop:
+		case <-d.ctx.Done():
+		}
+		close(stop)
+	}()
+
+	changes := make(chan string, 1)
+	go func() {
+		err := watchFiles(watcher.patterns, stop, func(path string) {
+			select {
+			case changes <- path:
+			default:
+			}
+		})
+		if err != nil {
+			logServiceError(name, "Failed to watch files", "error", err)
+		}
+	}()
+
+	debounce := watcher.debounce
+	if debounce == 0 {
+		debounce = defaultWatchDebounce
+	}
+	for {
+		var path string
+		select {
+		case <-stop:
+			return
+		case path = <-changes:
+		}
+
+		timer := time.NewTimer(debounce)
+	settle:
+		for {
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-changes:
+				timer.Reset(debounce)
+			case <-timer.C:
+				break settle
+			}
+		}
+		d.watchedFilesChanged(name, path)
+	}
+}
+
+// watchedFilesChanged restarts, reloads or signals a service after its
+// watched files changed, as its current definition says
+func (d *Daemon) watchedFilesChanged(name, path string) {
+	svc, exists := d.getConfig().Services[name]
+	if !exists {
+		return
+	}
+	action := svc.WatchAction
+	if action == "" {
+		action = WatchRestart
+	}
+	logServiceInfo(name, "Watched files changed", "path", path, "action", action)
+
+	if action == WatchRestart {
+		// Services stopped on purpose stay stopped
+		switch d.serviceState(name) {
+		case StateStopping, StateStopped, StateIdle, StateDisabled:
+			return
+		}
+		d.requestRestart(restartRequest{svc: svc, reason: RestartReasonFileChange, detail: path})
+		return
+	}
+	select {
+	case d.watchChan <- watchRequest{svc: svc, path: path}:
+	case <-d.ctx.Done():
+	}
+}
+
+// applyWatchAction runs a service's reload command or sends it a signal
+// after its watched files changed. Must be called with elevated privileges.
+func (d *Daemon) applyWatchAction(req watchRequest) error {
+	proc, exists := d.getServiceProcess(req.svc.Name)
+	if !exists || !proc.running() {
+		return nil
+	}
+	if req.svc.WatchAction == WatchReload {
+		return d.runReloadCommand(req.svc)
+	}
+	sig, err := parseSignal(req.svc.WatchAction)
+	if err != nil {
+		return err
+	}
+	return signalProcess(proc.cmd.Process.Pid, sig, req.svc.SignalGroup)
+}
+
+// pollFiles calls changed with each file matching patterns that is created,
+// modified or removed, checking every watchPollInterval until done is
+// closed. A pattern naming a directory matches the files in it.
+func pollFiles(patterns []string, done <-chan struct{}, changed func(path string)) error {
+	previous := fileFingerprints(patterns)
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+		}
+		current := fileFingerprints(patterns)
+		for path, fingerprint := range current {
+			if previous[path] != fingerprint {
+				changed(path)
+			}
+		}
+		for path := range previous {
+			if _, exists := current[path]; !exists {
+				changed(path)
+			}
+		}
+		previous = current
+	}
+}
+
+// fileFingerprints records the modification time and size of each file
+// matching patterns
+func fileFingerprints(patterns []string) map[string]string {
+	fingerprints := make(map[string]string)
+	for _, pattern := range patterns {
+		if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+			pattern = filepath.Join(pattern, "*")
+		}
+		matches, _ := filepath.Glob(pattern)
+		for _, path := range matches {
+			if info, err := os.Stat(path); err == nil {
+				fingerprints[path] = fmt.Sprintf("%d/%d", info.ModTime().UnixNano(), info.Size())
+			}
+		}
+	}
+	return fingerprints
+}
+
+// watchMatches reports whether a changed path matches one of patterns
+func watchMatches(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if pattern == path || pattern == filepath.Dir(path) {
+			return true
+		}
+		if matched, _ := filepath.Match(pattern, path); matched {
+			return true
+		}
+	}
+	return false
+}
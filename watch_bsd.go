@@ -0,0 +1,9 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+// watchFiles calls changed with each file matching patterns that changes,
+// until done is closed. There's no inotify here, so it polls.
+func watchFiles(patterns []string, done <-chan struct{}, changed func(path string)) error {
+	return pollFiles(patterns, done, changed)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// watchFiles calls changed with each file matching patterns that is created,
+// written, touched, moved or removed, until done is closed. It watches the
+// directories the patterns are in with inotify, falling back to polling if
+// one doesn't exist yet.
+func watchFiles(patterns []string, done <-chan struct{}, changed func(path string)) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("inotify: %v", err)
+	}
+	// Non-blocking, so the runtime poller can interrupt reads on Close
+	watcher := os.NewFile(uintptr(fd), "inotify")
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-done:
+		case <-stop:
+		}
+		watcher.Close()
+	}()
+
+	mask := uint32(syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB |
+		syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM | syscall.IN_DELETE)
+	dirs := make(map[int]string)
+	for _, pattern := range patterns {
+		dir := filepath.Dir(pattern)
+		if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+			dir = pattern
+		}
+		wd, err := syscall.InotifyAddWatch(fd, dir, mask)
+		if err != nil {
+			// The directory may be created later
+			return pollFiles(patterns, done, changed)
+		}
+		dirs[wd] = dir
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := watcher.Read(buf)
+		if err != nil {
+			select {
+			case <-done:
+				return nil
+			default:
+				return err
+			}
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			wd := int(int32(binary.NativeEndian.Uint32(buf[offset:])))
+			nameLen := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+			start := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[start:start+nameLen]), "\x00")
+			if dir, exists := dirs[wd]; exists && name != "" {
+				if path := filepath.Join(dir, name); watchMatches(patterns, path) {
+					changed(path)
+				}
+			}
+			offset = start + nameLen
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchRestartsService(t *testing.T) {
+	dir := t.TempDir()
+	svc := Service{Name: "web", Watch: []string{filepath.Join(dir, "*.conf")}, WatchDebounce: 20 * time.Millisecond}
+	config := &Config{Services: map[string]Service{"web": svc}}
+	d := NewDaemon(config, "", "", "")
+	defer d.cancel()
+	d.setState(svc, StateRunning)
+	d.syncWatchers(config)
+
+	// Give the watcher a moment to start before changing files
+	time.Sleep(50 * time.Millisecond)
+	os.WriteFile(filepath.Join(dir, "ignored.txt"), []byte("x"), 0o644)
+	for _, name := range []string{"a.conf", "b.conf"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for d.restarts.depth() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	req, ok := d.restarts.pop()
+	if !ok || req.reason != RestartReasonFileChange || !strings.HasSuffix(req.detail, ".conf") {
+		t.Fatalf("expected a file-change restart, got %+v", req)
+	}
+	// Both writes settle into one restart
+	time.Sleep(100 * time.Millisecond)
+	if d.restarts.depth() != 0 {
+		t.Error("expected changes within the debounce to restart once")
+	}
+
+	// Dropping the watch list stops the watcher
+	d.syncWatchers(&Config{Services: map[string]Service{"web": {Name: "web"}}})
+	if len(d.watchers) != 0 {
+		t.Errorf("expected no watchers, got %d", len(d.watchers))
+	}
+}
+
+func TestWatchMatches(t *testing.T) {
+	patterns := []string{"/etc/app/*.yaml", "/etc/certs", "/srv/app.ini"}
+	cases := map[string]bool{
+		"/etc/app/main.yaml":  true,
+		"/etc/app/main.json":  false,
+		"/etc/certs/tls.pem":  true,
+		"/srv/app.ini":        true,
+		"/srv/other.ini":      false,
+		"/etc/app/sub/x.yaml": false,
+	}
+	for path, want := range cases {
+		if got := watchMatches(patterns, path); got != want {
+			t.Errorf("watchMatches(%s) = %v, expected %v", path, got, want)
+		}
+	}
+}
+
+func TestValidateWatch(t *testing.T) {
+	cases := []struct {
+		svc Service
+		err string
+	}{
+		{Service{Watch: []string{"conf/*.yaml"}}, "must be an absolute path"},
+		{Service{Watch: []string{"/etc/[app"}}, "syntax error"},
+		{Service{Watch: []string{"/etc/*/app.yaml"}}, "only supported in the file name"},
+		{Service{WatchAction: "HUP"}, "is required"},
+		{Service{Watch: []string{"/etc/app"}, WatchAction: WatchReload}, "needs a reload_command"},
+		{Service{Watch: []string{"/etc/app"}, WatchAction: "bounce"}, "must be restart, reload or a signal name"},
+		{Service{Watch: []string{"/etc/app"}, WatchDebounce: -time.Second}, "must not be negative"},
+	}
+	for _, c := range cases {
+		config := &Config{Services: map[string]Service{"web": c.svc}}
+		if err := config.validateWatch(); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%+v: expected error containing %q, got %v", c.svc, c.err, err)
+		}
+	}
+
+	config := &Config{Services: map[string]Service{"web": {Watch: []string{"/etc/app/*.yaml"}, WatchAction: "HUP"}}}
+	if err := config.validateWatch(); err != nil {
+		t.Errorf("expected a signal action to be valid, got %v", err)
+	}
+}